@@ -0,0 +1,377 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+const (
+	aclAllUsersURI           = "http://acs.amazonaws.com/groups/global/AllUsers"
+	aclAuthenticatedUsersURI = "http://acs.amazonaws.com/groups/global/AuthenticatedUsers"
+)
+
+// BucketExposure is the risk assessment of a change to an S3 bucket's
+// policy, ACL or public access block.
+type BucketExposure struct {
+	Bucket       string
+	Score        int
+	Public       bool
+	CrossAccount bool
+	Findings     []string
+}
+
+func (b *BucketExposure) add(score int, finding string, args ...interface{}) {
+	b.Score += score
+	if b.Score > 100 {
+		b.Score = 100
+	}
+	b.Findings = append(b.Findings, fmt.Sprintf(finding, args...))
+}
+
+func (b *BucketExposure) Severity() string {
+	switch {
+	case b.Public:
+		return severityCritical
+	case b.CrossAccount, b.Score > 0:
+		return severityWarn
+	}
+	return severityInfo
+}
+
+func (b *BucketExposure) Summary() string {
+	var kind []string
+	if b.Public {
+		kind = append(kind, "public")
+	}
+	if b.CrossAccount {
+		kind = append(kind, "cross-account")
+	}
+	if len(kind) == 0 {
+		kind = append(kind, "no exposure detected")
+	}
+
+	s := fmt.Sprintf("Exposure risk %d/100 (%s)", b.Score, strings.Join(kind, ", "))
+	if b.Bucket != "" {
+		s = fmt.Sprintf("%s on %s", s, b.Bucket)
+	}
+	return s
+}
+
+// AssessBucketExposure scores S3 bucket policy, ACL and public access block
+// changes. It returns nil for any other event.
+func AssessBucketExposure(record *CloudTrailRecord) *BucketExposure {
+	if record.EventSource != "s3.amazonaws.com" {
+		return nil
+	}
+
+	rps := record.RequestParameters
+	exposure := &BucketExposure{}
+	exposure.Bucket, _ = rps["bucketName"].(string)
+
+	owner := record.RecipientAccountID
+	if owner == "" {
+		owner = record.UserIdentity.AccountID
+	}
+
+	switch record.EventName {
+	case "PutBucketPolicy":
+		assessBucketPolicy(exposure, rps["bucketPolicy"], owner)
+	case "PutBucketAcl":
+		assessBucketAcl(exposure, rps)
+	case "DeletePublicAccessBlock":
+		exposure.add(40, "Public access block removed")
+	case "PutBucketPublicAccessBlock":
+		assessPublicAccessBlock(exposure, rps["PublicAccessBlockConfiguration"])
+	default:
+		return nil
+	}
+
+	return exposure
+}
+
+type policyStatement struct {
+	Effect    string          `json:"Effect"`
+	Principal json.RawMessage `json:"Principal"`
+	Action    json.RawMessage `json:"Action"`
+	Resource  json.RawMessage `json:"Resource"`
+	Condition json.RawMessage `json:"Condition"`
+}
+
+// policyStatements decodes the Statement of an IAM policy document, which
+// is either a single statement or a list of them.
+func policyStatements(doc []byte) ([]policyStatement, error) {
+	var policy struct {
+		Statement json.RawMessage `json:"Statement"`
+	}
+	if err := json.Unmarshal(doc, &policy); err != nil {
+		return nil, err
+	}
+
+	var statements []policyStatement
+	if err := json.Unmarshal(policy.Statement, &statements); err != nil {
+		var statement policyStatement
+		if err := json.Unmarshal(policy.Statement, &statement); err != nil {
+			return nil, err
+		}
+		statements = append(statements, statement)
+	}
+	return statements, nil
+}
+
+func assessBucketPolicy(exposure *BucketExposure, raw interface{}, owner string) {
+	var doc []byte
+	switch p := raw.(type) {
+	case nil:
+		return
+	case string:
+		// Some clients deliver the policy as an encoded JSON string
+		// rather than a parsed document.
+		doc = []byte(p)
+	default:
+		doc, _ = json.Marshal(p)
+	}
+
+	statements, err := policyStatements(doc)
+	if err != nil {
+		exposure.add(10, "Bucket policy could not be parsed")
+		return
+	}
+
+	for i, statement := range statements {
+		if statement.Effect != "Allow" {
+			continue
+		}
+
+		conditioned := len(statement.Condition) > 0 && string(statement.Condition) != "null"
+		for _, principal := range policyPrincipals(statement.Principal) {
+			switch {
+			case principal == "*" && conditioned:
+				exposure.add(10, "Statement %d allows any principal subject to conditions", i)
+			case principal == "*":
+				exposure.Public = true
+				exposure.add(60, "Statement %d allows any principal (%s)", i, policyActions(statement.Action))
+			case owner != "" && principalAccount(principal) != "" && principalAccount(principal) != owner:
+				exposure.CrossAccount = true
+				exposure.add(25, "Statement %d allows account %s", i, principalAccount(principal))
+			}
+		}
+	}
+}
+
+// policyPrincipals flattens the "*", {"AWS": "..."} and {"AWS": [...]}
+// principal forms into a list of AWS principals.
+func policyPrincipals(raw json.RawMessage) []string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return []string{s}
+	}
+
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil
+	}
+
+	var principals []string
+	for kind, v := range m {
+		if kind != "AWS" {
+			continue
+		}
+		principals = append(principals, stringOrList(v)...)
+	}
+	return principals
+}
+
+func policyActions(raw json.RawMessage) string {
+	actions := stringOrList(raw)
+	if len(actions) == 0 {
+		return "no actions"
+	}
+	return strings.Join(actions, ", ")
+}
+
+func stringOrList(raw json.RawMessage) []string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return []string{s}
+	}
+	var l []string
+	json.Unmarshal(raw, &l)
+	return l
+}
+
+// principalAccount returns the account id of an account id or IAM ARN
+// principal, or an empty string for anything else.
+func principalAccount(principal string) string {
+	if strings.HasPrefix(principal, "arn:") {
+		parts := strings.Split(principal, ":")
+		if len(parts) > 4 {
+			return parts[4]
+		}
+		return ""
+	}
+	if len(principal) == 12 && strings.Trim(principal, "0123456789") == "" {
+		return principal
+	}
+	return ""
+}
+
+type aclPolicy struct {
+	Grants []aclGrant `xml:"AccessControlList>Grant"`
+}
+
+type aclGrant struct {
+	Grantee struct {
+		URI string `xml:"URI"`
+		ID  string `xml:"ID"`
+	} `xml:"Grantee"`
+	Permission string `xml:"Permission"`
+}
+
+func assessBucketAcl(exposure *BucketExposure, rps map[string]interface{}) {
+	// Canned ACLs arrive as the x-amz-acl header, either a plain string
+	// or a single element list.
+	for _, acl := range headerValues(rps["x-amz-acl"]) {
+		switch acl {
+		case "public-read-write":
+			exposure.Public = true
+			exposure.add(80, "Canned ACL %s grants everyone read and write", acl)
+		case "public-read":
+			exposure.Public = true
+			exposure.add(60, "Canned ACL %s grants everyone read", acl)
+		case "authenticated-read":
+			exposure.Public = true
+			exposure.add(50, "Canned ACL %s grants any AWS account read", acl)
+		}
+	}
+
+	for _, header := range []string{"x-amz-grant-read", "x-amz-grant-write", "x-amz-grant-read-acp", "x-amz-grant-write-acp", "x-amz-grant-full-control"} {
+		permission := strings.ToUpper(strings.TrimPrefix(header, "x-amz-grant-"))
+		// Each header lists its grantees as key="value" pairs, e.g.
+		// id="abc", uri="http://acs.amazonaws.com/groups/global/AllUsers".
+		for _, grant := range headerValues(rps[header]) {
+			key, value := grantPair(grant)
+			switch strings.ToLower(key) {
+			case "uri":
+				assessAclGrantee(exposure, value, "", permission)
+			case "id", "emailaddress":
+				assessAclGrantee(exposure, "", value, permission)
+			}
+		}
+	}
+
+	for _, grant := range aclGrants(rps["AccessControlPolicy"]) {
+		assessAclGrantee(exposure, grant.Grantee.URI, grant.Grantee.ID, grant.Permission)
+	}
+}
+
+func assessAclGrantee(exposure *BucketExposure, uri, grantee, permission string) {
+	switch uri {
+	case aclAllUsersURI:
+		exposure.Public = true
+		exposure.add(60, "ACL grants %s to everyone", permission)
+	case aclAuthenticatedUsersURI:
+		exposure.Public = true
+		exposure.add(50, "ACL grants %s to any AWS account", permission)
+	default:
+		if grantee != "" && strings.HasPrefix(permission, "FULL") {
+			exposure.add(10, "ACL grants %s to %s", permission, grantee)
+		}
+	}
+}
+
+// aclGrants decodes the AccessControlPolicy request parameter which is either
+// the raw XML body or its JSON rendering.
+func aclGrants(raw interface{}) []aclGrant {
+	switch p := raw.(type) {
+	case string:
+		var acl aclPolicy
+		if err := xml.Unmarshal([]byte(p), &acl); err != nil {
+			return nil
+		}
+		return acl.Grants
+	case map[string]interface{}:
+		list, _ := p["AccessControlList"].(map[string]interface{})
+		var grants []aclGrant
+		for _, g := range objectOrList(list["Grant"]) {
+			var grant aclGrant
+			grantee, _ := g["Grantee"].(map[string]interface{})
+			grant.Grantee.URI, _ = grantee["URI"].(string)
+			grant.Grantee.ID, _ = grantee["ID"].(string)
+			grant.Permission, _ = g["Permission"].(string)
+			grants = append(grants, grant)
+		}
+		return grants
+	}
+	return nil
+}
+
+func objectOrList(raw interface{}) []map[string]interface{} {
+	switch v := raw.(type) {
+	case map[string]interface{}:
+		return []map[string]interface{}{v}
+	case []interface{}:
+		var l []map[string]interface{}
+		for _, i := range v {
+			if m, ok := i.(map[string]interface{}); ok {
+				l = append(l, m)
+			}
+		}
+		return l
+	}
+	return nil
+}
+
+// grantPair splits a grant header value such as uri="..." into its key and
+// unquoted value.
+func grantPair(grant string) (string, string) {
+	i := strings.Index(grant, "=")
+	if i < 0 {
+		return "", ""
+	}
+	return strings.TrimSpace(grant[:i]), strings.Trim(strings.TrimSpace(grant[i+1:]), `"`)
+}
+
+func headerValues(raw interface{}) []string {
+	var l []string
+	switch v := raw.(type) {
+	case string:
+		l = splitHeader(l, v)
+	case []interface{}:
+		for _, i := range v {
+			if s, ok := i.(string); ok {
+				l = splitHeader(l, s)
+			}
+		}
+	}
+	return l
+}
+
+func splitHeader(l []string, header string) []string {
+	for _, v := range strings.Split(header, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l = append(l, v)
+		}
+	}
+	return l
+}
+
+func assessPublicAccessBlock(exposure *BucketExposure, raw interface{}) {
+	config, _ := raw.(map[string]interface{})
+	for _, setting := range []string{"BlockPublicAcls", "IgnorePublicAcls", "BlockPublicPolicy", "RestrictPublicBuckets"} {
+		if v, ok := config[setting]; ok && !isTrue(v) {
+			exposure.add(15, "%s disabled", setting)
+		}
+	}
+}
+
+func isTrue(v interface{}) bool {
+	switch b := v.(type) {
+	case bool:
+		return b
+	case string:
+		return strings.EqualFold(b, "true")
+	}
+	return false
+}
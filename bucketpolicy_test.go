@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func bucketRecord(t *testing.T, eventName, requestParameters string) *CloudTrailRecord {
+	var rps map[string]interface{}
+	if err := json.Unmarshal([]byte(requestParameters), &rps); err != nil {
+		t.Fatal(err)
+	}
+	return typedRecord(map[string]interface{}{
+		"eventSource":        "s3.amazonaws.com",
+		"eventName":          eventName,
+		"recipientAccountId": "111111111111",
+		"requestParameters":  rps,
+	})
+}
+
+func TestAssessBucketExposure(t *testing.T) {
+	tests := []struct {
+		name         string
+		event        string
+		params       string
+		public       bool
+		crossAccount bool
+		severity     string
+	}{
+		{
+			name:     "public policy",
+			event:    "PutBucketPolicy",
+			params:   `{"bucketName":"b","bucketPolicy":{"Statement":[{"Effect":"Allow","Principal":"*","Action":"s3:GetObject"}]}}`,
+			public:   true,
+			severity: severityCritical,
+		},
+		{
+			name:     "public policy as encoded string",
+			event:    "PutBucketPolicy",
+			params:   `{"bucketName":"b","bucketPolicy":"{\"Statement\":{\"Effect\":\"Allow\",\"Principal\":{\"AWS\":\"*\"},\"Action\":\"s3:*\"}}"}`,
+			public:   true,
+			severity: severityCritical,
+		},
+		{
+			name:     "conditioned public policy",
+			event:    "PutBucketPolicy",
+			params:   `{"bucketName":"b","bucketPolicy":{"Statement":[{"Effect":"Allow","Principal":"*","Action":"s3:GetObject","Condition":{"StringEquals":{"aws:PrincipalOrgID":"o-123"}}}]}}`,
+			severity: severityWarn,
+		},
+		{
+			name:         "cross-account policy",
+			event:        "PutBucketPolicy",
+			params:       `{"bucketName":"b","bucketPolicy":{"Statement":[{"Effect":"Allow","Principal":{"AWS":["arn:aws:iam::222222222222:root","arn:aws:iam::111111111111:role/x"]},"Action":"s3:GetObject"}]}}`,
+			crossAccount: true,
+			severity:     severityWarn,
+		},
+		{
+			name:     "deny only policy",
+			event:    "PutBucketPolicy",
+			params:   `{"bucketName":"b","bucketPolicy":{"Statement":[{"Effect":"Deny","Principal":"*","Action":"s3:*"}]}}`,
+			severity: severityInfo,
+		},
+		{
+			name:     "canned public acl",
+			event:    "PutBucketAcl",
+			params:   `{"bucketName":"b","x-amz-acl":["public-read"]}`,
+			public:   true,
+			severity: severityCritical,
+		},
+		{
+			name:     "acl grant json",
+			event:    "PutBucketAcl",
+			params:   `{"bucketName":"b","AccessControlPolicy":{"AccessControlList":{"Grant":[{"Grantee":{"URI":"http://acs.amazonaws.com/groups/global/AllUsers"},"Permission":"READ"}]}}}`,
+			public:   true,
+			severity: severityCritical,
+		},
+		{
+			name:     "acl grant xml",
+			event:    "PutBucketAcl",
+			params:   `{"bucketName":"b","AccessControlPolicy":"<AccessControlPolicy><AccessControlList><Grant><Grantee><URI>http://acs.amazonaws.com/groups/global/AuthenticatedUsers</URI></Grantee><Permission>WRITE</Permission></Grant></AccessControlList></AccessControlPolicy>"}`,
+			public:   true,
+			severity: severityCritical,
+		},
+		{
+			name:     "acl grant header with several grantees",
+			event:    "PutBucketAcl",
+			params:   `{"bucketName":"b","x-amz-grant-read":["id=\"abc\", uri=\"http://acs.amazonaws.com/groups/global/AllUsers\""]}`,
+			public:   true,
+			severity: severityCritical,
+		},
+		{
+			name:     "acl grant header to named grantees",
+			event:    "PutBucketAcl",
+			params:   `{"bucketName":"b","x-amz-grant-full-control":"id=\"abc\", emailAddress=\"ops@example.com\""}`,
+			severity: severityWarn,
+		},
+		{
+			name:     "private acl",
+			event:    "PutBucketAcl",
+			params:   `{"bucketName":"b","x-amz-acl":["private"]}`,
+			severity: severityInfo,
+		},
+		{
+			name:     "delete public access block",
+			event:    "DeletePublicAccessBlock",
+			params:   `{"bucketName":"b"}`,
+			severity: severityWarn,
+		},
+		{
+			name:     "weakened public access block",
+			event:    "PutBucketPublicAccessBlock",
+			params:   `{"bucketName":"b","PublicAccessBlockConfiguration":{"BlockPublicAcls":false,"IgnorePublicAcls":true,"BlockPublicPolicy":"true","RestrictPublicBuckets":true}}`,
+			severity: severityWarn,
+		},
+		{
+			name:     "full public access block",
+			event:    "PutBucketPublicAccessBlock",
+			params:   `{"bucketName":"b","PublicAccessBlockConfiguration":{"BlockPublicAcls":true,"IgnorePublicAcls":true,"BlockPublicPolicy":true,"RestrictPublicBuckets":true}}`,
+			severity: severityInfo,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exposure := AssessBucketExposure(bucketRecord(t, tt.event, tt.params))
+			if exposure == nil {
+				t.Fatal("expected an assessment")
+			}
+			if exposure.Public != tt.public {
+				t.Errorf("Public = %v, want %v (%v)", exposure.Public, tt.public, exposure.Findings)
+			}
+			if exposure.CrossAccount != tt.crossAccount {
+				t.Errorf("CrossAccount = %v, want %v (%v)", exposure.CrossAccount, tt.crossAccount, exposure.Findings)
+			}
+			if s := exposure.Severity(); s != tt.severity {
+				t.Errorf("Severity() = %s, want %s (%v)", s, tt.severity, exposure.Findings)
+			}
+			if exposure.Bucket != "b" {
+				t.Errorf("Bucket = %q", exposure.Bucket)
+			}
+		})
+	}
+}
+
+func TestAssessBucketExposureIgnoresOtherEvents(t *testing.T) {
+	record := bucketRecord(t, "PutObject", `{"bucketName":"b"}`)
+	if exposure := AssessBucketExposure(record); exposure != nil {
+		t.Errorf("expected nil, got %+v", exposure)
+	}
+
+	record = bucketRecord(t, "PutBucketPolicy", `{}`)
+	record.EventSource = "iam.amazonaws.com"
+	if exposure := AssessBucketExposure(record); exposure != nil {
+		t.Errorf("expected nil, got %+v", exposure)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	log "github.com/sirupsen/logrus"
+)
+
+// bucketLocator is the part of the S3 API used to find a bucket's region.
+type bucketLocator interface {
+	GetBucketLocationWithContext(aws.Context, *s3.GetBucketLocationInput, ...request.Option) (*s3.GetBucketLocationOutput, error)
+}
+
+var (
+	bucketRegionsMu sync.Mutex
+	// bucketRegions caches the region of every bucket looked up by warm
+	// invocations.
+	bucketRegions = map[string]string{}
+)
+
+// bucketRegion returns the region of bucket, which may differ from the region
+// of its event notification. It falls back to fallback when client can't
+// look buckets up or GetBucketLocation fails, e.g. without
+// s3:GetBucketLocation, and looks the bucket up again next time.
+func bucketRegion(ctx context.Context, client S3Getter, bucket, fallback string) string {
+	bucketRegionsMu.Lock()
+	region, ok := bucketRegions[bucket]
+	bucketRegionsMu.Unlock()
+	if ok {
+		return region
+	}
+
+	locator, ok := client.(bucketLocator)
+	if !ok {
+		return fallback
+	}
+
+	out, err := locator.GetBucketLocationWithContext(ctx, &s3.GetBucketLocationInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		// Not cached, a transient failure would stick for the life of the
+		// container.
+		log.Warnf("Reading %s from %s, looking up its region failed: %v", bucket, fallback, err)
+		return fallback
+	}
+	region = s3.NormalizeBucketLocation(aws.StringValue(out.LocationConstraint))
+
+	bucketRegionsMu.Lock()
+	bucketRegions[bucket] = region
+	bucketRegionsMu.Unlock()
+	return region
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// locatingS3Getter serves objects from a bucket in location.
+type locatingS3Getter struct {
+	*fakeS3Getter
+	location string
+	err      error
+
+	mu      sync.Mutex
+	lookups int
+}
+
+func (l *locatingS3Getter) GetBucketLocationWithContext(aws.Context, *s3.GetBucketLocationInput, ...request.Option) (*s3.GetBucketLocationOutput, error) {
+	l.mu.Lock()
+	l.lookups++
+	l.mu.Unlock()
+	if l.err != nil {
+		return nil, l.err
+	}
+	return &s3.GetBucketLocationOutput{LocationConstraint: aws.String(l.location)}, nil
+}
+
+func resetBucketRegions(t *testing.T) {
+	reset := func() {
+		bucketRegionsMu.Lock()
+		bucketRegions = map[string]string{}
+		bucketRegionsMu.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestStreamUsesBucketRegion(t *testing.T) {
+	tests := []struct {
+		name     string
+		location string
+		err      error
+		want     []string
+		lookups  int
+	}{
+		{"other region", "eu-west-1", nil, []string{"us-east-1", "eu-west-1"}, 1},
+		{"legacy EU location", "EU", nil, []string{"us-east-1", "eu-west-1"}, 1},
+		{"us-east-1", "", nil, []string{"us-east-1"}, 1},
+		// Failed lookups aren't cached.
+		{"lookup fails", "", errors.New("AccessDenied"), []string{"us-east-1"}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetBucketRegions(t)
+			captureSlack(t)
+
+			content := []byte(`{"Records":[]}`)
+			getter := &locatingS3Getter{
+				fakeS3Getter: &fakeS3Getter{objects: map[string][]byte{"test-harness/" + testS3Record.S3.Object.Key: content}},
+				location:     tt.location,
+				err:          tt.err,
+			}
+			withS3Getter(t, getter)
+			var regions []string
+			newS3Client = func(region, roleArn string) S3Getter {
+				regions = append(regions, region)
+				return getter
+			}
+
+			if err := Stream(context.Background(), testS3Record); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(regions, tt.want) {
+				t.Errorf("clients for %v, want %v", regions, tt.want)
+			}
+
+			// The region is looked up once per bucket.
+			regions = nil
+			if err := Stream(context.Background(), testS3Record); err != nil {
+				t.Fatal(err)
+			}
+			if getter.lookups != tt.lookups {
+				t.Errorf("expected %d GetBucketLocation calls, got %d", tt.lookups, getter.lookups)
+			}
+			if !reflect.DeepEqual(regions, tt.want) {
+				t.Errorf("clients for %v on the second read, want %v", regions, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	log "github.com/sirupsen/logrus"
+)
+
+// Time kept back from the Lambda deadline so the summary can still be sent.
+const defaultDeadlineReserve = 2 * time.Second
+
+// Maximum number of event ids listed in a budget summary message.
+const maxSummaryEventIDs = 20
+
+// Most messages SQS accepts in one SendMessageBatch call.
+const dlqBatchSize = 10
+
+var (
+	dlqClientMu sync.Mutex
+	dlqClient   sqsiface.SQSAPI
+)
+
+func budgetSQS() sqsiface.SQSAPI {
+	dlqClientMu.Lock()
+	defer dlqClientMu.Unlock()
+	if dlqClient == nil {
+		client := sqs.New(session.Must(session.NewSession()))
+		traceAWSClient(client.Client)
+		dlqClient = client
+	}
+	return dlqClient
+}
+
+type notifyBudgetKey struct{}
+
+// withNotifyBudget records the point in time after which an invocation stops
+// sending per-event notifications. It is the earlier of NOTIFY_TIME_BUDGET
+// from now and the Lambda deadline less NOTIFY_DEADLINE_RESERVE.
+func withNotifyBudget(ctx context.Context) context.Context {
+	var deadline time.Time
+
+	if v := os.Getenv("NOTIFY_TIME_BUDGET"); v != "" {
+		budget, err := time.ParseDuration(v)
+		if err != nil {
+			log.Warnf("Ignoring invalid NOTIFY_TIME_BUDGET %q: %v", v, err)
+		} else {
+			deadline = time.Now().Add(budget)
+		}
+	}
+
+	if d, ok := ctx.Deadline(); ok {
+		reserve := defaultDeadlineReserve
+		if v := os.Getenv("NOTIFY_DEADLINE_RESERVE"); v != "" {
+			if r, err := time.ParseDuration(v); err == nil {
+				reserve = r
+			} else {
+				log.Warnf("Ignoring invalid NOTIFY_DEADLINE_RESERVE %q: %v", v, err)
+			}
+		}
+		if d = d.Add(-reserve); deadline.IsZero() || d.Before(deadline) {
+			deadline = d
+		}
+	}
+
+	return context.WithValue(ctx, notifyBudgetKey{}, deadline)
+}
+
+func notifyBudgetExceeded(ctx context.Context) bool {
+	deadline, _ := ctx.Value(notifyBudgetKey{}).(time.Time)
+	return !deadline.IsZero() && time.Now().After(deadline)
+}
+
+// sendBudgetSummary reports the records that were not notified because the
+// time budget ran out and optionally forwards them to NOTIFY_DLQ_URL. The
+// summary goes to Slack like an event of the account of the log file.
+func sendBudgetSummary(ctx context.Context, deferred []*CloudTrailRecord, evt events.S3EventRecord) {
+	if len(deferred) == 0 {
+		return
+	}
+
+	s3URI := objectURI(evt)
+	var ids []string
+	for _, record := range deferred {
+		ids = append(ids, record.EventID)
+	}
+
+	log.WithFields(log.Fields{
+		"s3_uri":    s3URI,
+		"unsent":    len(deferred),
+		"event_ids": ids,
+	}).Warn("Notification time budget exceeded")
+
+	if queueUrl, ok := os.LookupEnv("NOTIFY_DLQ_URL"); ok && queueUrl != "" {
+		if err := sendToDLQ(ctx, queueUrl, deferred, s3URI); err != nil {
+			log.Warnf("Sending unsent events to DLQ: %v", err)
+		}
+	}
+
+	token := os.Getenv("SLACK_BOT_TOKEN")
+	webhookUrl, ok := slackWebhook(logAccountID(evt.S3.Object.Key))
+	if token == "" && !ok {
+		return
+	}
+
+	listed := ids
+	if len(listed) > maxSummaryEventIDs {
+		listed = listed[:maxSummaryEventIDs]
+	}
+	text := fmt.Sprintf("*%d events not notified* - time budget exceeded\n%s\n%s", len(deferred), s3URI, strings.Join(listed, "\n"))
+	if len(ids) > len(listed) {
+		text = fmt.Sprintf("%s\n... and %d more", text, len(ids)-len(listed))
+	}
+
+	slackBody, _ := json.Marshal(map[string]interface{}{
+		"channel": os.Getenv("SLACK_CHANNEL"),
+		"text":    "Not Used",
+		"blocks": []interface{}{
+			map[string]interface{}{
+				"type": "section",
+				"text": map[string]string{
+					"type": "mrkdwn",
+					"text": text,
+				},
+			},
+		},
+	})
+
+	if dryRunNotification(ctx, "slack", slackBody) {
+		return
+	}
+	var err error
+	if token != "" {
+		_, err = PostSlackMessage(ctx, token, slackBody)
+	} else {
+		err = SendSlackNotification(ctx, webhookUrl, slackBody)
+	}
+	if err != nil {
+		log.Debugln(string(slackBody))
+		log.Debug(err)
+	}
+}
+
+// sendToDLQ forwards the deferred records to queueUrl, dlqBatchSize per
+// SendMessageBatch call.
+func sendToDLQ(ctx context.Context, queueUrl string, deferred []*CloudTrailRecord, s3URI string) error {
+	if isDryRun(ctx) {
+		log.Infof("Dry run, not sending %d events to the DLQ", len(deferred))
+		return nil
+	}
+
+	for start := 0; start < len(deferred); start += dlqBatchSize {
+		end := start + dlqBatchSize
+		if end > len(deferred) {
+			end = len(deferred)
+		}
+
+		var entries []*sqs.SendMessageBatchRequestEntry
+		for i, record := range deferred[start:end] {
+			body, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, &sqs.SendMessageBatchRequestEntry{
+				Id:          aws.String(strconv.Itoa(i)),
+				MessageBody: aws.String(string(body)),
+				MessageAttributes: map[string]*sqs.MessageAttributeValue{
+					"s3_uri": {
+						DataType:    aws.String("String"),
+						StringValue: aws.String(s3URI),
+					},
+				},
+			})
+		}
+
+		out, err := budgetSQS().SendMessageBatchWithContext(ctx, &sqs.SendMessageBatchInput{
+			QueueUrl: aws.String(queueUrl),
+			Entries:  entries,
+		})
+		if err != nil {
+			return err
+		}
+		if len(out.Failed) > 0 {
+			var failed []string
+			for _, f := range out.Failed {
+				i, _ := strconv.Atoi(aws.StringValue(f.Id))
+				failed = append(failed, fmt.Sprintf("%s: %s", deferred[start+i].EventID, aws.StringValue(f.Message)))
+			}
+			return errors.New(strings.Join(failed, "; "))
+		}
+	}
+
+	return nil
+}
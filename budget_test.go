@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+type fakeSQS struct {
+	sqsiface.SQSAPI
+	inputs []*sqs.SendMessageBatchInput
+	// failed are the ids of the entries each batch reports as failed.
+	failed []string
+}
+
+func (f *fakeSQS) SendMessageBatchWithContext(ctx aws.Context, in *sqs.SendMessageBatchInput, opts ...request.Option) (*sqs.SendMessageBatchOutput, error) {
+	f.inputs = append(f.inputs, in)
+	out := &sqs.SendMessageBatchOutput{}
+	for _, id := range f.failed {
+		out.Failed = append(out.Failed, &sqs.BatchResultErrorEntry{Id: aws.String(id), Message: aws.String("throttled")})
+	}
+	return out, nil
+}
+
+// entries returns the message bodies of every batch sent.
+func (f *fakeSQS) entries() []string {
+	var bodies []string
+	for _, in := range f.inputs {
+		for _, e := range in.Entries {
+			bodies = append(bodies, *e.MessageBody)
+		}
+	}
+	return bodies
+}
+
+func TestNotifyBudgetUnset(t *testing.T) {
+	ctx := withNotifyBudget(context.Background())
+	if notifyBudgetExceeded(ctx) {
+		t.Error("budget should never be exceeded without NOTIFY_TIME_BUDGET or a deadline")
+	}
+}
+
+func TestNotifyBudgetFromLambdaDeadline(t *testing.T) {
+	setEnv(t, "NOTIFY_DEADLINE_RESERVE", "1h")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if !notifyBudgetExceeded(withNotifyBudget(ctx)) {
+		t.Error("budget should be exceeded when the reserve is larger than the remaining time")
+	}
+}
+
+func TestNotifyBudgetSendsSummary(t *testing.T) {
+	slack := captureSlack(t)
+	setEnv(t, "NOTIFY_TIME_BUDGET", "1ns")
+	setEnv(t, "NOTIFY_DLQ_URL", "https://sqs.us-east-1.amazonaws.com/012345678901/dlq")
+	dlq := &fakeSQS{}
+	dlqClient = dlq
+	defer func() { dlqClient = nil }()
+
+	ctx := withNotifyBudget(context.Background())
+	time.Sleep(time.Millisecond)
+
+	logFile := cloudTrailFile(
+		consoleRecord("CreateTags", "event-1"),
+		consoleRecord("RunInstances", "event-2"),
+		consoleRecord("DescribeInstances", "event-3"),
+	)
+	if _, err := FilterRecords(ctx, logFile.Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := slack.Bodies()
+	if len(bodies) != 1 {
+		t.Fatalf("expected a single summary, got %d messages", len(bodies))
+	}
+	for _, want := range []string{"2 events not notified", "event-1", "event-2"} {
+		if !strings.Contains(bodies[0], want) {
+			t.Errorf("summary missing %q: %s", want, bodies[0])
+		}
+	}
+	if strings.Contains(bodies[0], "event-3") {
+		t.Errorf("filtered event listed in summary: %s", bodies[0])
+	}
+
+	entries := dlq.entries()
+	if len(dlq.inputs) != 1 || len(entries) != 2 {
+		t.Fatalf("expected 2 DLQ messages in one batch, got %d in %d", len(entries), len(dlq.inputs))
+	}
+	if !strings.Contains(entries[0], "event-1") {
+		t.Errorf("unexpected DLQ body %s", entries[0])
+	}
+}
+
+func TestNotifyBudgetNotExceeded(t *testing.T) {
+	slack := captureSlack(t)
+	setEnv(t, "NOTIFY_TIME_BUDGET", "1m")
+
+	logFile := cloudTrailFile(
+		consoleRecord("CreateTags", "event-1"),
+		consoleRecord("RunInstances", "event-2"),
+	)
+	if _, err := FilterRecords(withNotifyBudget(context.Background()), logFile.Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(slack.Bodies()); n != 2 {
+		t.Errorf("expected 2 per-event messages, got %d", n)
+	}
+}
+
+func TestBudgetSummaryAccountWebhook(t *testing.T) {
+	account := captureSlack(t)
+	setEnv(t, "SLACK_WEBHOOK_012345678901", os.Getenv("SLACK_WEBHOOK"))
+	fallback := captureSlack(t)
+
+	sendBudgetSummary(context.Background(), []*CloudTrailRecord{typedRecord(consoleRecord("CreateTags", "event-1"))}, testS3Record)
+
+	if n := len(account.Bodies()); n != 1 {
+		t.Errorf("expected the summary on the account webhook, got %d messages", n)
+	}
+	if n := len(fallback.Bodies()); n != 0 {
+		t.Errorf("expected nothing on SLACK_WEBHOOK, got %d messages", n)
+	}
+}
+
+func TestSendToDLQBatches(t *testing.T) {
+	dlq := &fakeSQS{failed: []string{"1"}}
+	dlqClient = dlq
+	defer func() { dlqClient = nil }()
+
+	var deferred []*CloudTrailRecord
+	for i := 0; i < 12; i++ {
+		deferred = append(deferred, typedRecord(consoleRecord("CreateTags", fmt.Sprintf("event-%d", i))))
+	}
+
+	err := sendToDLQ(context.Background(), "https://sqs.us-east-1.amazonaws.com/012345678901/dlq", deferred, "s3://b/k")
+	if err == nil || !strings.Contains(err.Error(), "event-1: throttled") {
+		t.Errorf("expected the failed entry to be reported, got %v", err)
+	}
+	// The first batch fails, so the second is not sent.
+	if len(dlq.inputs) != 1 || len(dlq.inputs[0].Entries) != dlqBatchSize {
+		t.Errorf("expected one batch of %d messages, got %d batches", dlqBatchSize, len(dlq.inputs))
+	}
+
+	dlq.inputs, dlq.failed = nil, nil
+	if err := sendToDLQ(context.Background(), "https://sqs.us-east-1.amazonaws.com/012345678901/dlq", deferred, "s3://b/k"); err != nil {
+		t.Fatal(err)
+	}
+	if len(dlq.inputs) != 2 || len(dlq.inputs[1].Entries) != 2 {
+		t.Errorf("expected batches of 10 and 2 messages, got %d batches", len(dlq.inputs))
+	}
+}
+
+func TestSendToDLQDryRun(t *testing.T) {
+	setEnv(t, "DRY_RUN", "true")
+	dlq := &fakeSQS{}
+	dlqClient = dlq
+	defer func() { dlqClient = nil }()
+
+	deferred := []*CloudTrailRecord{typedRecord(consoleRecord("CreateTags", "event-1"))}
+	if err := sendToDLQ(context.Background(), "https://sqs.us-east-1.amazonaws.com/012345678901/dlq", deferred, "s3://b/k"); err != nil {
+		t.Fatal(err)
+	}
+	if len(dlq.inputs) != 0 {
+		t.Errorf("expected nothing sent under DRY_RUN, got %d batches", len(dlq.inputs))
+	}
+}
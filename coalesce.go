@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	log "github.com/sirupsen/logrus"
+)
+
+// coalesceCondition lets a marker be written when there is none or the
+// window of the last one has passed.
+const coalesceCondition = "attribute_not_exists(event_id) OR coalesce_until <= :now"
+
+// coalesceReleaseCondition lets a marker be removed only by the record that
+// opened its window.
+const coalesceReleaseCondition = "first_event_id = :event_id"
+
+// coalesceWindow returns COALESCE_WINDOW, zero when alerts aren't
+// coalesced.
+func coalesceWindow() time.Duration {
+	v := os.Getenv("COALESCE_WINDOW")
+	if v == "" {
+		return 0
+	}
+	window, err := time.ParseDuration(v)
+	if err != nil || window <= 0 {
+		log.Warnf("Ignoring invalid COALESCE_WINDOW %q", v)
+		return 0
+	}
+	return window
+}
+
+// checkCoalesceConfig warns when COALESCE_WINDOW is set without the
+// DEDUPE_TABLE its windows are stored in, as nothing is coalesced then.
+func checkCoalesceConfig() {
+	if os.Getenv("COALESCE_WINDOW") != "" && os.Getenv("DEDUPE_TABLE") == "" {
+		log.Warn("COALESCE_WINDOW is set without DEDUPE_TABLE, alerts are not coalesced")
+	}
+}
+
+// coalesceKey identifies the alerts coalesced together: the same event by
+// the same actor of the same account. The account keeps actors named only
+// by resolveUserName, such as "root", apart across accounts.
+func coalesceKey(record *CloudTrailRecord) string {
+	account := record.UserIdentity.AccountID
+	if account == "" {
+		account = record.RecipientAccountID
+	}
+	actor := record.UserIdentity.ARN
+	if actor == "" {
+		actor = record.UserIdentity.PrincipalID
+	}
+	if actor == "" {
+		actor = resolveUserName(record.UserIdentity)
+	}
+	return "coalesce#" + account + "#" + actor + "#" + record.EventName
+}
+
+// claimCoalesceWindow records a marker for the record's actor and event name
+// in DEDUPE_TABLE and reports whether the record should alert, which it
+// doesn't when an earlier alert of any invocation opened a COALESCE_WINDOW
+// that is still running at now. The marker shares the table's event_id
+// partition key and expires with the window. Without COALESCE_WINDOW or
+// DEDUPE_TABLE, in a dry run or when the table can't be written, every
+// record alerts.
+func claimCoalesceWindow(ctx context.Context, record *CloudTrailRecord, now time.Time) bool {
+	window := coalesceWindow()
+	table := os.Getenv("DEDUPE_TABLE")
+	if window == 0 || table == "" || isDryRun(ctx) {
+		return true
+	}
+
+	key := coalesceKey(record)
+	until := strconv.FormatInt(now.Add(window).Unix(), 10)
+	_, err := dedupeDynamoDB().PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"event_id":       {S: aws.String(key)},
+			"first_event_id": {S: aws.String(record.EventID)},
+			"coalesce_until": {N: aws.String(until)},
+			"expires_at":     {N: aws.String(until)},
+		},
+		ConditionExpression: aws.String(coalesceCondition),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": {N: aws.String(strconv.FormatInt(now.Unix(), 10))},
+		},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return false
+	}
+	if err != nil {
+		// An extra alert is better than a missed one.
+		log.Warnf("Recording %s in %s: %v", key, table, err)
+	}
+	return true
+}
+
+// releaseCoalesceWindow removes the window the record opened after its
+// notification failed, leaving a window opened by another record alone.
+func releaseCoalesceWindow(ctx context.Context, record *CloudTrailRecord) {
+	table := os.Getenv("DEDUPE_TABLE")
+	if coalesceWindow() == 0 || table == "" || isDryRun(ctx) {
+		return
+	}
+
+	key := coalesceKey(record)
+	_, err := dedupeDynamoDB().DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"event_id": {S: aws.String(key)},
+		},
+		ConditionExpression: aws.String(coalesceReleaseCondition),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":event_id": {S: aws.String(record.EventID)},
+		},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return
+	}
+	if err != nil {
+		log.Warnf("Releasing %s in %s: %v", key, table, err)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestClaimCoalesceWindow(t *testing.T) {
+	fake := withFakeDynamoDB(t)
+	setEnv(t, "DEDUPE_TABLE", "cloudtrail-alerts")
+	setEnv(t, "COALESCE_WINDOW", "10m")
+
+	ctx := context.Background()
+	start := time.Date(2021, 5, 14, 19, 0, 0, 0, time.UTC)
+	createTags := typedRecord(consoleRecord("CreateTags", "event-1"))
+	deleteTags := typedRecord(consoleRecord("DeleteTags", "event-2"))
+	other := consoleRecord("CreateTags", "event-3")
+	other["userIdentity"].(map[string]interface{})["principalId"] = "AIDAOTHERUSER"
+	otherUser := typedRecord(other)
+
+	steps := []struct {
+		record *CloudTrailRecord
+		at     time.Duration
+		want   bool
+	}{
+		{createTags, 0, true},
+		{createTags, 5 * time.Minute, false},
+		{deleteTags, 5 * time.Minute, true},
+		{otherUser, 5 * time.Minute, true},
+		{createTags, 9 * time.Minute, false},
+		// Beyond the window the next alert opens a new one.
+		{createTags, 11 * time.Minute, true},
+		{createTags, 20 * time.Minute, false},
+	}
+	for _, step := range steps {
+		if got := claimCoalesceWindow(ctx, step.record, start.Add(step.at)); got != step.want {
+			t.Errorf("%s %s after %s: claimCoalesceWindow() = %v, want %v", step.record.EventName, step.record.UserIdentity.PrincipalID, step.at, got, step.want)
+		}
+	}
+
+	// The marker of the window opened after 11 minutes.
+	until := strconv.FormatInt(start.Add(21*time.Minute).Unix(), 10)
+	item := fake.items["coalesce#012345678901#AIDAJU2GYCKZ322Y5JOKC#CreateTags"]
+	if item == nil || *item["coalesce_until"].N != until || *item["expires_at"].N != until {
+		t.Errorf("unexpected marker %v", item)
+	}
+}
+
+func TestCoalesceKeyAccount(t *testing.T) {
+	root := func(account string) *CloudTrailRecord {
+		record := consoleRecord("CreateTags", "event-1")
+		record["userIdentity"] = map[string]interface{}{"type": "Root", "accountId": account}
+		return typedRecord(record)
+	}
+	if a, b := coalesceKey(root("012345678901")), coalesceKey(root("210987654321")); a == b {
+		t.Errorf("actors of different accounts share the key %s", a)
+	}
+}
+
+func TestCheckCoalesceConfig(t *testing.T) {
+	hook := logtest.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+
+	setEnv(t, "COALESCE_WINDOW", "10m")
+	checkCoalesceConfig()
+	if entry := hook.LastEntry(); entry == nil || entry.Level != logrus.WarnLevel {
+		t.Errorf("expected a warning without DEDUPE_TABLE, got %v", entry)
+	}
+
+	hook.Reset()
+	setEnv(t, "DEDUPE_TABLE", "cloudtrail-alerts")
+	checkCoalesceConfig()
+	if n := len(hook.AllEntries()); n != 0 {
+		t.Errorf("expected no warning with DEDUPE_TABLE, got %d entries", n)
+	}
+}
+
+func TestClaimCoalesceWindowDisabled(t *testing.T) {
+	fake := withFakeDynamoDB(t)
+	setEnv(t, "DEDUPE_TABLE", "cloudtrail-alerts")
+
+	record := typedRecord(consoleRecord("CreateTags", "event-1"))
+	for _, window := range []string{"", "soon"} {
+		setEnv(t, "COALESCE_WINDOW", window)
+		for i := 0; i < 2; i++ {
+			if !claimCoalesceWindow(context.Background(), record, time.Now()) {
+				t.Errorf("COALESCE_WINDOW=%q: expected every record to alert", window)
+			}
+		}
+	}
+	if len(fake.inputs) != 0 {
+		t.Errorf("expected no markers, got %d", len(fake.inputs))
+	}
+}
+
+func TestFilterRecordsCoalesce(t *testing.T) {
+	alerts := captureAlerts(t)
+	withFakeDynamoDB(t)
+	setEnv(t, "DEDUPE_TABLE", "cloudtrail-alerts")
+	setEnv(t, "COALESCE_WINDOW", "5m")
+
+	logFile := cloudTrailFile(consoleRecord("CreateTags", "event-1"), consoleRecord("CreateTags", "event-2"), consoleRecord("DeleteTags", "event-3"))
+	if _, err := FilterRecords(context.Background(), logFile.Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+
+	names := map[string]int{}
+	for _, alert := range alerts.alerts {
+		names[alert.EventName]++
+	}
+	if len(alerts.alerts) != 2 || names["CreateTags"] != 1 || names["DeleteTags"] != 1 {
+		t.Errorf("expected one CreateTags and one DeleteTags alert, got %v", names)
+	}
+}
+
+func TestClaimCoalesceWindowDryRun(t *testing.T) {
+	fake := withFakeDynamoDB(t)
+	setEnv(t, "DEDUPE_TABLE", "cloudtrail-alerts")
+	setEnv(t, "COALESCE_WINDOW", "10m")
+	setEnv(t, "DRY_RUN", "true")
+
+	record := typedRecord(consoleRecord("CreateTags", "event-1"))
+	for i := 0; i < 2; i++ {
+		if !claimCoalesceWindow(context.Background(), record, time.Now()) {
+			t.Error("expected every record to alert in a dry run")
+		}
+	}
+	if len(fake.inputs) != 0 {
+		t.Errorf("expected no markers under DRY_RUN, got %d", len(fake.inputs))
+	}
+}
+
+func TestFilterRecordsCoalesceReleasesFailedNotification(t *testing.T) {
+	fake := withFakeDynamoDB(t)
+	alerts := captureAlerts(t)
+	setEnv(t, "DEDUPE_TABLE", "cloudtrail-alerts")
+	setEnv(t, "COALESCE_WINDOW", "5m")
+
+	// The failed CreateTags doesn't hold the window for the next one.
+	alerts.err = errors.New("webhook down")
+	for i, id := range []string{"event-1", "event-2"} {
+		if i > 0 {
+			alerts.err = nil
+		}
+		if _, err := FilterRecords(withNotifyFailures(context.Background()), cloudTrailFile(consoleRecord("CreateTags", id)).Stream(), testS3Record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(alerts.alerts) != 2 {
+		t.Errorf("expected both events to alert, got %d alerts", len(alerts.alerts))
+	}
+	item := fake.items[coalesceKey(typedRecord(consoleRecord("CreateTags", "event-2")))]
+	if item == nil || aws.StringValue(item["first_event_id"].S) != "event-2" {
+		t.Errorf("expected event-2 to hold the window, got %v", item)
+	}
+}
+
+func TestReleaseCoalesceWindowOfOtherRecord(t *testing.T) {
+	fake := withFakeDynamoDB(t)
+	setEnv(t, "DEDUPE_TABLE", "cloudtrail-alerts")
+	setEnv(t, "COALESCE_WINDOW", "5m")
+
+	first := typedRecord(consoleRecord("CreateTags", "event-1"))
+	claimCoalesceWindow(context.Background(), first, time.Now())
+	releaseCoalesceWindow(context.Background(), typedRecord(consoleRecord("CreateTags", "event-2")))
+	if _, ok := fake.items[coalesceKey(first)]; !ok {
+		t.Error("expected the window of event-1 to stay")
+	}
+}
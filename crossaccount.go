@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// CrossAccountAssumeRole is an AssumeRole call where the caller and the role
+// live in different accounts.
+type CrossAccountAssumeRole struct {
+	SourceAccount string
+	TargetAccount string
+	RoleArn       string
+	Known         bool
+}
+
+// ParseCrossAccountAssumeRole compares the caller account with the account of
+// requestParameters.roleArn. It returns nil for same-account calls, service
+// principals and any other event.
+func ParseCrossAccountAssumeRole(record *CloudTrailRecord) *CrossAccountAssumeRole {
+	if record.EventName != "AssumeRole" {
+		return nil
+	}
+
+	source := record.UserIdentity.AccountID
+	roleArn, _ := record.RequestParameters["roleArn"].(string)
+	target := principalAccount(roleArn)
+	if source == "" || target == "" || source == target {
+		return nil
+	}
+
+	known := knownAccounts()
+	return &CrossAccountAssumeRole{
+		SourceAccount: source,
+		TargetAccount: target,
+		RoleArn:       roleArn,
+		Known:         known[source] && known[target],
+	}
+}
+
+func (c *CrossAccountAssumeRole) Severity() string {
+	if c.Known {
+		return severityWarn
+	}
+	return severityCritical
+}
+
+func (c *CrossAccountAssumeRole) Summary() string {
+	s := fmt.Sprintf("Cross-account AssumeRole from %s into %s (%s)", c.SourceAccount, c.TargetAccount, c.RoleArn)
+	if !c.Known {
+		s += ", outside known accounts"
+	}
+	return s
+}
+
+func knownAccounts() map[string]bool {
+	known := map[string]bool{}
+	for _, id := range strings.Split(os.Getenv("KNOWN_ACCOUNT_IDS"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			known[id] = true
+		}
+	}
+	return known
+}
+
+// recipientAccountID returns the recipientAccountId of a record made by a
+// principal of another account, empty for same-account records and those
+// without either account.
+func recipientAccountID(record *CloudTrailRecord) string {
+	actor := record.UserIdentity.AccountID
+	if actor == "" || record.RecipientAccountID == "" || actor == record.RecipientAccountID {
+		return ""
+	}
+	return record.RecipientAccountID
+}
+
+// recipientAccountSummary renders the recipient account with its
+// SLACK_NAME_<accountId>, if any, e.g. "Recipient account: Production
+// (210987654321)".
+func recipientAccountSummary(accountID string) string {
+	if accountID == "" {
+		return ""
+	}
+	if name := os.Getenv("SLACK_NAME_" + accountID); name != "" {
+		return fmt.Sprintf("Recipient account: %s (%s)", name, accountID)
+	}
+	return "Recipient account: " + accountID
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func assumeRoleRecord(callerAccount, roleArn string) map[string]interface{} {
+	record := consoleRecord("AssumeRole", "assume-1")
+	record["eventSource"] = "sts.amazonaws.com"
+	record["userAgent"] = "aws-cli/2.2.5 Python/3.8.8"
+	record["requestParameters"] = map[string]interface{}{
+		"roleArn":         roleArn,
+		"roleSessionName": "first.last",
+	}
+	record["userIdentity"].(map[string]interface{})["accountId"] = callerAccount
+	return record
+}
+
+func TestParseCrossAccountAssumeRole(t *testing.T) {
+	setEnv(t, "KNOWN_ACCOUNT_IDS", "111111111111, 222222222222")
+
+	tests := []struct {
+		name     string
+		record   map[string]interface{}
+		cross    bool
+		severity string
+	}{
+		{name: "same account", record: assumeRoleRecord("111111111111", "arn:aws:iam::111111111111:role/Admin")},
+		{name: "known accounts", record: assumeRoleRecord("111111111111", "arn:aws:iam::222222222222:role/Admin"), cross: true, severity: severityWarn},
+		{name: "external account", record: assumeRoleRecord("111111111111", "arn:aws:iam::999999999999:role/Admin"), cross: true, severity: severityCritical},
+		{name: "service principal", record: assumeRoleRecord("", "arn:aws:iam::222222222222:role/Task")},
+		{name: "other event", record: consoleRecord("CreateTags", "x")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := ParseCrossAccountAssumeRole(typedRecord(tt.record))
+			if (c != nil) != tt.cross {
+				t.Fatalf("got %+v, want cross-account %v", c, tt.cross)
+			}
+			if c == nil {
+				return
+			}
+			if c.Severity() != tt.severity {
+				t.Errorf("Severity() = %s, want %s", c.Severity(), tt.severity)
+			}
+			if !strings.Contains(c.Summary(), c.SourceAccount) || !strings.Contains(c.Summary(), c.TargetAccount) {
+				t.Errorf("summary missing accounts: %s", c.Summary())
+			}
+		})
+	}
+}
+
+func TestCrossAccountAssumeRoleAlerts(t *testing.T) {
+	records := func() *CloudTrailFile {
+		return cloudTrailFile(
+			assumeRoleRecord("111111111111", "arn:aws:iam::999999999999:role/Admin"),
+			assumeRoleRecord("111111111111", "arn:aws:iam::111111111111:role/Admin"),
+		)
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		slack := captureSlack(t)
+		if _, err := FilterRecords(context.Background(), records().Stream(), testS3Record); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(slack.Bodies()); n != 0 {
+			t.Errorf("expected no messages, got %d", n)
+		}
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		slack := captureSlack(t)
+		setEnv(t, "ALERT_CROSS_ACCOUNT_ASSUME_ROLE", "true")
+		if _, err := FilterRecords(context.Background(), records().Stream(), testS3Record); err != nil {
+			t.Fatal(err)
+		}
+		bodies := slack.Bodies()
+		if len(bodies) != 1 {
+			t.Fatalf("expected 1 message, got %d", len(bodies))
+		}
+		if !strings.Contains(bodies[0], "from 111111111111 into 999999999999") {
+			t.Errorf("message missing accounts: %s", bodies[0])
+		}
+	})
+}
+
+func TestFilterRecordsRecipientAccount(t *testing.T) {
+	tests := []struct {
+		name      string
+		recipient string
+		wantID    string
+		want      string
+	}{
+		{"same account", "012345678901", "", ""},
+		{"no recipient", "", "", ""},
+		{"cross account", "210987654321", "210987654321", "Recipient account: 210987654321"},
+		{"labelled cross account", "111111111111", "111111111111", "Recipient account: Production (111111111111)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slack := captureSlack(t)
+			setEnv(t, "SLACK_NAME_111111111111", "Production")
+
+			record := consoleRecord("CreateTags", "event-1")
+			if tt.recipient != "" {
+				record["recipientAccountId"] = tt.recipient
+			}
+			if got := recipientAccountID(typedRecord(record)); got != tt.wantID {
+				t.Errorf("recipientAccountID() = %q", got)
+			}
+
+			logFile := cloudTrailFile(record)
+			if _, err := FilterRecords(context.Background(), logFile.Stream(), testS3Record); err != nil {
+				t.Fatal(err)
+			}
+
+			bodies := slack.Bodies()
+			if len(bodies) != 1 {
+				t.Fatalf("expected 1 message, got %d", len(bodies))
+			}
+			if tt.want == "" {
+				if strings.Contains(bodies[0], "Recipient account") {
+					t.Errorf("expected no recipient account, got %s", bodies[0])
+				}
+				return
+			}
+			if !strings.Contains(bodies[0], tt.want) {
+				t.Errorf("expected %q, got %s", tt.want, bodies[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// notifiedEvents is the set of event ids already notified in an invocation.
+type notifiedEvents struct {
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+type notifiedEventsKey struct{}
+
+// withEventDedupe attaches a set of notified event ids to ctx unless it
+// already carries one, so duplicates are skipped across every object of an
+// invocation.
+func withEventDedupe(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(notifiedEventsKey{}).(*notifiedEvents); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, notifiedEventsKey{}, &notifiedEvents{seen: map[string]bool{}})
+}
+
+// firstNotification records eventID as notified and reports whether it was
+// seen for the first time. Records without an event id are never treated as
+// duplicates.
+func firstNotification(ctx context.Context, eventID string) bool {
+	n, ok := ctx.Value(notifiedEventsKey{}).(*notifiedEvents)
+	if !ok || eventID == "" {
+		return true
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.seen[eventID] {
+		return false
+	}
+	n.seen[eventID] = true
+	return true
+}
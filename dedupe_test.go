@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestFilterRecordsDeduplicatesEventIDs(t *testing.T) {
+	slack := captureSlack(t)
+
+	logFile := cloudTrailFile(
+		consoleRecord("CreateTags", "event-1"),
+		consoleRecord("CreateTags", "event-1"),
+		consoleRecord("CreateTags", "event-2"),
+	)
+	if _, err := FilterRecords(context.Background(), logFile.Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(slack.Bodies()); n != 2 {
+		t.Errorf("expected 2 messages, got %d", n)
+	}
+}
+
+func TestEventDedupeSpansInvocation(t *testing.T) {
+	slack := captureSlack(t)
+	ctx := withEventDedupe(context.Background())
+
+	// The same event delivered in two objects of one invocation.
+	for i := 0; i < 2; i++ {
+		if _, err := FilterRecords(ctx, cloudTrailFile(consoleRecord("CreateTags", "event-1")).Stream(), testS3Record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(slack.Bodies()); n != 1 {
+		t.Errorf("expected 1 message, got %d", n)
+	}
+
+	// Records without an id are never deduplicated.
+	if !firstNotification(ctx, "") || !firstNotification(ctx, "") {
+		t.Error("empty event ids should not be deduplicated")
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Detection is a high-signal rule that alerts bypassing the read-only and
+// user agent suppression, though not the operator's ignores such as
+// IGNORE_PRINCIPALS.
+type Detection struct {
+	Name     string
+	Severity string
+	Match    func(record *CloudTrailRecord) bool
+}
+
+// Detections shipped with ENABLE_DEFAULT_DETECTIONS=true. Each can be
+// disabled or given another severity with DETECTION_<Name>=off|info|warn|critical.
+var defaultDetections = []Detection{
+	{Name: "ROOT_USAGE", Severity: severityCritical, Match: detectRootUsage},
+	{Name: "CLOUDTRAIL_TAMPERING", Severity: severityCritical, Match: detectCloudTrailTampering},
+	{Name: "PUBLIC_S3_EXPOSURE", Severity: severityCritical, Match: detectPublicS3Exposure},
+	{Name: "OPEN_SECURITY_GROUP", Severity: severityWarn, Match: detectOpenSecurityGroup},
+	{Name: "NEW_ACCESS_KEY", Severity: severityWarn, Match: detectNewAccessKey},
+	{Name: "IAM_ADMIN_GRANT", Severity: severityCritical, Match: detectIAMAdminGrant},
+}
+
+// MatchDetections returns the enabled detections matching a record, followed
+// by the PARAM_MATCH_RULES it matches.
+func MatchDetections(record *CloudTrailRecord) []Detection {
+	matched := matchDefaultDetections(record)
+	return append(matched, activeParamMatchRules().Match(record)...)
+}
+
+func matchDefaultDetections(record *CloudTrailRecord) []Detection {
+	if !getEnvBool("ENABLE_DEFAULT_DETECTIONS", false) {
+		return nil
+	}
+
+	var matched []Detection
+	for _, d := range defaultDetections {
+		switch override := strings.ToLower(os.Getenv(fmt.Sprintf("DETECTION_%s", d.Name))); override {
+		case "off", "false", "disabled":
+			continue
+		case severityInfo, severityWarn, severityCritical:
+			d.Severity = override
+		}
+
+		if d.Match(record) {
+			matched = append(matched, d)
+		}
+	}
+	return matched
+}
+
+func detectRootUsage(record *CloudTrailRecord) bool {
+	return record.UserIdentity.Type == "Root" && record.UserIdentity.InvokedBy == ""
+}
+
+func detectCloudTrailTampering(record *CloudTrailRecord) bool {
+	if record.EventSource != "cloudtrail.amazonaws.com" {
+		return false
+	}
+	switch record.EventName {
+	case "StopLogging", "DeleteTrail", "UpdateTrail", "PutEventSelectors", "DeleteEventDataStore":
+		return true
+	}
+	return false
+}
+
+func detectPublicS3Exposure(record *CloudTrailRecord) bool {
+	exposure := AssessBucketExposure(record)
+	return exposure != nil && exposure.Public
+}
+
+func detectOpenSecurityGroup(record *CloudTrailRecord) bool {
+	switch record.EventName {
+	case "AuthorizeSecurityGroupIngress", "AuthorizeSecurityGroupEgress":
+	default:
+		return false
+	}
+
+	permissions, _ := record.RequestParameters["ipPermissions"].(map[string]interface{})
+	for _, permission := range objectOrList(permissions["items"]) {
+		for _, ranges := range []string{"ipRanges", "ipv6Ranges"} {
+			r, _ := permission[ranges].(map[string]interface{})
+			for _, item := range objectOrList(r["items"]) {
+				if item["cidrIp"] == "0.0.0.0/0" || item["cidrIpv6"] == "::/0" {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func detectNewAccessKey(record *CloudTrailRecord) bool {
+	return record.EventSource == "iam.amazonaws.com" && record.EventName == "CreateAccessKey"
+}
+
+func detectIAMAdminGrant(record *CloudTrailRecord) bool {
+	if record.EventSource != "iam.amazonaws.com" {
+		return false
+	}
+
+	rps := record.RequestParameters
+	switch record.EventName {
+	case "AttachUserPolicy", "AttachRolePolicy", "AttachGroupPolicy":
+		return rps["policyArn"] == "arn:aws:iam::aws:policy/AdministratorAccess"
+	case "PutUserPolicy", "PutRolePolicy", "PutGroupPolicy":
+		doc, _ := rps["policyDocument"].(string)
+		return grantsAdmin(doc)
+	}
+	return false
+}
+
+// grantsAdmin reports whether a policy document allows every action on
+// every resource.
+func grantsAdmin(doc string) bool {
+	statements, err := policyStatements([]byte(doc))
+	if err != nil {
+		return false
+	}
+
+	for _, statement := range statements {
+		if statement.Effect == "Allow" && containsString(stringOrList(statement.Action), "*") && containsString(stringOrList(statement.Resource), "*") {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestDefaultDetections(t *testing.T) {
+	setEnv(t, "ENABLE_DEFAULT_DETECTIONS", "true")
+
+	root := consoleRecord("GetAccountSummary", "root-1")
+	root["userIdentity"] = map[string]interface{}{"type": "Root", "principalId": "012345678901", "accountId": "012345678901"}
+
+	stopLogging := consoleRecord("StopLogging", "trail-1")
+	stopLogging["eventSource"] = "cloudtrail.amazonaws.com"
+
+	publicBucket := consoleRecord("PutBucketAcl", "s3-1")
+	publicBucket["eventSource"] = "s3.amazonaws.com"
+	publicBucket["requestParameters"] = map[string]interface{}{"bucketName": "b", "x-amz-acl": []interface{}{"public-read"}}
+
+	openGroup := consoleRecord("AuthorizeSecurityGroupIngress", "sg-1")
+	openGroup["requestParameters"] = map[string]interface{}{
+		"groupId": "sg-0123",
+		"ipPermissions": map[string]interface{}{
+			"items": []interface{}{
+				map[string]interface{}{
+					"ipProtocol": "tcp",
+					"fromPort":   22,
+					"toPort":     22,
+					"ipRanges": map[string]interface{}{
+						"items": []interface{}{map[string]interface{}{"cidrIp": "0.0.0.0/0"}},
+					},
+				},
+			},
+		},
+	}
+
+	scopedGroup := consoleRecord("AuthorizeSecurityGroupIngress", "sg-2")
+	scopedGroup["requestParameters"] = map[string]interface{}{
+		"ipPermissions": map[string]interface{}{
+			"items": []interface{}{
+				map[string]interface{}{
+					"ipRanges": map[string]interface{}{
+						"items": []interface{}{map[string]interface{}{"cidrIp": "10.0.0.0/8"}},
+					},
+				},
+			},
+		},
+	}
+
+	accessKey := consoleRecord("CreateAccessKey", "iam-1")
+	accessKey["eventSource"] = "iam.amazonaws.com"
+
+	adminAttach := consoleRecord("AttachRolePolicy", "iam-2")
+	adminAttach["eventSource"] = "iam.amazonaws.com"
+	adminAttach["requestParameters"] = map[string]interface{}{"roleName": "x", "policyArn": "arn:aws:iam::aws:policy/AdministratorAccess"}
+
+	adminInline := consoleRecord("PutUserPolicy", "iam-3")
+	adminInline["eventSource"] = "iam.amazonaws.com"
+	adminInline["requestParameters"] = map[string]interface{}{
+		"userName":       "x",
+		"policyDocument": `{"Version":"2012-10-17","Statement":{"Effect":"Allow","Action":"*","Resource":"*"}}`,
+	}
+
+	scopedInline := consoleRecord("PutUserPolicy", "iam-4")
+	scopedInline["eventSource"] = "iam.amazonaws.com"
+	scopedInline["requestParameters"] = map[string]interface{}{
+		"policyDocument": `{"Statement":[{"Effect":"Allow","Action":"s3:GetObject","Resource":"*"}]}`,
+	}
+
+	tests := []struct {
+		record map[string]interface{}
+		want   string
+	}{
+		{root, "ROOT_USAGE"},
+		{stopLogging, "CLOUDTRAIL_TAMPERING"},
+		{publicBucket, "PUBLIC_S3_EXPOSURE"},
+		{openGroup, "OPEN_SECURITY_GROUP"},
+		{scopedGroup, ""},
+		{accessKey, "NEW_ACCESS_KEY"},
+		{adminAttach, "IAM_ADMIN_GRANT"},
+		{adminInline, "IAM_ADMIN_GRANT"},
+		{scopedInline, ""},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		for _, d := range MatchDetections(typedRecord(tt.record)) {
+			got = append(got, d.Name)
+		}
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("%s: got detections %v, want %q", tt.record["eventID"], got, tt.want)
+		}
+	}
+}
+
+func TestDefaultDetectionsDisabled(t *testing.T) {
+	accessKey := consoleRecord("CreateAccessKey", "iam-1")
+	accessKey["eventSource"] = "iam.amazonaws.com"
+
+	if d := MatchDetections(typedRecord(accessKey)); len(d) != 0 {
+		t.Errorf("detections should be off by default, got %v", d)
+	}
+}
+
+func TestDefaultDetectionsOverride(t *testing.T) {
+	setEnv(t, "ENABLE_DEFAULT_DETECTIONS", "true")
+	setEnv(t, "DETECTION_NEW_ACCESS_KEY", "critical")
+	setEnv(t, "DETECTION_CLOUDTRAIL_TAMPERING", "off")
+
+	accessKey := consoleRecord("CreateAccessKey", "iam-1")
+	accessKey["eventSource"] = "iam.amazonaws.com"
+	if d := MatchDetections(typedRecord(accessKey)); len(d) != 1 || d[0].Severity != severityCritical {
+		t.Errorf("expected a critical NEW_ACCESS_KEY detection, got %v", d)
+	}
+
+	stopLogging := consoleRecord("StopLogging", "trail-1")
+	stopLogging["eventSource"] = "cloudtrail.amazonaws.com"
+	if d := MatchDetections(typedRecord(stopLogging)); len(d) != 0 {
+		t.Errorf("expected CLOUDTRAIL_TAMPERING to be disabled, got %v", d)
+	}
+}
+
+func TestDetectionBypassesSuppression(t *testing.T) {
+	slack := captureSlack(t)
+	setEnv(t, "ENABLE_DEFAULT_DETECTIONS", "true")
+
+	// A read-only call from the CLI would normally be dropped twice over.
+	root := consoleRecord("GetAccountSummary", "root-1")
+	root["userAgent"] = "aws-cli/2.2.5"
+	root["userIdentity"] = map[string]interface{}{"type": "Root", "principalId": "012345678901", "accountId": "012345678901"}
+
+	logFile := cloudTrailFile(root, consoleRecord("GetUser", "user-1"))
+	if _, err := FilterRecords(context.Background(), logFile.Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := slack.Bodies()
+	if len(bodies) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(bodies))
+	}
+	for _, want := range []string{"Detections: ROOT_USAGE", "*Severity:* critical"} {
+		if !strings.Contains(bodies[0], want) {
+			t.Errorf("message missing %q: %s", want, bodies[0])
+		}
+	}
+}
+
+func TestDetectionSeverityKeptByExposure(t *testing.T) {
+	slack := captureSlack(t)
+	setEnv(t, "ENABLE_DEFAULT_DETECTIONS", "true")
+
+	// The root user's private bucket policy assesses as info, which must
+	// not lower the critical ROOT_USAGE detection.
+	policy := consoleRecord("PutBucketPolicy", "root-1")
+	policy["eventSource"] = "s3.amazonaws.com"
+	policy["userIdentity"] = map[string]interface{}{"type": "Root", "principalId": "012345678901", "accountId": "012345678901"}
+	policy["requestParameters"] = map[string]interface{}{
+		"bucketName": "b",
+		"bucketPolicy": map[string]interface{}{
+			"Statement": []interface{}{map[string]interface{}{"Effect": "Deny", "Principal": "*", "Action": "s3:*"}},
+		},
+	}
+
+	logFile := cloudTrailFile(policy)
+	if _, err := FilterRecords(context.Background(), logFile.Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := slack.Bodies()
+	if len(bodies) != 1 || !strings.Contains(bodies[0], "*Severity:* critical") {
+		t.Errorf("expected a critical message, got %v", bodies)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+var discordEmbedColors = map[string]int{
+	severityInfo:     0x0076D7,
+	severityWarn:     0xFFA500,
+	severityCritical: 0xD32F2F,
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+// discordEmbed renders an event as a Discord webhook message with a single
+// embed.
+func discordEmbed(alert AlertEvent) ([]byte, error) {
+	fields := []discordField{
+		{Name: "Account", Value: alert.Account, Inline: true},
+		{Name: "Region", Value: alert.Region, Inline: true},
+	}
+	if len(alert.Details) > 0 {
+		fields = append(fields, discordField{
+			Name:  "Severity: " + alert.Severity,
+			Value: strings.Join(alert.Details, "\n"),
+		})
+	}
+	if len(alert.Resources) > 0 {
+		fields = append(fields, discordField{
+			Name:  "Resources",
+			Value: strings.TrimPrefix(resourcesSummary(alert.Resources), "Resources: "),
+		})
+	}
+	fields = append(fields, discordField{Name: "CloudTrail", Value: fmt.Sprintf("[View event](%s)", alert.EventURL)})
+
+	// Discord rejects empty field values.
+	for i := range fields {
+		if fields[i].Value == "" {
+			fields[i].Value = "-"
+		}
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"embeds": []interface{}{
+			map[string]interface{}{
+				"title":       alert.EventName,
+				"description": fmt.Sprintf("%s by %s", alert.EventSource, alert.UserName),
+				"url":         alert.EventURL,
+				"color":       discordEmbedColors[alert.Severity],
+				"fields":      fields,
+				"timestamp":   alert.EventTime,
+			},
+		},
+	})
+}
+
+func SendDiscordNotification(ctx context.Context, webhookUrl string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookUrl, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Content-Type", "application/json")
+
+	client := notifyHTTPClient()
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer closeResponse(resp)
+
+	// Discord answers with 204 No Content, or 200 and the message when the
+	// webhook URL asks to wait for it.
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		buf := new(bytes.Buffer)
+		buf.ReadFrom(io.LimitReader(resp.Body, maxErrorBodyLength+1))
+		return fmt.Errorf("Non-ok response returned from Discord: %d %s", resp.StatusCode, truncate(buf.String(), maxErrorBodyLength))
+	}
+	return nil
+}
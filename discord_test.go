@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestDiscordEmbed(t *testing.T) {
+	body, err := discordEmbed(AlertEvent{
+		EventName:   "PutUserPolicy",
+		EventSource: "iam.amazonaws.com",
+		EventTime:   "2021-05-14T19:03:40Z",
+		Region:      "us-east-1",
+		Account:     ":maple_leaf: NON-PRD",
+		UserName:    "first.last",
+		EventURL:    "https://console.aws.amazon.com/cloudtrail/home?region=us-east-1#/events?EventId=event-1",
+		Severity:    severityWarn,
+		Details:     []string{"Detections: IAM_ADMIN_GRANT"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var message struct {
+		Embeds []struct {
+			Title       string         `json:"title"`
+			Description string         `json:"description"`
+			URL         string         `json:"url"`
+			Color       int            `json:"color"`
+			Timestamp   string         `json:"timestamp"`
+			Fields      []discordField `json:"fields"`
+		} `json:"embeds"`
+	}
+	if err := json.Unmarshal(body, &message); err != nil {
+		t.Fatal(err)
+	}
+	if len(message.Embeds) != 1 {
+		t.Fatalf("expected 1 embed, got %d", len(message.Embeds))
+	}
+	embed := message.Embeds[0]
+
+	if embed.Title != "PutUserPolicy" || embed.Description != "iam.amazonaws.com by first.last" || embed.Color != discordEmbedColors[severityWarn] {
+		t.Errorf("unexpected embed header %+v", embed)
+	}
+	if !strings.HasSuffix(embed.URL, "EventId=event-1") || embed.Timestamp != "2021-05-14T19:03:40Z" {
+		t.Errorf("unexpected url %q or timestamp %q", embed.URL, embed.Timestamp)
+	}
+
+	fields := map[string]string{}
+	for _, f := range embed.Fields {
+		fields[f.Name] = f.Value
+	}
+	want := map[string]string{
+		"Account":                   ":maple_leaf: NON-PRD",
+		"Region":                    "us-east-1",
+		"Severity: " + severityWarn: "Detections: IAM_ADMIN_GRANT",
+		"CloudTrail":                "[View event](https://console.aws.amazon.com/cloudtrail/home?region=us-east-1#/events?EventId=event-1)",
+	}
+	for k, v := range want {
+		if fields[k] != v {
+			t.Errorf("field %s = %q, want %q", k, fields[k], v)
+		}
+	}
+}
+
+func TestDiscordEmbedFillsEmptyFields(t *testing.T) {
+	body, err := discordEmbed(AlertEvent{EventName: "CreateTags", Severity: severityInfo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(body), `"value":""`) {
+		t.Errorf("empty field value in %s", body)
+	}
+}
+
+func TestSendDiscordNotification(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr bool
+	}{
+		{"no content", http.StatusNoContent, "", false},
+		{"wait", http.StatusOK, `{"id":"1"}`, false},
+		{"bad request", http.StatusBadRequest, `{"embeds":["0"]}`, true},
+		{"rate limited", http.StatusTooManyRequests, `{"retry_after":1.5}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			err := SendDiscordNotification(context.Background(), srv.URL, []byte(`{}`))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SendDiscordNotification() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFilterRecordsDiscord(t *testing.T) {
+	var mu sync.Mutex
+	var titles []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var message struct {
+			Embeds []struct {
+				Title string `json:"title"`
+			} `json:"embeds"`
+		}
+		json.NewDecoder(r.Body).Decode(&message)
+		mu.Lock()
+		for _, embed := range message.Embeds {
+			titles = append(titles, embed.Title)
+		}
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	setEnv(t, "DISCORD_WEBHOOK", srv.URL)
+
+	logFile := cloudTrailFile(consoleRecord("CreateTags", "event-1"))
+	if _, err := FilterRecords(context.Background(), logFile.Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+	if len(titles) != 1 || titles[0] != "CreateTags" {
+		t.Errorf("expected 1 Discord embed, got %v", titles)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	log "github.com/sirupsen/logrus"
+)
+
+// How long an event id is remembered in DEDUPE_TABLE unless DEDUPE_TTL is
+// set.
+const defaultDedupeTTL = 24 * time.Hour
+
+var (
+	dedupeClientMu sync.Mutex
+	dedupeClient   dynamodbiface.DynamoDBAPI
+)
+
+func dedupeDynamoDB() dynamodbiface.DynamoDBAPI {
+	dedupeClientMu.Lock()
+	defer dedupeClientMu.Unlock()
+	if dedupeClient == nil {
+		client := dynamodb.New(session.Must(session.NewSession()))
+		traceAWSClient(client.Client)
+		dedupeClient = client
+	}
+	return dedupeClient
+}
+
+func dedupeTTL() time.Duration {
+	if v := os.Getenv("DEDUPE_TTL"); v != "" {
+		if ttl, err := time.ParseDuration(v); err == nil && ttl > 0 {
+			return ttl
+		}
+		log.Warnf("Ignoring invalid DEDUPE_TTL %q", v)
+	}
+	return defaultDedupeTTL
+}
+
+// claimNotification records eventID in DEDUPE_TABLE and reports whether no
+// earlier invocation had, so an event delivered twice is notified once. The
+// table's partition key is the string event_id, and expires_at is the epoch
+// second to expire the item at with DynamoDB's TTL. Without DEDUPE_TABLE, in
+// a dry run or when the table can't be written, every event is notified.
+func claimNotification(ctx context.Context, eventID string) bool {
+	table := os.Getenv("DEDUPE_TABLE")
+	if table == "" || eventID == "" || isDryRun(ctx) {
+		return true
+	}
+
+	expiresAt := time.Now().Add(dedupeTTL()).Unix()
+	_, err := dedupeDynamoDB().PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"event_id":   {S: aws.String(eventID)},
+			"expires_at": {N: aws.String(strconv.FormatInt(expiresAt, 10))},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(event_id)"),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return false
+	}
+	if err != nil {
+		// A duplicate alert is better than a missed one.
+		log.Warnf("Recording %s in %s: %v", eventID, table, err)
+	}
+	return true
+}
+
+// releaseNotification removes the claim of eventID from DEDUPE_TABLE after
+// its notification failed, so it isn't skipped when the event is delivered
+// again.
+func releaseNotification(ctx context.Context, eventID string) {
+	table := os.Getenv("DEDUPE_TABLE")
+	if table == "" || eventID == "" || isDryRun(ctx) {
+		return
+	}
+
+	_, err := dedupeDynamoDB().DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"event_id": {S: aws.String(eventID)},
+		},
+	})
+	if err != nil {
+		log.Warnf("Releasing %s in %s: %v", eventID, table, err)
+	}
+}
+
+// recordSlackMessage adds the channel and timestamp of the Slack message an
+// event was posted as to its DEDUPE_TABLE item, so the message can be found
+// again from the event id.
+func recordSlackMessage(ctx context.Context, eventID string, message slackMessage) {
+	table := os.Getenv("DEDUPE_TABLE")
+	if table == "" || eventID == "" || message.TS == "" {
+		return
+	}
+
+	expiresAt := time.Now().Add(dedupeTTL()).Unix()
+	_, err := dedupeDynamoDB().UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"event_id": {S: aws.String(eventID)},
+		},
+		// The item normally exists, unless it couldn't be claimed.
+		UpdateExpression: aws.String("SET slack_channel = :channel, slack_ts = :ts, expires_at = if_not_exists(expires_at, :expires_at)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":channel":    {S: aws.String(message.Channel)},
+			":ts":         {S: aws.String(message.TS)},
+			":expires_at": {N: aws.String(strconv.FormatInt(expiresAt, 10))},
+		},
+	})
+	if err != nil {
+		log.Warnf("Recording the Slack message of %s in %s: %v", eventID, table, err)
+	}
+}
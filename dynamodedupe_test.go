@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// fakeDynamoDB is a table of event ids honouring the condition of the put.
+type fakeDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	mu      sync.Mutex
+	items   map[string]map[string]*dynamodb.AttributeValue
+	inputs  []*dynamodb.PutItemInput
+	updates []*dynamodb.UpdateItemInput
+	deletes []*dynamodb.DeleteItemInput
+	err     error
+}
+
+func (f *fakeDynamoDB) PutItemWithContext(ctx aws.Context, in *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.inputs = append(f.inputs, in)
+	if f.err != nil {
+		return nil, f.err
+	}
+	id := aws.StringValue(in.Item["event_id"].S)
+	if item, ok := f.items[id]; ok {
+		failed := awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+		switch aws.StringValue(in.ConditionExpression) {
+		case "attribute_not_exists(event_id)":
+			return nil, failed
+		case coalesceCondition:
+			until, _ := strconv.ParseInt(aws.StringValue(item["coalesce_until"].N), 10, 64)
+			now, _ := strconv.ParseInt(aws.StringValue(in.ExpressionAttributeValues[":now"].N), 10, 64)
+			if until > now {
+				return nil, failed
+			}
+		}
+	}
+	f.items[id] = in.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (f *fakeDynamoDB) UpdateItemWithContext(ctx aws.Context, in *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.updates = append(f.updates, in)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func (f *fakeDynamoDB) DeleteItemWithContext(ctx aws.Context, in *dynamodb.DeleteItemInput, opts ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deletes = append(f.deletes, in)
+	if f.err != nil {
+		return nil, f.err
+	}
+	id := aws.StringValue(in.Key["event_id"].S)
+	if want := in.ExpressionAttributeValues[":event_id"]; want != nil {
+		if item, ok := f.items[id]; !ok || aws.StringValue(item["first_event_id"].S) != aws.StringValue(want.S) {
+			return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+		}
+	}
+	delete(f.items, id)
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func withFakeDynamoDB(t *testing.T) *fakeDynamoDB {
+	fake := &fakeDynamoDB{items: map[string]map[string]*dynamodb.AttributeValue{}}
+	dedupeClient = fake
+	t.Cleanup(func() { dedupeClient = nil })
+	return fake
+}
+
+func TestClaimNotification(t *testing.T) {
+	fake := withFakeDynamoDB(t)
+	setEnv(t, "DEDUPE_TABLE", "cloudtrail-alerts")
+	setEnv(t, "DEDUPE_TTL", "1h")
+
+	ctx := context.Background()
+	if !claimNotification(ctx, "event-1") {
+		t.Error("expected a first-seen event to be notified")
+	}
+	if claimNotification(ctx, "event-1") {
+		t.Error("expected an already-seen event to be skipped")
+	}
+	if !claimNotification(ctx, "event-2") {
+		t.Error("expected another event to be notified")
+	}
+
+	in := fake.inputs[0]
+	if aws.StringValue(in.TableName) != "cloudtrail-alerts" {
+		t.Errorf("TableName = %s", aws.StringValue(in.TableName))
+	}
+	expiresAt, _ := strconv.ParseInt(aws.StringValue(in.Item["expires_at"].N), 10, 64)
+	if d := time.Until(time.Unix(expiresAt, 0)); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("expected the item to expire in an hour, got %s", d)
+	}
+}
+
+func TestClaimNotificationFailsOpen(t *testing.T) {
+	fake := withFakeDynamoDB(t)
+	fake.err = errors.New("throttled")
+	setEnv(t, "DEDUPE_TABLE", "cloudtrail-alerts")
+
+	if !claimNotification(context.Background(), "event-1") {
+		t.Error("expected the event to be notified when the table can't be written")
+	}
+}
+
+func TestClaimNotificationDryRun(t *testing.T) {
+	fake := withFakeDynamoDB(t)
+	setEnv(t, "DEDUPE_TABLE", "cloudtrail-alerts")
+	setEnv(t, "DRY_RUN", "true")
+
+	for i := 0; i < 2; i++ {
+		if !claimNotification(context.Background(), "event-1") {
+			t.Error("expected every event to be notified in a dry run")
+		}
+	}
+	if len(fake.inputs) != 0 {
+		t.Errorf("expected no DEDUPE_TABLE writes under DRY_RUN, got %d", len(fake.inputs))
+	}
+}
+
+func TestFilterRecordsReleasesFailedNotification(t *testing.T) {
+	fake := withFakeDynamoDB(t)
+	alerts := captureAlerts(t)
+	setEnv(t, "DEDUPE_TABLE", "cloudtrail-alerts")
+
+	// The first delivery fails to notify, the redelivery alerts again.
+	alerts.err = errors.New("webhook down")
+	for i := 0; i < 2; i++ {
+		logFile := cloudTrailFile(consoleRecord("CreateTags", "event-1"))
+		if _, err := FilterRecords(withNotifyFailures(context.Background()), logFile.Stream(), testS3Record); err != nil {
+			t.Fatal(err)
+		}
+		alerts.err = nil
+	}
+
+	if len(alerts.alerts) != 2 {
+		t.Errorf("expected the redelivered event to alert again, got %d alerts", len(alerts.alerts))
+	}
+	if len(fake.deletes) != 1 || aws.StringValue(fake.deletes[0].Key["event_id"].S) != "event-1" {
+		t.Errorf("expected the failed claim to be released, got %v", fake.deletes)
+	}
+	if _, ok := fake.items["event-1"]; !ok {
+		t.Error("expected the notified event to stay claimed")
+	}
+}
+
+func TestFilterRecordsDynamoDBDedupe(t *testing.T) {
+	fake := withFakeDynamoDB(t)
+	slack := captureSlack(t)
+
+	// Without DEDUPE_TABLE the table isn't used.
+	logFile := cloudTrailFile(consoleRecord("CreateTags", "event-1"))
+	if _, err := FilterRecords(context.Background(), logFile.Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.inputs) != 0 {
+		t.Errorf("expected no DynamoDB calls, got %d", len(fake.inputs))
+	}
+
+	setEnv(t, "DEDUPE_TABLE", "cloudtrail-alerts")
+	// Separate calls stand for separate invocations, each with its own
+	// in-memory dedupe.
+	for i := 0; i < 2; i++ {
+		logFile := cloudTrailFile(consoleRecord("CreateTags", "event-2"), consoleRecord("CreateTags", "event-3"))
+		if _, err := FilterRecords(context.Background(), logFile.Stream(), testS3Record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bodies := slack.Bodies()
+	if len(bodies) != 3 || slackBodyFor(bodies, "event-2") == "" || slackBodyFor(bodies, "event-3") == "" {
+		t.Errorf("expected event-2 and event-3 to be notified once, got %d messages", len(bodies))
+	}
+	if len(fake.inputs) != 4 {
+		t.Errorf("expected 4 conditional puts, got %d", len(fake.inputs))
+	}
+}
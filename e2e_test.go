@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+)
+
+var slackEventIDPattern = regexp.MustCompile(`EventId=([^|"]+)\|`)
+
+// TestEndToEnd reads a gzipped log file of console and non-console events
+// from an in-memory S3 through Stream and checks which events reach Slack.
+func TestEndToEnd(t *testing.T) {
+	content, err := ioutil.ReadFile("testdata/e2e-cloudtrail.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		want []string
+	}{
+		{
+			name: "defaults",
+			want: []string{"e2e-console-create-tags", "e2e-s3console-put-bucket-policy"},
+		},
+		{
+			name: "non-SSO sign-ins",
+			env:  map[string]string{"ALERT_NON_SSO_SIGNIN": "true"},
+			want: []string{"e2e-console-create-tags", "e2e-s3console-put-bucket-policy", "e2e-signin-password"},
+		},
+		{
+			name: "all sign-ins",
+			env:  map[string]string{"WATCH_CONSOLE_LOGIN": "true"},
+			want: []string{"e2e-console-create-tags", "e2e-s3console-put-bucket-policy", "e2e-signin-password", "e2e-signin-sso"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				setEnv(t, k, v)
+			}
+			slack := captureSlack(t)
+			getter := &fakeS3Getter{objects: map[string][]byte{
+				"test-harness/" + testS3Record.S3.Object.Key: gzipBytes(t, content),
+			}}
+			withS3Getter(t, getter)
+
+			if err := Stream(context.Background(), testS3Record); err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, body := range slack.Bodies() {
+				m := slackEventIDPattern.FindStringSubmatch(body)
+				if m == nil {
+					t.Fatalf("no event id in %s", body)
+				}
+				got = append(got, m[1])
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("notified %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// errorCodeListed reports whether a failed record's errorCode is in the comma
+// separated list of the environment variable key.
+func errorCodeListed(record *CloudTrailRecord, key string) bool {
+	if record.ErrorCode == "" {
+		return false
+	}
+	for _, code := range strings.Split(os.Getenv(key), ",") {
+		if strings.TrimSpace(code) == record.ErrorCode {
+			return true
+		}
+	}
+	return false
+}
+
+// ignoredErrorCode reports whether the record failed with one of
+// IGNORE_ERROR_CODES, such as expected throttling.
+func ignoredErrorCode(record *CloudTrailRecord) bool {
+	return errorCodeListed(record, "IGNORE_ERROR_CODES")
+}
+
+// errorCodeAlert reports whether the record failed with one of
+// ALERT_ON_ERROR_CODES, which then alerts like an event of
+// ALWAYS_ALERT_EVENTS.
+func errorCodeAlert(record *CloudTrailRecord) bool {
+	return errorCodeListed(record, "ALERT_ON_ERROR_CODES")
+}
+
+// errorSummary describes why the call failed, or returns an empty string for
+// a successful one.
+func errorSummary(record *CloudTrailRecord) string {
+	if record.ErrorCode == "" {
+		return ""
+	}
+	if record.ErrorMessage == "" {
+		return "Error: " + record.ErrorCode
+	}
+	return "Error: " + record.ErrorCode + ": " + record.ErrorMessage
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestFilterRecordsErrorCodes(t *testing.T) {
+	slack := captureSlack(t)
+	setEnv(t, "ALERT_ON_ERROR_CODES", "AccessDenied, UnauthorizedOperation")
+	setEnv(t, "IGNORE_ERROR_CODES", "ThrottlingException")
+
+	// Read-only and made by the CLI, so only its errorCode makes it alert.
+	denied := consoleRecord("DescribeInstances", "denied")
+	denied["readOnly"] = true
+	denied["userAgent"] = "aws-cli/2.2.5"
+	denied["errorCode"] = "AccessDenied"
+	denied["errorMessage"] = "User is not authorized to perform: ec2:DescribeInstances"
+	throttled := consoleRecord("CreateTags", "throttled")
+	throttled["errorCode"] = "ThrottlingException"
+	throttled["errorMessage"] = "Rate exceeded"
+	failed := consoleRecord("CreateTags", "failed")
+	failed["errorCode"] = "InvalidParameterValue"
+	unlisted := consoleRecord("DescribeInstances", "unlisted")
+	unlisted["readOnly"] = true
+	unlisted["errorCode"] = "InvalidInstanceID.NotFound"
+
+	logFile := cloudTrailFile(denied, throttled, failed, unlisted)
+	if _, err := FilterRecords(context.Background(), logFile.Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := slack.Bodies()
+	if len(bodies) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(bodies))
+	}
+	if body := slackBodyFor(bodies, "denied"); !strings.Contains(body, "Error: AccessDenied: User is not authorized to perform: ec2:DescribeInstances") {
+		t.Errorf("expected the AccessDenied to alert with its error, got %s", body)
+	}
+	if body := slackBodyFor(bodies, "failed"); !strings.Contains(body, "Error: InvalidParameterValue") {
+		t.Errorf("expected the failed call to alert with its error code, got %s", body)
+	}
+	if slackBodyFor(bodies, "throttled") != "" {
+		t.Error("expected the throttling error to be suppressed")
+	}
+}
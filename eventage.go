@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// eventAge returns how long ago a record's eventTime was. The age is unknown
+// when the eventTime is not an RFC3339 timestamp.
+func eventAge(record *CloudTrailRecord, now time.Time) (time.Duration, bool) {
+	t, err := time.Parse(time.RFC3339, record.EventTime)
+	if err != nil {
+		log.Warnf("Unknown age of event %s, invalid eventTime %q: %v", record.EventID, record.EventTime, err)
+		return 0, false
+	}
+	return now.Sub(t), true
+}
+
+// maxEventAge returns MAX_EVENT_AGE, or zero when it is unset or invalid and
+// events are notified however old they are.
+func maxEventAge() time.Duration {
+	v := os.Getenv("MAX_EVENT_AGE")
+	if v == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Warnf("Ignoring invalid MAX_EVENT_AGE %q", v)
+		return 0
+	}
+	return d
+}
+
+// formatEventAge renders an age to the second below a minute and to the
+// minute above, e.g. "42s" or "3h12m".
+func formatEventAge(age time.Duration) string {
+	if age < 0 {
+		// Clock skew between CloudTrail and the function.
+		age = 0
+	}
+	if age < time.Minute {
+		return age.Round(time.Second).String()
+	}
+	return strings.TrimSuffix(age.Round(time.Minute).String(), "0s")
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventAge(t *testing.T) {
+	now := time.Date(2021, 5, 14, 22, 15, 40, 0, time.UTC)
+
+	age, ok := eventAge(typedRecord(consoleRecord("CreateTags", "event-1")), now)
+	if !ok || age != 3*time.Hour+12*time.Minute {
+		t.Errorf("eventAge() = %s, %v", age, ok)
+	}
+	if s := formatEventAge(age); s != "3h12m" {
+		t.Errorf("formatEventAge(%s) = %q", age, s)
+	}
+	if s := formatEventAge(42 * time.Second); s != "42s" {
+		t.Errorf("formatEventAge(42s) = %q", s)
+	}
+
+	raw := consoleRecord("CreateTags", "event-1")
+	raw["eventTime"] = "14/05/2021 19:03"
+	if _, ok := eventAge(typedRecord(raw), now); ok {
+		t.Error("expected an unknown age for a malformed eventTime")
+	}
+}
+
+func TestFilterRecordsEventAge(t *testing.T) {
+	fresh := consoleRecord("CreateTags", "fresh")
+	fresh["eventTime"] = time.Now().Add(-5 * time.Minute).UTC().Format(time.RFC3339)
+	stale := consoleRecord("CreateTags", "stale")
+	stale["eventTime"] = time.Now().Add(-3 * time.Hour).UTC().Format(time.RFC3339)
+	malformed := consoleRecord("CreateTags", "malformed")
+	malformed["eventTime"] = "yesterday"
+
+	tests := []struct {
+		name        string
+		maxEventAge string
+		record      map[string]interface{}
+		want        string
+	}{
+		{"fresh", "1h", fresh, "5m old"},
+		{"stale without MAX_EVENT_AGE", "", stale, "3h0m old"},
+		{"stale", "1h", stale, ""},
+		{"malformed", "1h", malformed, "yesterday"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slack := captureSlack(t)
+			setEnv(t, "MAX_EVENT_AGE", tt.maxEventAge)
+
+			logFile := cloudTrailFile(tt.record)
+			if _, err := FilterRecords(context.Background(), logFile.Stream(), testS3Record); err != nil {
+				t.Fatal(err)
+			}
+
+			bodies := slack.Bodies()
+			if tt.want == "" {
+				if len(bodies) != 0 {
+					t.Errorf("expected no message, got %v", bodies)
+				}
+				return
+			}
+			if len(bodies) != 1 || !strings.Contains(bodies[0], tt.want) {
+				t.Fatalf("expected a message with %q, got %v", tt.want, bodies)
+			}
+			if tt.name == "malformed" && strings.Contains(bodies[0], " old") {
+				t.Errorf("expected no age for a malformed eventTime: %s", bodies[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// eventLogKeys are the fields of every "Event" log entry. Their values are
+// always strings, empty when the event has no such value, so that log
+// aggregators index them with one stable mapping.
+var eventLogKeys = []string{
+	"event_name",
+	"event_source",
+	"event_id",
+	"event_time",
+	"event_version",
+	"event_category",
+	"event_age_seconds",
+	"region",
+	"account_id",
+	"account",
+	"recipient_account_id",
+	"user_name",
+	"principal",
+	"user_agent",
+	"source_ip",
+	"source_ip_origin",
+	"s3_uri",
+	"severity",
+	"details",
+	"detections",
+	"resources",
+	"extra_fields",
+	"error_code",
+	"error_message",
+	"mfa_authenticated",
+	"source_identity",
+	"principal_tags",
+	"exposure_score",
+	"exposure_findings",
+	"signin_method",
+	"signin_root",
+	"signin_mfa",
+	"source_account_id",
+	"target_account_id",
+	"insight_type",
+	"insight_state",
+	"insight_baseline",
+	"insight_average",
+}
+
+// logMatchedEvent logs an event that passed the filters, whether or not it
+// is then notified.
+func logMatchedEvent(alert AlertEvent) {
+	log.WithFields(eventLogFields(alert)).Info("Event")
+}
+
+// eventLogFields returns the eventLogKeys of an alert.
+func eventLogFields(alert AlertEvent) log.Fields {
+	fields := log.Fields{}
+	for _, key := range eventLogKeys {
+		fields[key] = ""
+	}
+
+	fields["event_name"] = alert.EventName
+	fields["event_source"] = alert.EventSource
+	fields["event_id"] = alert.EventID
+	fields["event_time"] = alert.EventTime
+	fields["event_version"] = alert.EventVersion
+	fields["region"] = alert.Region
+	fields["account_id"] = alert.AccountID
+	fields["account"] = alert.Account
+	fields["recipient_account_id"] = alert.RecipientAccountID
+	fields["user_name"] = alert.UserName
+	fields["source_ip"] = alert.SourceIP
+	fields["s3_uri"] = alert.S3URI
+	fields["severity"] = alert.Severity
+	fields["details"] = strings.Join(alert.Details, "; ")
+	fields["resources"] = strings.Join(alert.Resources, ",")
+	fields["extra_fields"] = extraFieldsSummary(alert.ExtraFields)
+	fields["mfa_authenticated"] = formatOptionalBool(alert.MFAAuthenticated)
+	fields["source_identity"] = alert.SourceIdentity
+
+	record := alert.Record
+	if record == nil {
+		return fields
+	}
+	fields["event_category"] = record.EventCategory
+	if t, err := time.Parse(time.RFC3339, record.EventTime); err == nil {
+		fields["event_age_seconds"] = strconv.FormatInt(int64(time.Since(t).Seconds()), 10)
+	}
+	fields["principal"] = record.UserIdentity.PrincipalID
+	fields["user_agent"] = record.UserAgent
+	if alert.SourceIP != record.SourceIPAddress {
+		// The alert's address carries its resolved origin.
+		fields["source_ip"] = record.SourceIPAddress
+		fields["source_ip_origin"] = alert.SourceIP
+	}
+	fields["error_code"] = record.ErrorCode
+	fields["error_message"] = record.ErrorMessage
+
+	var detections []string
+	for _, d := range MatchDetections(record) {
+		detections = append(detections, d.Name)
+	}
+	fields["detections"] = strings.Join(detections, ",")
+	if session := ParseSession(record); session != nil {
+		var tags []string
+		for key, value := range session.PrincipalTags {
+			tags = append(tags, key+"="+value)
+		}
+		sort.Strings(tags)
+		fields["principal_tags"] = strings.Join(tags, ",")
+	}
+	if exposure := AssessBucketExposure(record); exposure != nil {
+		fields["exposure_score"] = strconv.Itoa(exposure.Score)
+		fields["exposure_findings"] = strings.Join(exposure.Findings, "; ")
+	}
+	if signIn := ParseSignIn(record); signIn != nil {
+		fields["signin_method"] = signIn.Method
+		fields["signin_root"] = strconv.FormatBool(signIn.Root)
+		fields["signin_mfa"] = strconv.FormatBool(signIn.MFAUsed)
+	}
+	if crossAccount := ParseCrossAccountAssumeRole(record); crossAccount != nil {
+		fields["source_account_id"] = crossAccount.SourceAccount
+		fields["target_account_id"] = crossAccount.TargetAccount
+	}
+	if insight := ParseInsight(record); insight != nil {
+		fields["insight_type"] = insight.Type
+		fields["insight_state"] = insight.State
+		fields["insight_baseline"] = strconv.FormatFloat(insight.Baseline, 'f', -1, 64)
+		fields["insight_average"] = strconv.FormatFloat(insight.Average, 'f', -1, 64)
+	}
+	return fields
+}
+
+// formatOptionalBool renders a value that may be unknown as "true", "false"
+// or "".
+func formatOptionalBool(b *bool) string {
+	if b == nil {
+		return ""
+	}
+	return strconv.FormatBool(*b)
+}
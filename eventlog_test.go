@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestEventLogFieldsAreStable(t *testing.T) {
+	captureSlack(t)
+	hook := logtest.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+
+	full := consoleRecord("PutBucketPolicy", "event-full")
+	full["eventSource"] = "s3.amazonaws.com"
+	full["sourceIPAddress"] = "203.0.113.10"
+	full["errorCode"] = "AccessDenied"
+	full["errorMessage"] = "Access Denied"
+	full["resources"] = []interface{}{map[string]interface{}{"ARN": "arn:aws:s3:::example-bucket"}}
+	full["userIdentity"].(map[string]interface{})["sessionContext"] = map[string]interface{}{
+		"attributes": map[string]interface{}{"mfaAuthenticated": "true"},
+	}
+	sparse := map[string]interface{}{"eventID": "event-sparse", "eventName": "CreateTags"}
+
+	logFile := cloudTrailFile(full, consoleRecord("CreateTags", "event-minimal"))
+	if _, err := FilterRecords(context.Background(), logFile.Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []logrus.Fields
+	byID := map[interface{}]logrus.Fields{}
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Event" {
+			entries = append(entries, entry.Data)
+			byID[entry.Data["event_id"]] = entry.Data
+		}
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 Event entries, got %d", len(entries))
+	}
+	entries = append(entries, eventLogFields(AlertEvent{Record: typedRecord(sparse)}), eventLogFields(AlertEvent{}))
+
+	for _, fields := range entries {
+		if len(fields) != len(eventLogKeys) {
+			t.Errorf("expected %d fields, got %d: %v", len(eventLogKeys), len(fields), fields)
+		}
+		for _, key := range eventLogKeys {
+			if _, ok := fields[key].(string); !ok {
+				t.Errorf("%s = %#v (%T), want a string", key, fields[key], fields[key])
+			}
+		}
+	}
+
+	want := map[string]string{
+		"event_id":          "event-full",
+		"error_code":        "AccessDenied",
+		"mfa_authenticated": "true",
+		"user_name":         "first.last",
+	}
+	for key, value := range want {
+		if byID["event-full"][key] != value {
+			t.Errorf("%s = %q, want %q", key, byID["event-full"][key], value)
+		}
+	}
+	for _, key := range []string{"error_code", "mfa_authenticated", "insight_type"} {
+		if byID["event-minimal"][key] != "" {
+			t.Errorf("%s = %q, want it empty", key, byID["event-minimal"][key])
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// eventSourceFilter restricts the records alerted on to the eventSources of
+// the comma separated MONITORED_EVENT_SOURCES, e.g.
+// "iam.amazonaws.com,kms.amazonaws.com".
+type eventSourceFilter struct {
+	monitored map[string]bool
+}
+
+func newEventSourceFilter() eventSourceFilter {
+	monitored := map[string]bool{}
+	for _, source := range strings.Split(os.Getenv("MONITORED_EVENT_SOURCES"), ",") {
+		if source = strings.TrimSpace(source); source != "" {
+			monitored[strings.ToLower(source)] = true
+		}
+	}
+	return eventSourceFilter{monitored: monitored}
+}
+
+// Allowed reports whether records of source should be filtered, which all
+// are without MONITORED_EVENT_SOURCES.
+func (f eventSourceFilter) Allowed(source string) bool {
+	return len(f.monitored) == 0 || f.monitored[strings.ToLower(source)]
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestEventSourceFilter(t *testing.T) {
+	if f := newEventSourceFilter(); !f.Allowed("ec2.amazonaws.com") || !f.Allowed("") {
+		t.Error("expected every source to be allowed without MONITORED_EVENT_SOURCES")
+	}
+
+	setEnv(t, "MONITORED_EVENT_SOURCES", "iam.amazonaws.com, KMS.amazonaws.com")
+	f := newEventSourceFilter()
+	for _, source := range []string{"iam.amazonaws.com", "kms.amazonaws.com"} {
+		if !f.Allowed(source) {
+			t.Errorf("%s should be allowed", source)
+		}
+	}
+	for _, source := range []string{"ec2.amazonaws.com", ""} {
+		if f.Allowed(source) {
+			t.Errorf("%q should be dropped", source)
+		}
+	}
+}
+
+func TestFilterRecordsMonitoredEventSources(t *testing.T) {
+	slack := captureSlack(t)
+	setEnv(t, "MONITORED_EVENT_SOURCES", "iam.amazonaws.com,kms.amazonaws.com")
+	setEnv(t, "ALWAYS_ALERT_EVENTS", "GetSecretValue")
+
+	monitored := consoleRecord("CreateUser", "monitored")
+	monitored["eventSource"] = "iam.amazonaws.com"
+	always := consoleRecord("GetSecretValue", "always")
+	always["eventSource"] = "secretsmanager.amazonaws.com"
+	logFile := cloudTrailFile(monitored, consoleRecord("CreateTags", "unmonitored"), always)
+	if _, err := FilterRecords(context.Background(), logFile.Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := slack.Bodies()
+	if len(bodies) != 2 || !strings.Contains(slackBodyFor(bodies, "monitored"), "iam.amazonaws.com") || slackBodyFor(bodies, "always") == "" {
+		t.Errorf("expected the iam and the always alerted events only, got %v", bodies)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultMaxKnownEventVersion is the newest eventVersion CloudTrail
+// documents and the filter was checked against.
+const defaultMaxKnownEventVersion = "1.11"
+
+// eventVersionWarnInterval is how often a warning is logged for each
+// eventVersion newer than MAX_KNOWN_EVENT_VERSION.
+const eventVersionWarnInterval = time.Hour
+
+var (
+	eventVersionWarnMu sync.Mutex
+	eventVersionWarned = map[string]time.Time{}
+)
+
+// checkEventVersion warns when a record's eventVersion is newer than
+// MAX_KNOWN_EVENT_VERSION, as AWS may have changed the shape of the records
+// the filter relies on. Each version is warned about at most once per
+// eventVersionWarnInterval.
+func checkEventVersion(record *CloudTrailRecord) {
+	if record.EventVersion == "" {
+		return
+	}
+	max := maxKnownEventVersion()
+	if !newerEventVersion(record.EventVersion, max) {
+		return
+	}
+
+	eventVersionWarnMu.Lock()
+	defer eventVersionWarnMu.Unlock()
+	if last, ok := eventVersionWarned[record.EventVersion]; ok && time.Since(last) < eventVersionWarnInterval {
+		return
+	}
+	eventVersionWarned[record.EventVersion] = time.Now()
+	log.WithFields(log.Fields{
+		"event_version":           record.EventVersion,
+		"max_known_event_version": max,
+		"event_id":                record.EventID,
+	}).Warnf("Record has eventVersion %s, newer than the filter is known to handle", record.EventVersion)
+}
+
+var (
+	maxKnownEventVersionMu     sync.Mutex
+	maxKnownEventVersionEnv    string
+	maxKnownEventVersionParsed = defaultMaxKnownEventVersion
+)
+
+// maxKnownEventVersion returns MAX_KNOWN_EVENT_VERSION, checking it again
+// only when it changes.
+func maxKnownEventVersion() string {
+	env := os.Getenv("MAX_KNOWN_EVENT_VERSION")
+
+	maxKnownEventVersionMu.Lock()
+	defer maxKnownEventVersionMu.Unlock()
+	if maxKnownEventVersionEnv != env {
+		maxKnownEventVersionParsed, maxKnownEventVersionEnv = defaultMaxKnownEventVersion, env
+		if env != "" {
+			if _, ok := parseEventVersion(env); !ok {
+				log.Warnf("Ignoring invalid MAX_KNOWN_EVENT_VERSION %q", env)
+			} else {
+				maxKnownEventVersionParsed = env
+			}
+		}
+	}
+	return maxKnownEventVersionParsed
+}
+
+// newerEventVersion reports whether the eventVersion v is newer than max.
+// Versions that don't parse are not considered newer.
+func newerEventVersion(v, max string) bool {
+	version, ok := parseEventVersion(v)
+	maxVersion, maxOK := parseEventVersion(max)
+	if !ok || !maxOK {
+		return false
+	}
+	for i := range version {
+		if version[i] != maxVersion[i] {
+			return version[i] > maxVersion[i]
+		}
+	}
+	return false
+}
+
+// parseEventVersion parses a <major>.<minor> eventVersion such as 1.08.
+func parseEventVersion(v string) ([2]int, bool) {
+	parts := strings.Split(v, ".")
+	if len(parts) != 2 {
+		return [2]int{}, false
+	}
+	var version [2]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return [2]int{}, false
+		}
+		version[i] = n
+	}
+	return version, true
+}
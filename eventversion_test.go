@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestNewerEventVersion(t *testing.T) {
+	tests := []struct {
+		v, max string
+		want   bool
+	}{
+		{"1.08", "1.11", false},
+		{"1.11", "1.11", false},
+		{"1.12", "1.11", true},
+		{"1.10", "1.09", true},
+		{"2.00", "1.11", true},
+		{"1.9", "1.10", false},
+		{"bogus", "1.11", false},
+		{"1.12", "bogus", false},
+	}
+	for _, tt := range tests {
+		if got := newerEventVersion(tt.v, tt.max); got != tt.want {
+			t.Errorf("newerEventVersion(%q, %q) = %v, want %v", tt.v, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestFilterRecordsEventVersion(t *testing.T) {
+	alerts := captureAlerts(t)
+	hook := logtest.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+	setEnv(t, "MAX_KNOWN_EVENT_VERSION", "1.08")
+	eventVersionWarned = map[string]time.Time{}
+	t.Cleanup(func() { eventVersionWarned = map[string]time.Time{} })
+
+	var records []map[string]interface{}
+	for id, version := range map[string]string{"known": "1.08", "older": "1.05", "newer-1": "1.09", "newer-2": "1.09"} {
+		record := consoleRecord("CreateTags", id)
+		record["eventVersion"] = version
+		records = append(records, record)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := FilterRecords(context.Background(), cloudTrailFile(records...).Stream(), testS3Record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	versions := map[string]string{}
+	for _, alert := range alerts.alerts {
+		versions[alert.EventID] = alert.EventVersion
+	}
+	if versions["known"] != "1.08" || versions["older"] != "1.05" || versions["newer-1"] != "1.09" {
+		t.Errorf("unexpected alert versions %v", versions)
+	}
+
+	var warnings []logrus.Fields
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == logrus.WarnLevel {
+			warnings = append(warnings, entry.Data)
+		}
+	}
+	if len(warnings) != 1 || warnings[0]["event_version"] != "1.09" || warnings[0]["max_known_event_version"] != "1.08" {
+		t.Errorf("expected a single warning for 1.09, got %v", warnings)
+	}
+}
+
+func TestMaxKnownEventVersionInvalid(t *testing.T) {
+	hook := logtest.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+	// Forget the version cached by earlier tests.
+	maxKnownEventVersion()
+	setEnv(t, "MAX_KNOWN_EVENT_VERSION", "latest")
+
+	for i := 0; i < 3; i++ {
+		if got := maxKnownEventVersion(); got != defaultMaxKnownEventVersion {
+			t.Errorf("maxKnownEventVersion() = %q, want the default", got)
+		}
+	}
+	if n := len(hook.AllEntries()); n != 1 {
+		t.Errorf("expected the invalid value to be warned about once, got %d entries", n)
+	}
+}
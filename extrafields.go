@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ExtraField is a labelled value taken from a record by EXTRA_FIELDS.
+type ExtraField struct {
+	Label string `json:"label"`
+	Value string `json:"value"`
+}
+
+// extraFieldPath maps a label to a dotted path within a record such as
+// requestParameters.bucketName.
+type extraFieldPath struct {
+	label string
+	path  []string
+}
+
+type extraFieldPaths []extraFieldPath
+
+// parseExtraFields decodes EXTRA_FIELDS, comma separated label=path pairs,
+// e.g. "Bucket=requestParameters.bucketName,Role=requestParameters.roleName".
+func parseExtraFields(value string) (extraFieldPaths, error) {
+	var paths extraFieldPaths
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		i := strings.Index(pair, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("%q: expected label=path", pair)
+		}
+		label, path := strings.TrimSpace(pair[:i]), strings.TrimSpace(pair[i+1:])
+		if label == "" || path == "" {
+			return nil, fmt.Errorf("%q: expected label=path", pair)
+		}
+		paths = append(paths, extraFieldPath{label, strings.Split(path, ".")})
+	}
+	return paths, nil
+}
+
+// Extract returns the fields found in a record, in the configured order.
+// Paths the record doesn't have are skipped.
+func (p extraFieldPaths) Extract(record *CloudTrailRecord) []ExtraField {
+	var fields []ExtraField
+	for _, field := range p {
+		if value, ok := lookupPath(record.Raw, field.path); ok {
+			fields = append(fields, ExtraField{field.label, value})
+		}
+	}
+	return fields
+}
+
+// lookupPath follows path through nested objects and renders the value it
+// ends at, with objects and arrays as JSON.
+func lookupPath(raw map[string]interface{}, path []string) (string, bool) {
+	var value interface{} = raw
+	for _, key := range path {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if value, ok = object[key]; !ok {
+			return "", false
+		}
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, true
+	case map[string]interface{}, []interface{}:
+		b, err := json.Marshal(v)
+		return string(b), err == nil
+	default:
+		return fmt.Sprint(v), true
+	}
+}
+
+var (
+	extraFieldsMu     sync.Mutex
+	extraFieldsEnv    string
+	extraFieldsParsed extraFieldPaths
+)
+
+// activeExtraFields returns the EXTRA_FIELDS, parsing them again only when
+// they change.
+func activeExtraFields() extraFieldPaths {
+	env := os.Getenv("EXTRA_FIELDS")
+
+	extraFieldsMu.Lock()
+	defer extraFieldsMu.Unlock()
+	if extraFieldsEnv != env {
+		extraFieldsParsed, extraFieldsEnv = nil, env
+		paths, err := parseExtraFields(env)
+		if err != nil {
+			log.Warnf("Ignoring invalid EXTRA_FIELDS: %v", err)
+		} else {
+			extraFieldsParsed = paths
+		}
+	}
+	return extraFieldsParsed
+}
+
+// extraFieldsSummary renders the fields as one line, e.g.
+// "Bucket: example, Role: admin".
+func extraFieldsSummary(fields []ExtraField) string {
+	var parts []string
+	for _, field := range fields {
+		parts = append(parts, field.Label+": "+field.Value)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExtraFields(t *testing.T) {
+	paths, err := parseExtraFields("Bucket=requestParameters.bucketName, Rule=requestParameters.lifecycle.rule.id,Count=requestParameters.count,Role=requestParameters.roleName,Tags=requestParameters.tags,Deep=eventName.missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := consoleRecord("PutBucketLifecycle", "event-1")
+	raw["requestParameters"] = map[string]interface{}{
+		"bucketName": "example-bucket",
+		"count":      float64(3),
+		"tags":       []interface{}{"a", "b"},
+		"lifecycle": map[string]interface{}{
+			"rule": map[string]interface{}{"id": "expire-logs"},
+		},
+	}
+
+	want := []ExtraField{
+		{"Bucket", "example-bucket"},
+		{"Rule", "expire-logs"},
+		{"Count", "3"},
+		{"Tags", `["a","b"]`},
+	}
+	if got := paths.Extract(typedRecord(raw)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Extract() = %v, want %v", got, want)
+	}
+	if got := paths.Extract(typedRecord(consoleRecord("CreateTags", "event-1"))); got != nil {
+		t.Errorf("expected no fields without requestParameters, got %v", got)
+	}
+
+	for _, invalid := range []string{"Bucket", "=requestParameters.bucketName", "Bucket="} {
+		if _, err := parseExtraFields(invalid); err == nil {
+			t.Errorf("expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestFilterRecordsExtraFields(t *testing.T) {
+	slack := captureSlack(t)
+	setEnv(t, "EXTRA_FIELDS", "Instance=requestParameters.instancesSet.items,Role=requestParameters.roleName")
+
+	raw := consoleRecord("CreateTags", "event-1")
+	raw["requestParameters"] = map[string]interface{}{
+		"instancesSet": map[string]interface{}{"items": "i-0123456789abcdef0"},
+	}
+	if _, err := FilterRecords(context.Background(), cloudTrailFile(raw).Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := slack.Bodies()
+	if len(bodies) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(bodies))
+	}
+	if !strings.Contains(bodies[0], `"text":"Instance: i-0123456789abcdef0"`) {
+		t.Errorf("message missing the extra field: %s", bodies[0])
+	}
+	if strings.Contains(bodies[0], "Role:") {
+		t.Errorf("absent path should be skipped: %s", bodies[0])
+	}
+}
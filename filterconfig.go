@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	log "github.com/sirupsen/logrus"
+)
+
+// FilterConfig lists the event names that are never alerted on.
+type FilterConfig struct {
+	// Replace the built-in rules when false, merge with them when true.
+	ExtendDefaults bool `json:"extendDefaults"`
+
+	IgnoreEvents   []string `json:"ignoreEvents"`
+	IgnorePrefixes []string `json:"ignorePrefixes"`
+	IgnoreSuffixes []string `json:"ignoreSuffixes"`
+	// Some events don't match AWS defined standards so these prefixes are
+	// matched case-insensitively.
+	IgnoreCasePrefixes []string `json:"ignoreCasePrefixes"`
+
+	EventSources map[string]EventSourceFilter `json:"eventSources"`
+}
+
+// EventSourceFilter overrides the global rules for a single eventSource.
+// AllowEvents are never ignored by name even when a global rule matches.
+type EventSourceFilter struct {
+	IgnoreEvents   []string `json:"ignoreEvents"`
+	IgnorePrefixes []string `json:"ignorePrefixes"`
+	AllowEvents    []string `json:"allowEvents"`
+}
+
+func defaultFilterConfig() *FilterConfig {
+	return &FilterConfig{
+		IgnoreEvents: []string{
+			"CheckMfa",
+			"CheckDomainAvailability",
+			"Decrypt",
+			"SetTaskStatus",
+			"BatchGetQueryExecution",
+			"QueryObjects",
+			"GenerateServiceLastAccessedDetails",
+			"REST.GET.OBJECT_LOCK_CONFIGURATION",
+			"AssumeRoleWithWebIdentity",
+		},
+		IgnorePrefixes: []string{
+			"Head",
+			"Describe",
+			"Test",
+			"Download",
+			"Report",
+			"Poll",
+			"Verify",
+			"Skip",
+			"Count",
+			"Detect",
+			"Lookup",
+			"StartQuery",
+			"StopQuery",
+			"CancelQuery",
+			"BatchGet",
+			"Search",
+		},
+		IgnoreSuffixes: []string{
+			"VirtualMFADevice",
+		},
+		IgnoreCasePrefixes: []string{
+			"Get",
+			"List",
+			"View",
+		},
+		EventSources: map[string]EventSourceFilter{
+			"logs.amazonaws.com": {
+				IgnoreEvents: []string{"PutQueryDefinition"},
+			},
+		},
+	}
+}
+
+// Ignored reports whether an event name from an event source matches any of
+// the ignore rules.
+func (c *FilterConfig) Ignored(eventSource, eventName string) bool {
+	return c.ignored(eventSource, eventName, true)
+}
+
+// IgnoredEvent reports whether an event name from an event source is listed
+// in the ignore rules, leaving out the prefix and suffix rules that guess
+// whether an event is read-only.
+func (c *FilterConfig) IgnoredEvent(eventSource, eventName string) bool {
+	return c.ignored(eventSource, eventName, false)
+}
+
+// Allowed reports whether an event name is listed in the allowEvents of its
+// event source, which keeps it from being ignored by any rule.
+func (c *FilterConfig) Allowed(eventSource, eventName string) bool {
+	source, ok := c.EventSources[eventSource]
+	return ok && containsString(source.AllowEvents, eventName)
+}
+
+func (c *FilterConfig) ignored(eventSource, eventName string, affixes bool) bool {
+	if c.Allowed(eventSource, eventName) {
+		return false
+	}
+	if source, ok := c.EventSources[eventSource]; ok {
+		if containsString(source.IgnoreEvents, eventName) || (affixes && hasAnyPrefix(eventName, source.IgnorePrefixes)) {
+			return true
+		}
+	}
+
+	if containsString(c.IgnoreEvents, eventName) {
+		return true
+	}
+	if !affixes {
+		return false
+	}
+	if hasAnyPrefix(eventName, c.IgnorePrefixes) {
+		return true
+	}
+	for _, prefix := range c.IgnoreCasePrefixes {
+		if hasPrefixFold(eventName, prefix) {
+			return true
+		}
+	}
+	for _, suffix := range c.IgnoreSuffixes {
+		if strings.HasSuffix(eventName, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *FilterConfig) merge(o *FilterConfig) {
+	c.IgnoreEvents = append(c.IgnoreEvents, o.IgnoreEvents...)
+	c.IgnorePrefixes = append(c.IgnorePrefixes, o.IgnorePrefixes...)
+	c.IgnoreSuffixes = append(c.IgnoreSuffixes, o.IgnoreSuffixes...)
+	c.IgnoreCasePrefixes = append(c.IgnoreCasePrefixes, o.IgnoreCasePrefixes...)
+	for name, source := range o.EventSources {
+		existing := c.EventSources[name]
+		existing.IgnoreEvents = append(existing.IgnoreEvents, source.IgnoreEvents...)
+		existing.IgnorePrefixes = append(existing.IgnorePrefixes, source.IgnorePrefixes...)
+		existing.AllowEvents = append(existing.AllowEvents, source.AllowEvents...)
+		c.EventSources[name] = existing
+	}
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasPrefixFold is strings.HasPrefix under Unicode case-folding.
+func hasPrefixFold(eventName, prefix string) bool {
+	return len(eventName) >= len(prefix) && strings.EqualFold(eventName[:len(prefix)], prefix)
+}
+
+// ParseFilterConfig decodes a filter config document, merging it with the
+// built-in defaults when extendDefaults is set.
+func ParseFilterConfig(r io.Reader) (*FilterConfig, error) {
+	var config FilterConfig
+	if err := json.NewDecoder(r).Decode(&config); err != nil {
+		return nil, fmt.Errorf("decoding filter config: %w", err)
+	}
+
+	if config.ExtendDefaults {
+		merged := defaultFilterConfig()
+		merged.merge(&config)
+		return merged, nil
+	}
+	return &config, nil
+}
+
+func LoadFilterConfig(ctx context.Context, s3Client S3Getter, bucket, key string) (*FilterConfig, error) {
+	obj, err := s3Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error getting filter config s3://%s/%s: %w", bucket, key, err)
+	}
+	defer obj.Body.Close()
+
+	return ParseFilterConfig(obj.Body)
+}
+
+var (
+	filterConfigClient   S3Getter
+	filterConfigMu       sync.Mutex
+	filterConfigLocation string
+	filterConfig         *FilterConfig
+)
+
+// loadFilterConfig loads the config from FILTER_CONFIG_BUCKET and
+// FILTER_CONFIG_KEY once and keeps it for warm invocations.
+func loadFilterConfig(ctx context.Context) error {
+	bucket, key := os.Getenv("FILTER_CONFIG_BUCKET"), os.Getenv("FILTER_CONFIG_KEY")
+	location := fmt.Sprintf("s3://%s/%s", bucket, key)
+
+	filterConfigMu.Lock()
+	defer filterConfigMu.Unlock()
+
+	if bucket == "" || key == "" {
+		filterConfig, filterConfigLocation = nil, ""
+		return nil
+	}
+	if filterConfig != nil && filterConfigLocation == location {
+		return nil
+	}
+
+	if filterConfigClient == nil {
+		s3Client := s3.New(session.Must(session.NewSession()), s3Config(aws.NewConfig()))
+		traceAWSClient(s3Client.Client)
+		filterConfigClient = s3Client
+	}
+
+	config, err := LoadFilterConfig(ctx, filterConfigClient, bucket, key)
+	if err != nil {
+		return err
+	}
+	log.Infof("Loaded filter config from %s", location)
+	filterConfig, filterConfigLocation = config, location
+	return nil
+}
+
+func activeFilterConfig() *FilterConfig {
+	filterConfigMu.Lock()
+	defer filterConfigMu.Unlock()
+
+	if filterConfig == nil {
+		return defaultFilterConfig()
+	}
+	return filterConfig
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestDefaultFilterConfig(t *testing.T) {
+	config := defaultFilterConfig()
+
+	tests := []struct {
+		source, name string
+		ignored      bool
+	}{
+		{"ec2.amazonaws.com", "DescribeInstances", true},
+		{"s3.amazonaws.com", "getBucketPolicy", true},
+		{"iam.amazonaws.com", "CreateVirtualMFADevice", true},
+		{"kms.amazonaws.com", "Decrypt", true},
+		{"logs.amazonaws.com", "PutQueryDefinition", true},
+		{"ssm.amazonaws.com", "PutQueryDefinition", false},
+		{"ec2.amazonaws.com", "CreateTags", false},
+	}
+
+	for _, tt := range tests {
+		if got := config.Ignored(tt.source, tt.name); got != tt.ignored {
+			t.Errorf("Ignored(%s, %s) = %v, want %v", tt.source, tt.name, got, tt.ignored)
+		}
+	}
+}
+
+func TestParseFilterConfig(t *testing.T) {
+	f, err := os.Open("testdata/filter-config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	config, err := ParseFilterConfig(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		source, name string
+		ignored      bool
+	}{
+		// From the config file
+		{"ec2.amazonaws.com", "CreateTags", true},
+		{"ec2.amazonaws.com", "PreviewChanges", true},
+		{"ec2.amazonaws.com", "ModifyInstanceAttribute", true},
+		{"rds.amazonaws.com", "ModifyDBInstance", false},
+		{"iam.amazonaws.com", "GetAccountAuthorizationDetails", false},
+		{"iam.amazonaws.com", "GetUser", true},
+		// Still ignored through extendDefaults
+		{"ec2.amazonaws.com", "DescribeInstances", true},
+		{"logs.amazonaws.com", "PutQueryDefinition", true},
+		{"ec2.amazonaws.com", "RunInstances", false},
+	}
+
+	for _, tt := range tests {
+		if got := config.Ignored(tt.source, tt.name); got != tt.ignored {
+			t.Errorf("Ignored(%s, %s) = %v, want %v", tt.source, tt.name, got, tt.ignored)
+		}
+	}
+}
+
+func TestParseFilterConfigReplacesDefaults(t *testing.T) {
+	config, err := ParseFilterConfig(strings.NewReader(`{"ignorePrefixes": ["Create"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !config.Ignored("ec2.amazonaws.com", "CreateTags") {
+		t.Error("expected CreateTags to be ignored")
+	}
+	if config.Ignored("ec2.amazonaws.com", "DescribeInstances") {
+		t.Error("expected the defaults to be replaced")
+	}
+}
+
+func TestParseFilterConfigInvalid(t *testing.T) {
+	if _, err := ParseFilterConfig(strings.NewReader(`{"ignorePrefixes": "Create"`)); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+// fakeS3Server serves objects from a map over the S3 REST API.
+func fakeS3Server(t *testing.T, objects map[string]string, requests *int) *s3.S3 {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		body, ok := objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`))
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+
+	return s3.New(session.Must(session.NewSession()), aws.NewConfig().
+		WithRegion("us-east-1").
+		WithEndpoint(srv.URL).
+		WithS3ForcePathStyle(true).
+		WithCredentials(credentials.NewStaticCredentials("id", "secret", "")))
+}
+
+func TestLoadFilterConfigCached(t *testing.T) {
+	content, err := os.ReadFile("testdata/filter-config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := 0
+	filterConfigClient = fakeS3Server(t, map[string]string{"/config-bucket/filters.json": string(content)}, &requests)
+	setEnv(t, "FILTER_CONFIG_BUCKET", "config-bucket")
+	setEnv(t, "FILTER_CONFIG_KEY", "filters.json")
+	t.Cleanup(func() {
+		filterConfigClient, filterConfig, filterConfigLocation = nil, nil, ""
+	})
+
+	for i := 0; i < 3; i++ {
+		if err := loadFilterConfig(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("expected the config to be fetched once, got %d requests", requests)
+	}
+
+	slack := captureSlack(t)
+	logFile := cloudTrailFile(
+		consoleRecord("CreateTags", "suppressed"),
+		consoleRecord("RunInstances", "passed"),
+	)
+	if _, err := FilterRecords(context.Background(), logFile.Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+	bodies := slack.Bodies()
+	if len(bodies) != 1 || !strings.Contains(bodies[0], "EventId=passed") {
+		t.Errorf("expected only RunInstances to alert, got %v", bodies)
+	}
+}
+
+func TestLoadFilterConfigMissing(t *testing.T) {
+	requests := 0
+	filterConfigClient = fakeS3Server(t, map[string]string{}, &requests)
+	setEnv(t, "FILTER_CONFIG_BUCKET", "config-bucket")
+	setEnv(t, "FILTER_CONFIG_KEY", "missing.json")
+	t.Cleanup(func() {
+		filterConfigClient, filterConfig, filterConfigLocation = nil, nil, ""
+	})
+
+	if err := loadFilterConfig(context.Background()); err == nil {
+		t.Error("expected an error for a missing config")
+	}
+	if activeFilterConfig().Ignored("ec2.amazonaws.com", "CreateTags") {
+		t.Error("expected the defaults to apply")
+	}
+}
+
+func TestHasPrefixFold(t *testing.T) {
+	tests := []struct {
+		eventName, prefix string
+		want              bool
+	}{
+		{"GetBucketPolicy", "Get", true},
+		{"getbucketpolicy", "Get", true},
+		{"GETBUCKETPOLICY", "Get", true},
+		{"gEtBucketPolicy", "Get", true},
+		{"listObjects", "List", true},
+		{"ViewBilling", "view", true},
+		{"Ge", "Get", false},
+		{"", "Get", false},
+		{"TargetGroup", "Get", false},
+		{"PutBucketPolicy", "Get", false},
+	}
+
+	for _, tt := range tests {
+		if got := hasPrefixFold(tt.eventName, tt.prefix); got != tt.want {
+			t.Errorf("hasPrefixFold(%q, %q) = %v, want %v", tt.eventName, tt.prefix, got, tt.want)
+		}
+	}
+
+	config := defaultFilterConfig()
+	for _, name := range []string{"getbucketpolicy", "GetBucketPolicy", "GETBUCKETPOLICY"} {
+		if !config.Ignored("s3.amazonaws.com", name) {
+			t.Errorf("expected %s to be ignored", name)
+		}
+	}
+}
+
+func TestFilterConfigIgnoredEvent(t *testing.T) {
+	config := defaultFilterConfig()
+	if !config.IgnoredEvent("kms.amazonaws.com", "Decrypt") {
+		t.Error("listed events should be ignored")
+	}
+	if !config.IgnoredEvent("logs.amazonaws.com", "PutQueryDefinition") {
+		t.Error("listed events of an event source should be ignored")
+	}
+	if config.IgnoredEvent("secretsmanager.amazonaws.com", "GetSecretValue") {
+		t.Error("prefix rules should not apply")
+	}
+}
+
+func TestFilterRecordsReadOnlyField(t *testing.T) {
+	tests := []struct {
+		name      string
+		eventName string
+		readOnly  interface{}
+		disabled  bool
+		alerts    bool
+	}{
+		{name: "read only", eventName: "CreateTags", readOnly: true},
+		{name: "read only string", eventName: "CreateTags", readOnly: "true"},
+		{name: "not read only Get", eventName: "GetSecretValue", readOnly: false, alerts: true},
+		{name: "not read only listed event", eventName: "Decrypt", readOnly: false},
+		{name: "missing readOnly Get", eventName: "GetSecretValue"},
+		{name: "missing readOnly write", eventName: "CreateTags", alerts: true},
+		{name: "field ignored", eventName: "CreateTags", readOnly: true, disabled: true, alerts: true},
+		{name: "field ignored Get", eventName: "GetSecretValue", readOnly: false, disabled: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slack := captureSlack(t)
+			if tt.disabled {
+				setEnv(t, "IGNORE_READONLY_FIELD", "false")
+			}
+
+			record := consoleRecord(tt.eventName, "event-1")
+			if tt.readOnly != nil {
+				record["readOnly"] = tt.readOnly
+			}
+			if _, err := FilterRecords(context.Background(), cloudTrailFile(record).Stream(), testS3Record); err != nil {
+				t.Fatal(err)
+			}
+			if alerted := len(slack.Bodies()) > 0; alerted != tt.alerts {
+				t.Errorf("alerted = %v, want %v", alerted, tt.alerts)
+			}
+		})
+	}
+}
+
+func TestFilterRecordsReadOnlyAllowedEvent(t *testing.T) {
+	f, err := os.Open("testdata/filter-config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	config, err := ParseFilterConfig(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filterConfig = config
+	t.Cleanup(func() { filterConfig = nil })
+
+	slack := captureSlack(t)
+	allowed := consoleRecord("GetAccountAuthorizationDetails", "allowed")
+	allowed["eventSource"] = "iam.amazonaws.com"
+	allowed["readOnly"] = true
+	other := consoleRecord("GetUser", "read-only")
+	other["eventSource"] = "iam.amazonaws.com"
+	other["readOnly"] = true
+
+	if _, err := FilterRecords(context.Background(), cloudTrailFile(allowed, other).Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+	bodies := slack.Bodies()
+	if len(bodies) != 1 || !strings.Contains(bodies[0], "EventId=allowed") {
+		t.Errorf("expected only the allowed event to alert, got %v", bodies)
+	}
+}
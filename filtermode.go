@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	filterModeDenylist  = "denylist"
+	filterModeAllowlist = "allowlist"
+)
+
+// eventFilter decides which records are suppressed. In the default denylist
+// mode records are dropped by the filter config and user agent rules unless
+// a detection matches, and by the operator's ignores either way. In allowlist mode only the MONITORED_EVENTS alert.
+type eventFilter struct {
+	allowlist bool
+	monitored map[string]bool
+}
+
+func newEventFilter() eventFilter {
+	switch mode := strings.ToLower(os.Getenv("FILTER_MODE")); mode {
+	case "", filterModeDenylist:
+		return eventFilter{}
+	case filterModeAllowlist:
+		monitored := map[string]bool{}
+		for _, name := range strings.Split(os.Getenv("MONITORED_EVENTS"), ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				monitored[name] = true
+			}
+		}
+		if len(monitored) == 0 {
+			log.Warn("FILTER_MODE is allowlist but MONITORED_EVENTS is empty, no event will alert")
+		}
+		return eventFilter{allowlist: true, monitored: monitored}
+	default:
+		log.Warnf("Ignoring invalid FILTER_MODE %q, using %s", mode, filterModeDenylist)
+		return eventFilter{}
+	}
+}
+
+func (f eventFilter) Suppressed(record *CloudTrailRecord, detections []Detection) bool {
+	return f.SuppressionReason(record, detections) != ""
+}
+
+// SuppressionReason returns why the record is suppressed, empty when it
+// isn't.
+func (f eventFilter) SuppressionReason(record *CloudTrailRecord, detections []Detection) string {
+	if f.allowlist {
+		if !f.monitored[record.EventName] {
+			return "not in MONITORED_EVENTS"
+		}
+		return ""
+	}
+	if len(detections) > 0 {
+		// Detections bypass the read-only and user agent checks, not what
+		// the operator chose to ignore.
+		return ignoredReason(record)
+	}
+	return suppressionReason(record)
+}
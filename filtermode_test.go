@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestFilterRecordsFilterMode(t *testing.T) {
+	records := func() *CloudTrailFile {
+		assumeRole := consoleRecord("AssumeRole", "assume-role")
+		assumeRole["eventSource"] = "sts.amazonaws.com"
+		assumeRole["userAgent"] = "aws-cli/2.2.5"
+		describe := consoleRecord("DescribeInstances", "describe")
+		return cloudTrailFile(assumeRole, describe, consoleRecord("CreateTags", "create-tags"))
+	}
+
+	tests := []struct {
+		mode      string
+		monitored string
+		want      []string
+	}{
+		{mode: "", want: []string{"create-tags"}},
+		{mode: "denylist", monitored: "AssumeRole", want: []string{"create-tags"}},
+		{mode: "allowlist", monitored: "AssumeRole", want: []string{"assume-role"}},
+		{mode: "allowlist", monitored: "AssumeRole, DescribeInstances", want: []string{"assume-role", "describe"}},
+		{mode: "allowlist"},
+		{mode: "bogus", monitored: "AssumeRole", want: []string{"create-tags"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode+" "+tt.monitored, func(t *testing.T) {
+			slack := captureSlack(t)
+			setEnv(t, "FILTER_MODE", tt.mode)
+			setEnv(t, "MONITORED_EVENTS", tt.monitored)
+
+			if _, err := FilterRecords(context.Background(), records().Stream(), testS3Record); err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, id := range []string{"assume-role", "describe", "create-tags"} {
+				if slackBodyFor(slack.Bodies(), id) != "" {
+					got = append(got, id)
+				}
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("notified %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
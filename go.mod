@@ -3,7 +3,9 @@ module github.com/Techcadia/cloudtrail-console-actions
 go 1.16
 
 require (
-	github.com/aws/aws-lambda-go v1.24.0
+	github.com/aws/aws-lambda-go v1.28.0
 	github.com/aws/aws-sdk-go v1.38.55
+	github.com/aws/aws-xray-sdk-go v1.6.0
 	github.com/sirupsen/logrus v1.8.1
+	golang.org/x/sync v0.1.0
 )
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/aws/aws-lambda-go/events"
+	log "github.com/sirupsen/logrus"
+)
+
+// Handler accepts an S3 event notification, an SNS notification whose
+// messages are S3 event notifications, a batch of them queued in SQS or a
+// single CloudTrail event delivered by EventBridge. A {"selftest": true}
+// payload runs the self-test instead, and a {"replay": {...}} payload
+// processes the log files already in a bucket.
+func Handler(ctx context.Context, raw json.RawMessage) (interface{}, error) {
+	var selfTest SelfTest
+	if err := json.Unmarshal(raw, &selfTest); err == nil && selfTest.SelfTest {
+		return nil, SelfTestHandler(ctx, selfTest)
+	}
+
+	var replay struct {
+		Replay *Replay `json:"replay"`
+	}
+	if err := json.Unmarshal(raw, &replay); err == nil && replay.Replay != nil {
+		return ReplayHandler(ctx, *replay.Replay)
+	}
+
+	var probe struct {
+		DetailType string          `json:"detail-type"`
+		Detail     json.RawMessage `json:"detail"`
+	}
+	if err := json.Unmarshal(raw, &probe); err == nil && probe.DetailType != "" && len(probe.Detail) > 0 {
+		return nil, EventBridgeHandler(ctx, raw)
+	}
+
+	var sqsEvent events.SQSEvent
+	if err := json.Unmarshal(raw, &sqsEvent); err == nil && len(sqsEvent.Records) > 0 && sqsEvent.Records[0].EventSource == "aws:sqs" {
+		return SQSHandler(ctx, sqsEvent)
+	}
+
+	s3Event, err := decodeS3Event(raw)
+	if err != nil {
+		return nil, err
+	}
+	return S3Handler(ctx, s3Event)
+}
+
+func decodeS3Event(raw json.RawMessage) (events.S3Event, error) {
+	var probe struct {
+		Event   string `json:"Event"`
+		Records []struct {
+			EventSource    string `json:"eventSource"`
+			SNSEventSource string `json:"EventSource"`
+		} `json:"Records"`
+	}
+	if err := json.Unmarshal(raw, &probe); err != nil {
+		return events.S3Event{}, fmt.Errorf("decoding event: %w", err)
+	}
+	// S3 sends an s3:TestEvent without records when the notification is
+	// first configured.
+	if probe.Event == "s3:TestEvent" {
+		return events.S3Event{}, nil
+	}
+	if len(probe.Records) == 0 {
+		return events.S3Event{}, fmt.Errorf("event has no records: %s", raw)
+	}
+
+	switch source := probe.Records[0]; {
+	case source.EventSource == "aws:s3":
+		var s3Event events.S3Event
+		if err := json.Unmarshal(raw, &s3Event); err != nil {
+			return events.S3Event{}, fmt.Errorf("decoding S3 event: %w", err)
+		}
+		unescapeObjectKeys(s3Event)
+		return s3Event, nil
+	case source.SNSEventSource == "aws:sns":
+		var snsEvent events.SNSEvent
+		if err := json.Unmarshal(raw, &snsEvent); err != nil {
+			return events.S3Event{}, fmt.Errorf("decoding SNS event: %w", err)
+		}
+		return unwrapSNSEvent(snsEvent)
+	default:
+		return events.S3Event{}, fmt.Errorf("unsupported event source %q", source.EventSource+source.SNSEventSource)
+	}
+}
+
+// unescapeObjectKeys decodes the object keys of an S3 event notification,
+// which are URL encoded with spaces as "+". Keys listed from the bucket or
+// given on the command line are used as is.
+func unescapeObjectKeys(s3Event events.S3Event) {
+	for i, record := range s3Event.Records {
+		key, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil {
+			log.Warnf("Using undecodable object key %q as is: %v", record.S3.Object.Key, err)
+			continue
+		}
+		s3Event.Records[i].S3.Object.Key = key
+	}
+}
+
+func unwrapSNSEvent(snsEvent events.SNSEvent) (events.S3Event, error) {
+	var s3Event events.S3Event
+	for _, record := range snsEvent.Records {
+		inner, err := decodeS3Event(json.RawMessage(record.SNS.Message))
+		if err != nil {
+			return events.S3Event{}, fmt.Errorf("decoding S3 event from SNS message %s: %w", record.SNS.MessageID, err)
+		}
+		if len(inner.Records) == 0 {
+			log.Debugf("Skipping SNS message %s without S3 records", record.SNS.MessageID)
+			continue
+		}
+		s3Event.Records = append(s3Event.Records, inner.Records...)
+	}
+	return s3Event, nil
+}
+
+// SQSHandler processes S3 event notifications queued in SQS. Only the messages
+// that failed are reported back so the rest of the batch isn't redelivered.
+func SQSHandler(ctx context.Context, sqsEvent events.SQSEvent) (response SQSProcessingResult, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoveredPanic(r)
+		}
+	}()
+
+	ctx, err = prepareInvocation(ctx)
+	if err != nil {
+		return response, err
+	}
+	defer flushMetrics(ctx)
+	defer reportNotifyFailures(ctx)
+
+	for _, message := range sqsEvent.Records {
+		if err := processSQSMessage(ctx, message); err != nil {
+			log.WithFields(log.Fields{
+				"message_id": message.MessageId,
+			}).Errorf("Processing SQS message: %v", err)
+			response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{
+				ItemIdentifier: message.MessageId,
+			})
+		}
+	}
+
+	response.ProcessingResult = processingCounterFrom(ctx).Result()
+	return response, nil
+}
+
+// processSQSMessage processes the S3 event notification of one message. A
+// panic fails the message rather than the whole batch.
+func processSQSMessage(ctx context.Context, message events.SQSMessage) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoveredPanic(r)
+		}
+	}()
+	body := json.RawMessage(message.Body)
+
+	// Notifications fanned out through SNS without raw message delivery
+	// arrive wrapped in the SNS envelope.
+	var envelope struct {
+		Type    string `json:"Type"`
+		Message string `json:"Message"`
+	}
+	if err := json.Unmarshal(body, &envelope); err == nil && envelope.Type == "Notification" {
+		body = json.RawMessage(envelope.Message)
+	}
+
+	s3Event, err := decodeS3Event(body)
+	if err != nil {
+		return err
+	}
+
+	for _, s3Record := range s3Event.Records {
+		err := Stream(ctx, s3Record)
+		if errors.Is(err, ErrSkippedObject) {
+			log.Debug(err)
+		} else if err = retryableError(s3Record, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EventBridgeHandler filters a single CloudTrail event delivered by an
+// EventBridge rule, such as "AWS API Call via CloudTrail", without waiting for
+// the log file to be written to S3.
+func EventBridgeHandler(ctx context.Context, raw json.RawMessage) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoveredPanic(r)
+		}
+	}()
+
+	var event events.CloudWatchEvent
+	if err := json.Unmarshal(raw, &event); err != nil {
+		return fmt.Errorf("decoding EventBridge event: %w", err)
+	}
+
+	var record CloudTrailRecord
+	if err := json.Unmarshal(event.Detail, &record); err != nil {
+		return fmt.Errorf("decoding CloudTrail event %s: %w", event.ID, err)
+	}
+	if record.EventID == "" {
+		return fmt.Errorf("EventBridge event %s (%s) is not a CloudTrail event", event.ID, event.DetailType)
+	}
+
+	ctx, err = prepareInvocation(ctx)
+	if err != nil {
+		return err
+	}
+	defer flushMetrics(ctx)
+	defer reportNotifyFailures(ctx)
+
+	logFile := &CloudTrailFile{Records: []CloudTrailRecord{record}}
+	result, err := FilterRecords(ctx, logFile.Stream(), events.S3EventRecord{AWSRegion: event.Region})
+	writeMatchedRecords(ctx, events.S3EventRecord{}, record.EventID, result.Matched)
+	return err
+}
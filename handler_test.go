@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestDecodeS3EventFromSNS(t *testing.T) {
+	raw, err := os.ReadFile("testdata/sns-s3-event.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s3Event, err := decodeS3Event(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s3Event.Records) != 1 {
+		t.Fatalf("expected 1 S3 record, got %d", len(s3Event.Records))
+	}
+	record := s3Event.Records[0]
+	if record.S3.Bucket.Name != "example-com-cloudtrail" {
+		t.Errorf("unexpected bucket %s", record.S3.Bucket.Name)
+	}
+	if want := "AWSLogs/012345678901/CloudTrail/us-east-1/2021/05/14/012345678901_CloudTrail_us-east-1_20210514T1905Z_abcdefghijklmnop.json.gz"; record.S3.Object.Key != want {
+		t.Errorf("unexpected key %s", record.S3.Object.Key)
+	}
+	if record.AWSRegion != "us-east-1" {
+		t.Errorf("unexpected region %s", record.AWSRegion)
+	}
+}
+
+func TestDecodeS3EventDirect(t *testing.T) {
+	raw := json.RawMessage(`{"Records":[{"eventSource":"aws:s3","awsRegion":"us-west-2","s3":{"bucket":{"name":"b"},"object":{"key":"AWSLogs/012345678901/CloudTrail-Digest/x.json.gz"}}}]}`)
+
+	s3Event, err := decodeS3Event(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s3Event.Records) != 1 || s3Event.Records[0].S3.Bucket.Name != "b" {
+		t.Errorf("unexpected records %+v", s3Event.Records)
+	}
+
+	// Digest objects are skipped before any S3 call is made.
+	if _, err := Handler(context.Background(), raw); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDecodeS3EventUnsupported(t *testing.T) {
+	for _, raw := range []string{
+		`{"Records":[{"eventSource":"aws:sns"}]}`,
+		`{"Records":[]}`,
+		`[`,
+	} {
+		if _, err := decodeS3Event(json.RawMessage(raw)); err == nil {
+			t.Errorf("expected an error for %s", raw)
+		}
+	}
+}
+
+func TestSQSHandlerPartialBatchFailure(t *testing.T) {
+	slack := captureSlack(t)
+
+	objects := map[string]string{}
+	var sqsEvent events.SQSEvent
+	for i := 1; i <= 10; i++ {
+		key := fmt.Sprintf("AWSLogs/012345678901/CloudTrail/us-east-1/2021/05/14/file-%d.json.gz", i)
+		if i != 3 {
+			record, _ := json.Marshal(consoleRecord("CreateTags", fmt.Sprintf("event-%d", i)))
+			objects["/test-bucket/"+key] = fmt.Sprintf(`{"Records":[%s]}`, record)
+		}
+
+		body := fmt.Sprintf(`{"Records":[{"eventSource":"aws:s3","awsRegion":"us-east-1","s3":{"bucket":{"name":"test-bucket"},"object":{"key":%q}}}]}`, key)
+		sqsEvent.Records = append(sqsEvent.Records, events.SQSMessage{
+			MessageId:   fmt.Sprintf("message-%d", i),
+			EventSource: "aws:sqs",
+			Body:        body,
+		})
+	}
+
+	requests := 0
+	client := fakeS3Server(t, objects, &requests)
+	newS3Client = func(string, string) S3Getter { return client }
+	fastS3Retries(t)
+	defer func() { newS3Client = defaultS3Client }()
+
+	raw, _ := json.Marshal(sqsEvent)
+	out, err := Handler(context.Background(), raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, ok := out.(SQSProcessingResult)
+	if !ok {
+		t.Fatalf("unexpected response %T", out)
+	}
+	if len(response.BatchItemFailures) != 1 || response.BatchItemFailures[0].ItemIdentifier != "message-3" {
+		t.Errorf("expected only message-3 to fail, got %+v", response.BatchItemFailures)
+	}
+	if n := len(slack.Bodies()); n != 9 {
+		t.Errorf("expected the other 9 objects to alert, got %d messages", n)
+	}
+	if want := (ProcessingResult{9, 9, 9, 9}); response.ProcessingResult != want {
+		t.Errorf("ProcessingResult = %+v, want %+v", response.ProcessingResult, want)
+	}
+}
+
+func TestSQSHandlerSNSEnvelope(t *testing.T) {
+	raw, err := os.ReadFile("testdata/sns-s3-event.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snsEvent events.SNSEvent
+	if err := json.Unmarshal(raw, &snsEvent); err != nil {
+		t.Fatal(err)
+	}
+
+	// The test event carries no S3 records so it is acknowledged without
+	// any S3 call.
+	envelope, _ := json.Marshal(map[string]string{"Type": "Notification", "Message": snsEvent.Records[1].SNS.Message})
+	response, err := SQSHandler(context.Background(), events.SQSEvent{Records: []events.SQSMessage{
+		{MessageId: "message-1", EventSource: "aws:sqs", Body: string(envelope)},
+		{MessageId: "message-2", EventSource: "aws:sqs", Body: "not json"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.BatchItemFailures) != 1 || response.BatchItemFailures[0].ItemIdentifier != "message-2" {
+		t.Errorf("expected only message-2 to fail, got %+v", response.BatchItemFailures)
+	}
+}
+
+func TestEventBridgeHandler(t *testing.T) {
+	raw, err := os.ReadFile("testdata/eventbridge-cloudtrail-event.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slack := captureSlack(t)
+	if _, err := Handler(context.Background(), raw); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := slack.Bodies()
+	if len(bodies) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(bodies))
+	}
+	for _, want := range []string{
+		"*CreateTags* - ec2.amazonaws.com",
+		"first.last@example.com",
+		"https://console.aws.amazon.com/cloudtrail/home?region=eu-west-1#/events?EventId=b4c2d1e0-9f8e-4d7c-a6b5-c4d3e2f1a0b9|2021-05-14T19:03:40Z",
+	} {
+		if !strings.Contains(bodies[0], want) {
+			t.Errorf("message missing %q: %s", want, bodies[0])
+		}
+	}
+}
+
+func TestEventBridgeHandlerWithoutObject(t *testing.T) {
+	raw, err := os.ReadFile("testdata/eventbridge-cloudtrail-event.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alerts := captureAlerts(t)
+	hook := logtest.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+
+	if err := EventBridgeHandler(context.Background(), raw); err != nil {
+		t.Fatal(err)
+	}
+	if len(alerts.alerts) != 1 || alerts.alerts[0].S3URI != "" {
+		t.Errorf("expected an alert without an s3_uri, got %+v", alerts.alerts)
+	}
+	for _, entry := range hook.AllEntries() {
+		if uri, ok := entry.Data["s3_uri"]; ok && uri != "" {
+			t.Errorf("%s logged with s3_uri %v", entry.Message, uri)
+		}
+	}
+}
+
+func TestEventBridgeHandlerRejectsOtherEvents(t *testing.T) {
+	raw := json.RawMessage(`{"id":"x","detail-type":"EC2 Instance State-change Notification","detail":{"instance-id":"i-0123","state":"running"}}`)
+	if err := EventBridgeHandler(context.Background(), raw); err == nil {
+		t.Error("expected an error for a non CloudTrail event")
+	}
+}
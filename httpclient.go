@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Timeout of a notification request unless HTTP_TIMEOUT_SECONDS is set.
+const defaultHTTPTimeout = 10 * time.Second
+
+// Most of a response body read to let its connection be reused.
+const maxDrainLength = 64 << 10
+
+// notifyTransport is shared by every notification so that warm invocations
+// reuse their connections instead of paying for a new TLS handshake.
+var notifyTransport = &http.Transport{
+	Proxy: notifyProxy,
+	DialContext: (&net.Dialer{
+		Timeout:   5 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext,
+	ForceAttemptHTTP2:     true,
+	MaxIdleConns:          32,
+	MaxIdleConnsPerHost:   8,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   5 * time.Second,
+	ExpectContinueTimeout: time.Second,
+}
+
+var (
+	notifyProxyMu     sync.Mutex
+	notifyProxyEnv    string
+	notifyProxyParsed *url.URL
+)
+
+// notifyProxy returns the proxy a notification request is sent through:
+// NOTIFY_PROXY_URL when set, otherwise the one HTTPS_PROXY, HTTP_PROXY and
+// NO_PROXY select for the request's URL, nil for none.
+func notifyProxy(req *http.Request) (*url.URL, error) {
+	if proxy := notifyProxyOverride(); proxy != nil {
+		return proxy, nil
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+// notifyProxyOverride returns NOTIFY_PROXY_URL, parsing it again only when
+// it changes, or nil when it is unset or invalid.
+func notifyProxyOverride() *url.URL {
+	env := os.Getenv("NOTIFY_PROXY_URL")
+
+	notifyProxyMu.Lock()
+	defer notifyProxyMu.Unlock()
+	if notifyProxyEnv != env {
+		notifyProxyParsed, notifyProxyEnv = nil, env
+		if env != "" {
+			// Neither the value nor the parse error is logged, proxy URLs
+			// may carry credentials.
+			proxy, err := url.Parse(env)
+			if err != nil || (proxy.Scheme != "http" && proxy.Scheme != "https" && proxy.Scheme != "socks5") || proxy.Host == "" {
+				log.Warn("Ignoring invalid NOTIFY_PROXY_URL, expected an http, https or socks5 URL")
+			} else {
+				notifyProxyParsed = proxy
+			}
+		}
+	}
+	return notifyProxyParsed
+}
+
+var (
+	httpClientMu  sync.Mutex
+	httpClientEnv string
+	httpClient    *http.Client
+)
+
+// notifyHTTPClient returns the client every sink sends its notifications
+// with, timing requests out after HTTP_TIMEOUT_SECONDS.
+func notifyHTTPClient() *http.Client {
+	env := os.Getenv("HTTP_TIMEOUT_SECONDS")
+
+	httpClientMu.Lock()
+	defer httpClientMu.Unlock()
+	if httpClient == nil || httpClientEnv != env {
+		timeout := defaultHTTPTimeout
+		if env != "" {
+			if seconds, err := strconv.ParseFloat(env, 64); err == nil && seconds > 0 {
+				timeout = time.Duration(seconds * float64(time.Second))
+			} else {
+				log.Warnf("Ignoring invalid HTTP_TIMEOUT_SECONDS %q", env)
+			}
+		}
+		httpClientEnv = env
+		httpClient = &http.Client{Transport: notifyTransport, Timeout: timeout}
+	}
+	return tracedHTTPClient(httpClient)
+}
+
+// closeResponse reads what is left of a response body before closing it, as
+// a connection is only reused once its last response was read to the end.
+func closeResponse(resp *http.Response) {
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxDrainLength))
+	resp.Body.Close()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Values of the eventCategory of a CloudTrail record.
+const (
+	eventCategoryManagement = "Management"
+	eventCategoryData       = "Data"
+	eventCategoryInsight    = "Insight"
+)
+
+// Insight is a CloudTrail Insights event, raised when the rate of an API's
+// calls or errors departs from its baseline.
+type Insight struct {
+	Type        string
+	State       string
+	EventSource string
+	EventName   string
+	Baseline    float64
+	Average     float64
+	// Duration is the length of the insight in minutes, zero for the
+	// event starting it.
+	Duration float64
+}
+
+// ParseInsight extracts the insightDetails of an Insights event. It returns
+// nil for any other event category.
+func ParseInsight(record *CloudTrailRecord) *Insight {
+	if record.EventCategory != eventCategoryInsight {
+		return nil
+	}
+
+	details := record.InsightDetails
+	insightContext, _ := details["insightContext"].(map[string]interface{})
+	statistics, _ := insightContext["statistics"].(map[string]interface{})
+	baseline, _ := statistics["baseline"].(map[string]interface{})
+	observed, _ := statistics["insight"].(map[string]interface{})
+
+	return &Insight{
+		Type:        stringField(details, "insightType"),
+		State:       stringField(details, "state"),
+		EventSource: stringField(details, "eventSource"),
+		EventName:   stringField(details, "eventName"),
+		Baseline:    numberField(baseline, "average"),
+		Average:     numberField(observed, "average"),
+		Duration:    numberField(statistics, "insightDuration"),
+	}
+}
+
+func (i *Insight) Severity() string {
+	if i.State == "Start" {
+		return severityWarn
+	}
+	return severityInfo
+}
+
+func (i *Insight) Summary() string {
+	kind := "API call rate"
+	if i.Type == "ApiErrorRateInsight" {
+		kind = "API error rate"
+	}
+
+	s := fmt.Sprintf("%s insight %s: average %s against a baseline of %s",
+		kind, strings.ToLower(i.State), formatNumber(i.Average), formatNumber(i.Baseline))
+	if i.Duration > 0 {
+		s = fmt.Sprintf("%s over %s minutes", s, formatNumber(i.Duration))
+	}
+	return s
+}
+
+func numberField(m map[string]interface{}, key string) float64 {
+	switch v := m[key].(type) {
+	case float64:
+		return v
+	case string:
+		f, _ := strconv.ParseFloat(v, 64)
+		return f
+	}
+	return 0
+}
+
+func formatNumber(f float64) string {
+	return strconv.FormatFloat(math.Round(f*100)/100, 'f', -1, 64)
+}
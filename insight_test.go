@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func readLogFixture(t *testing.T, path string) *CloudTrailFile {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logFile CloudTrailFile
+	if err := json.Unmarshal(content, &logFile); err != nil {
+		t.Fatal(err)
+	}
+	return &logFile
+}
+
+func TestParseInsight(t *testing.T) {
+	logFile := readLogFixture(t, "testdata/insight-event.json")
+	record := &logFile.Records[0]
+
+	if record.EventSource != "iam.amazonaws.com" || record.EventName != "DeleteAccessKey" {
+		t.Errorf("event source and name should come from insightDetails, got %s %s", record.EventSource, record.EventName)
+	}
+	if reason := malformedRecord(record); reason != "" {
+		t.Errorf("insight considered malformed: %s", reason)
+	}
+
+	insight := ParseInsight(record)
+	if insight == nil {
+		t.Fatal("expected an insight")
+	}
+	want := Insight{Type: "ApiCallRateInsight", State: "Start", EventSource: "iam.amazonaws.com", EventName: "DeleteAccessKey", Baseline: 0.0208333333, Average: 12}
+	if *insight != want {
+		t.Errorf("ParseInsight() = %+v, want %+v", *insight, want)
+	}
+	if s := insight.Summary(); s != "API call rate insight start: average 12 against a baseline of 0.02" {
+		t.Errorf("Summary() = %q", s)
+	}
+	if insight.Severity() != severityWarn {
+		t.Errorf("Severity() = %s", insight.Severity())
+	}
+
+	if ParseInsight(typedRecord(consoleRecord("CreateTags", "event-1"))) != nil {
+		t.Error("expected nil for a management event")
+	}
+}
+
+func TestFilterRecordsInsight(t *testing.T) {
+	slack := captureSlack(t)
+
+	logFile := readLogFixture(t, "testdata/insight-event.json")
+	if _, err := FilterRecords(context.Background(), logFile.Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := slack.Bodies()
+	if len(bodies) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(bodies))
+	}
+	for _, want := range []string{
+		"*DeleteAccessKey* - iam.amazonaws.com",
+		"CloudTrail Insights",
+		"API call rate insight start: average 12 against a baseline of 0.02",
+	} {
+		if !strings.Contains(bodies[0], want) {
+			t.Errorf("message missing %q: %s", want, bodies[0])
+		}
+	}
+}
+
+func TestFilterRecordsDataEvent(t *testing.T) {
+	slack := captureSlack(t)
+
+	logFile := readLogFixture(t, "testdata/data-event.json")
+	record := logFile.Records[0]
+	if record.EventCategory != eventCategoryData || len(record.Resources) != 2 || record.Resources[1].AccountID != "012345678901" {
+		t.Errorf("unexpected resources %+v", record.Resources)
+	}
+
+	if _, err := FilterRecords(context.Background(), logFile.Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := slack.Bodies()
+	if len(bodies) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(bodies))
+	}
+	want := "Resources: arn:aws:s3:::example-bucket/reports/2021.csv, arn:aws:s3:::example-bucket"
+	if !strings.Contains(bodies[0], want) {
+		t.Errorf("message missing %q: %s", want, bodies[0])
+	}
+}
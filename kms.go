@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+var kmsKeyArnPattern = regexp.MustCompile(`arn:aws[a-z-]*:kms:[a-z0-9-]+:\d{12}:(?:key|alias)/[A-Za-z0-9/_-]+`)
+
+// KMSAccessError is returned when S3 can't decrypt an SSE-KMS log file
+// because the Lambda role may not use the KMS key it is encrypted with.
+type KMSAccessError struct {
+	// KeyArn is the key named by the error, empty when S3 didn't say.
+	KeyArn string
+	Err    error
+}
+
+func (e *KMSAccessError) Error() string {
+	key := e.KeyArn
+	if key == "" {
+		key = "the bucket's KMS key"
+	}
+	return fmt.Sprintf("KMS decryption failed, the Lambda role needs kms:Decrypt on %s in its key policy: %v", key, e.Err)
+}
+
+func (e *KMSAccessError) Unwrap() error {
+	return e.Err
+}
+
+// kmsAccessError returns a KMSAccessError for a GetObject failure caused by
+// KMS anywhere in the awserr chain, or nil for any other error.
+func kmsAccessError(err error) *KMSAccessError {
+	for cause := err; cause != nil; {
+		var aerr awserr.Error
+		if !errors.As(cause, &aerr) {
+			return nil
+		}
+		if isKMSError(aerr) {
+			return &KMSAccessError{KeyArn: kmsKeyArnPattern.FindString(aerr.Message()), Err: err}
+		}
+		cause = aerr.OrigErr()
+	}
+	return nil
+}
+
+func isKMSError(aerr awserr.Error) bool {
+	code, message := aerr.Code(), aerr.Message()
+	if strings.HasPrefix(code, "KMS.") {
+		return true
+	}
+	switch code {
+	case "AccessDenied", "AccessDeniedException":
+		return strings.Contains(message, "kms:") || strings.Contains(message, "KMS")
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+const testKMSKeyArn = "arn:aws:kms:us-east-1:012345678901:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+
+func TestFetchLogFromS3KMSAccessDenied(t *testing.T) {
+	fastS3Retries(t)
+
+	tests := []struct {
+		name   string
+		err    error
+		keyArn string
+	}{
+		{
+			name:   "access denied",
+			err:    awserr.NewRequestFailure(awserr.New("AccessDenied", "User: arn:aws:sts::012345678901:assumed-role/cloudtrail-console-actions/fn is not authorized to perform: kms:Decrypt on resource: "+testKMSKeyArn+" because no identity-based policy allows the kms:Decrypt action", nil), 403, "req-1"),
+			keyArn: testKMSKeyArn,
+		},
+		{
+			name:   "nested kms error",
+			err:    awserr.New("AccessDenied", "Access Denied", awserr.New("AccessDeniedException", "The ciphertext refers to a customer master key that does not exist or kms:Decrypt is not allowed", nil)),
+			keyArn: "",
+		},
+		{
+			name:   "disabled key",
+			err:    awserr.NewRequestFailure(awserr.New("KMS.DisabledException", testKMSKeyArn+" is disabled.", nil), 400, "req-1"),
+			keyArn: testKMSKeyArn,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			getter := &flakyS3Getter{errs: []error{tt.err}}
+			_, err := fetchLogFromS3(context.Background(), getter, "b", testS3Record.S3.Object.Key)
+
+			var kerr *KMSAccessError
+			if !errors.As(err, &kerr) {
+				t.Fatalf("expected a KMSAccessError, got %v", err)
+			}
+			if kerr.KeyArn != tt.keyArn {
+				t.Errorf("KeyArn = %q, want %q", kerr.KeyArn, tt.keyArn)
+			}
+			if !strings.Contains(err.Error(), "the Lambda role needs kms:Decrypt on ") {
+				t.Errorf("unhelpful message %q", err)
+			}
+			if tt.keyArn != "" && !strings.Contains(err.Error(), "kms:Decrypt on "+tt.keyArn) {
+				t.Errorf("message %q does not name the key", err)
+			}
+			var aerr awserr.Error
+			if !errors.As(err, &aerr) {
+				t.Error("the AWS error should still be wrapped")
+			}
+		})
+	}
+}
+
+func TestFetchLogFromS3AccessDeniedWithoutKMS(t *testing.T) {
+	fastS3Retries(t)
+
+	getter := &flakyS3Getter{errs: []error{awserr.NewRequestFailure(awserr.New("AccessDenied", "Access Denied", nil), 403, "req-1")}}
+	_, err := fetchLogFromS3(context.Background(), getter, "b", testS3Record.S3.Object.Key)
+
+	var kerr *KMSAccessError
+	if err == nil || errors.As(err, &kerr) {
+		t.Errorf("expected a plain AccessDenied, got %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/arn"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	linkStyleCloudTrail = "cloudtrail"
+	linkStyleResource   = "resource"
+)
+
+// consoleHost returns the AWS console hostname of the partition a region
+// belongs to.
+func consoleHost(region string) string {
+	switch {
+	case strings.HasPrefix(region, "us-gov-"):
+		return "console.amazonaws-us-gov.com"
+	case strings.HasPrefix(region, "cn-"):
+		return "console.amazonaws.cn"
+	}
+	return "console.aws.amazon.com"
+}
+
+func consoleEventURL(region, eventID string) string {
+	return fmt.Sprintf("https://%s/cloudtrail/home?region=%s#/events?EventId=%s", consoleHost(region), region, eventID)
+}
+
+// alertURL returns the link of an alert. With LINK_STYLE=resource it is the
+// service console page of the first resource of the record there is one for,
+// otherwise the event in the CloudTrail console.
+func alertURL(record *CloudTrailRecord) string {
+	link, _ := alertLink(record)
+	return link
+}
+
+// alertLink returns alertURL with a button label naming what it opens, e.g.
+// "View in CloudTrail" or "View bucket".
+func alertLink(record *CloudTrailRecord) (string, string) {
+	switch style := strings.ToLower(os.Getenv("LINK_STYLE")); style {
+	case "", linkStyleCloudTrail:
+	case linkStyleResource:
+		for _, resource := range resourceARNs(record) {
+			if link, label := resourceConsoleURL(resource, record.AwsRegion); link != "" {
+				return link, label
+			}
+		}
+	default:
+		log.Warnf("Ignoring invalid LINK_STYLE %q, using %s", style, linkStyleCloudTrail)
+	}
+	return consoleEventURL(record.AwsRegion, record.EventID), "View in CloudTrail"
+}
+
+// resourceConsoleURL returns the service console page of an S3 bucket, IAM
+// role or EC2 instance ARN and its label, or empty strings for other
+// resources. Global resources open in the event's region.
+func resourceConsoleURL(resourceARN, region string) (string, string) {
+	parsed, err := arn.Parse(resourceARN)
+	if err != nil {
+		return "", ""
+	}
+	if parsed.Region != "" {
+		region = parsed.Region
+	}
+	host := consoleHost(region)
+
+	switch parsed.Service {
+	case "s3":
+		// Object ARNs are <bucket>/<key>.
+		bucket := strings.SplitN(parsed.Resource, "/", 2)[0]
+		if bucket == "" {
+			return "", ""
+		}
+		return fmt.Sprintf("https://%s/s3/buckets/%s?region=%s", host, url.PathEscape(bucket), region), "View bucket"
+	case "iam":
+		if !strings.HasPrefix(parsed.Resource, "role/") {
+			return "", ""
+		}
+		name := parsed.Resource[strings.LastIndex(parsed.Resource, "/")+1:]
+		return fmt.Sprintf("https://%s/iam/home#/roles/%s", host, url.PathEscape(name)), "View role"
+	case "ec2":
+		if !strings.HasPrefix(parsed.Resource, "instance/") || region == "" {
+			return "", ""
+		}
+		return fmt.Sprintf("https://%s/ec2/home?region=%s#InstanceDetails:instanceId=%s", host, region, strings.TrimPrefix(parsed.Resource, "instance/")), "View instance"
+	}
+	return "", ""
+}
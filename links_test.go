@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestConsoleEventURL(t *testing.T) {
+	tests := []struct {
+		region, want string
+	}{
+		{"us-east-1", "https://console.aws.amazon.com/cloudtrail/home?region=us-east-1#/events?EventId=event-1"},
+		{"us-gov-west-1", "https://console.amazonaws-us-gov.com/cloudtrail/home?region=us-gov-west-1#/events?EventId=event-1"},
+		{"cn-north-1", "https://console.amazonaws.cn/cloudtrail/home?region=cn-north-1#/events?EventId=event-1"},
+	}
+
+	for _, tt := range tests {
+		if got := consoleEventURL(tt.region, "event-1"); got != tt.want {
+			t.Errorf("consoleEventURL(%s) = %s, want %s", tt.region, got, tt.want)
+		}
+	}
+}
+
+func TestAlertURL(t *testing.T) {
+	record := func(eventName string, arns ...string) *CloudTrailRecord {
+		raw := consoleRecord(eventName, "event-1")
+		var resources []interface{}
+		for _, arn := range arns {
+			resources = append(resources, map[string]interface{}{"ARN": arn})
+		}
+		raw["resources"] = resources
+		return typedRecord(raw)
+	}
+	cloudTrailURL := "https://console.aws.amazon.com/cloudtrail/home?region=us-east-1#/events?EventId=event-1"
+
+	tests := []struct {
+		style  string
+		record *CloudTrailRecord
+		want   string
+	}{
+		{"resource", record("PutBucketPolicy", "arn:aws:s3:::example-bucket"), "https://console.aws.amazon.com/s3/buckets/example-bucket?region=us-east-1"},
+		{"resource", record("PutObjectAcl", "arn:aws:s3:::example-bucket/reports/2021.csv"), "https://console.aws.amazon.com/s3/buckets/example-bucket?region=us-east-1"},
+		{"resource", record("AttachRolePolicy", "arn:aws:iam::012345678901:role/service-role/Admin"), "https://console.aws.amazon.com/iam/home#/roles/Admin"},
+		{"resource", record("StopInstances", "arn:aws:ec2:eu-west-1:012345678901:instance/i-0123456789abcdef0"), "https://console.aws.amazon.com/ec2/home?region=eu-west-1#InstanceDetails:instanceId=i-0123456789abcdef0"},
+		{"resource", record("ScheduleKeyDeletion", "arn:aws:kms:us-east-1:012345678901:key/1234abcd", "arn:aws:s3:::example-bucket"), "https://console.aws.amazon.com/s3/buckets/example-bucket?region=us-east-1"},
+		// Resources without a console page fall back to the event.
+		{"resource", record("ScheduleKeyDeletion", "arn:aws:kms:us-east-1:012345678901:key/1234abcd"), cloudTrailURL},
+		{"resource", record("AttachUserPolicy", "arn:aws:iam::012345678901:user/first.last"), cloudTrailURL},
+		{"resource", record("CreateTags", "not-an-arn"), cloudTrailURL},
+		{"resource", record("CreateTags"), cloudTrailURL},
+		{"", record("PutBucketPolicy", "arn:aws:s3:::example-bucket"), cloudTrailURL},
+		{"cloudtrail", record("PutBucketPolicy", "arn:aws:s3:::example-bucket"), cloudTrailURL},
+		{"bogus", record("PutBucketPolicy", "arn:aws:s3:::example-bucket"), cloudTrailURL},
+	}
+
+	for _, tt := range tests {
+		setEnv(t, "LINK_STYLE", tt.style)
+		if got := alertURL(tt.record); got != tt.want {
+			t.Errorf("LINK_STYLE=%q alertURL(%s %v) = %s, want %s", tt.style, tt.record.EventName, resourceARNs(tt.record), got, tt.want)
+		}
+	}
+}
+
+func TestFilterRecordsLinkStyleResource(t *testing.T) {
+	slack := captureSlack(t)
+	setEnv(t, "LINK_STYLE", "resource")
+
+	record := consoleRecord("PutBucketPolicy", "event-1")
+	record["eventSource"] = "s3.amazonaws.com"
+	record["resources"] = []interface{}{map[string]interface{}{"ARN": "arn:aws:s3:::example-bucket", "type": "AWS::S3::Bucket"}}
+	if _, err := FilterRecords(context.Background(), cloudTrailFile(record).Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := slack.Bodies()
+	if len(bodies) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(bodies))
+	}
+	if want := "<https://console.aws.amazon.com/s3/buckets/example-bucket?region=us-east-1|"; !strings.Contains(bodies[0], want) {
+		t.Errorf("expected %s in %s", want, bodies[0])
+	}
+}
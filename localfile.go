@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Bucket name of the s3_uri of a local log file.
+const localBucket = "local"
+
+// printNotifier writes every alert to w as a line of JSON instead of
+// notifying anyone.
+type printNotifier struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (n printNotifier) Notify(ctx context.Context, alert AlertEvent) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return json.NewEncoder(n.w).Encode(alert)
+}
+
+// processLocalFile runs a log file on disk, gzipped or not, through the same
+// decoding and filtering as a log file read from S3 and writes the events
+// that would alert to w. Nothing is notified or recorded, as in a dry run.
+func processLocalFile(ctx context.Context, path string, w io.Writer) (ProcessingResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ProcessingResult{}, err
+	}
+
+	ctx = withDryRun(withNotifiers(ctx, printNotifier{mu: &sync.Mutex{}, w: w}))
+	ctx, err = prepareInvocation(ctx)
+	if err != nil {
+		f.Close()
+		return ProcessingResult{}, err
+	}
+	defer reportNotifyFailures(ctx)
+
+	body, err := openLogFile(&s3.GetObjectOutput{Body: f})
+	if err != nil {
+		return ProcessingResult{}, fmt.Errorf("%v: %w", path, err)
+	}
+	defer body.Close()
+
+	evt := events.S3EventRecord{S3: events.S3Entity{
+		Bucket: events.S3Bucket{Name: localBucket},
+		Object: events.S3Object{Key: path},
+	}}
+	if _, err := FilterRecords(ctx, decodeRecords(body), evt); err != nil {
+		return processingCounterFrom(ctx).Result(), fmt.Errorf("%v: %w", path, err)
+	}
+	counter := processingCounterFrom(ctx)
+	counter.Add(metricObjectsProcessed, 1)
+	return counter.Result(), nil
+}
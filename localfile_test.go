@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestProcessLocalFile(t *testing.T) {
+	slack := captureSlack(t)
+
+	content, err := ioutil.ReadFile("testdata/e2e-cloudtrail.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gzipped := filepath.Join(t.TempDir(), "file.json.gz")
+	if err := ioutil.WriteFile(gzipped, gzipBytes(t, content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"testdata/e2e-cloudtrail.json", gzipped} {
+		var out bytes.Buffer
+		result, err := processLocalFile(context.Background(), path, &out)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		dec := json.NewDecoder(&out)
+		for dec.More() {
+			var alert AlertEvent
+			if err := dec.Decode(&alert); err != nil {
+				t.Fatal(err)
+			}
+			if alert.S3URI != "s3://local/"+path {
+				t.Errorf("s3_uri = %s", alert.S3URI)
+			}
+			got = append(got, alert.EventID)
+		}
+		sort.Strings(got)
+		if want := []string{"e2e-console-create-tags", "e2e-s3console-put-bucket-policy"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: printed %v, want %v", path, got, want)
+		}
+		if result.ObjectsProcessed != 1 || result.RecordsFilteredIn != 2 || result.RecordsScanned == 0 {
+			t.Errorf("%s: unexpected result %+v", path, result)
+		}
+	}
+
+	if n := len(slack.Bodies()); n != 0 {
+		t.Errorf("expected nothing to be sent to Slack, got %d messages", n)
+	}
+}
+
+func TestProcessLocalFileMissing(t *testing.T) {
+	if _, err := processLocalFile(context.Background(), filepath.Join(t.TempDir(), "missing.json"), ioutil.Discard); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestProcessLocalFileDryRun(t *testing.T) {
+	slack := captureSlack(t)
+	fake := withFakeDynamoDB(t)
+	setEnv(t, "DEDUPE_TABLE", "cloudtrail-alerts")
+	setEnv(t, "SUMMARY_MODE", "true")
+
+	if _, err := processLocalFile(context.Background(), "testdata/e2e-cloudtrail.json", ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if bodies := slack.Bodies(); len(bodies) != 0 {
+		t.Errorf("expected no summary to be sent to Slack, got %v", bodies)
+	}
+	if len(fake.inputs) != 0 {
+		t.Errorf("expected no DEDUPE_TABLE writes, got %d", len(fake.inputs))
+	}
+}
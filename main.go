@@ -1,347 +1,1070 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
 )
 
 type CloudTrailFile struct {
-	Records []map[string]interface{} `json:"Records"`
+	Records []CloudTrailRecord `json:"Records"`
+}
+
+// S3Getter is the part of the S3 API used to read log files.
+type S3Getter interface {
+	GetObjectWithContext(aws.Context, *s3.GetObjectInput, ...request.Option) (*s3.GetObjectOutput, error)
+}
+
+var newS3Client = defaultS3Client
+
+// defaultS3Client builds an S3 client for region, assuming roleArn first when
+// it is set. SDK retries are off as getObjectWithRetry retries log files.
+func defaultS3Client(region, roleArn string) S3Getter {
+	sess := session.Must(session.NewSession())
+	s3ClientConfig := s3Config(aws.NewConfig().WithRegion(region).WithMaxRetries(0))
+	if roleArn != "" {
+		s3ClientConfig = s3ClientConfig.WithCredentials(stscreds.NewCredentials(sess, roleArn))
+	}
+	s3Client := s3.New(sess, s3ClientConfig)
+	traceAWSClient(s3Client.Client)
+	return s3Client
+}
+
+// s3Config points an S3 client config at AWS_S3_ENDPOINT and turns on
+// path-style addressing with S3_FORCE_PATH_STYLE, for localstack and other
+// S3-compatible stores.
+func s3Config(config *aws.Config) *aws.Config {
+	if endpoint := os.Getenv("AWS_S3_ENDPOINT"); endpoint != "" {
+		config = config.WithEndpoint(endpoint)
+	}
+	if getEnvBool("S3_FORCE_PATH_STYLE", false) {
+		config = config.WithS3ForcePathStyle(true)
+	}
+	return config
 }
 
 func init() {
 }
 
 func main() {
+	file := flag.String("file", "", "process a local CloudTrail log file and print the events that would alert instead of running as a Lambda function")
+	flag.Parse()
+
 	log.SetFormatter(&log.JSONFormatter{})
+	configureLogLevel()
+
+	if *file != "" {
+		result, err := processLocalFile(context.Background(), *file, os.Stdout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.WithFields(log.Fields{
+			"records_scanned":     result.RecordsScanned,
+			"records_filtered_in": result.RecordsFilteredIn,
+		}).Info("Processed local file")
+		return
+	}
+
 	log.Info("Starting v0.1.5")
-	lambda.Start(S3Handler)
+	checkCoalesceConfig()
+	lambda.Start(Handler)
 }
 
-func S3Handler(ctx context.Context, s3Event events.S3Event) error {
+func configureLogLevel() {
+	log.SetLevel(logLevel(os.Getenv("LOG_LEVEL")))
+}
+
+// logLevel parses a LOG_LEVEL value and falls back to info when it is empty
+// or invalid.
+func logLevel(value string) log.Level {
+	if value == "" {
+		return log.InfoLevel
+	}
+	level, err := log.ParseLevel(value)
+	if err != nil {
+		log.Warnf("Ignoring invalid LOG_LEVEL %q: %v", value, err)
+		return log.InfoLevel
+	}
+	return level
+}
+
+// S3Handler filters the log files of an S3 event notification and returns
+// how much work it did, also when it fails part way.
+func S3Handler(ctx context.Context, s3Event events.S3Event) (result ProcessingResult, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoveredPanic(r)
+		}
+	}()
 	log.Infof("S3 event: %v", s3Event)
 
-	for _, s3Record := range s3Event.Records {
-		err := Stream(s3Record)
+	ctx, err = prepareInvocation(ctx)
+	if err != nil {
+		return ProcessingResult{}, err
+	}
+	defer flushMetrics(ctx)
+	defer reportNotifyFailures(ctx)
+	counter := processingCounterFrom(ctx)
+
+	// Every object is processed even when another one fails.
+	errs := make([]error, len(s3Event.Records))
+	var g errgroup.Group
+	g.SetLimit(objectConcurrency())
+	for i, s3Record := range s3Event.Records {
+		i, s3Record := i, s3Record
+		g.Go(func() error {
+			// The handler can't recover a panic raised on another
+			// goroutine.
+			defer func() {
+				if r := recover(); r != nil {
+					errs[i] = fmt.Errorf("s3://%s/%s: %w", s3Record.S3.Bucket.Name, s3Record.S3.Object.Key, recoveredPanic(r))
+				}
+			}()
+			err := Stream(ctx, s3Record)
+			if errors.Is(err, ErrSkippedObject) {
+				log.Debug(err)
+			} else if err != nil {
+				errs[i] = retryableError(s3Record, err)
+			}
+			return nil
+		})
+	}
+	g.Wait()
+
+	var failed objectErrors
+	for _, err := range errs {
 		if err != nil {
-			return err
+			failed = append(failed, err)
 		}
 	}
+	if len(failed) > 0 {
+		return counter.Result(), failed
+	}
+	return counter.Result(), nil
+}
 
-	return nil
+// objectErrors collects the failures of the objects of one S3 event.
+type objectErrors []error
+
+func (e objectErrors) Error() string {
+	var messages []string
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
 }
 
-func FilterRecords(logFile *CloudTrailFile, evt events.S3EventRecord) error {
-	for _, record := range logFile.Records {
-		userIdentity, _ := record["userIdentity"].(map[string]interface{})
+// prepareInvocation sets up the per-invocation state shared by every handler.
+func prepareInvocation(ctx context.Context) (context.Context, error) {
+	if err := loadFilterConfig(ctx); err != nil {
+		return ctx, err
+	}
+	// Compiles the user agent expressions, the severity and parameter
+	// rules, the runbook links, the extra fields, the Slack template, the
+	// key pattern and the source IP networks so invalid ones are reported
+	// once.
+	consoleUserAgents()
+	suppressedUserAgents()
+	activeSeverityRules()
+	activeParamMatchRules()
+	activeRunbookLinks()
+	activeExtraFields()
+	slackTemplate()
+	cloudTrailKeyPattern()
+	activeSourceIPNetworks()
+	ctx = withProcessingCounter(withEventDedupe(withMetrics(withNotifyBudget(withNotifyFailures(ctx)))))
+	return withConfiguredNotifiers(ctx), nil
+}
 
-		if userIdentity["invokedBy"] == "AWS Internal" {
-			continue
+// RecordStream calls fn with each record of a log file in turn, stopping at
+// the first error fn returns.
+type RecordStream func(fn func(record *CloudTrailRecord) error) error
+
+func (f *CloudTrailFile) Stream() RecordStream {
+	return func(fn func(record *CloudTrailRecord) error) error {
+		for i := range f.Records {
+			if err := fn(&f.Records[i]); err != nil {
+				return err
+			}
 		}
+		return nil
+	}
+}
 
-		switch en := record["eventName"].(string); {
-		// Some events don't match AWS defined standards
-		// So we have to convert the input to Title
-		case strings.HasPrefix(strings.Title(en), "Get"):
-			continue
-		// Some events don't match AWS defined standards
-		// So we have to convert the input to Title
-		case strings.HasPrefix(strings.Title(en), "List"):
-			continue
-		// Some events don't match AWS defined standards
-		// So we have to convert the input to Title
-		case strings.HasPrefix(strings.Title(en), "View"):
-			continue
-		case strings.HasPrefix(en, "Head"):
-			continue
-		case strings.HasPrefix(en, "Describe"):
-			continue
-		case strings.HasPrefix(en, "Test"):
-			continue
-		case strings.HasPrefix(en, "Download"):
-			continue
-		case strings.HasPrefix(en, "Report"):
-			continue
-		case strings.HasPrefix(en, "Poll"):
-			continue
-		case strings.HasPrefix(en, "Verify"):
-			continue
-		case strings.HasPrefix(en, "Skip"):
-			continue
-		case strings.HasPrefix(en, "Count"):
-			continue
-		case strings.HasPrefix(en, "Detect"):
-			continue
-		case strings.HasPrefix(en, "Lookup"):
-			continue
-		case en == "ConsoleLogin":
-			continue
-		case strings.HasSuffix(en, "VirtualMFADevice"):
-			continue
-		case en == "CheckMfa":
-			continue
-		case en == "CheckDomainAvailability":
-			continue
-		case en == "Decrypt":
-			continue
-		case en == "SetTaskStatus":
-			continue
-		case en == "BatchGetQueryExecution":
-			continue
-		case en == "QueryObjects":
-			continue
-		case strings.HasPrefix(en, "StartQuery"):
-			continue
-		case strings.HasPrefix(en, "StopQuery"):
-			continue
-		case strings.HasPrefix(en, "CancelQuery"):
-			continue
-		case strings.HasPrefix(en, "BatchGet"):
-			continue
-		case strings.HasPrefix(en, "Search"):
-			continue
-		case en == "GenerateServiceLastAccessedDetails":
-			continue
-		case en == "REST.GET.OBJECT_LOCK_CONFIGURATION":
-			continue
-		case en == "AssumeRoleWithWebIdentity":
-			continue
-		case en == "PutQueryDefinition":
-			if record["eventSource"] == "logs.amazonaws.com" {
-				continue
+// Number of records filtered and notified at once unless WORKER_CONCURRENCY
+// is set.
+const defaultWorkerConcurrency = 4
+
+// Number of objects of an S3 event read at once unless OBJECT_CONCURRENCY is
+// set.
+const defaultObjectConcurrency = 4
+
+// FilterRecords logs and notifies the records of a log file that
+// ShouldAlert, and returns them.
+func FilterRecords(ctx context.Context, records RecordStream, evt events.S3EventRecord) (FilterResult, error) {
+	if notifyFailuresFrom(ctx) == nil {
+		// Called outside of an invocation, which otherwise reports them.
+		ctx = withNotifyFailures(ctx)
+		defer reportNotifyFailures(ctx)
+	}
+	sorted := getEnvBool("SORT_BY_EVENT_TIME", false)
+	if sorted {
+		// Sorting needs the whole file in memory.
+		var logFile CloudTrailFile
+		if err := records(func(record *CloudTrailRecord) error {
+			logFile.Records = append(logFile.Records, *record)
+			return nil
+		}); err != nil {
+			return FilterResult{}, err
+		}
+		sortRecordsByEventTime(logFile.Records)
+		records = logFile.Stream()
+	}
+
+	// Matched and deferred records are numbered so they are listed in file
+	// order regardless of which worker finished first.
+	type numberedRecord struct {
+		seq       int
+		record    *CloudTrailRecord
+		duplicate bool
+	}
+	var (
+		mu       sync.Mutex
+		matched  []numberedRecord
+		deferred []numberedRecord
+		// panicked is the first panic recovered from a record.
+		panicked error
+	)
+	// The summary is sent with ctx as it is now, the worker context is
+	// canceled once they are done.
+	defer func(ctx context.Context) {
+		sort.Slice(deferred, func(i, j int) bool { return deferred[i].seq < deferred[j].seq })
+		var unsent []*CloudTrailRecord
+		for _, d := range deferred {
+			unsent = append(unsent, d.record)
+		}
+		sendBudgetSummary(ctx, unsent, evt)
+	}(ctx)
+
+	if getEnvBool("SUMMARY_MODE", false) {
+		summary := newEventSummary()
+		ctx = withEventSummary(ctx, summary)
+		defer sendEventSummary(ctx, summary, evt)
+	}
+
+	ctx = withConfiguredNotifiers(withEventDedupe(ctx))
+	var order *dispatchOrder
+	if sorted {
+		// Keeps the ordered notifiers in eventTime order.
+		order = newDispatchOrder()
+	}
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(workerConcurrency())
+
+	metrics := metricsFrom(ctx)
+	counter := processingCounterFrom(ctx)
+	filter := newRecordFilter()
+	seq := 0
+	err := records(func(record *CloudTrailRecord) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		metrics.Add(metricRecordsScanned, 1)
+		counter.Add(metricRecordsScanned, 1)
+		index := seq
+		seq++
+
+		if reason := malformedRecord(record); reason != "" {
+			malformedRecordLog(index, evt).Warnf("Skipping malformed record: %s", reason)
+			if order != nil {
+				order.Done(index)
+			}
+			return nil
+		}
+		checkEventVersion(record)
+		g.Go(func() error {
+			ctx := ctx
+			if order != nil {
+				defer order.Done(index)
+				ctx = withDispatchTurn(ctx, order, index)
 			}
-		case en == "PutObject":
-			// Fingerprinting on KeyPath for LB Logs
-			// Objects are originating outside our account with these account ids.
-			// https://docs.aws.amazon.com/elasticloadbalancing/latest/classic/enable-access-logs.html
-			if rps, ok := record["requestParameters"].(map[string]interface{}); ok {
-				if k, ok := rps["key"].(string); ok {
-					if strings.HasPrefix(k, "elb/AWSLogs") {
-						continue
+			// A record the filter can't cope with must not take the
+			// rest of the file down with it, but fails the file once
+			// it is done.
+			defer func() {
+				if r := recover(); r != nil {
+					malformedRecordLog(index, evt).Warnf("Skipping record that could not be filtered: %v", r)
+					mu.Lock()
+					if panicked == nil {
+						panicked = fmt.Errorf("record %d: %w", index, recoveredPanic(r))
 					}
+					mu.Unlock()
 				}
-			}
+			}()
 
-		case en == "AssumeRole":
-			if record["userAgent"] == "Coral/Netty4" {
-				switch userIdentity["invokedBy"] {
-				case
-					"ecs-tasks.amazonaws.com",
-					"ec2.amazonaws.com",
-					"monitoring.rds.amazonaws.com",
-					"lambda.amazonaws.com":
-					continue
-				}
+			alert, reason := filter.ShouldAlert(record)
+			if !alert {
+				log.Debugf("Not alerting on %s: %s", record.EventID, reason)
+				return nil
 			}
+			isMatched, held := filterRecord(ctx, record, evt, filter.Always(record))
+			mu.Lock()
+			matched = append(matched, numberedRecord{index, record, !isMatched})
+			if held {
+				deferred = append(deferred, numberedRecord{index, record, false})
+			}
+			mu.Unlock()
+			return nil
+		})
+		return nil
+	})
+	if werr := g.Wait(); err == nil {
+		err = werr
+	}
+	if err == nil {
+		err = panicked
+	}
+
+	sort.Slice(matched, func(i, j int) bool { return matched[i].seq < matched[j].seq })
+	// Any copy of an event repeated in the file may be the one the workers
+	// notified, it is listed once where it first appears. Events notified
+	// for an earlier object of the invocation aren't listed at all.
+	notified := map[string]bool{}
+	for _, m := range matched {
+		if !m.duplicate {
+			notified[m.record.EventID] = true
 		}
+	}
+	listed := map[string]bool{}
+	var result FilterResult
+	for _, m := range matched {
+		id := m.record.EventID
+		if !notified[id] || listed[id] {
+			continue
+		}
+		if id != "" {
+			listed[id] = true
+		}
+		result.Matched = append(result.Matched, m.record)
+	}
+	return result, err
+}
 
-		if usa, ok := record["userAgent"]; ok {
-			switch ua := usa.(string); {
-			case ua == "console.amazonaws.com":
-				break
-			case ua == "signin.amazonaws.com":
-				break
-			case ua == "Coral/Jakarta":
-				break
-			case ua == "Coral/Netty4":
-				break
-			case ua == "AWS CloudWatch Console":
-				break
-			case strings.HasPrefix(ua, "AWS Signin"):
-				break
-			case strings.HasPrefix(ua, "S3Console/"):
-				break
-			case strings.HasPrefix(ua, "[S3Console"):
-				break
-			case strings.HasPrefix(ua, "Mozilla/"):
-				break
-			case matchString("console.*.amazonaws.com", ua):
-				break
-			case matchString("signin.*.amazonaws.com", ua):
-				break
-			case matchString("aws-internal*", ua):
-				break
-			default:
-				continue
-			}
+// objectURI returns the s3:// URI of the log file of evt, empty for events
+// that didn't come from a log file, such as those delivered by EventBridge.
+func objectURI(evt events.S3EventRecord) string {
+	if evt.S3.Bucket.Name == "" {
+		return ""
+	}
+	return fmt.Sprintf("s3://%s/%s", evt.S3.Bucket.Name, evt.S3.Object.Key)
+}
+
+func malformedRecordLog(index int, evt events.S3EventRecord) *log.Entry {
+	return log.WithFields(log.Fields{
+		"record_index": index,
+		"s3_uri":       objectURI(evt),
+	})
+}
+
+// alwaysAlertEvents returns the event names of ALWAYS_ALERT_EVENTS, which
+// alert regardless of any filter.
+func alwaysAlertEvents() map[string]bool {
+	names := map[string]bool{}
+	for _, name := range strings.Split(os.Getenv("ALWAYS_ALERT_EVENTS"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+func workerConcurrency() int {
+	return concurrency("WORKER_CONCURRENCY", defaultWorkerConcurrency)
+}
+
+func objectConcurrency() int {
+	return concurrency("OBJECT_CONCURRENCY", defaultObjectConcurrency)
+}
+
+// concurrency returns the positive number set in env, or fallback.
+func concurrency(env string, fallback int) int {
+	v := os.Getenv(env)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		log.Warnf("Ignoring invalid %s %q", env, v)
+		return fallback
+	}
+	return n
+}
+
+// filterRecord logs and notifies a single record that ShouldAlert, with
+// always set when it bypasses MAX_EVENT_AGE and the quiet hours. It reports
+// whether the record matched, i.e. wasn't a duplicate, and whether the
+// notification was held back by the time budget.
+func filterRecord(ctx context.Context, record *CloudTrailRecord, evt events.S3EventRecord, always bool) (matched, held bool) {
+	metrics := metricsFrom(ctx)
+	counter := processingCounterFrom(ctx)
+	userIdentity := record.UserIdentity
+
+	detections := MatchDetections(record)
+	if !firstNotification(ctx, record.EventID) {
+		log.Debugf("Skipping duplicate event %s", record.EventID)
+		return false, false
+	}
+	metrics.Add(metricRecordsMatched, 1)
+	counter.Add(metricRecordsMatched, 1)
+
+	userName := principalDisplayName(ctx, userIdentity)
+	accountID := userIdentity.AccountID
+	insight := ParseInsight(record)
+	if insight != nil {
+		// Insights have no userIdentity, CloudTrail raised them.
+		userName = "CloudTrail Insights"
+		accountID = record.RecipientAccountID
+	}
+	if accountID == "" {
+		// Some service events have no userIdentity.accountId, the key of
+		// the log file names the account it was delivered for.
+		accountID = logAccountID(evt.S3.Object.Key)
+	}
+
+	severity := activeSeverityRules().Severity(record.EventName)
+	var details []string
+	var detectionNames []string
+	for _, d := range detections {
+		severity = maxSeverity(severity, d.Severity)
+		detectionNames = append(detectionNames, d.Name)
+	}
+	if len(detectionNames) > 0 {
+		details = append(details, fmt.Sprintf("Detections: %s", strings.Join(detectionNames, ", ")))
+	}
+	if loggingDisabledAlert(record) {
+		severity = severityCritical
+		details = append(details, loggingDisabledSummary(record))
+	}
+	exposure := AssessBucketExposure(record)
+	if exposure != nil {
+		severity = maxSeverity(severity, exposure.Severity())
+		details = append(details, exposure.Summary())
+		details = append(details, exposure.Findings...)
+	}
+	signIn := ParseSignIn(record)
+	if signIn != nil {
+		severity = maxSeverity(severity, signIn.Severity())
+		details = append(details, signIn.Summary())
+	}
+	crossAccount := ParseCrossAccountAssumeRole(record)
+	if crossAccount != nil {
+		severity = maxSeverity(severity, crossAccount.Severity())
+		details = append(details, crossAccount.Summary())
+	}
+	if insight != nil {
+		severity = maxSeverity(severity, insight.Severity())
+		details = append(details, insight.Summary())
+	}
+	session := ParseSession(record)
+	if session != nil {
+		details = append(details, session.Summary())
+	}
+	if privilegedWithoutMFA(record, session, severity) {
+		// Leads the details so it can't be missed.
+		details = append([]string{"Privileged action performed without MFA"}, details...)
+	}
+	if summary := errorSummary(record); summary != "" {
+		details = append(details, summary)
+	}
+	recipientAccount := recipientAccountID(record)
+	resources := resourceARNs(record)
+	extraFields := activeExtraFields().Extract(record)
+
+	s3URI := objectURI(evt)
+	age, ageKnown := eventAge(record, time.Now())
+	sourceIP := describeSourceIP(record.SourceIPAddress)
+	eventURL, eventURLLabel := alertLink(record)
+	alert := AlertEvent{
+		EventName:          record.EventName,
+		EventSource:        record.EventSource,
+		EventID:            record.EventID,
+		EventTime:          record.EventTime,
+		EventVersion:       record.EventVersion,
+		Region:             record.AwsRegion,
+		AccountID:          accountID,
+		Account:            accountLabel(accountID),
+		RecipientAccountID: recipientAccount,
+		UserName:           userName,
+		SourceIP:           sourceIP,
+		S3URI:              s3URI,
+		EventURL:           eventURL,
+		EventURLLabel:      eventURLLabel,
+		RunbookURL:         runbookURL(record.EventName),
+		Severity:           severity,
+		Details:            details,
+		Resources:          resources,
+		ExtraFields:        extraFields,
+		Record:             record,
+	}
+	if session != nil {
+		alert.MFAAuthenticated = session.MFAAuthenticated
+		alert.SourceIdentity = session.SourceIdentity
+	}
+	if ageKnown {
+		alert.Age = formatEventAge(age)
+	}
+	logMatchedEvent(alert)
+
+	if maxAge := maxEventAge(); !always && ageKnown && maxAge > 0 && age > maxAge {
+		log.Debugf("Not notifying %s, it is %s old", record.EventID, formatEventAge(age))
+		return true, false
+	}
+
+	if !always && severity != severityCritical && activeQuietHours().Contains(record.EventTime) {
+		log.Debugf("Not notifying %s during quiet hours", record.EventID)
+		return true, false
+	}
+
+	if summary := eventSummaryFrom(ctx); summary != nil {
+		summary.Add(alert)
+		return true, false
+	}
+	if notifyBudgetExceeded(ctx) {
+		return true, true
+	}
+	if !claimNotification(ctx, record.EventID) {
+		log.Debugf("Skipping %s, already notified by another invocation", record.EventID)
+		return true, false
+	}
+	if !always && !claimCoalesceWindow(ctx, record, time.Now()) {
+		log.Debugf("Not notifying %s, %s was alerted for the same actor within COALESCE_WINDOW", record.EventID, record.EventName)
+		return true, false
+	}
+	if err := notifyFunc(ctx, alert); err != nil {
+		log.Debugf("Notifying %s: %v", record.EventID, err)
+		notifyFailuresFrom(ctx).Add(err)
+		// A retry of the log file gets to alert the event again.
+		if !always {
+			releaseCoalesceWindow(ctx, record)
 		}
+		releaseNotification(ctx, record.EventID)
+	}
+	return true, false
+}
+
+// ignoredReason returns why IGNORE_PRINCIPALS, IGNORE_IDENTITY_TYPES,
+// SUPPRESS_ENVIRONMENTS or IGNORE_ERROR_CODES drop a record, empty when none
+// of them does. Unlike the other suppressions they also drop detections.
+func ignoredReason(record *CloudTrailRecord) string {
+	switch {
+	case ignoredPrincipal(record):
+		return "ignored principal"
+	case ignoredIdentityType(record):
+		return "ignored identity type"
+	case suppressedEnvironment(record):
+		return "suppressed environment"
+	case ignoredErrorCode(record):
+		return "ignored error code"
+	}
+	return ""
+}
+
+// suppressRecord reports whether a record is read-only, internal, made by an
+// ignored principal or was not made from the console and so should not alert.
+func suppressRecord(record *CloudTrailRecord) bool {
+	return suppressionReason(record) != ""
+}
 
-		userName := fmt.Sprintf("%s", userIdentity["principalId"])
-		if strings.Contains(userName, ":") {
-			userName = strings.Split(userName, ":")[1]
+// suppressionReason returns why suppressRecord suppresses a record, empty
+// when it doesn't.
+func suppressionReason(record *CloudTrailRecord) string {
+	if record.UserIdentity.InvokedBy == "AWS Internal" {
+		return "internal call"
+	}
+	if reason := ignoredReason(record); reason != "" {
+		return reason
+	}
+	if record.EventCategory == eventCategoryInsight {
+		// The event name filters are meant for the API calls themselves,
+		// not for an unusual rate of them.
+		return ""
+	}
+
+	en := record.EventName
+	config := activeFilterConfig()
+	switch {
+	case config.Allowed(record.EventSource, en):
+		// Allowed events alert even when the record says it's read-only.
+	case record.ReadOnly != nil && getEnvBool("IGNORE_READONLY_FIELD", true):
+		if *record.ReadOnly {
+			return "read-only"
 		}
-		if userIdentity["userName"] != nil {
-			userName = fmt.Sprintf("%s", userIdentity["userName"])
+		// The record says it isn't read-only so the event name prefixes
+		// aren't consulted, only the events listed by name.
+		if config.IgnoredEvent(record.EventSource, en) {
+			return "ignored event"
 		}
+	case config.Ignored(record.EventSource, en):
+		return "ignored event"
+	}
 
-		log.WithFields(log.Fields{
-			"user_agent":   record["userAgent"],
-			"event_time":   record["eventTime"],
-			"principal":    userIdentity["principalId"],
-			"user_name":    userName,
-			"event_source": record["eventSource"],
-			"event_name":   record["eventName"],
-			"account_id":   userIdentity["accountId"],
-			"event_id":     record["eventID"],
-			"s3_uri":       fmt.Sprintf("s3://%s/%s", evt.S3.Bucket.Name, evt.S3.Object.Key),
-		}).Info("Event")
-
-		if webhookUrl, ok := os.LookupEnv("SLACK_WEBHOOK"); ok {
-			slackBody := fmt.Sprintf(`
-{
-  "channel": "%s",
-  "text": "Not Used",
-  "blocks": [
-    {
-      "type": "section",
-      "text": {
-        "type": "mrkdwn",
-        "text": "*%s* - %s"
-      }
-    },
-    {
-      "type": "context",
-      "elements": [
-        {
-          "type": "mrkdwn",
-          "text": "%s"
-        },
-        {
-          "type": "mrkdwn",
-          "text": "%s"
-        },
-        {
-          "type": "mrkdwn",
-          "text": "<https://console.aws.amazon.com/cloudtrail/home?region=%s#/events?EventId=%s|%s>"
-        }
-      ]
-    }
-  ]
-}
-`,
-				os.Getenv("SLACK_CHANNEL"),
-				record["eventName"],
-				record["eventSource"],
-				getEnv(
-					fmt.Sprintf("SLACK_NAME_%s", userIdentity["accountId"]),
-					getEnv("SLACK_NAME", fmt.Sprintf("%s", userIdentity["accountId"]))),
-				userName,
-				record["awsRegion"],
-				record["eventID"],
-				record["eventTime"])
-
-			err := SendSlackNotification(webhookUrl, []byte(slackBody))
-			if err != nil {
-				log.Debugln(slackBody)
-				log.Debug(err)
+	switch {
+	case en == "ConsoleLogin":
+		if !signInAlerts(ParseSignIn(record)) {
+			return "sign-in not alerted"
+		}
+	case en == "PutObject":
+		// Fingerprinting on KeyPath for LB Logs
+		// Objects are originating outside our account with these account ids.
+		// https://docs.aws.amazon.com/elasticloadbalancing/latest/classic/enable-access-logs.html
+		if k, ok := record.RequestParameters["key"].(string); ok {
+			if strings.HasPrefix(k, "elb/AWSLogs") {
+				return "load balancer log delivery"
+			}
+		}
+
+	case en == "AssumeRole":
+		if record.UserAgent == "Coral/Netty4" {
+			switch record.UserIdentity.InvokedBy {
+			case
+				"ecs-tasks.amazonaws.com",
+				"ec2.amazonaws.com",
+				"monitoring.rds.amazonaws.com",
+				"lambda.amazonaws.com":
+				return "assumed by a service"
 			}
 		}
+		// Cross-account calls are rarely made from the console so they
+		// skip the user agent check below.
+		if getEnvBool("ALERT_CROSS_ACCOUNT_ASSUME_ROLE", false) && ParseCrossAccountAssumeRole(record) != nil {
+			return ""
+		}
 	}
-	// log.Infof("Scanned %d records", len(logFile.Records))
-	return nil
+
+	if _, ok := record.Raw["userAgent"]; ok && !consoleUserAgents().Match(record.UserAgent) {
+		return "not a console user agent"
+	}
+	if suppressedUserAgent(record.UserAgent) {
+		return "suppressed user agent"
+	}
+
+	return ""
+}
+
+// sortRecordsByEventTime orders records oldest first. Records with a missing
+// or unparseable eventTime keep their relative order at the end.
+func sortRecordsByEventTime(records []CloudTrailRecord) {
+	eventTime := func(record CloudTrailRecord) (time.Time, bool) {
+		t, err := time.Parse(time.RFC3339, record.EventTime)
+		return t, err == nil
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		ti, iok := eventTime(records[i])
+		tj, jok := eventTime(records[j])
+		if !iok || !jok {
+			return iok && !jok
+		}
+		return ti.Before(tj)
+	})
 }
 
-func Stream(evt events.S3EventRecord) error {
-	s3ClientConfig := aws.NewConfig().WithRegion(evt.AWSRegion)
-	s3Client := s3.New(session.Must(session.NewSession()), s3ClientConfig)
+func Stream(ctx context.Context, evt events.S3EventRecord) error {
 	s3Bucket := evt.S3.Bucket.Name
 	s3Object := evt.S3.Object.Key
+	roleArn := s3RoleArn(logAccountID(s3Object))
+	s3Client := newS3Client(evt.AWSRegion, roleArn)
+	region := bucketRegion(ctx, s3Client, s3Bucket, evt.AWSRegion)
+	if region != evt.AWSRegion {
+		s3Client = newS3Client(region, roleArn)
+	}
 
-	log.Debugf("Reading %s from %s with client config of %+v", s3Object, s3Bucket, s3Client.Config)
+	log.Debugf("Reading %s from %s in %s", s3Object, s3Bucket, region)
 
-	obj, err := fetchLogFromS3(s3Client, s3Bucket, s3Object)
+	obj, err := fetchLogFromS3(ctx, s3Client, s3Bucket, s3Object)
 	if err != nil {
-		return fmt.Errorf("%v: %v", s3Object, err)
-	}
-	if obj == nil {
-		return nil
+		return fmt.Errorf("%v: %w", s3Object, err)
 	}
+	objectLog := log.WithFields(objectLogFields(evt, obj))
+	objectLog.Debug("Read log file")
 
-	logFile, err := readLogFile(obj)
+	body, err := openLogFile(obj)
 	if err != nil {
-		return fmt.Errorf("%v: %v", s3Object, err)
+		return fmt.Errorf("%v: %w", s3Object, err)
 	}
+	defer body.Close()
 
-	err = FilterRecords(logFile, evt)
+	records := 0
+	decoded := decodeRecords(body)
+	var stream RecordStream = func(fn func(record *CloudTrailRecord) error) error {
+		return decoded(func(record *CloudTrailRecord) error {
+			records++
+			return fn(record)
+		})
+	}
+	if limit, skip := maxRecordsPerFile(); limit > 0 {
+		stream = limitRecords(stream, s3Object, limit, skip)
+	}
+	result, err := FilterRecords(ctx, stream, evt)
+	writeMatchedRecords(ctx, evt, matchedSource(s3Object), result.Matched)
 	if err != nil {
-		return fmt.Errorf("%v: %v", s3Object, err)
+		return fmt.Errorf("%v: %w", s3Object, err)
+	}
+	if records == 0 {
+		log.Debugf("%s has no records", s3Object)
 	}
+	objectLog.WithField("records", records).Debug("Processed log file")
+	processingCounterFrom(ctx).Add(metricObjectsProcessed, 1)
 
 	return nil
 }
 
-func fetchLogFromS3(s3Client *s3.S3, s3Bucket string, s3Object string) (*s3.GetObjectOutput, error) {
-	logInput := &s3.GetObjectInput{
-		Bucket: aws.String(s3Bucket),
-		Key:    aws.String(s3Object),
+// ErrSkippedObject is returned for objects that are not CloudTrail log files,
+// such as digest files and AWS Config snapshots delivered to the same bucket.
+var ErrSkippedObject = errors.New("not a CloudTrail log file")
+
+// Object keys of CloudTrail log files, overridable with CLOUDTRAIL_KEY_PATTERN.
+const defaultCloudTrailKeyPattern = `/CloudTrail(-Insight)?/.*\.json\.gz$`
+
+var defaultCloudTrailKeyRegexp = regexp.MustCompile(defaultCloudTrailKeyPattern)
+
+var (
+	cloudTrailKeyPatternMu  sync.Mutex
+	cloudTrailKeyPatternEnv string
+	cloudTrailKeyPatternP   = defaultCloudTrailKeyRegexp
+)
+
+// cloudTrailKeyPattern returns CLOUDTRAIL_KEY_PATTERN, falling back to the
+// default pattern when it is unset or invalid. It is compiled again only
+// when it changes.
+func cloudTrailKeyPattern() *regexp.Regexp {
+	env := os.Getenv("CLOUDTRAIL_KEY_PATTERN")
+
+	cloudTrailKeyPatternMu.Lock()
+	defer cloudTrailKeyPatternMu.Unlock()
+	if cloudTrailKeyPatternEnv != env {
+		cloudTrailKeyPatternP, cloudTrailKeyPatternEnv = defaultCloudTrailKeyRegexp, env
+		if env != "" {
+			pattern, err := regexp.Compile(env)
+			if err != nil {
+				log.Warnf("Ignoring invalid CLOUDTRAIL_KEY_PATTERN %q: %v", env, err)
+			} else {
+				cloudTrailKeyPatternP = pattern
+			}
+		}
 	}
+	return cloudTrailKeyPatternP
+}
 
+func fetchLogFromS3(ctx context.Context, s3Client S3Getter, s3Bucket string, s3Object string) (*s3.GetObjectOutput, error) {
 	if strings.Contains(s3Object, "/CloudTrail-Digest/") || strings.Contains(s3Object, "/Config/") {
-		return nil, nil
+		return nil, ErrSkippedObject
+	}
+	for _, substring := range strings.Split(os.Getenv("IGNORE_KEY_SUBSTRINGS"), ",") {
+		if substring = strings.TrimSpace(substring); substring != "" && strings.Contains(s3Object, substring) {
+			return nil, fmt.Errorf("%w: key contains %q", ErrSkippedObject, substring)
+		}
+	}
+	if pattern := cloudTrailKeyPattern(); !pattern.MatchString(s3Object) {
+		return nil, fmt.Errorf("%w: key does not match %s", ErrSkippedObject, pattern)
 	}
 
-	obj, err := s3Client.GetObject(logInput)
+	logInput, err := logObjectInput(s3Bucket, s3Object)
 	if err != nil {
+		return nil, err
+	}
+	obj, err := getObjectWithRetry(ctx, s3Client, logInput)
+	if err != nil {
+		if kerr := kmsAccessError(err); kerr != nil {
+			return nil, kerr
+		}
 		if aerr, ok := err.(awserr.Error); ok {
-			return nil, fmt.Errorf("AWS Error: %v", aerr)
+			return nil, fmt.Errorf("AWS Error: %w", aerr)
 		}
-		return nil, fmt.Errorf("Error getting S3 Object: %v", err)
+		return nil, fmt.Errorf("Error getting S3 Object: %w", err)
 	}
 
 	return obj, nil
 }
 
 func readLogFile(object *s3.GetObjectOutput) (*CloudTrailFile, error) {
-	defer object.Body.Close()
+	body, err := openLogFile(object)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var logFile CloudTrailFile
+	err = decodeRecords(body)(func(record *CloudTrailRecord) error {
+		logFile.Records = append(logFile.Records, *record)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &logFile, nil
+}
+
+type logFileReader struct {
+	io.Reader
+	closers []io.Closer
+}
 
-	var logFileBlob io.ReadCloser
+func (r *logFileReader) Close() error {
 	var err error
-	if object.ContentType != nil && *object.ContentType == "application/x-gzip" {
-		logFileBlob, err = gzip.NewReader(object.Body)
-		if err != nil {
-			return nil, fmt.Errorf("extracting json.gz file: %v", err)
+	for _, c := range r.closers {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// objectLogFields describes a log file object by its size and last
+// modification, either of which may be missing from the response.
+func objectLogFields(evt events.S3EventRecord, obj *s3.GetObjectOutput) log.Fields {
+	fields := log.Fields{
+		"s3_uri": objectURI(evt),
+	}
+	if obj.ContentLength != nil {
+		fields["object_size"] = *obj.ContentLength
+	}
+	if obj.LastModified != nil {
+		fields["object_last_modified"] = obj.LastModified.UTC().Format(time.RFC3339)
+	}
+	return fields
+}
+
+// openLogFile returns the contents of a log file object, decompressing it
+// when gzipped.
+func openLogFile(object *s3.GetObjectOutput) (io.ReadCloser, error) {
+	// CloudTrail objects are not always served with a gzip ContentType so
+	// the body is sniffed for the gzip magic number instead.
+	body := bufio.NewReader(object.Body)
+	if !gzipped(body) {
+		if gzipEncoded(object) {
+			// The HTTP client already decoded it.
+			log.Debug("Reading a gzip Content-Encoding log file as decoded in transit")
 		}
-		defer logFileBlob.Close()
-	} else {
-		logFileBlob = object.Body
+		return &logFileReader{Reader: body, closers: []io.Closer{object.Body}}, nil
 	}
 
-	blobBuf := new(bytes.Buffer)
-	_, err = blobBuf.ReadFrom(logFileBlob)
+	gzipReader, err := gzip.NewReader(body)
 	if err != nil {
-		return nil, fmt.Errorf("Error reading from logFileBlob: %v", err)
+		object.Body.Close()
+		return nil, fmt.Errorf("extracting json.gz file: %w", err)
+	}
+	// Every member of a multi-member file is read, one after the
+	// other, without buffering the whole file.
+	gzipReader.Multistream(true)
+	reader := &logFileReader{Reader: gzipReader, closers: []io.Closer{gzipReader, object.Body}}
+	if !gzipEncoded(object) {
+		return reader, nil
 	}
 
-	var logFile CloudTrailFile
-	err = json.Unmarshal(blobBuf.Bytes(), &logFile)
+	// A .json.gz uploaded with Content-Encoding gzip is compressed twice
+	// when the encoding wasn't decoded in transit.
+	inner := bufio.NewReader(gzipReader)
+	if !gzipped(inner) {
+		reader.Reader = inner
+		return reader, nil
+	}
+	innerReader, err := gzip.NewReader(inner)
 	if err != nil {
-		return nil, fmt.Errorf("unmarshalling s3 object to CloudTrailFile: %v", err)
+		reader.Close()
+		return nil, fmt.Errorf("extracting gzip encoded json.gz file: %w", err)
 	}
+	innerReader.Multistream(true)
+	reader.Reader = innerReader
+	reader.closers = append([]io.Closer{innerReader}, reader.closers...)
+	return reader, nil
+}
 
-	return &logFile, nil
+// gzipped reports whether r starts with the gzip magic number.
+func gzipped(r *bufio.Reader) bool {
+	magic, _ := r.Peek(2)
+	return len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b
+}
+
+// gzipEncoded reports whether the object was stored with a gzip
+// Content-Encoding, whatever its Content-Type.
+func gzipEncoded(object *s3.GetObjectOutput) bool {
+	for _, encoding := range strings.Split(aws.StringValue(object.ContentEncoding), ",") {
+		if strings.EqualFold(strings.TrimSpace(encoding), "gzip") {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeRecords decodes the Records of a CloudTrail log file one at a time so
+// only a single record is held in memory. Newline delimited exports with one
+// bare record per line, as written by CloudTrail Lake, are read as well.
+func decodeRecords(r io.Reader) RecordStream {
+	return func(fn func(record *CloudTrailRecord) error) error {
+		dec := json.NewDecoder(r)
+
+		// An empty object, or one of only whitespace, has no records.
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("unmarshalling s3 object to CloudTrailFile: %w", err)
+		}
+		if tok != json.Delim('{') {
+			return fmt.Errorf("unmarshalling s3 object to CloudTrailFile: %w", unexpectedTokenError{json.Delim('{'), tok})
+		}
+		first, wrapped, err := decodeLogFile(dec, fn)
+		if err != nil {
+			return err
+		}
+
+		if !wrapped && isBareRecord(first) {
+			record := newCloudTrailRecord(first)
+			if err := fn(&record); err != nil {
+				return err
+			}
+			for {
+				var record CloudTrailRecord
+				if err := dec.Decode(&record); err == io.EOF {
+					return nil
+				} else if err != nil {
+					return fmt.Errorf("unmarshalling s3 object to CloudTrailFile: %w", err)
+				}
+				if err := fn(&record); err != nil {
+					return err
+				}
+			}
+		}
+
+		// A multi-member gzip file is a concatenation of log files.
+		for {
+			tok, err := dec.Token()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("unmarshalling s3 object to CloudTrailFile: %w", err)
+			}
+			if tok != json.Delim('{') {
+				return fmt.Errorf("unmarshalling s3 object to CloudTrailFile: %w", unexpectedTokenError{json.Delim('{'), tok})
+			}
+			if _, _, err := decodeLogFile(dec, fn); err != nil {
+				return err
+			}
+		}
+	}
 }
 
-func matchString(m, s string) bool {
-	v, _ := regexp.MatchString(m, s)
-	return v
+// decodeLogFile passes the Records of the log file object whose opening
+// brace dec just read to fn. It reports whether the object had Records and,
+// when it didn't, returns its other keys so that a newline delimited record
+// can be told apart from a log file without Records.
+func decodeLogFile(dec *json.Decoder, fn func(record *CloudTrailRecord) error) (map[string]interface{}, bool, error) {
+	first := map[string]interface{}{}
+	wrapped := false
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, false, fmt.Errorf("unmarshalling s3 object to CloudTrailFile: %w", err)
+		}
+
+		if key != "Records" {
+			var value interface{}
+			if err := dec.Decode(&value); err != nil {
+				return nil, false, fmt.Errorf("unmarshalling s3 object to CloudTrailFile: %w", err)
+			}
+			if !wrapped {
+				first[fmt.Sprint(key)] = value
+			}
+			continue
+		}
+		wrapped, first = true, nil
+
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, false, fmt.Errorf("unmarshalling s3 object to CloudTrailFile: %w", err)
+		}
+		if tok == nil {
+			continue
+		}
+		if tok != json.Delim('[') {
+			return nil, false, fmt.Errorf("unmarshalling s3 object to CloudTrailFile: %w", unexpectedTokenError{json.Delim('['), tok})
+		}
+		for dec.More() {
+			var record CloudTrailRecord
+			if err := dec.Decode(&record); err != nil {
+				return nil, false, fmt.Errorf("unmarshalling s3 object to CloudTrailFile: %w", err)
+			}
+			if err := fn(&record); err != nil {
+				return nil, false, err
+			}
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return nil, false, err
+		}
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return nil, false, err
+	}
+	return first, wrapped, nil
+}
+
+// isBareRecord reports whether a top level object is a CloudTrail record
+// rather than a log file without Records.
+func isBareRecord(object map[string]interface{}) bool {
+	for _, key := range []string{"eventID", "eventName", "eventVersion"} {
+		if _, ok := object[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("unmarshalling s3 object to CloudTrailFile: %w", err)
+	}
+	if tok != delim {
+		return fmt.Errorf("unmarshalling s3 object to CloudTrailFile: %w", unexpectedTokenError{delim, tok})
+	}
+	return nil
+}
+
+// unexpectedTokenError is a log file that isn't laid out like one.
+type unexpectedTokenError struct {
+	want json.Delim
+	got  json.Token
+}
+
+func (e unexpectedTokenError) Error() string {
+	return fmt.Sprintf("expected %v, got %v", e.want, e.got)
 }
 
 func prettyPrint(i interface{}) string {
@@ -349,27 +1072,175 @@ func prettyPrint(i interface{}) string {
 	return string(s)
 }
 
-func SendSlackNotification(webhookUrl string, slackBody []byte) error {
+func SendSlackNotification(ctx context.Context, webhookUrl string, slackBody []byte) error {
+	_, err := postToSlack(ctx, webhookUrl, "", slackBody)
+	return err
+}
 
-	req, err := http.NewRequest(http.MethodPost, webhookUrl, bytes.NewBuffer(slackBody))
-	if err != nil {
-		return err
+// postToSlack posts a message to a webhook or, with a bot token, to the Web
+// API, retrying when rate limited. It returns the response body.
+func postToSlack(ctx context.Context, url, token string, slackBody []byte) ([]byte, error) {
+	maxRetries := defaultSlackMaxRetries
+	if v, err := strconv.Atoi(os.Getenv("SLACK_MAX_RETRIES")); err == nil && v >= 0 {
+		maxRetries = v
 	}
 
-	req.Header.Add("Content-Type", "application/json")
+	client := notifyHTTPClient()
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(slackBody))
+		if err != nil {
+			return nil, err
+		}
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
+		req.Header.Add("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Add("Authorization", "Bearer "+token)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		buf := new(bytes.Buffer)
+		buf.ReadFrom(io.LimitReader(resp.Body, maxSlackResponseLength))
+		closeResponse(resp)
+
+		if resp.StatusCode == http.StatusTooManyRequests {
+			if attempt >= maxRetries {
+				return nil, fmt.Errorf("Slack rate limit still exceeded after %d retries: %s", maxRetries, truncate(buf.String(), maxErrorBodyLength))
+			}
+			wait := retryAfter(resp.Header.Get("Retry-After"))
+			log.Debugf("Slack rate limited, retrying in %s", wait)
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			continue
+		}
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return nil, fmt.Errorf("Non-ok response returned from Slack: %d %s", resp.StatusCode, truncate(buf.String(), maxErrorBodyLength))
+		}
+		return buf.Bytes(), nil
+	}
+}
+
+// Longest Slack response read, chat.postMessage echoes the whole message.
+const maxSlackResponseLength = 64 << 10
+
+const defaultSlackMaxRetries = 3
+
+// Longest wait for a rate limited Slack request, a longer Retry-After would
+// hold up the rest of the log file.
+const maxSlackRetryAfter = 30 * time.Second
+
+// retryAfter parses the seconds of a Retry-After header, waiting a second
+// when it is missing or invalid and at most maxSlackRetryAfter.
+func retryAfter(header string) time.Duration {
+	seconds, err := strconv.Atoi(header)
+	if err != nil || seconds < 0 {
+		return time.Second
+	}
+	if wait := time.Duration(seconds) * time.Second; wait < maxSlackRetryAfter {
+		return wait
 	}
+	return maxSlackRetryAfter
+}
+
+// Longest response body included in a notification error.
+const maxErrorBodyLength = 256
 
-	buf := new(bytes.Buffer)
-	buf.ReadFrom(resp.Body)
-	if buf.String() != "ok" {
-		return errors.New(fmt.Sprintf("Non-ok response returned from Slack: %s", buf.String()))
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
 	}
-	return nil
+	return s[:n] + "..."
+}
+
+// accountLabel is the display name of an account, SLACK_NAME_<accountId>
+// falling back to SLACK_NAME and then the account id itself.
+func accountLabel(accountId string) string {
+	return getEnv(
+		fmt.Sprintf("SLACK_NAME_%s", accountId),
+		getEnv("SLACK_NAME", accountId))
+}
+
+// slackWebhook returns the SLACK_WEBHOOK_<accountId> of an account, falling
+// back to SLACK_WEBHOOK.
+func slackWebhook(accountId string) (string, bool) {
+	if webhookUrl, ok := os.LookupEnv(fmt.Sprintf("SLACK_WEBHOOK_%s", accountId)); ok && accountId != "" {
+		return webhookUrl, true
+	}
+	return os.LookupEnv("SLACK_WEBHOOK")
+}
+
+// slackChannel returns the SLACK_CHANNEL_<prefix> for an event source, where
+// the prefix is the service name such as iam for iam.amazonaws.com, falling
+// back to SLACK_CHANNEL.
+func slackChannel(eventSource string) string {
+	prefix := strings.SplitN(eventSource, ".", 2)[0]
+	prefix = strings.ReplaceAll(prefix, "-", "_")
+	if prefix != "" {
+		if channel := os.Getenv(fmt.Sprintf("SLACK_CHANNEL_%s", prefix)); channel != "" {
+			return channel
+		}
+	}
+	return os.Getenv("SLACK_CHANNEL")
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	if value, ok := os.LookupEnv(key); ok {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return fallback
+}
+
+func slackDetailsBlock(severity string, details []string) string {
+	if len(details) == 0 {
+		return ""
+	}
+
+	text := fmt.Sprintf("*Severity:* %s\n%s", severity, strings.Join(details, "\n"))
+	block, _ := json.Marshal(map[string]interface{}{
+		"type": "section",
+		"text": map[string]string{
+			"type": "mrkdwn",
+			"text": text,
+		},
+	})
+	return "\n    " + string(block) + ","
+}
+
+// dryRunNotification logs the rendered body of a notification instead of
+// sending it when DRY_RUN is set or ctx is a dry run and reports whether it
+// did so.
+func dryRunNotification(ctx context.Context, sink string, body []byte) bool {
+	if !isDryRun(ctx) {
+		return false
+	}
+	log.WithFields(log.Fields{
+		"sink": sink,
+		"body": string(body),
+	}).Info("Dry run notification")
+	return true
+}
+
+// slackContextElement renders an extra element of the context block, or
+// nothing when text is empty.
+func slackContextElement(text string) string {
+	if text == "" {
+		return ""
+	}
+
+	element, _ := json.Marshal(map[string]string{
+		"type": "mrkdwn",
+		"text": text,
+	})
+	return "\n        " + string(element) + ","
 }
 
 func getEnv(key, fallback string) string {
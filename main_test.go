@@ -3,13 +3,28 @@ package main
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 )
 
 func TestReadExamples(t *testing.T) {
@@ -60,7 +75,7 @@ func testReadLogFile(t *testing.T, testData []byte, path string) error {
 		return err
 	}
 
-	FilterRecords(logFile, events.S3EventRecord{
+	FilterRecords(context.Background(), logFile.Stream(), events.S3EventRecord{
 		AWSRegion: "us-east-1",
 		S3: events.S3Entity{
 			Bucket: events.S3Bucket{
@@ -77,3 +92,1001 @@ func testReadLogFile(t *testing.T, testData []byte, path string) error {
 
 	return nil
 }
+
+func setEnv(t *testing.T, key, value string) {
+	prev, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, prev)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+type slackCapture struct {
+	sync.Mutex
+	bodies []string
+}
+
+func (c *slackCapture) Bodies() []string {
+	c.Lock()
+	defer c.Unlock()
+	return append([]string(nil), c.bodies...)
+}
+
+// captureSlack points SLACK_WEBHOOK at a local server recording every body
+// posted to it.
+func captureSlack(t *testing.T) *slackCapture {
+	c := &slackCapture{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		c.Lock()
+		c.bodies = append(c.bodies, string(body))
+		c.Unlock()
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(srv.Close)
+	setEnv(t, "SLACK_WEBHOOK", srv.URL)
+	return c
+}
+
+// slackBodyFor returns the message sent for eventID. Records are notified
+// concurrently so messages arrive in any order.
+func slackBodyFor(bodies []string, eventID string) string {
+	for _, body := range bodies {
+		if strings.Contains(body, "EventId="+eventID+"|") {
+			return body
+		}
+	}
+	return ""
+}
+
+func consoleRecord(eventName, eventID string) map[string]interface{} {
+	return map[string]interface{}{
+		"eventTime":   "2021-05-14T19:03:40Z",
+		"eventSource": "ec2.amazonaws.com",
+		"eventName":   eventName,
+		"awsRegion":   "us-east-1",
+		"userAgent":   "console.amazonaws.com",
+		"eventID":     eventID,
+		"userIdentity": map[string]interface{}{
+			"type":        "IAMUser",
+			"principalId": "AIDAJU2GYCKZ322Y5JOKC",
+			"accountId":   "012345678901",
+			"userName":    "first.last",
+		},
+	}
+}
+
+// typedRecord builds a CloudTrailRecord from a record literal, as decoding
+// it from a log file would.
+func typedRecord(raw map[string]interface{}) *CloudTrailRecord {
+	record := newCloudTrailRecord(raw)
+	return &record
+}
+
+func cloudTrailFile(records ...map[string]interface{}) *CloudTrailFile {
+	logFile := &CloudTrailFile{}
+	for _, raw := range records {
+		logFile.Records = append(logFile.Records, *typedRecord(raw))
+	}
+	return logFile
+}
+
+var testS3Record = events.S3EventRecord{
+	AWSRegion: "us-east-1",
+	S3: events.S3Entity{
+		Bucket: events.S3Bucket{Name: "test-harness"},
+		Object: events.S3Object{Key: "AWSLogs/012345678901/CloudTrail/us-east-1/2021/05/14/file.json.gz"},
+	},
+}
+
+func TestSortRecordsByEventTime(t *testing.T) {
+	records := []CloudTrailRecord{
+		{EventID: "c", EventTime: "2021-05-14T19:03:42Z"},
+		{EventID: "bad", EventTime: "yesterday"},
+		{EventID: "a", EventTime: "2021-05-14T19:03:40Z"},
+		{EventID: "missing"},
+		{EventID: "b", EventTime: "2021-05-14T19:03:41Z"},
+	}
+
+	sortRecordsByEventTime(records)
+
+	var got []string
+	for _, r := range records {
+		got = append(got, r.EventID)
+	}
+	if want := "a,b,c,bad,missing"; strings.Join(got, ",") != want {
+		t.Errorf("got order %v, want %s", got, want)
+	}
+}
+
+func TestFilterRecordsSortedDispatch(t *testing.T) {
+	slack := captureSlack(t)
+	setEnv(t, "SORT_BY_EVENT_TIME", "true")
+
+	late := consoleRecord("RunInstances", "late")
+	late["eventTime"] = "2021-05-14T20:00:00Z"
+	early := consoleRecord("CreateTags", "early")
+
+	logFile := cloudTrailFile(late, early)
+	if _, err := FilterRecords(context.Background(), logFile.Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := slack.Bodies()
+	if len(bodies) != 2 || !strings.Contains(bodies[0], "EventId=early") || !strings.Contains(bodies[1], "EventId=late") {
+		t.Errorf("notifications not sent in eventTime order: %v", bodies)
+	}
+}
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestAlwaysAlertEvents(t *testing.T) {
+	token := consoleRecord("GetFederationToken", "token-1")
+	token["eventSource"] = "sts.amazonaws.com"
+	token["userAgent"] = "aws-cli/2.2.5 Python/3.8.8"
+	token["awsRegion"] = "ap-south-1"
+
+	t.Run("filtered by default", func(t *testing.T) {
+		slack := captureSlack(t)
+		if _, err := FilterRecords(context.Background(), cloudTrailFile(token).Stream(), testS3Record); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(slack.Bodies()); n != 0 {
+			t.Errorf("expected no messages, got %d", n)
+		}
+	})
+
+	t.Run("listed", func(t *testing.T) {
+		slack := captureSlack(t)
+		setEnv(t, "ALWAYS_ALERT_EVENTS", "GetSecretValue, GetFederationToken")
+		setEnv(t, "REGION_ALLOWLIST", "us-east-1")
+		if _, err := FilterRecords(context.Background(), cloudTrailFile(token).Stream(), testS3Record); err != nil {
+			t.Fatal(err)
+		}
+		if bodies := slack.Bodies(); len(bodies) != 1 || !strings.Contains(bodies[0], "*GetFederationToken* - sts.amazonaws.com") {
+			t.Errorf("expected GetFederationToken to alert, got %v", bodies)
+		}
+	})
+}
+
+func TestSlackWebhookPerAccount(t *testing.T) {
+	prod := captureSlack(t)
+	setEnv(t, "SLACK_WEBHOOK_111111111111", os.Getenv("SLACK_WEBHOOK"))
+	global := captureSlack(t)
+
+	prodRecord := consoleRecord("CreateTags", "prod")
+	prodRecord["userIdentity"].(map[string]interface{})["accountId"] = "111111111111"
+	devRecord := consoleRecord("CreateTags", "dev")
+
+	if _, err := FilterRecords(context.Background(), cloudTrailFile(prodRecord, devRecord).Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+
+	if bodies := prod.Bodies(); len(bodies) != 1 || !strings.Contains(bodies[0], "EventId=prod") {
+		t.Errorf("account webhook got %v", bodies)
+	}
+	if bodies := global.Bodies(); len(bodies) != 1 || !strings.Contains(bodies[0], "EventId=dev") {
+		t.Errorf("global webhook got %v", bodies)
+	}
+}
+
+func TestFilterRecordsAccountIDFromKey(t *testing.T) {
+	alerts := captureAlerts(t)
+	hook := logtest.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+	setEnv(t, "SLACK_NAME_012345678901", "Production")
+
+	missing := consoleRecord("CreateTags", "missing")
+	delete(missing["userIdentity"].(map[string]interface{}), "accountId")
+	other := consoleRecord("CreateTags", "other")
+	other["userIdentity"].(map[string]interface{})["accountId"] = "111111111111"
+
+	if _, err := FilterRecords(context.Background(), cloudTrailFile(missing, other).Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"missing": "012345678901", "other": "111111111111"}
+	if len(alerts.alerts) != len(want) {
+		t.Fatalf("expected %d alerts, got %d", len(want), len(alerts.alerts))
+	}
+	for _, alert := range alerts.alerts {
+		if alert.AccountID != want[alert.EventID] {
+			t.Errorf("%s: AccountID = %q, want %q", alert.EventID, alert.AccountID, want[alert.EventID])
+		}
+		if alert.EventID == "missing" && alert.Account != "Production" {
+			t.Errorf("missing: Account = %q, want the SLACK_NAME of the key's account", alert.Account)
+		}
+	}
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Event" && entry.Data["event_id"] == "missing" && entry.Data["account_id"] != "012345678901" {
+			t.Errorf("logged account_id = %v", entry.Data["account_id"])
+		}
+	}
+}
+
+func TestSlackChannel(t *testing.T) {
+	setEnv(t, "SLACK_CHANNEL_iam", "#iam-alerts")
+	setEnv(t, "SLACK_CHANNEL_sso_directory", "#sso-alerts")
+
+	t.Run("default", func(t *testing.T) {
+		setEnv(t, "SLACK_CHANNEL", "")
+		if got := slackChannel("ec2.amazonaws.com"); got != "" {
+			t.Errorf("expected no channel, got %q", got)
+		}
+	})
+
+	setEnv(t, "SLACK_CHANNEL", "#alerts")
+	tests := map[string]string{
+		"iam.amazonaws.com":           "#iam-alerts",
+		"sso-directory.amazonaws.com": "#sso-alerts",
+		"s3.amazonaws.com":            "#alerts",
+		"":                            "#alerts",
+	}
+	for source, want := range tests {
+		if got := slackChannel(source); got != want {
+			t.Errorf("slackChannel(%q) = %q, want %q", source, got, want)
+		}
+	}
+
+	slack := captureSlack(t)
+	iam := consoleRecord("CreateUser", "iam-1")
+	iam["eventSource"] = "iam.amazonaws.com"
+	if _, err := FilterRecords(context.Background(), cloudTrailFile(iam, consoleRecord("CreateTags", "ec2-1")).Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+	bodies := slack.Bodies()
+	if body := slackBodyFor(bodies, "iam-1"); !strings.Contains(body, `"channel": "#iam-alerts"`) {
+		t.Errorf("IAM event not sent to #iam-alerts: %s", body)
+	}
+	if body := slackBodyFor(bodies, "ec2-1"); !strings.Contains(body, `"channel": "#alerts"`) {
+		t.Errorf("EC2 event not sent to #alerts: %s", body)
+	}
+}
+
+func TestSlackWebhookUnset(t *testing.T) {
+	setEnv(t, "SLACK_WEBHOOK", "")
+	os.Unsetenv("SLACK_WEBHOOK")
+
+	if webhookUrl, ok := slackWebhook("012345678901"); ok {
+		t.Errorf("expected no webhook, got %q", webhookUrl)
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	tests := map[string]logrus.Level{
+		"":        logrus.InfoLevel,
+		"debug":   logrus.DebugLevel,
+		"WARN":    logrus.WarnLevel,
+		"verbose": logrus.InfoLevel,
+	}
+	for value, want := range tests {
+		if got := logLevel(value); got != want {
+			t.Errorf("logLevel(%q) = %v, want %v", value, got, want)
+		}
+	}
+
+	prev := logrus.GetLevel()
+	defer logrus.SetLevel(prev)
+	setEnv(t, "LOG_LEVEL", "debug")
+	configureLogLevel()
+	if !logrus.IsLevelEnabled(logrus.DebugLevel) {
+		t.Error("debug logging should be enabled")
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	slack := captureSlack(t)
+	setEnv(t, "DRY_RUN", "true")
+	hook := logtest.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+
+	if _, err := FilterRecords(context.Background(), cloudTrailFile(consoleRecord("CreateTags", "event-1")).Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(slack.Bodies()); n != 0 {
+		t.Errorf("expected no Slack calls, got %d", n)
+	}
+
+	var events, dryRuns int
+	for _, entry := range hook.AllEntries() {
+		switch entry.Message {
+		case "Event":
+			events++
+		case "Dry run notification":
+			dryRuns++
+			body, _ := entry.Data["body"].(string)
+			if entry.Level != logrus.InfoLevel || entry.Data["sink"] != "slack" || !json.Valid([]byte(body)) || !strings.Contains(body, "*CreateTags* - ec2.amazonaws.com") {
+				t.Errorf("unexpected dry run entry %v %v", entry.Level, entry.Data)
+			}
+		}
+	}
+	if events != 1 || dryRuns != 1 {
+		t.Errorf("expected 1 event and 1 dry run log line, got %d and %d", events, dryRuns)
+	}
+}
+
+func TestReadLogFileDetectsGzip(t *testing.T) {
+	content := []byte(`{"Records":[{"eventName":"CreateTags","eventID":"event-1"}]}`)
+	octetStream := "application/octet-stream"
+	gzipType := "application/x-gzip"
+
+	tests := []struct {
+		name        string
+		body        []byte
+		contentType *string
+	}{
+		{"gzip with octet-stream ContentType", gzipBytes(t, content), &octetStream},
+		{"gzip without ContentType", gzipBytes(t, content), nil},
+		{"plaintext with gzip ContentType", content, &gzipType},
+		{"plaintext without ContentType", content, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := &s3.GetObjectOutput{Body: BufferCloser{bytes.NewBuffer(tt.body)}, ContentType: tt.contentType}
+			logFile, err := readLogFile(obj)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(logFile.Records) != 1 || logFile.Records[0].EventID != "event-1" {
+				t.Errorf("unexpected records %v", logFile.Records)
+			}
+		})
+	}
+}
+
+func TestReadLogFileFormats(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"records", `{"Records":[{"eventName":"CreateTags","eventID":"event-1"},{"eventName":"RunInstances","eventID":"event-2"}]}`},
+		{"ndjson", "{\"eventName\":\"CreateTags\",\"eventID\":\"event-1\"}\n{\"eventName\":\"RunInstances\",\"eventID\":\"event-2\"}\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := &s3.GetObjectOutput{Body: BufferCloser{bytes.NewBuffer(gzipBytes(t, []byte(tt.content)))}}
+			logFile, err := readLogFile(obj)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(logFile.Records) != 2 || logFile.Records[0].EventName != "CreateTags" || logFile.Records[1].EventID != "event-2" {
+				t.Errorf("unexpected records %+v", logFile.Records)
+			}
+		})
+	}
+}
+
+func TestSendSlackNotification(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr string
+	}{
+		{"ok", http.StatusOK, "ok", ""},
+		{"ok with newline", http.StatusOK, "ok\n", ""},
+		{"block kit body", http.StatusOK, `{"ok":true}`, ""},
+		{"bad request", http.StatusBadRequest, "invalid_payload", "400 invalid_payload"},
+		{"server error", http.StatusInternalServerError, strings.Repeat("x", 1000), "500 " + strings.Repeat("x", maxErrorBodyLength) + "..."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			err := SendSlackNotification(context.Background(), srv.URL, []byte(`{}`))
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.HasSuffix(err.Error(), tt.wantErr)):
+				t.Errorf("error = %v, want suffix %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSendSlackNotificationRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxRetries string
+		limited    int
+		attempts   int
+		wantErr    bool
+	}{
+		{"succeeds after two 429s", "", 2, 3, false},
+		{"retries exhausted", "", 5, 4, true},
+		{"retries disabled", "0", 1, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.maxRetries != "" {
+				setEnv(t, "SLACK_MAX_RETRIES", tt.maxRetries)
+			}
+
+			attempts := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				if attempts <= tt.limited {
+					w.Header().Set("Retry-After", "0")
+					w.WriteHeader(http.StatusTooManyRequests)
+					w.Write([]byte("rate_limited"))
+					return
+				}
+				w.Write([]byte("ok"))
+			}))
+			defer srv.Close()
+
+			err := SendSlackNotification(context.Background(), srv.URL, []byte(`{}`))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if attempts != tt.attempts {
+				t.Errorf("expected %d attempts, got %d", tt.attempts, attempts)
+			}
+		})
+	}
+}
+
+func TestSendSlackNotificationRetryCanceled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := SendSlackNotification(ctx, srv.URL, []byte(`{}`)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("waited %s for the retry", elapsed)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	for header, want := range map[string]time.Duration{
+		"3":        3 * time.Second,
+		"0":        0,
+		"3600":     maxSlackRetryAfter,
+		"":         time.Second,
+		"tomorrow": time.Second,
+	} {
+		if got := retryAfter(header); got != want {
+			t.Errorf("retryAfter(%q) = %s, want %s", header, got, want)
+		}
+	}
+}
+
+func syntheticLogFile(n int) []byte {
+	var logFile CloudTrailFile
+	for i := 0; i < n; i++ {
+		name := "CreateTags"
+		if i%3 == 0 {
+			name = "DescribeInstances"
+		}
+		logFile.Records = append(logFile.Records, *typedRecord(consoleRecord(name, fmt.Sprintf("event-%d", i))))
+	}
+	content, _ := json.Marshal(logFile)
+	return content
+}
+
+// fakeS3Getter serves canned object bodies keyed by bucket/key.
+type fakeS3Getter struct {
+	objects map[string][]byte
+
+	mu   sync.Mutex
+	keys []string
+}
+
+func (f *fakeS3Getter) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	key := aws.StringValue(input.Bucket) + "/" + aws.StringValue(input.Key)
+	f.mu.Lock()
+	f.keys = append(f.keys, key)
+	f.mu.Unlock()
+	body, ok := f.objects[key]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	}
+	return &s3.GetObjectOutput{Body: BufferCloser{bytes.NewBuffer(body)}}, nil
+}
+
+func withS3Getter(t *testing.T, getter S3Getter) {
+	fastS3Retries(t)
+	newS3Client = func(string, string) S3Getter { return getter }
+	t.Cleanup(func() { newS3Client = defaultS3Client })
+}
+
+func TestStream(t *testing.T) {
+	content := []byte(`{"Records":[{"eventName":"CreateTags","eventSource":"ec2.amazonaws.com","userAgent":"console.amazonaws.com","eventID":"event-1","userIdentity":{"type":"IAMUser","accountId":"012345678901"}}]}`)
+	key := testS3Record.S3.Object.Key
+
+	tests := []struct {
+		name     string
+		body     []byte
+		messages int
+		wantErr  string
+	}{
+		{name: "gzipped", body: gzipBytes(t, content), messages: 1},
+		{name: "plaintext", body: content, messages: 1},
+		{name: "corrupt", body: []byte(`{"Records":[{"eventName"`), wantErr: "unmarshalling s3 object"},
+		{name: "not an object", body: []byte(`[]`), wantErr: "unmarshalling s3 object"},
+		{name: "no records", body: []byte(`{"Records":[]}`)},
+		{name: "gzipped no records", body: gzipBytes(t, []byte(`{"Records":[]}`))},
+		{name: "empty", body: []byte{}},
+		{name: "whitespace", body: []byte("\n")},
+		{name: "empty gzip", body: gzipBytes(t, nil)},
+		{name: "no such key", wantErr: s3.ErrCodeNoSuchKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slack := captureSlack(t)
+			getter := &fakeS3Getter{objects: map[string][]byte{}}
+			if tt.body != nil {
+				getter.objects["test-harness/"+key] = tt.body
+			}
+			withS3Getter(t, getter)
+
+			err := Stream(context.Background(), testS3Record)
+			if tt.wantErr == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr) || !strings.Contains(err.Error(), key)) {
+				t.Fatalf("error = %v, want %q for %s", err, tt.wantErr, key)
+			}
+			var aerr awserr.Error
+			if errors.As(err, &aerr) && aerr.Code() != tt.wantErr {
+				t.Errorf("wrapped AWS error %s, want %q", aerr.Code(), tt.wantErr)
+			}
+			if n := len(slack.Bodies()); n != tt.messages {
+				t.Errorf("expected %d messages, got %d", tt.messages, n)
+			}
+		})
+	}
+}
+
+func TestStreamMultiMemberGzip(t *testing.T) {
+	slack := captureSlack(t)
+
+	// Concatenated gzip members, each a log file of its own.
+	var body []byte
+	for _, members := range [][]string{{"event-1", "event-2"}, {"event-3"}, {}, {"event-4"}} {
+		var records []map[string]interface{}
+		for _, eventID := range members {
+			records = append(records, consoleRecord("CreateTags", eventID))
+		}
+		content, _ := json.Marshal(cloudTrailFile(records...))
+		body = append(body, gzipBytes(t, content)...)
+	}
+	withS3Getter(t, &fakeS3Getter{objects: map[string][]byte{"test-harness/" + testS3Record.S3.Object.Key: body}})
+
+	if err := Stream(context.Background(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+	bodies := slack.Bodies()
+	if len(bodies) != 4 {
+		t.Fatalf("expected 4 messages, got %d", len(bodies))
+	}
+	for _, eventID := range []string{"event-1", "event-2", "event-3", "event-4"} {
+		if slackBodyFor(bodies, eventID) == "" {
+			t.Errorf("no message for %s", eventID)
+		}
+	}
+}
+
+func TestStreamSkipsDigests(t *testing.T) {
+	getter := &fakeS3Getter{}
+	withS3Getter(t, getter)
+
+	evt := testS3Record
+	evt.S3.Object.Key = "AWSLogs/012345678901/CloudTrail-Digest/us-east-1/2021/05/14/file.json.gz"
+	if err := Stream(context.Background(), evt); !errors.Is(err, ErrSkippedObject) {
+		t.Fatalf("Stream() = %v, want ErrSkippedObject", err)
+	}
+	if _, err := S3Handler(context.Background(), events.S3Event{Records: []events.S3EventRecord{evt}}); err != nil {
+		t.Errorf("S3Handler() = %v, skipped objects are not an error", err)
+	}
+	if len(getter.keys) != 0 {
+		t.Errorf("digest should not be fetched, got %v", getter.keys)
+	}
+}
+
+func TestS3HandlerContinuesAfterFailedObject(t *testing.T) {
+	slack := captureSlack(t)
+
+	var s3Event events.S3Event
+	objects := map[string][]byte{}
+	for i := 1; i <= 3; i++ {
+		evt := testS3Record
+		evt.S3.Object.Key = fmt.Sprintf("AWSLogs/012345678901/CloudTrail/us-east-1/2021/05/14/file-%d.json.gz", i)
+		s3Event.Records = append(s3Event.Records, evt)
+
+		content, _ := json.Marshal(cloudTrailFile(consoleRecord("CreateTags", fmt.Sprintf("event-%d", i))))
+		if i == 2 {
+			content = []byte("not json")
+		}
+		objects["test-harness/"+evt.S3.Object.Key] = content
+	}
+	getter := &fakeS3Getter{objects: objects}
+	withS3Getter(t, getter)
+
+	result, err := S3Handler(context.Background(), s3Event)
+	if err == nil || !strings.Contains(err.Error(), "file-2.json.gz") {
+		t.Fatalf("expected the error of file-2, got %v", err)
+	}
+	if strings.Contains(err.Error(), "file-1") || strings.Contains(err.Error(), "file-3") {
+		t.Errorf("unexpected errors %v", err)
+	}
+
+	bodies := slack.Bodies()
+	if len(bodies) != 2 || slackBodyFor(bodies, "event-1") == "" || slackBodyFor(bodies, "event-3") == "" {
+		t.Errorf("expected event-1 and event-3 to alert, got %d messages", len(bodies))
+	}
+	if len(getter.keys) != 3 || result.ObjectsProcessed != 2 {
+		t.Errorf("expected all 3 objects to be read and 2 processed, got %v and %+v", getter.keys, result)
+	}
+}
+
+func TestFetchLogFromS3IgnoreKeySubstrings(t *testing.T) {
+	setEnv(t, "IGNORE_KEY_SUBSTRINGS", "/exports/, _backup_")
+
+	for key, skipped := range map[string]bool{
+		"AWSLogs/012345678901/CloudTrail/us-east-1/2021/05/14/file.json.gz":            false,
+		"AWSLogs/012345678901/CloudTrail/exports/us-east-1/2021/05/14/file.json.gz":    true,
+		"AWSLogs/012345678901/CloudTrail/us-east-1/2021/05/14/_backup_file.json.gz":    true,
+		"AWSLogs/012345678901/CloudTrail-Digest/us-east-1/2021/05/14/file.json.gz":     true,
+		"AWSLogs/012345678901/Config/us-east-1/2021/05/14/ConfigSnapshot/file.json.gz": true,
+	} {
+		getter := &fakeS3Getter{objects: map[string][]byte{"b/" + key: []byte(`{"Records":[]}`)}}
+		_, err := fetchLogFromS3(context.Background(), getter, "b", key)
+		if got := errors.Is(err, ErrSkippedObject); got != skipped {
+			t.Errorf("%s: skipped = %v, want %v (%v)", key, got, skipped, err)
+		}
+		if fetched := len(getter.keys) > 0; fetched == skipped {
+			t.Errorf("%s: fetched = %v", key, fetched)
+		}
+	}
+}
+
+func TestStreamCloudTrailKeyPattern(t *testing.T) {
+	content := []byte(`{"Records":[]}`)
+	tests := []struct {
+		name    string
+		pattern string
+		key     string
+		fetched bool
+	}{
+		{name: "log file", key: "AWSLogs/012345678901/CloudTrail/us-east-1/2021/05/14/file.json.gz", fetched: true},
+		{name: "organization trail", key: "AWSLogs/o-abc123/012345678901/CloudTrail/us-east-1/2021/05/14/file.json.gz", fetched: true},
+		{name: "insights", key: "AWSLogs/012345678901/CloudTrail-Insight/us-east-1/2021/05/14/file.json.gz", fetched: true},
+		{name: "digest", key: "AWSLogs/012345678901/CloudTrail-Digest/us-east-1/2021/05/14/file.json.gz"},
+		{name: "test event", key: "s3-test-event"},
+		{name: "not gzipped", key: "AWSLogs/012345678901/CloudTrail/us-east-1/2021/05/14/file.json"},
+		{name: "other prefix", key: "AWSLogs/012345678901/elasticloadbalancing/us-east-1/2021/05/14/file.json.gz"},
+		{name: "custom pattern", pattern: `^exports/.*\.json$`, key: "exports/2021/05/14/file.json", fetched: true},
+		{name: "custom pattern mismatch", pattern: `^exports/.*\.json$`, key: "AWSLogs/012345678901/CloudTrail/us-east-1/2021/05/14/file.json.gz"},
+		{name: "invalid pattern", pattern: `(`, key: "AWSLogs/012345678901/CloudTrail/us-east-1/2021/05/14/file.json.gz", fetched: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEnv(t, "CLOUDTRAIL_KEY_PATTERN", tt.pattern)
+			getter := &fakeS3Getter{objects: map[string][]byte{"test-harness/" + tt.key: content}}
+			withS3Getter(t, getter)
+
+			evt := testS3Record
+			evt.S3.Object.Key = tt.key
+			err := Stream(context.Background(), evt)
+			if tt.fetched && err != nil {
+				t.Fatal(err)
+			}
+			if !tt.fetched && !errors.Is(err, ErrSkippedObject) {
+				t.Errorf("Stream() = %v, want ErrSkippedObject", err)
+			}
+			if fetched := len(getter.keys) > 0; fetched != tt.fetched {
+				t.Errorf("fetched = %v, want %v", fetched, tt.fetched)
+			}
+		})
+	}
+}
+
+func TestCloudTrailKeyPatternInvalidWarnsOnce(t *testing.T) {
+	hook := logtest.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+	// Forget the pattern cached by earlier tests.
+	cloudTrailKeyPattern()
+	setEnv(t, "CLOUDTRAIL_KEY_PATTERN", "(")
+
+	for i := 0; i < 3; i++ {
+		if got := cloudTrailKeyPattern(); got.String() != defaultCloudTrailKeyPattern {
+			t.Errorf("cloudTrailKeyPattern() = %s, want the default", got)
+		}
+	}
+	if n := len(hook.AllEntries()); n != 1 {
+		t.Errorf("expected the invalid pattern to be warned about once, got %d entries", n)
+	}
+}
+
+func TestHandlerDecodesObjectKeys(t *testing.T) {
+	slack := captureSlack(t)
+	hook := logtest.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+
+	key := "AWSLogs/012345678901/CloudTrail/us-east-1/2021/05/14/team a/file=1.json.gz"
+	getter := &fakeS3Getter{objects: map[string][]byte{
+		"test-harness/" + key: []byte(`{"Records":[{"eventName":"CreateTags","eventSource":"ec2.amazonaws.com","userAgent":"console.amazonaws.com","eventID":"event-1","userIdentity":{"type":"IAMUser","accountId":"012345678901"}}]}`),
+	}}
+	withS3Getter(t, getter)
+
+	raw := json.RawMessage(`{"Records":[{"eventSource":"aws:s3","awsRegion":"us-east-1","s3":{"bucket":{"name":"test-harness"},"object":{"key":"AWSLogs/012345678901/CloudTrail/us-east-1/2021/05/14/team+a/file%3D1.json.gz"}}}]}`)
+	if _, err := Handler(context.Background(), raw); err != nil {
+		t.Fatal(err)
+	}
+	if len(getter.keys) != 1 || getter.keys[0] != "test-harness/"+key {
+		t.Errorf("expected the decoded key to be fetched, got %v", getter.keys)
+	}
+	if n := len(slack.Bodies()); n != 1 {
+		t.Errorf("expected 1 message, got %d", n)
+	}
+
+	var s3URI interface{}
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Event" {
+			s3URI = entry.Data["s3_uri"]
+		}
+	}
+	if s3URI != "s3://test-harness/"+key {
+		t.Errorf("s3_uri = %v, want the decoded key", s3URI)
+	}
+}
+
+func TestStreamRawObjectKeys(t *testing.T) {
+	// Keys listed from the bucket aren't URL encoded, so "+" and "%" are
+	// part of the name.
+	key := "AWSLogs/012345678901/CloudTrail/us-east-1/2021/05/14/team+a/file%3D1.json.gz"
+	getter := &fakeS3Getter{objects: map[string][]byte{"test-harness/" + key: []byte(`{"Records":[]}`)}}
+	withS3Getter(t, getter)
+
+	evt := testS3Record
+	evt.S3.Object.Key = key
+	if err := Stream(context.Background(), evt); err != nil {
+		t.Fatal(err)
+	}
+	if len(getter.keys) != 1 || getter.keys[0] != "test-harness/"+key {
+		t.Errorf("expected the key to be fetched as is, got %v", getter.keys)
+	}
+}
+
+func TestDecodeRecordsMatchesUnmarshal(t *testing.T) {
+	content := syntheticLogFile(50)
+
+	var unmarshalled CloudTrailFile
+	if err := json.Unmarshal(content, &unmarshalled); err != nil {
+		t.Fatal(err)
+	}
+	slack := captureSlack(t)
+	if _, err := FilterRecords(context.Background(), unmarshalled.Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+	want := slack.Bodies()
+
+	slack = captureSlack(t)
+	if _, err := FilterRecords(context.Background(), decodeRecords(bytes.NewReader(content)), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+	got := slack.Bodies()
+
+	sort.Strings(want)
+	sort.Strings(got)
+	if len(want) == 0 || strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("streamed filtering sent %d messages, unmarshalled sent %d", len(got), len(want))
+	}
+}
+
+func TestDecodeRecords(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		records int
+		wantErr bool
+	}{
+		{"records", `{"Records":[{"eventID":"a"},{"eventID":"b"}]}`, 2, false},
+		{"other keys", `{"Digest":{"x":[1,2]},"Records":[{"eventID":"a"}],"Trailer":"x"}`, 1, false},
+		{"null records", `{"Records":null}`, 0, false},
+		{"no records", `{}`, 0, false},
+		{"truncated", `{"Records":[{"eventID":"a"},{"event`, 1, true},
+		{"not an object", `[{"eventID":"a"}]`, 0, true},
+		{"ndjson", "{\"eventID\":\"a\",\"eventName\":\"CreateTags\"}\n{\"eventID\":\"b\"}\n{\"eventID\":\"c\"}\n", 3, false},
+		{"single ndjson record", `{"eventVersion":"1.08","eventID":"a"}`, 1, false},
+		{"truncated ndjson", "{\"eventID\":\"a\"}\n{\"event", 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := 0
+			err := decodeRecords(strings.NewReader(tt.content))(func(record *CloudTrailRecord) error {
+				n++
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if n != tt.records {
+				t.Errorf("decoded %d records, want %d", n, tt.records)
+			}
+		})
+	}
+}
+
+func BenchmarkUnmarshalLogFile(b *testing.B) {
+	content := syntheticLogFile(10000)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		blobBuf := new(bytes.Buffer)
+		blobBuf.ReadFrom(bytes.NewReader(content))
+		var logFile CloudTrailFile
+		if err := json.Unmarshal(blobBuf.Bytes(), &logFile); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeRecords(b *testing.B) {
+	content := syntheticLogFile(10000)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		err := decodeRecords(bytes.NewReader(content))(func(record *CloudTrailRecord) error {
+			return nil
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestDefaultS3ClientEndpoint(t *testing.T) {
+	client := defaultS3Client("us-east-1", "").(*s3.S3)
+	if client.Config.Endpoint != nil || aws.BoolValue(client.Config.S3ForcePathStyle) {
+		t.Errorf("expected the default endpoint, got %s path-style %v",
+			aws.StringValue(client.Config.Endpoint), aws.BoolValue(client.Config.S3ForcePathStyle))
+	}
+
+	setEnv(t, "AWS_S3_ENDPOINT", "http://localhost:4566")
+	setEnv(t, "S3_FORCE_PATH_STYLE", "true")
+	client = defaultS3Client("us-east-1", "").(*s3.S3)
+	if aws.StringValue(client.Config.Endpoint) != "http://localhost:4566" {
+		t.Errorf("Endpoint = %s", aws.StringValue(client.Config.Endpoint))
+	}
+	if !aws.BoolValue(client.Config.S3ForcePathStyle) {
+		t.Error("expected path-style addressing")
+	}
+	if client.Endpoint != "http://localhost:4566" {
+		t.Errorf("client endpoint = %s", client.Endpoint)
+	}
+}
+
+// cannedS3Getter returns the same output for every object.
+type cannedS3Getter struct {
+	output s3.GetObjectOutput
+	body   []byte
+}
+
+func (c cannedS3Getter) GetObjectWithContext(aws.Context, *s3.GetObjectInput, ...request.Option) (*s3.GetObjectOutput, error) {
+	output := c.output
+	output.Body = BufferCloser{bytes.NewBuffer(c.body)}
+	return &output, nil
+}
+
+func TestStreamLogsObjectMetadata(t *testing.T) {
+	captureSlack(t)
+	prev := logrus.GetLevel()
+	defer logrus.SetLevel(prev)
+	logrus.SetLevel(logrus.DebugLevel)
+	hook := logtest.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+
+	body, _ := json.Marshal(cloudTrailFile(consoleRecord("CreateTags", "event-1"), consoleRecord("CreateTags", "event-2")))
+	lastModified := time.Date(2021, 5, 14, 19, 5, 0, 0, time.UTC)
+	for _, output := range []s3.GetObjectOutput{
+		{ContentLength: aws.Int64(int64(len(body))), LastModified: &lastModified},
+		{},
+	} {
+		hook.Reset()
+		withS3Getter(t, cannedS3Getter{output: output, body: body})
+		if err := Stream(context.Background(), testS3Record); err != nil {
+			t.Fatal(err)
+		}
+
+		var read, processed *logrus.Entry
+		for _, entry := range hook.AllEntries() {
+			switch entry.Message {
+			case "Read log file":
+				read = entry
+			case "Processed log file":
+				processed = entry
+			}
+		}
+		if read == nil || processed == nil {
+			t.Fatalf("expected the read and processed log entries, got %v", hook.AllEntries())
+		}
+		if processed.Data["records"] != 2 || processed.Data["s3_uri"] != "s3://test-harness/"+testS3Record.S3.Object.Key {
+			t.Errorf("unexpected per-file summary %v", processed.Data)
+		}
+
+		if output.ContentLength == nil {
+			if _, ok := read.Data["object_size"]; ok {
+				t.Errorf("expected no size without a ContentLength, got %v", read.Data)
+			}
+			if _, ok := read.Data["object_last_modified"]; ok {
+				t.Errorf("expected no last modified time without LastModified, got %v", read.Data)
+			}
+			continue
+		}
+		if read.Data["object_size"] != int64(len(body)) || read.Data["object_last_modified"] != "2021-05-14T19:05:00Z" {
+			t.Errorf("unexpected object fields %v", read.Data)
+		}
+		if processed.Data["object_size"] != int64(len(body)) {
+			t.Errorf("expected the size in the per-file summary, got %v", processed.Data)
+		}
+	}
+}
+
+func TestStreamContentEncodingGzip(t *testing.T) {
+	content, _ := json.Marshal(cloudTrailFile(consoleRecord("CreateTags", "event-1")))
+
+	tests := []struct {
+		name string
+		body []byte
+	}{
+		// Served as stored, a plain JSON file compressed in transit.
+		{"encoded", gzipBytes(t, content)},
+		// Decoded in transit by the HTTP client.
+		{"decoded", content},
+		// A .json.gz compressed once more in transit.
+		{"encoded json.gz", gzipBytes(t, gzipBytes(t, content))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slack := captureSlack(t)
+			output := s3.GetObjectOutput{
+				ContentType:     aws.String("application/json"),
+				ContentEncoding: aws.String("gzip"),
+			}
+			withS3Getter(t, cannedS3Getter{output: output, body: tt.body})
+
+			if err := Stream(context.Background(), testS3Record); err != nil {
+				t.Fatal(err)
+			}
+			if bodies := slack.Bodies(); len(bodies) != 1 || slackBodyFor(bodies, "event-1") == "" {
+				t.Errorf("expected a message for event-1, got %v", bodies)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	log "github.com/sirupsen/logrus"
+)
+
+// S3Putter is the part of the S3 API matched records are written with.
+type S3Putter interface {
+	PutObjectWithContext(aws.Context, *s3.PutObjectInput, ...request.Option) (*s3.PutObjectOutput, error)
+}
+
+var (
+	matchedClientMu sync.Mutex
+	matchedClient   S3Putter
+)
+
+func matchedS3() S3Putter {
+	matchedClientMu.Lock()
+	defer matchedClientMu.Unlock()
+	if matchedClient == nil {
+		client := s3.New(session.Must(session.NewSession()), s3Config(aws.NewConfig()))
+		traceAWSClient(client.Client)
+		matchedClient = client
+	}
+	return matchedClient
+}
+
+// matchedLine is a line of a matched records object.
+type matchedLine struct {
+	EventID string            `json:"event_id"`
+	S3URI   string            `json:"s3_uri,omitempty"`
+	Record  *CloudTrailRecord `json:"record"`
+}
+
+// writeMatchedRecords writes the records that matched in a log file to
+// MATCHED_BUCKET as JSON lines, one object per day of eventTime at
+// <MATCHED_PREFIX>/dt=<yyyy-mm-dd>/<source>.jsonl, so Athena can partition
+// them by date. Records without a valid eventTime go under the current day.
+// A failed write is logged, matched records don't fail their log file.
+func writeMatchedRecords(ctx context.Context, evt events.S3EventRecord, source string, records []*CloudTrailRecord) {
+	bucket := os.Getenv("MATCHED_BUCKET")
+	if bucket == "" || len(records) == 0 || dryRunFrom(ctx) {
+		return
+	}
+
+	s3URI := objectURI(evt)
+	days := map[string]*bytes.Buffer{}
+	for _, record := range records {
+		t, err := time.Parse(time.RFC3339, record.EventTime)
+		if err != nil {
+			t = time.Now()
+		}
+		day := t.UTC().Format("2006-01-02")
+		if days[day] == nil {
+			days[day] = &bytes.Buffer{}
+		}
+		line, err := json.Marshal(matchedLine{EventID: record.EventID, S3URI: s3URI, Record: record})
+		if err != nil {
+			log.Warnf("Encoding matched record %s: %v", record.EventID, err)
+			continue
+		}
+		days[day].Write(append(line, '\n'))
+	}
+
+	var sorted []string
+	for day := range days {
+		sorted = append(sorted, day)
+	}
+	sort.Strings(sorted)
+	for _, day := range sorted {
+		key := matchedKey(os.Getenv("MATCHED_PREFIX"), day, source)
+		_, err := matchedS3().PutObjectWithContext(ctx, &s3.PutObjectInput{
+			Bucket:      aws.String(bucket),
+			Key:         aws.String(key),
+			Body:        bytes.NewReader(days[day].Bytes()),
+			ContentType: aws.String("application/x-ndjson"),
+		})
+		if err != nil {
+			log.Warnf("Writing matched records to s3://%s/%s: %v", bucket, key, err)
+		}
+	}
+}
+
+// matchedKey returns the key of the matched records of source on day.
+func matchedKey(prefix, day, source string) string {
+	key := fmt.Sprintf("dt=%s/%s.jsonl", day, source)
+	if prefix = strings.Trim(prefix, "/"); prefix != "" {
+		key = prefix + "/" + key
+	}
+	return key
+}
+
+// matchedSource names the matched records object of a log file after it,
+// e.g. 012345678901_CloudTrail_us-east-1_20210514T1905Z_abc for
+// 012345678901_CloudTrail_us-east-1_20210514T1905Z_abc.json.gz.
+func matchedSource(objectKey string) string {
+	name := path.Base(objectKey)
+	for _, ext := range []string{".gz", ".json", ".jsonl"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return name
+}
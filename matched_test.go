@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// fakeS3Putter keeps the bodies of the objects put.
+type fakeS3Putter struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	err     error
+}
+
+func (f *fakeS3Putter) PutObjectWithContext(ctx aws.Context, in *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	body, err := ioutil.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[aws.StringValue(in.Bucket)+"/"+aws.StringValue(in.Key)] = body
+	return &s3.PutObjectOutput{}, nil
+}
+
+func withFakeS3Putter(t *testing.T) *fakeS3Putter {
+	fake := &fakeS3Putter{objects: map[string][]byte{}}
+	matchedClient = fake
+	t.Cleanup(func() { matchedClient = nil })
+	return fake
+}
+
+func matchedLines(t *testing.T, body []byte) []map[string]interface{} {
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		var line map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("invalid JSON line %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestStreamWritesMatchedRecords(t *testing.T) {
+	captureSlack(t)
+	fake := withFakeS3Putter(t)
+	setEnv(t, "MATCHED_BUCKET", "interesting-events")
+	setEnv(t, "MATCHED_PREFIX", "cloudtrail/")
+
+	nextDay := consoleRecord("DeleteTags", "event-3")
+	nextDay["eventTime"] = "2021-05-15T00:00:01Z"
+	readOnly := consoleRecord("DescribeInstances", "read-only")
+	readOnly["readOnly"] = true
+	content, _ := json.Marshal(cloudTrailFile(consoleRecord("CreateTags", "event-1"), readOnly, consoleRecord("RunInstances", "event-2"), nextDay))
+	withS3Getter(t, &fakeS3Getter{objects: map[string][]byte{"test-harness/" + testS3Record.S3.Object.Key: content}})
+
+	if err := Stream(context.Background(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+
+	source := matchedSource(testS3Record.S3.Object.Key)
+	wantObjects := map[string][]string{
+		"interesting-events/cloudtrail/dt=2021-05-14/" + source + ".jsonl": {"event-1", "event-2"},
+		"interesting-events/cloudtrail/dt=2021-05-15/" + source + ".jsonl": {"event-3"},
+	}
+	if len(fake.objects) != len(wantObjects) {
+		t.Fatalf("expected %d objects, got %v", len(wantObjects), fake.objects)
+	}
+	for key, ids := range wantObjects {
+		lines := matchedLines(t, fake.objects[key])
+		if len(lines) != len(ids) {
+			t.Fatalf("%s: expected %d lines, got %d", key, len(ids), len(lines))
+		}
+		for i, line := range lines {
+			record, _ := line["record"].(map[string]interface{})
+			if line["event_id"] != ids[i] || record["eventID"] != ids[i] || record["userIdentity"] == nil {
+				t.Errorf("%s line %d: unexpected %v", key, i, line)
+			}
+			if line["s3_uri"] != "s3://test-harness/"+testS3Record.S3.Object.Key {
+				t.Errorf("%s line %d: s3_uri = %v", key, i, line["s3_uri"])
+			}
+		}
+	}
+}
+
+func TestStreamMatchedRecordsUnset(t *testing.T) {
+	captureSlack(t)
+	fake := withFakeS3Putter(t)
+	content, _ := json.Marshal(cloudTrailFile(consoleRecord("CreateTags", "event-1")))
+	withS3Getter(t, &fakeS3Getter{objects: map[string][]byte{"test-harness/" + testS3Record.S3.Object.Key: content}})
+
+	if err := Stream(context.Background(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.objects) != 0 {
+		t.Errorf("expected nothing written without MATCHED_BUCKET, got %v", fake.objects)
+	}
+}
+
+func TestStreamMatchedRecordsWriteFails(t *testing.T) {
+	slack := captureSlack(t)
+	fake := withFakeS3Putter(t)
+	fake.err = errors.New("AccessDenied")
+	setEnv(t, "MATCHED_BUCKET", "interesting-events")
+	content, _ := json.Marshal(cloudTrailFile(consoleRecord("CreateTags", "event-1")))
+	withS3Getter(t, &fakeS3Getter{objects: map[string][]byte{"test-harness/" + testS3Record.S3.Object.Key: content}})
+
+	if err := Stream(context.Background(), testS3Record); err != nil {
+		t.Errorf("a failed write should not fail the log file, got %v", err)
+	}
+	if len(slack.Bodies()) != 1 {
+		t.Error("expected the event to be notified")
+	}
+}
+
+func TestMatchedKey(t *testing.T) {
+	for _, tt := range []struct{ prefix, want string }{
+		{"", "dt=2021-05-14/file.jsonl"},
+		{"matched", "matched/dt=2021-05-14/file.jsonl"},
+		{"/matched/cloudtrail/", "matched/cloudtrail/dt=2021-05-14/file.jsonl"},
+	} {
+		if got := matchedKey(tt.prefix, "2021-05-14", "file"); got != tt.want {
+			t.Errorf("matchedKey(%q) = %s, want %s", tt.prefix, got, tt.want)
+		}
+	}
+	if got := matchedSource("AWSLogs/012345678901/CloudTrail/us-east-1/2021/05/14/012345678901_CloudTrail_us-east-1_20210514T1905Z_abc.json.gz"); got != "012345678901_CloudTrail_us-east-1_20210514T1905Z_abc" {
+		t.Errorf("matchedSource() = %s", got)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	metricRecordsScanned    = "RecordsScanned"
+	metricRecordsMatched    = "RecordsMatched"
+	metricNotificationsSent = "NotificationsSent"
+)
+
+var (
+	metricsClientMu sync.Mutex
+	metricsClient   cloudwatchiface.CloudWatchAPI
+)
+
+func metricsCloudWatch() cloudwatchiface.CloudWatchAPI {
+	metricsClientMu.Lock()
+	defer metricsClientMu.Unlock()
+	if metricsClient == nil {
+		client := cloudwatch.New(session.Must(session.NewSession()))
+		traceAWSClient(client.Client)
+		metricsClient = client
+	}
+	return metricsClient
+}
+
+// MetricsPublisher counts what an invocation did and publishes it to
+// CloudWatch in a single PutMetricData call. A nil publisher is a no-op.
+type MetricsPublisher struct {
+	client    cloudwatchiface.CloudWatchAPI
+	namespace string
+
+	mu     sync.Mutex
+	counts map[string]float64
+}
+
+func NewMetricsPublisher(client cloudwatchiface.CloudWatchAPI, namespace string) *MetricsPublisher {
+	return &MetricsPublisher{
+		client:    client,
+		namespace: namespace,
+		counts:    map[string]float64{},
+	}
+}
+
+func (m *MetricsPublisher) Add(metric string, n int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counts[metric] += float64(n)
+}
+
+func (m *MetricsPublisher) Flush() error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var dimensions []*cloudwatch.Dimension
+	if name := os.Getenv("AWS_LAMBDA_FUNCTION_NAME"); name != "" {
+		dimensions = append(dimensions, &cloudwatch.Dimension{
+			Name:  aws.String("FunctionName"),
+			Value: aws.String(name),
+		})
+	}
+
+	var data []*cloudwatch.MetricDatum
+	for _, metric := range []string{metricRecordsScanned, metricRecordsMatched, metricNotificationsSent} {
+		data = append(data, &cloudwatch.MetricDatum{
+			MetricName: aws.String(metric),
+			Dimensions: dimensions,
+			Unit:       aws.String(cloudwatch.StandardUnitCount),
+			Value:      aws.Float64(m.counts[metric]),
+		})
+	}
+
+	_, err := m.client.PutMetricData(&cloudwatch.PutMetricDataInput{
+		Namespace:  aws.String(m.namespace),
+		MetricData: data,
+	})
+	if err == nil {
+		m.counts = map[string]float64{}
+	}
+	return err
+}
+
+type metricsKey struct{}
+
+// withMetrics attaches a publisher to the invocation when METRICS_NAMESPACE
+// is set.
+func withMetrics(ctx context.Context) context.Context {
+	namespace := os.Getenv("METRICS_NAMESPACE")
+	if namespace == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, metricsKey{}, NewMetricsPublisher(metricsCloudWatch(), namespace))
+}
+
+func metricsFrom(ctx context.Context) *MetricsPublisher {
+	m, _ := ctx.Value(metricsKey{}).(*MetricsPublisher)
+	return m
+}
+
+func flushMetrics(ctx context.Context) {
+	if err := metricsFrom(ctx).Flush(); err != nil {
+		log.Warnf("Publishing metrics: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+)
+
+type fakeCloudWatch struct {
+	cloudwatchiface.CloudWatchAPI
+	inputs []*cloudwatch.PutMetricDataInput
+}
+
+func (f *fakeCloudWatch) PutMetricData(in *cloudwatch.PutMetricDataInput) (*cloudwatch.PutMetricDataOutput, error) {
+	f.inputs = append(f.inputs, in)
+	return &cloudwatch.PutMetricDataOutput{}, nil
+}
+
+func TestMetricsPublisherFlush(t *testing.T) {
+	captureSlack(t)
+	setEnv(t, "METRICS_NAMESPACE", "CloudTrailConsole")
+	setEnv(t, "AWS_LAMBDA_FUNCTION_NAME", "cloudTrailConsole")
+	fake := &fakeCloudWatch{}
+	metricsClient = fake
+	defer func() { metricsClient = nil }()
+
+	ctx, err := prepareInvocation(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		logFile := cloudTrailFile(
+			consoleRecord("CreateTags", fmt.Sprintf("tags-%d", i)),
+			consoleRecord("DescribeInstances", fmt.Sprintf("describe-%d", i)),
+		)
+		if _, err := FilterRecords(ctx, logFile.Stream(), testS3Record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	flushMetrics(ctx)
+
+	if len(fake.inputs) != 1 {
+		t.Fatalf("expected a single PutMetricData call, got %d", len(fake.inputs))
+	}
+	in := fake.inputs[0]
+	if *in.Namespace != "CloudTrailConsole" {
+		t.Errorf("unexpected namespace %s", *in.Namespace)
+	}
+
+	want := map[string]float64{
+		metricRecordsScanned:    4,
+		metricRecordsMatched:    2,
+		metricNotificationsSent: 2,
+	}
+	if len(in.MetricData) != len(want) {
+		t.Fatalf("expected %d metrics, got %d", len(want), len(in.MetricData))
+	}
+	for _, datum := range in.MetricData {
+		if v, ok := want[*datum.MetricName]; !ok || *datum.Value != v {
+			t.Errorf("%s = %v, want %v", *datum.MetricName, *datum.Value, v)
+		}
+		if len(datum.Dimensions) != 1 || *datum.Dimensions[0].Name != "FunctionName" || *datum.Dimensions[0].Value != "cloudTrailConsole" {
+			t.Errorf("unexpected dimensions %v", datum.Dimensions)
+		}
+		if *datum.Unit != cloudwatch.StandardUnitCount {
+			t.Errorf("unexpected unit %s", *datum.Unit)
+		}
+	}
+}
+
+func TestMetricsDisabled(t *testing.T) {
+	fake := &fakeCloudWatch{}
+	metricsClient = fake
+	defer func() { metricsClient = nil }()
+
+	ctx, err := prepareInvocation(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metricsFrom(ctx) != nil {
+		t.Fatal("expected no publisher without METRICS_NAMESPACE")
+	}
+
+	metricsFrom(ctx).Add(metricRecordsScanned, 1)
+	flushMetrics(ctx)
+	if len(fake.inputs) != 0 {
+		t.Errorf("expected no PutMetricData calls, got %d", len(fake.inputs))
+	}
+}
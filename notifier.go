@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// AlertEvent is a record that passed the filters, with the fields every
+// notifier renders. Its JSON form is what the generic webhook posts.
+type AlertEvent struct {
+	EventName   string `json:"event_name"`
+	EventSource string `json:"event_source"`
+	EventID     string `json:"event_id"`
+	EventTime   string `json:"event_time"`
+	// EventVersion is the record's eventVersion, e.g. 1.08.
+	EventVersion string `json:"event_version,omitempty"`
+	Region       string `json:"region"`
+	AccountID    string `json:"account_id"`
+	// Account is the display name of AccountID.
+	Account string `json:"account"`
+	// RecipientAccountID is the account a cross-account call was made
+	// to, empty unless it differs from AccountID.
+	RecipientAccountID string `json:"recipient_account_id,omitempty"`
+	UserName           string `json:"user_name"`
+	// SourceIP is the source address with its resolved origin, if any.
+	SourceIP string `json:"source_ip"`
+	S3URI    string `json:"s3_uri"`
+	EventURL string `json:"event_url"`
+	// EventURLLabel names the page EventURL opens, e.g. "View bucket".
+	EventURLLabel string `json:"-"`
+	// RunbookURL is what on-call should follow for the event, empty
+	// unless RUNBOOK_LINKS or DEFAULT_RUNBOOK_URL set one.
+	RunbookURL string   `json:"runbook_url,omitempty"`
+	Severity   string   `json:"severity"`
+	Details    []string `json:"details"`
+	// Age is how long before processing the event happened, empty when
+	// its eventTime could not be parsed.
+	Age string `json:"age,omitempty"`
+	// Resources are the ARNs of the resources the record lists.
+	Resources []string `json:"resources,omitempty"`
+	// ExtraFields are the values of the record selected by EXTRA_FIELDS.
+	ExtraFields []ExtraField `json:"extra_fields,omitempty"`
+	// MFAAuthenticated and SourceIdentity describe the session the record
+	// was made with, nil and empty when it doesn't say.
+	MFAAuthenticated *bool  `json:"mfa_authenticated,omitempty"`
+	SourceIdentity   string `json:"source_identity,omitempty"`
+
+	Record *CloudTrailRecord `json:"-"`
+}
+
+// Notifier delivers alerts to one destination.
+type Notifier interface {
+	Notify(ctx context.Context, alert AlertEvent) error
+}
+
+// errNotNotified is returned by a notifier that deliberately did not send an
+// alert, such as in a dry run or for an event it doesn't handle.
+var errNotNotified = errors.New("not notified")
+
+type notifiersKey struct{}
+
+// withNotifiers attaches the notifiers alerts are fanned out to.
+func withNotifiers(ctx context.Context, notifiers ...Notifier) context.Context {
+	return context.WithValue(ctx, notifiersKey{}, notifiers)
+}
+
+// withConfiguredNotifiers attaches the notifiers configured by the
+// environment unless ctx already carries a list.
+func withConfiguredNotifiers(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(notifiersKey{}).([]Notifier); ok {
+		return ctx
+	}
+	return withNotifiers(ctx, configuredNotifiers()...)
+}
+
+func configuredNotifiers() []Notifier {
+	var notifiers []Notifier
+	ordered := orderedNotifierNames()
+	add := func(name string, notifier Notifier) {
+		if ordered == nil || ordered[name] {
+			notifier = orderedNotifier{notifier}
+		}
+		notifiers = append(notifiers, notifier)
+	}
+	if slackConfigured() {
+		add("slack", slackNotifier{})
+	}
+	if webhookUrl := os.Getenv("TEAMS_WEBHOOK"); webhookUrl != "" {
+		add("teams", teamsNotifier{webhookUrl: webhookUrl})
+	}
+	if webhookUrl := os.Getenv("DISCORD_WEBHOOK"); webhookUrl != "" {
+		add("discord", discordNotifier{webhookUrl: webhookUrl})
+	}
+	if topicArn := os.Getenv("SNS_TOPIC_ARN"); topicArn != "" {
+		add("sns", snsNotifier{topicArn: topicArn})
+	}
+	if routingKey := os.Getenv("PAGERDUTY_ROUTING_KEY"); routingKey != "" {
+		add("pagerduty", pagerDutyNotifier{routingKey: routingKey, events: pagerDutyEvents()})
+	}
+	if webhookUrl := os.Getenv("GENERIC_WEBHOOK_URL"); webhookUrl != "" {
+		add("webhook", webhookNotifier{webhookUrl: webhookUrl, headers: webhookHeaders()})
+	}
+	return notifiers
+}
+
+// notifyErrors collects the failures of the notifiers of one alert.
+type notifyErrors []error
+
+func (e notifyErrors) Error() string {
+	var messages []string
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// notifyFunc sends the alerts of the records FilterRecords matches. Tests
+// replace it to capture the AlertEvents instead of sending them, see
+// captureAlerts.
+var notifyFunc = notifyAll
+
+// notifyAll fans an alert out to every notifier of ctx and returns the
+// failures of all of them. The ordered notifiers are sent to last, once the
+// records before this one in a sorted file are done.
+func notifyAll(ctx context.Context, alert AlertEvent) error {
+	notifiers, _ := ctx.Value(notifiersKey{}).([]Notifier)
+	metrics := metricsFrom(ctx)
+	counter := processingCounterFrom(ctx)
+
+	var errs notifyErrors
+	notify := func(notifier Notifier) {
+		err := notifier.Notify(ctx, alert)
+		switch {
+		case err == nil:
+			metrics.Add(metricNotificationsSent, 1)
+			counter.Add(metricNotificationsSent, 1)
+		case errors.Is(err, errNotNotified):
+		default:
+			errs = append(errs, err)
+		}
+	}
+	var ordered []Notifier
+	for _, notifier := range notifiers {
+		if _, ok := notifier.(orderedNotifier); ok {
+			ordered = append(ordered, notifier)
+			continue
+		}
+		notify(notifier)
+	}
+	if len(ordered) > 0 {
+		if err := waitDispatchTurn(ctx); err != nil {
+			errs = append(errs, err)
+		} else {
+			for _, notifier := range ordered {
+				notify(notifier)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+type slackNotifier struct{}
+
+// slackConfigured reports whether SLACK_BOT_TOKEN, SLACK_WEBHOOK or any
+// per-account SLACK_WEBHOOK_<accountId> is set.
+func slackConfigured() bool {
+	if os.Getenv("SLACK_BOT_TOKEN") != "" {
+		return true
+	}
+	if _, ok := os.LookupEnv("SLACK_WEBHOOK"); ok {
+		return true
+	}
+	for _, env := range os.Environ() {
+		if strings.HasPrefix(env, "SLACK_WEBHOOK_") {
+			return true
+		}
+	}
+	return false
+}
+
+func (n slackNotifier) Notify(ctx context.Context, alert AlertEvent) error {
+	if token := os.Getenv("SLACK_BOT_TOKEN"); token != "" {
+		return n.post(ctx, token, alert)
+	}
+
+	webhookUrl, ok := slackWebhook(alert.AccountID)
+	if !ok {
+		return errNotNotified
+	}
+
+	slackBody := slackEventBody(alert)
+	if dryRunNotification(ctx, "slack", []byte(slackBody)) {
+		return errNotNotified
+	}
+	if err := SendSlackNotification(ctx, webhookUrl, []byte(slackBody)); err != nil {
+		log.Debugln(slackBody)
+		return err
+	}
+	return nil
+}
+
+// post sends the alert with the bot token and replies in its thread with
+// the request parameters and response elements of the event.
+func (slackNotifier) post(ctx context.Context, token string, alert AlertEvent) error {
+	slackBody := slackEventBody(alert)
+	if dryRunNotification(ctx, "slack", []byte(slackBody)) {
+		return errNotNotified
+	}
+	message, err := PostSlackMessage(ctx, token, []byte(slackBody))
+	if err != nil {
+		log.Debugln(slackBody)
+		return err
+	}
+	log.WithFields(log.Fields{
+		"event_id":      alert.EventID,
+		"slack_channel": message.Channel,
+		"slack_ts":      message.TS,
+	}).Info("Posted Slack message")
+	recordSlackMessage(ctx, alert.EventID, message)
+
+	reply := slackThreadReply(message, alert.Record)
+	if reply == nil {
+		return nil
+	}
+	// The alert itself was delivered so a failed reply is only logged.
+	if _, err := PostSlackMessage(ctx, token, reply); err != nil {
+		log.Warnf("Replying to Slack message of %s: %v", alert.EventID, err)
+	}
+	return nil
+}
+
+type teamsNotifier struct {
+	webhookUrl string
+}
+
+func (n teamsNotifier) Notify(ctx context.Context, alert AlertEvent) error {
+	details := alert.Details
+	if resources := resourcesSummary(alert.Resources); resources != "" {
+		details = append(details[:len(details):len(details)], resources)
+	}
+	teamsBody, err := teamsMessageCard(
+		alert.EventName,
+		alert.EventSource,
+		alert.UserName,
+		alert.Account,
+		alert.EventURL,
+		alert.EventURLLabel,
+		alert.Severity,
+		details)
+	if err != nil {
+		return err
+	}
+
+	if dryRunNotification(ctx, "teams", teamsBody) {
+		return errNotNotified
+	}
+	if err := SendTeamsNotification(ctx, n.webhookUrl, teamsBody); err != nil {
+		log.Debugln(string(teamsBody))
+		return err
+	}
+	return nil
+}
+
+type discordNotifier struct {
+	webhookUrl string
+}
+
+func (n discordNotifier) Notify(ctx context.Context, alert AlertEvent) error {
+	discordBody, err := discordEmbed(alert)
+	if err != nil {
+		return err
+	}
+
+	if dryRunNotification(ctx, "discord", discordBody) {
+		return errNotNotified
+	}
+	if err := SendDiscordNotification(ctx, n.webhookUrl, discordBody); err != nil {
+		log.Debugln(string(discordBody))
+		return err
+	}
+	return nil
+}
+
+type webhookNotifier struct {
+	webhookUrl string
+	headers    map[string]string
+}
+
+func (n webhookNotifier) Notify(ctx context.Context, alert AlertEvent) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	if dryRunNotification(ctx, "webhook", body) {
+		return errNotNotified
+	}
+	if err := SendWebhook(ctx, n.webhookUrl, n.headers, body); err != nil {
+		log.Debugln(string(body))
+		return err
+	}
+	return nil
+}
+
+type snsNotifier struct {
+	topicArn string
+}
+
+func (n snsNotifier) Notify(ctx context.Context, alert AlertEvent) error {
+	message := newSNSAlert(alert)
+	body, _ := json.Marshal(message)
+	if dryRunNotification(ctx, "sns", body) {
+		return errNotNotified
+	}
+	return PublishToSNS(ctx, defaultSNSClient(), n.topicArn, message)
+}
+
+type pagerDutyNotifier struct {
+	routingKey string
+	events     map[string]bool
+}
+
+func (n pagerDutyNotifier) Notify(ctx context.Context, alert AlertEvent) error {
+	if !n.events[alert.EventName] {
+		return errNotNotified
+	}
+
+	// The routing key is a secret and left out of the dry run log.
+	body, _ := json.Marshal(pagerDutyTrigger("", alert.Record, alert.Severity))
+	if dryRunNotification(ctx, "pagerduty", body) {
+		return errNotNotified
+	}
+	return SendPagerDutyEvent(ctx, n.routingKey, alert.Record, alert.Severity)
+}
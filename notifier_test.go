@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type fakeNotifier struct {
+	sync.Mutex
+	err    error
+	alerts []AlertEvent
+}
+
+func (f *fakeNotifier) Notify(ctx context.Context, alert AlertEvent) error {
+	f.Lock()
+	defer f.Unlock()
+	f.alerts = append(f.alerts, alert)
+	return f.err
+}
+
+// captureAlerts replaces notifyFunc for the duration of the test and
+// returns the notifier the alerts are captured with.
+func captureAlerts(t *testing.T) *fakeNotifier {
+	capture := &fakeNotifier{}
+	notifyFunc = capture.Notify
+	t.Cleanup(func() { notifyFunc = notifyAll })
+	return capture
+}
+
+func TestCaptureAlerts(t *testing.T) {
+	slack := captureSlack(t)
+	alerts := captureAlerts(t)
+
+	record := consoleRecord("DeleteBucketPolicy", "event-1")
+	record["eventSource"] = "s3.amazonaws.com"
+	record["errorCode"] = "AccessDenied"
+	record["resources"] = []interface{}{map[string]interface{}{"ARN": "arn:aws:s3:::example-bucket"}}
+	if _, err := FilterRecords(context.Background(), cloudTrailFile(record).Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(slack.Bodies()); n != 0 {
+		t.Errorf("expected nothing sent to Slack, got %d messages", n)
+	}
+	if len(alerts.alerts) != 1 {
+		t.Fatalf("expected 1 alert, got %d", len(alerts.alerts))
+	}
+	alert := alerts.alerts[0]
+	for field, got := range map[string][2]string{
+		"EventName":   {alert.EventName, "DeleteBucketPolicy"},
+		"EventSource": {alert.EventSource, "s3.amazonaws.com"},
+		"EventID":     {alert.EventID, "event-1"},
+		"EventTime":   {alert.EventTime, "2021-05-14T19:03:40Z"},
+		"Region":      {alert.Region, "us-east-1"},
+		"AccountID":   {alert.AccountID, "012345678901"},
+		"UserName":    {alert.UserName, "first.last"},
+		"S3URI":       {alert.S3URI, "s3://test-harness/" + testS3Record.S3.Object.Key},
+		"EventURL":    {alert.EventURL, consoleEventURL("us-east-1", "event-1")},
+	} {
+		if got[0] != got[1] {
+			t.Errorf("%s = %q, want %q", field, got[0], got[1])
+		}
+	}
+	if len(alert.Resources) != 1 || alert.Resources[0] != "arn:aws:s3:::example-bucket" {
+		t.Errorf("Resources = %v", alert.Resources)
+	}
+	if !strings.Contains(strings.Join(alert.Details, "\n"), "AccessDenied") {
+		t.Errorf("expected the error code in the details, got %v", alert.Details)
+	}
+	if alert.Record == nil || alert.Record.EventID != "event-1" {
+		t.Errorf("Record = %+v", alert.Record)
+	}
+}
+
+func TestFilterRecordsNotifiesEveryNotifier(t *testing.T) {
+	first, second := &fakeNotifier{}, &fakeNotifier{}
+	ctx := withNotifiers(context.Background(), first, second)
+
+	logFile := cloudTrailFile(consoleRecord("CreateTags", "event-1"))
+	if _, err := FilterRecords(ctx, logFile.Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, notifier := range []*fakeNotifier{first, second} {
+		if len(notifier.alerts) != 1 {
+			t.Fatalf("notifier %d: expected 1 alert, got %d", i, len(notifier.alerts))
+		}
+		alert := notifier.alerts[0]
+		if alert.EventName != "CreateTags" || alert.EventID != "event-1" || alert.Record == nil {
+			t.Errorf("notifier %d: unexpected alert %+v", i, alert)
+		}
+	}
+}
+
+func TestNotifyAllAggregatesErrors(t *testing.T) {
+	failing := &fakeNotifier{err: errors.New("slack: 500")}
+	skipping := &fakeNotifier{err: errNotNotified}
+	working := &fakeNotifier{}
+	also := &fakeNotifier{err: errors.New("teams: 400")}
+	metrics := NewMetricsPublisher(nil, "test")
+	ctx := context.WithValue(withNotifiers(context.Background(), failing, skipping, working, also), metricsKey{}, metrics)
+
+	err := notifyAll(ctx, AlertEvent{EventName: "CreateTags"})
+	if err == nil || err.Error() != "slack: 500; teams: 400" {
+		t.Errorf("notifyAll() = %v", err)
+	}
+	for i, notifier := range []*fakeNotifier{failing, skipping, working, also} {
+		if len(notifier.alerts) != 1 {
+			t.Errorf("notifier %d was called %d times", i, len(notifier.alerts))
+		}
+	}
+	if sent := metrics.counts[metricNotificationsSent]; sent != 1 {
+		t.Errorf("notifications sent = %v, want 1", sent)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type notifyFailuresKey struct{}
+
+// notifyFailures counts the alerts that could not be delivered, so that a
+// misconfigured destination is reported once rather than for every event.
+type notifyFailures struct {
+	mu    sync.Mutex
+	count int
+	last  error
+}
+
+// withNotifyFailures attaches a failure count to ctx unless it already
+// carries one, so the failures of every object of an invocation add up.
+func withNotifyFailures(ctx context.Context) context.Context {
+	if notifyFailuresFrom(ctx) != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, notifyFailuresKey{}, &notifyFailures{})
+}
+
+func notifyFailuresFrom(ctx context.Context) *notifyFailures {
+	f, _ := ctx.Value(notifyFailuresKey{}).(*notifyFailures)
+	return f
+}
+
+// Add records a failed notification. It is safe to call on a nil count.
+func (f *notifyFailures) Add(err error) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.count++
+	f.last = err
+}
+
+// reportNotifyFailures logs the failures counted in ctx since the last
+// report as a single warning.
+func reportNotifyFailures(ctx context.Context) {
+	f := notifyFailuresFrom(ctx)
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	count, last := f.count, f.last
+	f.count, f.last = 0, nil
+	f.mu.Unlock()
+
+	if count > 0 {
+		log.WithFields(log.Fields{
+			"notification_failures": count,
+		}).Warnf("%d notifications failed, the last with: %v", count, last)
+	}
+}
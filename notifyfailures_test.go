@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+// notifyFailureWarnings returns the aggregated warnings logged to hook.
+func notifyFailureWarnings(hook *logtest.Hook) []*logrus.Entry {
+	var warnings []*logrus.Entry
+	for _, entry := range hook.AllEntries() {
+		if _, ok := entry.Data["notification_failures"]; ok {
+			warnings = append(warnings, entry)
+		}
+	}
+	return warnings
+}
+
+func TestFilterRecordsAggregatesNotifyFailures(t *testing.T) {
+	hook := logtest.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+
+	failing := &fakeNotifier{err: errors.New("Slack returned 404: no_service")}
+	ctx := withNotifiers(context.Background(), failing)
+	logFile := cloudTrailFile(
+		consoleRecord("CreateTags", "event-1"),
+		consoleRecord("CreateTags", "event-2"),
+		consoleRecord("CreateTags", "event-3"),
+	)
+	if _, err := FilterRecords(ctx, logFile.Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+
+	warnings := notifyFailureWarnings(hook)
+	if len(warnings) != 1 {
+		t.Fatalf("expected 1 aggregated warning, got %d", len(warnings))
+	}
+	warning := warnings[0]
+	if warning.Level != logrus.WarnLevel || warning.Data["notification_failures"] != 3 || !strings.Contains(warning.Message, "no_service") {
+		t.Errorf("unexpected warning %v: %s", warning.Data, warning.Message)
+	}
+
+	// The events are logged all the same.
+	logged := 0
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Event" {
+			logged++
+		}
+	}
+	if logged != 3 {
+		t.Errorf("expected 3 event logs, got %d", logged)
+	}
+}
+
+func TestS3HandlerAggregatesNotifyFailures(t *testing.T) {
+	hook := logtest.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+
+	var s3Event events.S3Event
+	objects := map[string][]byte{}
+	for i := 1; i <= 2; i++ {
+		evt := testS3Record
+		evt.S3.Object.Key = fmt.Sprintf("AWSLogs/012345678901/CloudTrail/us-east-1/2021/05/14/file-%d.json.gz", i)
+		s3Event.Records = append(s3Event.Records, evt)
+		content, _ := json.Marshal(cloudTrailFile(consoleRecord("CreateTags", fmt.Sprintf("event-%d", i))))
+		objects["test-harness/"+evt.S3.Object.Key] = content
+	}
+	withS3Getter(t, &fakeS3Getter{objects: objects})
+
+	failing := &fakeNotifier{err: errors.New("Slack returned 404: no_service")}
+	if _, err := S3Handler(withNotifiers(context.Background(), failing), s3Event); err != nil {
+		t.Fatal(err)
+	}
+
+	// One warning for the invocation, not one per log file.
+	warnings := notifyFailureWarnings(hook)
+	if len(warnings) != 1 || warnings[0].Data["notification_failures"] != 2 {
+		t.Errorf("expected 1 warning of 2 failures, got %v", warnings)
+	}
+}
+
+func TestFilterRecordsNoNotifyFailures(t *testing.T) {
+	hook := logtest.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+
+	ctx := withNotifiers(context.Background(), &fakeNotifier{})
+	if _, err := FilterRecords(ctx, cloudTrailFile(consoleRecord("CreateTags", "event-1")).Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+	if warnings := notifyFailureWarnings(hook); len(warnings) != 0 {
+		t.Errorf("expected no warning, got %v", warnings)
+	}
+}
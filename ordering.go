@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+)
+
+// orderedNotifier marks a notifier that receives the alerts of a sorted file
+// one at a time in eventTime order, see ORDERED_NOTIFIERS.
+type orderedNotifier struct {
+	Notifier
+}
+
+// orderedNotifierNames returns the notifiers ORDERED_NOTIFIERS lists, nil
+// when it is unset so that every notifier is ordered.
+func orderedNotifierNames() map[string]bool {
+	v, ok := os.LookupEnv("ORDERED_NOTIFIERS")
+	if !ok {
+		return nil
+	}
+	names := map[string]bool{}
+	for _, name := range strings.Split(v, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// dispatchOrder lets the workers of a file send to the ordered notifiers in
+// record order while filtering and the other notifiers run concurrently.
+type dispatchOrder struct {
+	mu sync.Mutex
+	// next is the lowest record not done yet.
+	next    int
+	done    map[int]bool
+	waiting map[int]chan struct{}
+}
+
+func newDispatchOrder() *dispatchOrder {
+	return &dispatchOrder{done: map[int]bool{}, waiting: map[int]chan struct{}{}}
+}
+
+// Wait blocks until every record before seq is done.
+func (o *dispatchOrder) Wait(ctx context.Context, seq int) error {
+	o.mu.Lock()
+	if o.next == seq {
+		o.mu.Unlock()
+		return nil
+	}
+	turn := make(chan struct{})
+	o.waiting[seq] = turn
+	o.mu.Unlock()
+
+	select {
+	case <-turn:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Done marks record seq as done, notified or not. Every record of the file
+// must be marked for the records after it to get their turn.
+func (o *dispatchOrder) Done(seq int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.done[seq] = true
+	for o.done[o.next] {
+		delete(o.done, o.next)
+		o.next++
+	}
+	if turn, ok := o.waiting[o.next]; ok {
+		close(turn)
+		delete(o.waiting, o.next)
+	}
+}
+
+type dispatchTurnKey struct{}
+
+type dispatchTurn struct {
+	order *dispatchOrder
+	seq   int
+}
+
+// withDispatchTurn attaches the place of the record being filtered in order.
+func withDispatchTurn(ctx context.Context, order *dispatchOrder, seq int) context.Context {
+	return context.WithValue(ctx, dispatchTurnKey{}, dispatchTurn{order, seq})
+}
+
+// waitDispatchTurn blocks until the ordered notifiers are free for the record
+// of ctx. It returns at once outside of a sorted file.
+func waitDispatchTurn(ctx context.Context) error {
+	turn, ok := ctx.Value(dispatchTurnKey{}).(dispatchTurn)
+	if !ok {
+		return nil
+	}
+	return turn.order.Wait(ctx, turn.seq)
+}
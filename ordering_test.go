@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type notifierFunc func(ctx context.Context, alert AlertEvent) error
+
+func (f notifierFunc) Notify(ctx context.Context, alert AlertEvent) error {
+	return f(ctx, alert)
+}
+
+func TestFilterRecordsOrderedNotifiers(t *testing.T) {
+	setEnv(t, "SORT_BY_EVENT_TIME", "true")
+
+	// The unordered notifier holds the early record until it got the late
+	// one, which only works if it isn't serialized with the ordered one.
+	lateSeen := make(chan struct{})
+	unordered := notifierFunc(func(ctx context.Context, alert AlertEvent) error {
+		switch alert.EventID {
+		case "early":
+			select {
+			case <-lateSeen:
+			case <-time.After(5 * time.Second):
+				t.Error("late record was not notified concurrently")
+			}
+		case "late":
+			close(lateSeen)
+		}
+		return nil
+	})
+	ordered := &fakeNotifier{}
+
+	late := consoleRecord("RunInstances", "late")
+	late["eventTime"] = "2021-05-14T20:00:00Z"
+	early := consoleRecord("CreateTags", "early")
+
+	ctx := withNotifiers(context.Background(), unordered, orderedNotifier{ordered})
+	if _, err := FilterRecords(ctx, cloudTrailFile(late, early).Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ordered.alerts) != 2 || ordered.alerts[0].EventID != "early" || ordered.alerts[1].EventID != "late" {
+		t.Errorf("ordered notifier got %v, want early then late", ordered.alerts)
+	}
+}
+
+func TestConfiguredOrderedNotifiers(t *testing.T) {
+	setEnv(t, "SLACK_WEBHOOK", "https://hooks.slack.com/services/x")
+	setEnv(t, "GENERIC_WEBHOOK_URL", "https://example.com/hook")
+
+	tests := []struct {
+		name    string
+		set     bool
+		env     string
+		ordered []bool
+	}{
+		{name: "unset", ordered: []bool{true, true}},
+		{name: "webhook only", set: true, env: "webhook", ordered: []bool{false, true}},
+		{name: "empty", set: true, ordered: []bool{false, false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.set {
+				setEnv(t, "ORDERED_NOTIFIERS", tt.env)
+			}
+			notifiers := configuredNotifiers()
+			if len(notifiers) != len(tt.ordered) {
+				t.Fatalf("got %d notifiers, want %d", len(notifiers), len(tt.ordered))
+			}
+			for i, notifier := range notifiers {
+				if _, ok := notifier.(orderedNotifier); ok != tt.ordered[i] {
+					t.Errorf("notifier %d (%T) ordered = %v, want %v", i, notifier, ok, tt.ordered[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+var pagerDutySeverities = map[string]string{
+	severityInfo:     "info",
+	severityWarn:     "warning",
+	severityCritical: "critical",
+}
+
+type pagerDutyEvent struct {
+	RoutingKey  string           `json:"routing_key"`
+	EventAction string           `json:"event_action"`
+	DedupKey    string           `json:"dedup_key"`
+	Payload     pagerDutyPayload `json:"payload"`
+	Links       []pagerDutyLink  `json:"links,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string            `json:"summary"`
+	Source        string            `json:"source"`
+	Severity      string            `json:"severity"`
+	Timestamp     string            `json:"timestamp,omitempty"`
+	Component     string            `json:"component,omitempty"`
+	CustomDetails map[string]string `json:"custom_details,omitempty"`
+}
+
+type pagerDutyLink struct {
+	Href string `json:"href"`
+	Text string `json:"text"`
+}
+
+// pagerDutyEvents returns the event names listed in PAGERDUTY_EVENTS.
+func pagerDutyEvents() map[string]bool {
+	names := map[string]bool{}
+	for _, name := range strings.Split(os.Getenv("PAGERDUTY_EVENTS"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+func pagerDutyTrigger(routingKey string, record *CloudTrailRecord, severity string) pagerDutyEvent {
+	return pagerDutyEvent{
+		RoutingKey:  routingKey,
+		EventAction: "trigger",
+		DedupKey:    record.EventID,
+		Payload: pagerDutyPayload{
+			Summary:   fmt.Sprintf("%s - %s in %s", record.EventName, record.EventSource, accountLabel(record.UserIdentity.AccountID)),
+			Source:    record.EventSource,
+			Severity:  pagerDutySeverities[severity],
+			Timestamp: record.EventTime,
+			Component: record.AwsRegion,
+			CustomDetails: map[string]string{
+				"event_id":   record.EventID,
+				"account_id": record.UserIdentity.AccountID,
+				"principal":  record.UserIdentity.PrincipalID,
+				"arn":        record.UserIdentity.ARN,
+				"source_ip":  record.SourceIPAddress,
+				"user_agent": record.UserAgent,
+			},
+		},
+		Links: []pagerDutyLink{
+			{Href: consoleEventURL(record.AwsRegion, record.EventID), Text: "View in CloudTrail"},
+		},
+	}
+}
+
+// SendPagerDutyEvent triggers an Events API v2 alert for a record,
+// deduplicated on its eventID.
+func SendPagerDutyEvent(ctx context.Context, routingKey string, record *CloudTrailRecord, severity string) error {
+	body, err := json.Marshal(pagerDutyTrigger(routingKey, record, severity))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, pagerDutyEventsURL, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Content-Type", "application/json")
+
+	client := notifyHTTPClient()
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer closeResponse(resp)
+
+	if resp.StatusCode != http.StatusAccepted {
+		buf := new(bytes.Buffer)
+		buf.ReadFrom(resp.Body)
+		return fmt.Errorf("Non-ok response returned from PagerDuty: %d %s", resp.StatusCode, truncate(buf.String(), maxErrorBodyLength))
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// capturePagerDuty points the Events API at a local server recording every
+// event posted to it.
+func capturePagerDuty(t *testing.T) func() []pagerDutyEvent {
+	var mu sync.Mutex
+	var events []pagerDutyEvent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var event pagerDutyEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Errorf("invalid event %s: %v", body, err)
+		}
+		mu.Lock()
+		events = append(events, event)
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"status":"success","message":"Event processed","dedup_key":"x"}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	prev := pagerDutyEventsURL
+	pagerDutyEventsURL = srv.URL
+	t.Cleanup(func() { pagerDutyEventsURL = prev })
+
+	return func() []pagerDutyEvent {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]pagerDutyEvent(nil), events...)
+	}
+}
+
+func TestSendPagerDutyEvent(t *testing.T) {
+	events := capturePagerDuty(t)
+
+	record := consoleRecord("StopLogging", "trail-1")
+	record["eventSource"] = "cloudtrail.amazonaws.com"
+	if err := SendPagerDutyEvent(context.Background(), "routing-key", typedRecord(record), severityCritical); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := events()
+	if len(sent) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(sent))
+	}
+	event := sent[0]
+	if event.RoutingKey != "routing-key" || event.EventAction != "trigger" || event.DedupKey != "trail-1" {
+		t.Errorf("unexpected event %+v", event)
+	}
+	if event.Payload.Severity != "critical" || event.Payload.Source != "cloudtrail.amazonaws.com" || event.Payload.Timestamp != "2021-05-14T19:03:40Z" {
+		t.Errorf("unexpected payload %+v", event.Payload)
+	}
+	if event.Payload.Summary != "StopLogging - cloudtrail.amazonaws.com in 012345678901" {
+		t.Errorf("unexpected summary %q", event.Payload.Summary)
+	}
+	if len(event.Links) != 1 || event.Links[0].Href != consoleEventURL("us-east-1", "trail-1") {
+		t.Errorf("unexpected links %+v", event.Links)
+	}
+}
+
+func TestSendPagerDutyEventError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"status":"invalid event"}`))
+	}))
+	defer srv.Close()
+	prev := pagerDutyEventsURL
+	pagerDutyEventsURL = srv.URL
+	defer func() { pagerDutyEventsURL = prev }()
+
+	if err := SendPagerDutyEvent(context.Background(), "routing-key", typedRecord(consoleRecord("StopLogging", "trail-1")), severityCritical); err == nil {
+		t.Error("expected an error for a 400 response")
+	}
+}
+
+func TestPagerDutyEventMatching(t *testing.T) {
+	captureSlack(t)
+	events := capturePagerDuty(t)
+	setEnv(t, "PAGERDUTY_ROUTING_KEY", "routing-key")
+	setEnv(t, "PAGERDUTY_EVENTS", "DeleteTrail, StopLogging")
+
+	logFile := cloudTrailFile(
+		consoleRecord("StopLogging", "trail-1"),
+		consoleRecord("CreateTags", "tags-1"),
+		consoleRecord("DeleteTrail", "trail-2"),
+	)
+	if _, err := FilterRecords(context.Background(), logFile.Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := events()
+	var keys []string
+	for _, event := range sent {
+		keys = append(keys, event.DedupKey)
+	}
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "trail-1,trail-2" {
+		t.Errorf("expected pages for trail-1 and trail-2, got %+v", sent)
+	}
+}
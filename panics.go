@@ -0,0 +1,17 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// recoveredPanic logs a value recovered from a panic with the stack it was
+// raised on and turns it into an error, so a bug fails the invocation and
+// the trigger's retry and dead-letter policy applies instead of the runtime
+// crashing.
+func recoveredPanic(r interface{}) error {
+	log.WithField("stack", string(debug.Stack())).Errorf("Recovered from panic: %v", r)
+	return fmt.Errorf("panic: %v", r)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+type panicNotifier struct{}
+
+func (panicNotifier) Notify(ctx context.Context, alert AlertEvent) error {
+	var seen map[string]bool
+	seen[alert.EventID] = true
+	return nil
+}
+
+type panicS3Getter struct{}
+
+func (panicS3Getter) GetObjectWithContext(aws.Context, *s3.GetObjectInput, ...request.Option) (*s3.GetObjectOutput, error) {
+	panic("unexpected")
+}
+
+func TestS3HandlerRecoversNotifierPanic(t *testing.T) {
+	content, _ := json.Marshal(cloudTrailFile(consoleRecord("CreateTags", "event-1"), consoleRecord("CreateTags", "event-2")))
+	withS3Getter(t, &fakeS3Getter{objects: map[string][]byte{"test-harness/" + testS3Record.S3.Object.Key: content}})
+
+	ctx := withNotifiers(context.Background(), panicNotifier{})
+	_, err := S3Handler(ctx, events.S3Event{Records: []events.S3EventRecord{testS3Record}})
+	if err == nil || !strings.Contains(err.Error(), "panic: assignment to entry in nil map") {
+		t.Fatalf("expected the panic as an error, got %v", err)
+	}
+}
+
+func TestS3HandlerRecoversObjectPanic(t *testing.T) {
+	withS3Getter(t, panicS3Getter{})
+	captureSlack(t)
+
+	_, err := S3Handler(context.Background(), events.S3Event{Records: []events.S3EventRecord{testS3Record}})
+	if err == nil || !strings.Contains(err.Error(), testS3Record.S3.Object.Key) || !strings.Contains(err.Error(), "panic: unexpected") {
+		t.Fatalf("expected the panic as an error, got %v", err)
+	}
+	var failed objectErrors
+	if !errors.As(err, &failed) || len(failed) != 1 {
+		t.Errorf("expected the object to fail, got %#v", err)
+	}
+}
+
+func TestSQSHandlerRecoversMessagePanic(t *testing.T) {
+	withS3Getter(t, panicS3Getter{})
+	captureSlack(t)
+
+	s3Record := testS3Record
+	s3Record.EventSource = "aws:s3"
+	body, _ := json.Marshal(events.S3Event{Records: []events.S3EventRecord{s3Record}})
+	response, err := SQSHandler(context.Background(), events.SQSEvent{Records: []events.SQSMessage{
+		{MessageId: "message-1", EventSource: "aws:sqs", Body: string(body)},
+		{MessageId: "message-2", EventSource: "aws:sqs", Body: `{"Event":"s3:TestEvent"}`},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.BatchItemFailures) != 1 || response.BatchItemFailures[0].ItemIdentifier != "message-1" {
+		t.Errorf("expected only message-1 to fail, got %+v", response.BatchItemFailures)
+	}
+}
+
+type panicSQS struct {
+	fakeSQS
+}
+
+func (panicSQS) SendMessageBatchWithContext(aws.Context, *sqs.SendMessageBatchInput, ...request.Option) (*sqs.SendMessageBatchOutput, error) {
+	panic("unexpected")
+}
+
+func TestEventBridgeHandlerRecoversPanic(t *testing.T) {
+	raw, err := os.ReadFile("testdata/eventbridge-cloudtrail-event.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	captureSlack(t)
+	// The budget summary is sent outside of the record workers.
+	setEnv(t, "NOTIFY_TIME_BUDGET", "1ns")
+	setEnv(t, "NOTIFY_DLQ_URL", "https://sqs.us-east-1.amazonaws.com/012345678901/dlq")
+	dlqClient = panicSQS{}
+	defer func() { dlqClient = nil }()
+
+	err = EventBridgeHandler(context.Background(), raw)
+	if err == nil || !strings.Contains(err.Error(), "panic: unexpected") {
+		t.Fatalf("expected the panic as an error, got %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// paramMatchRule alerts on the events whose request parameter at Path
+// matches Regex, labelling them with Label.
+type paramMatchRule struct {
+	// Event is the event name or a path.Match pattern such as "Put*Policy".
+	Event string `json:"event"`
+	// Path is a dotted path within requestParameters, e.g. policyDocument.
+	Path  string `json:"path"`
+	Regex string `json:"regex"`
+	Label string `json:"label"`
+	// Severity is info, warn or critical, warn by default.
+	Severity string `json:"severity"`
+
+	pattern *regexp.Regexp
+}
+
+type paramMatchRules []paramMatchRule
+
+// parseParamMatchRules decodes PARAM_MATCH_RULES, a JSON list of rules such
+// as [{"event": "PutRolePolicy", "path": "policyDocument",
+// "regex": "\"Action\":\\s*\"\\*\"", "label": "WILDCARD_POLICY"}].
+func parseParamMatchRules(value string) (paramMatchRules, error) {
+	var rules paramMatchRules
+	if err := json.Unmarshal([]byte(value), &rules); err != nil {
+		return nil, err
+	}
+
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Event == "" || rule.Path == "" || rule.Regex == "" || rule.Label == "" {
+			return nil, fmt.Errorf("rule %d: event, path, regex and label are required", i)
+		}
+		if _, err := path.Match(rule.Event, ""); err != nil {
+			return nil, fmt.Errorf("%s: %w", rule.Label, err)
+		}
+		if rule.Severity == "" {
+			rule.Severity = severityWarn
+		} else if _, ok := severityRank[rule.Severity]; !ok {
+			return nil, fmt.Errorf("%s: unknown severity %q", rule.Label, rule.Severity)
+		}
+		pattern, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", rule.Label, err)
+		}
+		rule.pattern = pattern
+	}
+	return rules, nil
+}
+
+// Match returns a detection for each rule matching a record, so that it
+// alerts like the default detections do.
+func (r paramMatchRules) Match(record *CloudTrailRecord) []Detection {
+	var matched []Detection
+	for _, rule := range r {
+		rule := rule
+		if ok, _ := path.Match(rule.Event, record.EventName); !ok {
+			continue
+		}
+		if MatchRequestParam(record, rule.Path, rule.pattern) {
+			matched = append(matched, Detection{
+				Name:     rule.Label,
+				Severity: rule.Severity,
+				Match: func(record *CloudTrailRecord) bool {
+					return MatchRequestParam(record, rule.Path, rule.pattern)
+				},
+			})
+		}
+	}
+	return matched
+}
+
+// MatchRequestParam reports whether the request parameter at the dotted
+// path matches regex. Objects and arrays are matched in their JSON form.
+func MatchRequestParam(record *CloudTrailRecord, path string, regex *regexp.Regexp) bool {
+	value, ok := lookupPath(record.RequestParameters, strings.Split(path, "."))
+	return ok && regex.MatchString(value)
+}
+
+var (
+	paramMatchRulesMu     sync.Mutex
+	paramMatchRulesEnv    string
+	paramMatchRulesParsed paramMatchRules
+)
+
+// activeParamMatchRules returns the PARAM_MATCH_RULES, parsing them again
+// only when they change.
+func activeParamMatchRules() paramMatchRules {
+	env := os.Getenv("PARAM_MATCH_RULES")
+
+	paramMatchRulesMu.Lock()
+	defer paramMatchRulesMu.Unlock()
+	if paramMatchRulesEnv != env {
+		paramMatchRulesParsed, paramMatchRulesEnv = nil, env
+		if env != "" {
+			rules, err := parseParamMatchRules(env)
+			if err != nil {
+				log.Warnf("Ignoring invalid PARAM_MATCH_RULES: %v", err)
+			} else {
+				paramMatchRulesParsed = rules
+			}
+		}
+	}
+	return paramMatchRulesParsed
+}
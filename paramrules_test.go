@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+const wildcardPolicyRules = `[{"event": "Put*Policy", "path": "policyDocument", "regex": "\"Action\":\\s*\"\\*\"", "label": "WILDCARD_POLICY", "severity": "critical"}]`
+
+func policyRecord(eventID, policyDocument string) map[string]interface{} {
+	record := consoleRecord("PutRolePolicy", eventID)
+	record["eventSource"] = "iam.amazonaws.com"
+	record["requestParameters"] = map[string]interface{}{
+		"roleName":       "Admin",
+		"policyName":     "inline",
+		"policyDocument": policyDocument,
+	}
+	return record
+}
+
+func TestParamMatchRules(t *testing.T) {
+	rules, err := parseParamMatchRules(wildcardPolicyRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wildcard := typedRecord(policyRecord("wildcard", `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action": "*","Resource":"*"}]}`))
+	scoped := typedRecord(policyRecord("scoped", `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"s3:GetObject","Resource":"arn:aws:s3:::example-bucket/*"}]}`))
+	other := typedRecord(policyRecord("other", `{"Statement":[{"Action":"*"}]}`))
+	other.EventName = "CreatePolicyVersion"
+
+	if matched := rules.Match(wildcard); len(matched) != 1 || matched[0].Name != "WILDCARD_POLICY" || matched[0].Severity != severityCritical || !matched[0].Match(wildcard) {
+		t.Errorf("expected the wildcard policy to match, got %+v", matched)
+	}
+	if matched := rules.Match(scoped); len(matched) != 0 {
+		t.Errorf("expected the scoped policy not to match, got %+v", matched)
+	}
+	if matched := rules.Match(other); len(matched) != 0 {
+		t.Errorf("expected other events not to match, got %+v", matched)
+	}
+}
+
+func TestParseParamMatchRulesInvalid(t *testing.T) {
+	for _, value := range []string{
+		`{"event": "PutRolePolicy"}`,
+		`[{"event": "PutRolePolicy", "path": "policyDocument", "regex": "(", "label": "BROKEN"}]`,
+		`[{"event": "PutRolePolicy", "path": "policyDocument", "regex": "x"}]`,
+		`[{"event": "[", "path": "policyDocument", "regex": "x", "label": "BAD_PATTERN"}]`,
+		`[{"event": "PutRolePolicy", "path": "policyDocument", "regex": "x", "label": "L", "severity": "urgent"}]`,
+	} {
+		if _, err := parseParamMatchRules(value); err == nil {
+			t.Errorf("parseParamMatchRules(%s) should fail", value)
+		}
+	}
+}
+
+func TestFilterRecordsParamMatchRules(t *testing.T) {
+	alerts := captureAlerts(t)
+	setEnv(t, "PARAM_MATCH_RULES", wildcardPolicyRules)
+
+	wildcard := policyRecord("wildcard", `{"Statement":[{"Effect":"Allow","Action":"*","Resource":"*"}]}`)
+	scoped := policyRecord("scoped", `{"Statement":[{"Effect":"Allow","Action":"s3:GetObject","Resource":"*"}]}`)
+	// Matched rules alert even when the user agent would suppress the
+	// event.
+	cli := policyRecord("cli", `{"Statement":[{"Effect":"Allow","Action":"*","Resource":"*"}]}`)
+	cli["userAgent"] = "aws-cli/2.2.5"
+
+	if _, err := FilterRecords(context.Background(), cloudTrailFile(wildcard, scoped, cli).Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+
+	byID := map[string]AlertEvent{}
+	for _, alert := range alerts.alerts {
+		byID[alert.EventID] = alert
+	}
+	if len(byID) != 3 {
+		t.Fatalf("expected 3 alerts, got %v", byID)
+	}
+	for _, id := range []string{"wildcard", "cli"} {
+		alert := byID[id]
+		if alert.Severity != severityCritical || !strings.Contains(strings.Join(alert.Details, "\n"), "Detections: WILDCARD_POLICY") {
+			t.Errorf("%s: expected a critical WILDCARD_POLICY alert, got %s %v", id, alert.Severity, alert.Details)
+		}
+	}
+	if alert := byID["scoped"]; alert.Severity != severityInfo || strings.Contains(strings.Join(alert.Details, "\n"), "WILDCARD_POLICY") {
+		t.Errorf("scoped: expected no label, got %s %v", alert.Severity, alert.Details)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	log "github.com/sirupsen/logrus"
+)
+
+// permanentError reports whether retrying an object that failed with err
+// can't succeed: the log file isn't valid gzip or JSON, or S3 or KMS refuse
+// access until someone changes a bucket or a policy. Throttling, timeouts,
+// server errors and anything unknown may go away and aren't permanent.
+func permanentError(err error) bool {
+	var (
+		syntaxErr *json.SyntaxError
+		typeErr   *json.UnmarshalTypeError
+		tokenErr  unexpectedTokenError
+		kmsErr    *KMSAccessError
+	)
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) || errors.As(err, &tokenErr) || errors.As(err, &kmsErr) {
+		return true
+	}
+	if errors.Is(err, gzip.ErrHeader) || errors.Is(err, gzip.ErrChecksum) {
+		return true
+	}
+
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		switch aerr.Code() {
+		case "AccessDenied", "AccessDeniedException", "AllAccessDisabled", "NoSuchBucket", "InvalidObjectState":
+			return true
+		}
+	}
+	return false
+}
+
+// retryableError returns the error an object failed with when the event
+// should be retried. With RETRY_ON_PERMANENT=false permanent failures are
+// logged at error level and dropped instead, so the event isn't retried
+// only to end up in the dead letter queue later.
+func retryableError(s3Record events.S3EventRecord, err error) error {
+	if err == nil || getEnvBool("RETRY_ON_PERMANENT", true) || !permanentError(err) {
+		return err
+	}
+	log.WithField("s3_uri", objectURI(s3Record)).Errorf("Not retrying permanent failure: %v", err)
+	return nil
+}
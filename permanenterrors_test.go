@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+func decodeError(content string) error {
+	err := decodeRecords(strings.NewReader(content))(func(*CloudTrailRecord) error { return nil })
+	return fmt.Errorf("key: %w", err)
+}
+
+func TestPermanentError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{decodeError(`{"Records":[{"eventID":1x}]}`), true},
+		{decodeError(`["not a log file"]`), true},
+		// A body cut short may be a dropped connection.
+		{decodeError(`{"Records":[{"eventID":`), false},
+		{fmt.Errorf("key: %w", awserr.New("AccessDenied", "Access Denied", nil)), true},
+		{fmt.Errorf("key: %w", &KMSAccessError{Err: awserr.New("KMS.DisabledException", "disabled", nil)}), true},
+		{fmt.Errorf("key: %w", awserr.NewRequestFailure(awserr.New("SlowDown", "Please reduce your request rate.", nil), 503, "req-1")), false},
+		{fmt.Errorf("key: %w", awserr.New("ThrottlingException", "Rate exceeded", nil)), false},
+		{fmt.Errorf("key: %w", context.DeadlineExceeded), false},
+	}
+
+	for _, tt := range tests {
+		if got := permanentError(tt.err); got != tt.want {
+			t.Errorf("permanentError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestS3HandlerRetryOnPermanent(t *testing.T) {
+	key := testS3Record.S3.Object.Key
+	tests := []struct {
+		name             string
+		getter           S3Getter
+		retryOnPermanent string
+		wantErr          bool
+	}{
+		{"unmarshal error", &fakeS3Getter{objects: map[string][]byte{"test-harness/" + key: []byte(`{"Records":[{"eventID":1x}]}`)}}, "false", false},
+		{"unmarshal error retried by default", &fakeS3Getter{objects: map[string][]byte{"test-harness/" + key: []byte(`{"Records":[{"eventID":1x}]}`)}}, "", true},
+		{"throttling error", &flakyS3Getter{errs: []error{awserr.NewRequestFailure(awserr.New("SlowDown", "Please reduce your request rate.", nil), 503, "req-1")}}, "false", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			captureSlack(t)
+			withS3Getter(t, tt.getter)
+			setEnv(t, "S3_GET_MAX_RETRIES", "0")
+			setEnv(t, "RETRY_ON_PERMANENT", tt.retryOnPermanent)
+			hook := logtest.NewGlobal()
+			defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+
+			_, err := S3Handler(context.Background(), events.S3Event{Records: []events.S3EventRecord{testS3Record}})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("S3Handler() error = %v, want an error: %v", err, tt.wantErr)
+			}
+
+			var dropped bool
+			for _, entry := range hook.AllEntries() {
+				if entry.Level == logrus.ErrorLevel && entry.Data["s3_uri"] == "s3://test-harness/"+key {
+					dropped = true
+				}
+			}
+			if dropped == tt.wantErr {
+				t.Errorf("expected an error log entry: %v", !tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProcessSQSMessageDropsPermanentErrors(t *testing.T) {
+	withS3Getter(t, &fakeS3Getter{objects: map[string][]byte{"test-harness/" + testS3Record.S3.Object.Key: []byte(`not json`)}})
+	setEnv(t, "RETRY_ON_PERMANENT", "false")
+
+	body := fmt.Sprintf(`{"Records":[{"eventSource":"aws:s3","awsRegion":"us-east-1","s3":{"bucket":{"name":"test-harness"},"object":{"key":%q}}}]}`, testS3Record.S3.Object.Key)
+	if err := processSQSMessage(context.Background(), events.SQSMessage{MessageId: "m-1", Body: body}); err != nil {
+		t.Errorf("expected the permanent failure to be dropped, got %v", err)
+	}
+
+	setEnv(t, "RETRY_ON_PERMANENT", "")
+	if err := processSQSMessage(context.Background(), events.SQSMessage{MessageId: "m-1", Body: body}); err == nil {
+		t.Error("expected the failure to be retried by default")
+	}
+}
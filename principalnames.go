@@ -0,0 +1,257 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	log "github.com/sirupsen/logrus"
+)
+
+// How many principals principalNames remembers.
+const principalNameCacheSize = 512
+
+// The tag of an IAM user or role holding its display name.
+const principalNameTag = "Name"
+
+// How long a failed lookup is remembered before the principal is looked up
+// again.
+const principalNameFailureTTL = 15 * time.Minute
+
+var newIAMClient = defaultIAMClient
+
+// defaultIAMClient builds an IAM client, assuming roleArn first when it is
+// set.
+func defaultIAMClient(roleArn string) iamiface.IAMAPI {
+	sess := session.Must(session.NewSession())
+	config := aws.NewConfig()
+	if roleArn != "" {
+		config = config.WithCredentials(stscreds.NewCredentials(sess, roleArn))
+	}
+	client := iam.New(sess, config)
+	traceAWSClient(client.Client)
+	return client
+}
+
+var (
+	iamClientsMu sync.Mutex
+	iamClients   = map[string]iamiface.IAMAPI{} // by role ARN
+)
+
+// principalIAM returns an IAM client for the principals of accountID: the
+// S3_ROLE_ARN of the account when that role is in the account, or else the
+// function's own credentials when accountID is the function's account. It
+// returns nil for any other account, its users and roles can't be looked up.
+func principalIAM(ctx context.Context, accountID string) iamiface.IAMAPI {
+	if accountID == "" {
+		return nil
+	}
+	roleArn := s3RoleArn(accountID)
+	if principalAccount(roleArn) != accountID {
+		if accountID != functionAccountID(ctx) {
+			return nil
+		}
+		roleArn = ""
+	}
+
+	iamClientsMu.Lock()
+	defer iamClientsMu.Unlock()
+	client, ok := iamClients[roleArn]
+	if !ok {
+		client = newIAMClient(roleArn)
+		iamClients[roleArn] = client
+	}
+	return client
+}
+
+// functionAccountID returns the account of the invoked function, empty
+// outside of Lambda.
+func functionAccountID(ctx context.Context) string {
+	lc, ok := lambdacontext.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	return principalAccount(lc.InvokedFunctionArn)
+}
+
+// principalNames is kept across warm invocations.
+var principalNames = NewPrincipalNameCache(principalNameCacheSize)
+
+// PrincipalNameCache remembers the display names of the most recently used
+// IAM user and role ARNs.
+type PrincipalNameCache struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List // of *principalName, most recently used first
+	names map[string]*list.Element
+}
+
+type principalName struct {
+	arn     string
+	name    string
+	expires time.Time // zero for names that don't expire
+}
+
+// NewPrincipalNameCache returns a cache of up to size names.
+func NewPrincipalNameCache(size int) *PrincipalNameCache {
+	return &PrincipalNameCache{
+		size:  size,
+		order: list.New(),
+		names: map[string]*list.Element{},
+	}
+}
+
+// Get returns the name of arn and marks it as recently used.
+func (c *PrincipalNameCache) Get(arn string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.names[arn]
+	if !ok {
+		return "", false
+	}
+	entry := e.Value.(*principalName)
+	if !entry.expires.IsZero() && !time.Now().Before(entry.expires) {
+		c.order.Remove(e)
+		delete(c.names, arn)
+		return "", false
+	}
+	c.order.MoveToFront(e)
+	return entry.name, true
+}
+
+// Add remembers the name of arn, evicting the least recently used name when
+// the cache is full.
+func (c *PrincipalNameCache) Add(arn, name string) {
+	c.add(arn, name, time.Time{})
+}
+
+// AddExpiring remembers the name of arn for ttl.
+func (c *PrincipalNameCache) AddExpiring(arn, name string, ttl time.Duration) {
+	c.add(arn, name, time.Now().Add(ttl))
+}
+
+func (c *PrincipalNameCache) add(arn, name string, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.names[arn]; ok {
+		entry := e.Value.(*principalName)
+		entry.name, entry.expires = name, expires
+		c.order.MoveToFront(e)
+		return
+	}
+	c.names[arn] = c.order.PushFront(&principalName{arn, name, expires})
+	if c.order.Len() > c.size {
+		oldest := c.order.Remove(c.order.Back()).(*principalName)
+		delete(c.names, oldest.arn)
+	}
+}
+
+// Len returns the number of names in the cache.
+func (c *PrincipalNameCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// Resolve returns the display name of an IAM user, or of the role of an
+// assumed role session followed by the session name, e.g.
+// "Platform Admin (first.last)". The display name is the user's or role's
+// Name tag, or else its name. Identities of other types and those IAM
+// doesn't resolve keep the name resolveUserName gives them, ending with the
+// principalId, as do principals of accounts client returns nil for. Each ARN
+// is looked up once, failures again after principalNameFailureTTL.
+func (c *PrincipalNameCache) Resolve(ctx context.Context, client func(ctx context.Context, accountID string) iamiface.IAMAPI, userIdentity UserIdentity) string {
+	fallback := resolveUserName(userIdentity)
+
+	var arn, sessionName string
+	switch userIdentity.Type {
+	case "IAMUser":
+		arn = userIdentity.ARN
+	case "AssumedRole":
+		issuer, _ := userIdentity.SessionContext["sessionIssuer"].(map[string]interface{})
+		if stringField(issuer, "type") != "Role" {
+			return fallback
+		}
+		arn = stringField(issuer, "arn")
+		if parts := strings.SplitN(userIdentity.ARN, ":assumed-role/", 2); len(parts) == 2 {
+			if role := strings.SplitN(parts[1], "/", 2); len(role) == 2 {
+				sessionName = role[1]
+			}
+		}
+	}
+	if arn == "" {
+		return fallback
+	}
+
+	name, ok := c.Get(arn)
+	if !ok {
+		iamClient := client(ctx, principalAccount(arn))
+		if iamClient == nil {
+			return fallback
+		}
+		var err error
+		if name, err = lookupPrincipalName(ctx, iamClient, arn); err != nil {
+			log.Warnf("Resolving the name of %s: %v", arn, err)
+			c.AddExpiring(arn, "", principalNameFailureTTL)
+			return fallback
+		}
+		c.Add(arn, name)
+	}
+	if name == "" {
+		return fallback
+	}
+	if sessionName != "" {
+		return name + " (" + sessionName + ")"
+	}
+	return name
+}
+
+// lookupPrincipalName returns the display name of the IAM user or role arn,
+// arn:aws:iam::<account>:user/<path>/<name> or
+// arn:aws:iam::<account>:role/<path>/<name>.
+func lookupPrincipalName(ctx context.Context, client iamiface.IAMAPI, arn string) (string, error) {
+	resource := arn[strings.LastIndex(arn, ":")+1:]
+	name := resource[strings.LastIndex(resource, "/")+1:]
+
+	var tags []*iam.Tag
+	switch {
+	case strings.HasPrefix(resource, "user/"):
+		out, err := client.GetUserWithContext(ctx, &iam.GetUserInput{UserName: aws.String(name)})
+		if err != nil {
+			return "", err
+		}
+		name, tags = aws.StringValue(out.User.UserName), out.User.Tags
+	case strings.HasPrefix(resource, "role/"):
+		out, err := client.GetRoleWithContext(ctx, &iam.GetRoleInput{RoleName: aws.String(name)})
+		if err != nil {
+			return "", err
+		}
+		name, tags = aws.StringValue(out.Role.RoleName), out.Role.Tags
+	default:
+		return "", nil
+	}
+
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) == principalNameTag && aws.StringValue(tag.Value) != "" {
+			return aws.StringValue(tag.Value), nil
+		}
+	}
+	return name, nil
+}
+
+// principalDisplayName returns the name an event is attributed to, resolved
+// through IAM when RESOLVE_PRINCIPAL_NAMES is set.
+func principalDisplayName(ctx context.Context, userIdentity UserIdentity) string {
+	if !getEnvBool("RESOLVE_PRINCIPAL_NAMES", false) {
+		return resolveUserName(userIdentity)
+	}
+	return principalNames.Resolve(ctx, principalIAM, userIdentity)
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+)
+
+// fakeIAM knows the users and roles by name, keyed on their tags.
+type fakeIAM struct {
+	iamiface.IAMAPI
+	users map[string][]*iam.Tag
+	roles map[string][]*iam.Tag
+
+	mu    sync.Mutex
+	calls []string
+}
+
+func (f *fakeIAM) GetUserWithContext(ctx aws.Context, in *iam.GetUserInput, opts ...request.Option) (*iam.GetUserOutput, error) {
+	f.mu.Lock()
+	f.calls = append(f.calls, "GetUser "+aws.StringValue(in.UserName))
+	f.mu.Unlock()
+	tags, ok := f.users[aws.StringValue(in.UserName)]
+	if !ok {
+		return nil, awserr.New(iam.ErrCodeNoSuchEntityException, "The user cannot be found.", nil)
+	}
+	return &iam.GetUserOutput{User: &iam.User{UserName: in.UserName, Tags: tags}}, nil
+}
+
+func (f *fakeIAM) GetRoleWithContext(ctx aws.Context, in *iam.GetRoleInput, opts ...request.Option) (*iam.GetRoleOutput, error) {
+	f.mu.Lock()
+	f.calls = append(f.calls, "GetRole "+aws.StringValue(in.RoleName))
+	f.mu.Unlock()
+	tags, ok := f.roles[aws.StringValue(in.RoleName)]
+	if !ok {
+		return nil, awserr.New(iam.ErrCodeNoSuchEntityException, "The role cannot be found.", nil)
+	}
+	return &iam.GetRoleOutput{Role: &iam.Role{RoleName: in.RoleName, Tags: tags}}, nil
+}
+
+func nameTag(name string) []*iam.Tag {
+	return []*iam.Tag{{Key: aws.String("Team"), Value: aws.String("platform")}, {Key: aws.String("Name"), Value: aws.String(name)}}
+}
+
+// withFakeIAM serves every IAM client from fake and records the roles the
+// clients were built for.
+func withFakeIAM(t *testing.T, fake *fakeIAM) *[]string {
+	var roleArns []string
+	newIAMClient = func(roleArn string) iamiface.IAMAPI {
+		roleArns = append(roleArns, roleArn)
+		return fake
+	}
+	iamClients = map[string]iamiface.IAMAPI{}
+	principalNames = NewPrincipalNameCache(principalNameCacheSize)
+	t.Cleanup(func() {
+		newIAMClient = defaultIAMClient
+		iamClients = map[string]iamiface.IAMAPI{}
+		principalNames = NewPrincipalNameCache(principalNameCacheSize)
+	})
+	return &roleArns
+}
+
+// functionContext runs as a function in account 012345678901.
+func functionContext() context.Context {
+	return lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{
+		InvokedFunctionArn: "arn:aws:lambda:us-east-1:012345678901:function:cloudtrail-console-actions",
+	})
+}
+
+func TestPrincipalNameCacheResolve(t *testing.T) {
+	fake := &fakeIAM{
+		users: map[string][]*iam.Tag{"first.last": nameTag("First Last"), "ci": nil},
+		roles: map[string][]*iam.Tag{"Admin": nameTag("Platform Admin")},
+	}
+	user := UserIdentity{Type: "IAMUser", PrincipalID: "AIDAEXAMPLE", ARN: "arn:aws:iam::012345678901:user/first.last", UserName: "first.last"}
+	untagged := UserIdentity{Type: "IAMUser", PrincipalID: "AIDACI", ARN: "arn:aws:iam::012345678901:user/automation/ci", UserName: "ci"}
+	deleted := UserIdentity{Type: "IAMUser", PrincipalID: "AIDADELETED", ARN: "arn:aws:iam::012345678901:user/gone"}
+	role := UserIdentity{
+		Type:        "AssumedRole",
+		PrincipalID: "AROAEXAMPLE:first.last",
+		ARN:         "arn:aws:sts::012345678901:assumed-role/Admin/first.last",
+		SessionContext: map[string]interface{}{
+			"sessionIssuer": map[string]interface{}{"type": "Role", "arn": "arn:aws:iam::012345678901:role/Admin", "userName": "Admin"},
+		},
+	}
+	root := UserIdentity{Type: "Root", PrincipalID: "012345678901", ARN: "arn:aws:iam::012345678901:root"}
+
+	cache := NewPrincipalNameCache(principalNameCacheSize)
+	ctx := context.Background()
+	client := func(ctx context.Context, accountID string) iamiface.IAMAPI { return fake }
+	for i := 0; i < 3; i++ {
+		for identity, want := range map[*UserIdentity]string{
+			&user:     "First Last",
+			&untagged: "ci",
+			&deleted:  "AIDADELETED",
+			&role:     "Platform Admin (first.last)",
+			&root:     "root",
+		} {
+			if got := cache.Resolve(ctx, client, *identity); got != want {
+				t.Errorf("Resolve(%s) = %q, want %q", identity.ARN, got, want)
+			}
+		}
+	}
+
+	// Each principal is looked up once, the failed one included.
+	if len(fake.calls) != 4 {
+		t.Errorf("expected 4 IAM calls, got %v", fake.calls)
+	}
+}
+
+func TestPrincipalNameCacheResolveOtherAccount(t *testing.T) {
+	fake := &fakeIAM{users: map[string][]*iam.Tag{"first.last": nameTag("First Last")}}
+	user := UserIdentity{Type: "IAMUser", PrincipalID: "AIDAEXAMPLE", ARN: "arn:aws:iam::210987654321:user/first.last", UserName: "first.last"}
+
+	cache := NewPrincipalNameCache(principalNameCacheSize)
+	client := func(ctx context.Context, accountID string) iamiface.IAMAPI { return nil }
+	if got := cache.Resolve(context.Background(), client, user); got != "first.last" {
+		t.Errorf("Resolve() = %q, want the name from the record", got)
+	}
+	if len(fake.calls) != 0 || cache.Len() != 0 {
+		t.Errorf("expected no lookup, got %v", fake.calls)
+	}
+}
+
+func TestPrincipalNameCacheExpiring(t *testing.T) {
+	cache := NewPrincipalNameCache(principalNameCacheSize)
+	cache.AddExpiring("arn:1", "", time.Hour)
+	cache.AddExpiring("arn:2", "", -time.Second)
+
+	if _, ok := cache.Get("arn:1"); !ok {
+		t.Error("expected arn:1 to be cached until it expires")
+	}
+	if _, ok := cache.Get("arn:2"); ok {
+		t.Error("expected the expired arn:2 to be looked up again")
+	}
+	if cache.Len() != 1 {
+		t.Errorf("Len() = %d", cache.Len())
+	}
+}
+
+func TestPrincipalIAM(t *testing.T) {
+	ctx := functionContext()
+	tests := []struct {
+		name     string
+		env      map[string]string
+		account  string
+		wantRole string
+		found    bool
+	}{
+		{name: "function account", account: "012345678901", found: true},
+		{name: "other account", account: "210987654321"},
+		{name: "unknown account"},
+		{name: "account role", env: map[string]string{"S3_ROLE_ARN": "arn:aws:iam::{accountId}:role/TrailReader"}, account: "210987654321", wantRole: "arn:aws:iam::210987654321:role/TrailReader", found: true},
+		{name: "account specific role", env: map[string]string{"S3_ROLE_ARN_210987654321": "arn:aws:iam::210987654321:role/Reader"}, account: "210987654321", wantRole: "arn:aws:iam::210987654321:role/Reader", found: true},
+		{name: "role of the trail bucket", env: map[string]string{"S3_ROLE_ARN": "arn:aws:iam::111122223333:role/TrailReader"}, account: "210987654321"},
+		{name: "role of the trail bucket for the function account", env: map[string]string{"S3_ROLE_ARN": "arn:aws:iam::111122223333:role/TrailReader"}, account: "012345678901", found: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roleArns := withFakeIAM(t, &fakeIAM{})
+			for k, v := range tt.env {
+				setEnv(t, k, v)
+			}
+
+			client := principalIAM(ctx, tt.account)
+			if found := client != nil; found != tt.found {
+				t.Fatalf("found a client = %v, want %v", found, tt.found)
+			}
+			if tt.found && (len(*roleArns) != 1 || (*roleArns)[0] != tt.wantRole) {
+				t.Errorf("expected a client for role %q, got %q", tt.wantRole, *roleArns)
+			}
+		})
+	}
+}
+
+func TestPrincipalNameCacheEviction(t *testing.T) {
+	cache := NewPrincipalNameCache(2)
+	cache.Add("arn:1", "one")
+	cache.Add("arn:2", "two")
+	if _, ok := cache.Get("arn:1"); !ok {
+		t.Fatal("expected arn:1 to be cached")
+	}
+	cache.Add("arn:3", "three")
+
+	if _, ok := cache.Get("arn:2"); ok {
+		t.Error("expected the least recently used arn:2 to be evicted")
+	}
+	for arn, want := range map[string]string{"arn:1": "one", "arn:3": "three"} {
+		if got, ok := cache.Get(arn); !ok || got != want {
+			t.Errorf("Get(%s) = %q, %v", arn, got, ok)
+		}
+	}
+	if cache.Len() != 2 {
+		t.Errorf("Len() = %d", cache.Len())
+	}
+}
+
+func TestFilterRecordsResolvePrincipalNames(t *testing.T) {
+	fake := &fakeIAM{users: map[string][]*iam.Tag{"first.last": nameTag("First Last")}}
+	withFakeIAM(t, fake)
+
+	var records []map[string]interface{}
+	for i := 0; i < 3; i++ {
+		record := consoleRecord("CreateTags", "event-"+strconv.Itoa(i))
+		userIdentity := record["userIdentity"].(map[string]interface{})
+		userIdentity["arn"] = "arn:aws:iam::012345678901:user/first.last"
+		records = append(records, record)
+	}
+
+	for _, resolve := range []string{"", "true"} {
+		slack := captureSlack(t)
+		setEnv(t, "RESOLVE_PRINCIPAL_NAMES", resolve)
+
+		logFile := cloudTrailFile(records...)
+		if _, err := FilterRecords(functionContext(), logFile.Stream(), testS3Record); err != nil {
+			t.Fatal(err)
+		}
+
+		want := "first.last"
+		if resolve != "" {
+			want = "First Last"
+		}
+		bodies := slack.Bodies()
+		if len(bodies) != len(records) {
+			t.Fatalf("expected %d messages, got %d", len(records), len(bodies))
+		}
+		for _, body := range bodies {
+			if !strings.Contains(body, want) {
+				t.Errorf("RESOLVE_PRINCIPAL_NAMES=%q: expected %q in %s", resolve, want, body)
+			}
+		}
+	}
+
+	// The warm cache serves later records and invocations.
+	if len(fake.calls) != 1 {
+		t.Errorf("expected 1 IAM call, got %v", fake.calls)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// ignoredPrincipal reports whether the record was made by one of the
+// IGNORE_PRINCIPALS, a comma separated list of principal ids, ARNs or
+// substrings of them such as the name of an automation role.
+func ignoredPrincipal(record *CloudTrailRecord) bool {
+	for _, principal := range strings.Split(os.Getenv("IGNORE_PRINCIPALS"), ",") {
+		principal = strings.TrimSpace(principal)
+		if principal == "" {
+			continue
+		}
+		if strings.Contains(record.UserIdentity.PrincipalID, principal) || strings.Contains(record.UserIdentity.ARN, principal) {
+			return true
+		}
+	}
+	return false
+}
+
+// ignoredIdentityType reports whether the record's userIdentity type is one
+// of IGNORE_IDENTITY_TYPES, a comma separated list such as
+// "AWSService,AWSAccount".
+func ignoredIdentityType(record *CloudTrailRecord) bool {
+	for _, identityType := range strings.Split(os.Getenv("IGNORE_IDENTITY_TYPES"), ",") {
+		if identityType = strings.TrimSpace(identityType); identityType != "" && strings.EqualFold(identityType, record.UserIdentity.Type) {
+			return true
+		}
+	}
+	return false
+}
+
+// rootAlert reports whether ALERT_ON_ROOT is set and the record was made by
+// the root user, which then alerts like an event of ALWAYS_ALERT_EVENTS.
+func rootAlert(record *CloudTrailRecord) bool {
+	return record.UserIdentity.Type == "Root" && getEnvBool("ALERT_ON_ROOT", false)
+}
+
+// resolveUserName returns the name an event is attributed to, in order of
+// precedence:
+//
+//   - the role of a service-linked role session, whose session names are
+//     opaque
+//   - the session name of an assumed role
+//   - the userName, e.g. of an IAM user
+//   - the principalId, after the colon of a role session
+//
+// Root events are attributed to "root".
+func resolveUserName(userIdentity UserIdentity) string {
+	if userIdentity.Type == "Root" {
+		return "root"
+	}
+
+	issuer, _ := userIdentity.SessionContext["sessionIssuer"].(map[string]interface{})
+	if strings.Contains(stringField(issuer, "arn"), ":role/aws-service-role/") {
+		if name := stringField(issuer, "userName"); name != "" {
+			return name
+		}
+	}
+
+	// arn:aws:sts::<account>:assumed-role/<role>/<session>
+	if parts := strings.SplitN(userIdentity.ARN, ":assumed-role/", 2); len(parts) == 2 {
+		if role := strings.SplitN(parts[1], "/", 2); len(role) == 2 && role[1] != "" {
+			return role[1]
+		}
+	}
+
+	if userIdentity.UserName != "" {
+		return userIdentity.UserName
+	}
+
+	principalID := userIdentity.PrincipalID
+	if i := strings.Index(principalID, ":"); i >= 0 && i < len(principalID)-1 {
+		return principalID[i+1:]
+	}
+	return principalID
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestFilterRecordsIgnorePrincipals(t *testing.T) {
+	slack := captureSlack(t)
+	setEnv(t, "IGNORE_PRINCIPALS", "role/terraform, AROAEXAMPLEBACKUP:")
+
+	terraform := consoleRecord("CreateTags", "terraform")
+	terraform["userIdentity"] = map[string]interface{}{
+		"type":        "AssumedRole",
+		"principalId": "AROAEXAMPLETERRAFORM:ci",
+		"arn":         "arn:aws:sts::012345678901:assumed-role/terraform/ci",
+		"accountId":   "012345678901",
+	}
+	backup := consoleRecord("CreateTags", "backup")
+	backup["userIdentity"] = map[string]interface{}{
+		"type":        "AssumedRole",
+		"principalId": "AROAEXAMPLEBACKUP:nightly",
+		"arn":         "arn:aws:sts::012345678901:assumed-role/backup/nightly",
+		"accountId":   "012345678901",
+	}
+	interactive := consoleRecord("CreateTags", "interactive")
+
+	if _, err := FilterRecords(context.Background(), cloudTrailFile(terraform, backup, interactive).Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := slack.Bodies()
+	if len(bodies) != 1 || slackBodyFor(bodies, "interactive") == "" {
+		t.Errorf("expected only the interactive user to alert, got %v", bodies)
+	}
+}
+
+func TestResolveUserName(t *testing.T) {
+	tests := []struct {
+		name         string
+		userIdentity map[string]interface{}
+		want         string
+	}{
+		{
+			name: "IAM user",
+			userIdentity: map[string]interface{}{
+				"type":        "IAMUser",
+				"principalId": "AIDAJU2GYCKZ322Y5JOKC",
+				"arn":         "arn:aws:iam::012345678901:user/first.last",
+				"userName":    "first.last",
+			},
+			want: "first.last",
+		},
+		{
+			name: "assumed role",
+			userIdentity: map[string]interface{}{
+				"type":        "AssumedRole",
+				"principalId": "AROAQTKSM5RSQEXAMPLE:first.last@example.com",
+				"arn":         "arn:aws:sts::012345678901:assumed-role/Admin/first.last@example.com",
+				"sessionContext": map[string]interface{}{
+					"sessionIssuer": map[string]interface{}{
+						"type":     "Role",
+						"arn":      "arn:aws:iam::012345678901:role/Admin",
+						"userName": "Admin",
+					},
+				},
+			},
+			want: "first.last@example.com",
+		},
+		{
+			name: "service-linked role",
+			userIdentity: map[string]interface{}{
+				"type":        "AssumedRole",
+				"principalId": "AROAQTKSM5RSQSLR:1621018999123456789",
+				"arn":         "arn:aws:sts::012345678901:assumed-role/AWSServiceRoleForAutoScaling/1621018999123456789",
+				"sessionContext": map[string]interface{}{
+					"sessionIssuer": map[string]interface{}{
+						"type":     "Role",
+						"arn":      "arn:aws:iam::012345678901:role/aws-service-role/autoscaling.amazonaws.com/AWSServiceRoleForAutoScaling",
+						"userName": "AWSServiceRoleForAutoScaling",
+					},
+				},
+			},
+			want: "AWSServiceRoleForAutoScaling",
+		},
+		{
+			name: "root",
+			userIdentity: map[string]interface{}{
+				"type":        "Root",
+				"principalId": "012345678901",
+				"arn":         "arn:aws:iam::012345678901:root",
+			},
+			want: "root",
+		},
+		{
+			name: "principal id only",
+			userIdentity: map[string]interface{}{
+				"type":        "AssumedRole",
+				"principalId": "AROAQTKSM5RSQEXAMPLE:session",
+			},
+			want: "session",
+		},
+		{
+			name: "trailing colon",
+			userIdentity: map[string]interface{}{
+				"type":        "AssumedRole",
+				"principalId": "AROAQTKSM5RSQEXAMPLE:",
+			},
+			want: "AROAQTKSM5RSQEXAMPLE:",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := typedRecord(map[string]interface{}{"userIdentity": tt.userIdentity})
+			if got := resolveUserName(record.UserIdentity); got != tt.want {
+				t.Errorf("resolveUserName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterRecordsIdentityTypes(t *testing.T) {
+	rootLogin := consoleRecord("ConsoleLogin", "root-login")
+	rootLogin["eventSource"] = "signin.amazonaws.com"
+	rootLogin["userIdentity"] = map[string]interface{}{
+		"type":        "Root",
+		"principalId": "012345678901",
+		"arn":         "arn:aws:iam::012345678901:root",
+		"accountId":   "012345678901",
+	}
+	rootLogin["responseElements"] = map[string]interface{}{"ConsoleLogin": "Success"}
+	rootLogin["additionalEventData"] = map[string]interface{}{"MFAUsed": "Yes"}
+
+	rootRead := consoleRecord("DescribeInstances", "root-read")
+	rootRead["readOnly"] = true
+	rootRead["userIdentity"] = rootLogin["userIdentity"]
+
+	service := consoleRecord("CreateTags", "service")
+	service["userIdentity"] = map[string]interface{}{
+		"type":      "AWSService",
+		"invokedBy": "autoscaling.amazonaws.com",
+	}
+
+	user := consoleRecord("CreateTags", "user")
+
+	tests := []struct {
+		name     string
+		env      map[string]string
+		record   map[string]interface{}
+		messages int
+	}{
+		{"root console login", nil, rootLogin, 0},
+		{"root console login with ALERT_ON_ROOT", map[string]string{"ALERT_ON_ROOT": "true"}, rootLogin, 1},
+		{"root console login ignored with ALERT_ON_ROOT", map[string]string{"ALERT_ON_ROOT": "true", "IGNORE_IDENTITY_TYPES": "Root"}, rootLogin, 1},
+		{"root read-only event", nil, rootRead, 0},
+		{"root read-only event with ALERT_ON_ROOT", map[string]string{"ALERT_ON_ROOT": "true"}, rootRead, 1},
+		{"AWSService", nil, service, 1},
+		{"AWSService ignored", map[string]string{"IGNORE_IDENTITY_TYPES": "AWSAccount, AWSService"}, service, 0},
+		{"IAMUser", map[string]string{"IGNORE_IDENTITY_TYPES": "AWSService"}, user, 1},
+		{"IAMUser with ALERT_ON_ROOT", map[string]string{"ALERT_ON_ROOT": "true"}, user, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slack := captureSlack(t)
+			for key, value := range tt.env {
+				setEnv(t, key, value)
+			}
+
+			if _, err := FilterRecords(context.Background(), cloudTrailFile(tt.record).Stream(), testS3Record); err != nil {
+				t.Fatal(err)
+			}
+			if n := len(slack.Bodies()); n != tt.messages {
+				t.Errorf("expected %d messages, got %d", tt.messages, n)
+			}
+		})
+	}
+}
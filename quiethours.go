@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// quietHours is the daily window, from start up to but excluding end, during
+// which only critical and ALWAYS_ALERT_EVENTS events are notified. A window
+// whose start is after its end spans midnight.
+type quietHours struct {
+	start, end int
+	location   *time.Location
+}
+
+// newQuietHours parses the values of QUIET_HOURS_START, QUIET_HOURS_END and
+// QUIET_HOURS_TZ. It returns nil when neither hour is set.
+func newQuietHours(startHour, endHour, tz string) (*quietHours, error) {
+	if startHour == "" && endHour == "" {
+		return nil, nil
+	}
+
+	start, err := quietHour(startHour)
+	if err != nil {
+		return nil, fmt.Errorf("QUIET_HOURS_START: %w", err)
+	}
+	end, err := quietHour(endHour)
+	if err != nil {
+		return nil, fmt.Errorf("QUIET_HOURS_END: %w", err)
+	}
+
+	location := time.UTC
+	if tz != "" {
+		if location, err = time.LoadLocation(tz); err != nil {
+			return nil, fmt.Errorf("QUIET_HOURS_TZ: %w", err)
+		}
+	}
+	return &quietHours{start: start, end: end, location: location}, nil
+}
+
+func quietHour(v string) (int, error) {
+	hour, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, err
+	}
+	if hour < 0 || hour > 23 {
+		return 0, fmt.Errorf("hour %d out of range", hour)
+	}
+	return hour, nil
+}
+
+// Contains reports whether an RFC 3339 event time falls into the window.
+// Unparseable times are never quiet.
+func (q *quietHours) Contains(eventTime string) bool {
+	if q == nil {
+		return false
+	}
+	t, err := time.Parse(time.RFC3339, eventTime)
+	if err != nil {
+		return false
+	}
+
+	hour := t.In(q.location).Hour()
+	if q.start <= q.end {
+		return hour >= q.start && hour < q.end
+	}
+	return hour >= q.start || hour < q.end
+}
+
+var (
+	quietHoursMu     sync.Mutex
+	quietHoursEnv    string
+	quietHoursWindow *quietHours
+)
+
+// activeQuietHours returns the configured quiet hours, loading them again
+// only when the configuration changes.
+func activeQuietHours() *quietHours {
+	start, end, tz := os.Getenv("QUIET_HOURS_START"), os.Getenv("QUIET_HOURS_END"), os.Getenv("QUIET_HOURS_TZ")
+	env := start + "\x00" + end + "\x00" + tz
+
+	quietHoursMu.Lock()
+	defer quietHoursMu.Unlock()
+	if quietHoursEnv != env {
+		window, err := newQuietHours(start, end, tz)
+		if err != nil {
+			log.Warnf("Ignoring invalid quiet hours: %v", err)
+		}
+		quietHoursWindow, quietHoursEnv = window, env
+	}
+	return quietHoursWindow
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestQuietHoursContains(t *testing.T) {
+	tests := []struct {
+		name       string
+		start, end string
+		tz         string
+		eventTime  string
+		want       bool
+	}{
+		{name: "inside", start: "9", end: "17", eventTime: "2021-05-14T12:00:00Z", want: true},
+		{name: "at end", start: "9", end: "17", eventTime: "2021-05-14T17:00:00Z"},
+		{name: "outside", start: "9", end: "17", eventTime: "2021-05-14T08:59:59Z"},
+		{name: "overnight late", start: "22", end: "7", eventTime: "2021-05-14T23:30:00Z", want: true},
+		{name: "overnight early", start: "22", end: "7", eventTime: "2021-05-14T06:59:00Z", want: true},
+		{name: "overnight day", start: "22", end: "7", eventTime: "2021-05-14T12:00:00Z"},
+		{name: "time zone", start: "22", end: "7", tz: "America/New_York", eventTime: "2021-05-14T03:00:00Z", want: true},
+		{name: "time zone day", start: "22", end: "7", tz: "America/New_York", eventTime: "2021-05-14T12:00:00Z"},
+		{name: "unparseable time", start: "0", end: "23", eventTime: "yesterday"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := newQuietHours(tt.start, tt.end, tt.tz)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := q.Contains(tt.eventTime); got != tt.want {
+				t.Errorf("Contains(%s) = %v, want %v", tt.eventTime, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewQuietHoursInvalid(t *testing.T) {
+	for _, v := range [][3]string{
+		{"22", "", ""},
+		{"24", "7", ""},
+		{"night", "7", ""},
+		{"22", "7", "Mars/Olympus_Mons"},
+	} {
+		if _, err := newQuietHours(v[0], v[1], v[2]); err == nil {
+			t.Errorf("expected an error for %v", v)
+		}
+	}
+	if q, err := newQuietHours("", "", "UTC"); q != nil || err != nil {
+		t.Errorf("expected no quiet hours, got %v, %v", q, err)
+	}
+}
+
+func TestFilterRecordsQuietHours(t *testing.T) {
+	slack := captureSlack(t)
+	setEnv(t, "QUIET_HOURS_START", "22")
+	setEnv(t, "QUIET_HOURS_END", "7")
+	setEnv(t, "ALWAYS_ALERT_EVENTS", "GetFederationToken")
+
+	night := consoleRecord("CreateTags", "night")
+	night["eventTime"] = "2021-05-14T23:00:00Z"
+	day := consoleRecord("CreateTags", "day")
+	day["eventTime"] = "2021-05-14T12:00:00Z"
+	critical := consoleRecord("PutBucketPolicy", "critical")
+	critical["eventSource"] = "s3.amazonaws.com"
+	critical["requestParameters"] = map[string]interface{}{
+		"bucketName": "b",
+		"bucketPolicy": map[string]interface{}{
+			"Statement": []interface{}{
+				map[string]interface{}{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject"},
+			},
+		},
+	}
+	critical["eventTime"] = "2021-05-14T23:00:00Z"
+	always := consoleRecord("GetFederationToken", "always")
+	always["eventSource"] = "sts.amazonaws.com"
+	always["eventTime"] = "2021-05-14T23:00:00Z"
+
+	if _, err := FilterRecords(context.Background(), cloudTrailFile(night, day, critical, always).Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := slack.Bodies()
+	if len(bodies) != 3 || slackBodyFor(bodies, "night") != "" {
+		t.Errorf("expected all but the routine night event to alert, got %d messages", len(bodies))
+	}
+	for _, id := range []string{"day", "critical", "always"} {
+		if slackBodyFor(bodies, id) == "" {
+			t.Errorf("expected %s to alert", id)
+		}
+	}
+}
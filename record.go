@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// CloudTrailRecord is a single CloudTrail event. Only the fields the filter
+// relies on are typed, the complete record is kept in Raw.
+type CloudTrailRecord struct {
+	EventVersion        string
+	EventTime           string
+	EventSource         string
+	EventName           string
+	EventType           string
+	EventCategory       string // Management, Data or Insight
+	EventID             string
+	AwsRegion           string
+	SourceIPAddress     string
+	UserAgent           string
+	RecipientAccountID  string
+	ReadOnly            *bool // nil when the record has no readOnly field
+	ErrorCode           string
+	ErrorMessage        string
+	UserIdentity        UserIdentity
+	RequestParameters   map[string]interface{}
+	AdditionalEventData map[string]interface{}
+	Resources           []Resource
+	InsightDetails      map[string]interface{}
+
+	Raw map[string]interface{}
+}
+
+// Resource is an element of the resources list of a CloudTrail record.
+type Resource struct {
+	ARN       string
+	Type      string
+	AccountID string
+}
+
+// UserIdentity is the userIdentity element of a CloudTrail record.
+type UserIdentity struct {
+	Type           string
+	PrincipalID    string
+	ARN            string
+	AccountID      string
+	UserName       string
+	InvokedBy      string
+	SessionContext map[string]interface{}
+}
+
+// UnmarshalJSON decodes a record without failing on unknown fields or on
+// fields of an unexpected type, which are left empty.
+func (r *CloudTrailRecord) UnmarshalJSON(data []byte) error {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*r = newCloudTrailRecord(raw)
+	return nil
+}
+
+// MarshalJSON returns the record as it was decoded.
+func (r CloudTrailRecord) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.Raw)
+}
+
+func newCloudTrailRecord(raw map[string]interface{}) CloudTrailRecord {
+	userIdentity, _ := raw["userIdentity"].(map[string]interface{})
+	sessionContext, _ := userIdentity["sessionContext"].(map[string]interface{})
+	requestParameters, _ := raw["requestParameters"].(map[string]interface{})
+	additionalEventData, _ := raw["additionalEventData"].(map[string]interface{})
+	insightDetails, _ := raw["insightDetails"].(map[string]interface{})
+
+	var resources []Resource
+	for _, r := range objectOrList(raw["resources"]) {
+		resources = append(resources, Resource{
+			ARN:       stringField(r, "ARN"),
+			Type:      stringField(r, "type"),
+			AccountID: stringField(r, "accountId"),
+		})
+	}
+
+	// Insights events name the source and name of the API calls they are
+	// about in insightDetails only.
+	eventSource := stringField(raw, "eventSource")
+	if _, ok := raw["eventSource"]; !ok {
+		eventSource = stringField(insightDetails, "eventSource")
+	}
+	eventName := stringField(raw, "eventName")
+	if _, ok := raw["eventName"]; !ok {
+		eventName = stringField(insightDetails, "eventName")
+	}
+
+	return CloudTrailRecord{
+		EventVersion:       stringField(raw, "eventVersion"),
+		EventTime:          stringField(raw, "eventTime"),
+		EventSource:        eventSource,
+		EventName:          eventName,
+		EventType:          stringField(raw, "eventType"),
+		EventCategory:      stringField(raw, "eventCategory"),
+		EventID:            stringField(raw, "eventID"),
+		AwsRegion:          stringField(raw, "awsRegion"),
+		SourceIPAddress:    stringField(raw, "sourceIPAddress"),
+		UserAgent:          stringField(raw, "userAgent"),
+		RecipientAccountID: stringField(raw, "recipientAccountId"),
+		ReadOnly:           boolField(raw, "readOnly"),
+		ErrorCode:          stringField(raw, "errorCode"),
+		ErrorMessage:       stringField(raw, "errorMessage"),
+		UserIdentity: UserIdentity{
+			Type:           stringField(userIdentity, "type"),
+			PrincipalID:    stringField(userIdentity, "principalId"),
+			ARN:            stringField(userIdentity, "arn"),
+			AccountID:      stringField(userIdentity, "accountId"),
+			UserName:       stringField(userIdentity, "userName"),
+			InvokedBy:      stringField(userIdentity, "invokedBy"),
+			SessionContext: sessionContext,
+		},
+		RequestParameters:   requestParameters,
+		AdditionalEventData: additionalEventData,
+		Resources:           resources,
+		InsightDetails:      insightDetails,
+		Raw:                 raw,
+	}
+}
+
+// malformedRecord returns why a record can't be filtered, or an empty string
+// for a well-formed record.
+func malformedRecord(record *CloudTrailRecord) string {
+	if record.EventName == "" {
+		if _, ok := record.Raw["eventName"]; ok {
+			return fmt.Sprintf("eventName is %T, not a string", record.Raw["eventName"])
+		}
+		return "eventName is missing"
+	}
+	if record.EventCategory == eventCategoryInsight {
+		// Insights are raised by CloudTrail rather than made by anyone.
+		return ""
+	}
+	if _, ok := record.Raw["userIdentity"].(map[string]interface{}); !ok {
+		return "userIdentity is missing"
+	}
+	return ""
+}
+
+func stringField(m map[string]interface{}, key string) string {
+	s, _ := m[key].(string)
+	return s
+}
+
+// boolField returns a boolean or "true"/"false" string field, or nil when the
+// field is missing or of any other type.
+func boolField(m map[string]interface{}, key string) *bool {
+	var b bool
+	switch v := m[key].(type) {
+	case bool:
+		b = v
+	case string:
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil
+		}
+		b = parsed
+	default:
+		return nil
+	}
+	return &b
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestCloudTrailRecordUnmarshal(t *testing.T) {
+	content, err := ioutil.ReadFile("examples/PutBucketPolicy.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var logFile CloudTrailFile
+	if err := json.Unmarshal(content, &logFile); err != nil {
+		t.Fatal(err)
+	}
+	if len(logFile.Records) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(logFile.Records))
+	}
+	record := logFile.Records[0]
+
+	tests := []struct {
+		field, got, want string
+	}{
+		{"EventVersion", record.EventVersion, "1.08"},
+		{"EventTime", record.EventTime, "2021-05-14T19:03:40Z"},
+		{"EventSource", record.EventSource, "s3.amazonaws.com"},
+		{"EventName", record.EventName, "PutBucketPolicy"},
+		{"EventType", record.EventType, "AwsApiCall"},
+		{"EventID", record.EventID, "404956a8-8b3a-400e-a180-5b0659d77403"},
+		{"AwsRegion", record.AwsRegion, "us-east-1"},
+		{"SourceIPAddress", record.SourceIPAddress, "1.1.1.1"},
+		{"UserAgent", record.UserAgent, "[S3Console/0.4, aws-internal/3 aws-sdk-java/1.11.1002]"},
+		{"RecipientAccountID", record.RecipientAccountID, "012345678901"},
+		{"UserIdentity.Type", record.UserIdentity.Type, "AssumedRole"},
+		{"UserIdentity.PrincipalID", record.UserIdentity.PrincipalID, "AROAQTKSM5RSQEXAMPLE:first.last@example.com"},
+		{"UserIdentity.ARN", record.UserIdentity.ARN, "arn:aws:sts::012345678901:assumed-role/Admin/first.last@example.com"},
+		{"UserIdentity.AccountID", record.UserIdentity.AccountID, "012345678901"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.field, tt.got, tt.want)
+		}
+	}
+
+	if record.RequestParameters["bucketName"] != "example-bucket" {
+		t.Errorf("RequestParameters = %v", record.RequestParameters)
+	}
+	if record.AdditionalEventData["SignatureVersion"] != "SigV4" {
+		t.Errorf("AdditionalEventData = %v", record.AdditionalEventData)
+	}
+	if record.UserIdentity.SessionContext["sessionIssuer"] == nil {
+		t.Errorf("SessionContext = %v", record.UserIdentity.SessionContext)
+	}
+	if record.Raw["managementEvent"] != true {
+		t.Errorf("Raw is missing untyped fields: %v", record.Raw)
+	}
+}
+
+func TestCloudTrailRecordTolerantDecode(t *testing.T) {
+	var record CloudTrailRecord
+	content := `{"eventName":42,"eventID":"a","userIdentity":"not an object","requestParameters":null,"somethingNew":{"x":1}}`
+	if err := json.Unmarshal([]byte(content), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.EventName != "" || record.EventID != "a" || record.UserIdentity.Type != "" || record.RequestParameters != nil {
+		t.Errorf("unexpected record %+v", record)
+	}
+
+	// Marshalling returns the original record, including unknown fields.
+	out, err := json.Marshal(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var roundTrip map[string]interface{}
+	json.Unmarshal(out, &roundTrip)
+	if roundTrip["eventName"] != float64(42) || roundTrip["somethingNew"] == nil {
+		t.Errorf("marshalled record lost fields: %s", out)
+	}
+}
+
+func TestFilterRecordsSkipsMalformedRecords(t *testing.T) {
+	slack := captureSlack(t)
+	hook := logtest.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+
+	noIdentity := consoleRecord("CreateTags", "no-identity")
+	delete(noIdentity, "userIdentity")
+	numericName := consoleRecord("CreateTags", "numeric-name")
+	numericName["eventName"] = 42.0
+	nilParameters := consoleRecord("PutBucketAcl", "nil-parameters")
+	nilParameters["eventSource"] = "s3.amazonaws.com"
+	nilParameters["requestParameters"] = nil
+
+	logFile := cloudTrailFile(noIdentity, numericName, nilParameters, consoleRecord("CreateTags", "valid"))
+	if _, err := FilterRecords(context.Background(), logFile.Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := slack.Bodies()
+	if len(bodies) != 2 || slackBodyFor(bodies, "nil-parameters") == "" || slackBodyFor(bodies, "valid") == "" {
+		t.Errorf("expected only the well-formed records to alert, got %d messages", len(bodies))
+	}
+
+	var skipped []string
+	for _, entry := range hook.AllEntries() {
+		if entry.Level != logrus.WarnLevel {
+			continue
+		}
+		if entry.Data["s3_uri"] != "s3://test-harness/"+testS3Record.S3.Object.Key {
+			t.Errorf("warning without s3_uri: %v", entry.Data)
+		}
+		skipped = append(skipped, fmt.Sprintf("%v:%s", entry.Data["record_index"], entry.Message))
+	}
+	want := []string{
+		"0:Skipping malformed record: userIdentity is missing",
+		"1:Skipping malformed record: eventName is float64, not a string",
+	}
+	if strings.Join(skipped, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected warnings %q", skipped)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var errRecordLimit = errors.New("record limit reached")
+
+// maxRecordsPerFile returns MAX_RECORDS_PER_FILE, zero when there is no
+// limit, and whether MAX_RECORDS_PER_FILE_ACTION asks for files over it to be
+// skipped rather than truncated.
+func maxRecordsPerFile() (int, bool) {
+	v := os.Getenv("MAX_RECORDS_PER_FILE")
+	if v == "" {
+		return 0, false
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit <= 0 {
+		log.Warnf("Ignoring invalid MAX_RECORDS_PER_FILE %q", v)
+		return 0, false
+	}
+
+	switch action := strings.ToLower(os.Getenv("MAX_RECORDS_PER_FILE_ACTION")); action {
+	case "", "truncate":
+		return limit, false
+	case "skip":
+		return limit, true
+	default:
+		log.Warnf("Ignoring invalid MAX_RECORDS_PER_FILE_ACTION %q, truncating", action)
+		return limit, false
+	}
+}
+
+// limitRecords stops records of the log file s3Object after limit records.
+// Truncated, it passes the first limit records on. Skipped, it holds up to
+// limit records back and passes none on when there are more.
+func limitRecords(records RecordStream, s3Object string, limit int, skip bool) RecordStream {
+	if skip {
+		return func(fn func(record *CloudTrailRecord) error) error {
+			var held []*CloudTrailRecord
+			err := records(func(record *CloudTrailRecord) error {
+				if len(held) == limit {
+					return errRecordLimit
+				}
+				held = append(held, record)
+				return nil
+			})
+			if errors.Is(err, errRecordLimit) {
+				log.Warnf("Skipping %s, it has more than %d records", s3Object, limit)
+				return fmt.Errorf("%w: more than %d records", ErrSkippedObject, limit)
+			} else if err != nil {
+				return err
+			}
+			for _, record := range held {
+				if err := fn(record); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	return func(fn func(record *CloudTrailRecord) error) error {
+		n := 0
+		err := records(func(record *CloudTrailRecord) error {
+			if n == limit {
+				return errRecordLimit
+			}
+			n++
+			return fn(record)
+		})
+		if errors.Is(err, errRecordLimit) {
+			log.Warnf("%s has more than %d records, only the first %d are processed", s3Object, limit, limit)
+			return nil
+		}
+		return err
+	}
+}
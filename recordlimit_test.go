@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestStreamMaxRecordsPerFile(t *testing.T) {
+	var records []map[string]interface{}
+	for i := 1; i <= 5; i++ {
+		records = append(records, consoleRecord("CreateTags", fmt.Sprintf("event-%d", i)))
+	}
+	content, _ := json.Marshal(cloudTrailFile(records...))
+
+	tests := []struct {
+		name     string
+		limit    string
+		action   string
+		messages int
+		skipped  bool
+	}{
+		{"no limit", "", "", 5, false},
+		{"under the limit", "10", "", 5, false},
+		{"at the limit", "5", "skip", 5, false},
+		{"over the limit", "3", "", 3, false},
+		{"over the limit truncated", "3", "truncate", 3, false},
+		{"over the limit skipped", "3", "skip", 0, true},
+		{"invalid limit", "many", "", 5, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slack := captureSlack(t)
+			setEnv(t, "MAX_RECORDS_PER_FILE", tt.limit)
+			setEnv(t, "MAX_RECORDS_PER_FILE_ACTION", tt.action)
+			withS3Getter(t, &fakeS3Getter{objects: map[string][]byte{"test-harness/" + testS3Record.S3.Object.Key: gzipBytes(t, content)}})
+
+			err := Stream(context.Background(), testS3Record)
+			if tt.skipped != errors.Is(err, ErrSkippedObject) || (!tt.skipped && err != nil) {
+				t.Fatalf("Stream() = %v, skipped %v", err, tt.skipped)
+			}
+
+			bodies := slack.Bodies()
+			if len(bodies) != tt.messages {
+				t.Fatalf("expected %d messages, got %d", tt.messages, len(bodies))
+			}
+			for i := 1; i <= tt.messages; i++ {
+				if slackBodyFor(bodies, fmt.Sprintf("event-%d", i)) == "" {
+					t.Errorf("expected the first %d records to alert, event-%d didn't", tt.messages, i)
+				}
+			}
+		})
+	}
+}
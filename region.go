@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// regionFilter restricts the records alerted on by their awsRegion using the
+// comma separated REGION_ALLOWLIST and REGION_DENYLIST.
+type regionFilter struct {
+	allow map[string]bool
+	deny  map[string]bool
+}
+
+func newRegionFilter() regionFilter {
+	return regionFilter{
+		allow: regionSet(os.Getenv("REGION_ALLOWLIST")),
+		deny:  regionSet(os.Getenv("REGION_DENYLIST")),
+	}
+}
+
+// Allowed reports whether records from region should be filtered. A region
+// on the denylist is dropped even when it is also allowlisted.
+func (f regionFilter) Allowed(region string) bool {
+	if f.deny[region] {
+		return false
+	}
+	return len(f.allow) == 0 || f.allow[region]
+}
+
+func regionSet(list string) map[string]bool {
+	regions := map[string]bool{}
+	for _, region := range strings.Split(list, ",") {
+		if region = strings.TrimSpace(region); region != "" {
+			regions[region] = true
+		}
+	}
+	return regions
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRegionFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		allow   string
+		deny    string
+		allowed []string
+		dropped []string
+	}{
+		{name: "unset", allowed: []string{"us-east-1", "ap-south-1", ""}},
+		{name: "allowlist", allow: "us-east-1, eu-west-1", allowed: []string{"us-east-1", "eu-west-1"}, dropped: []string{"ap-south-1", ""}},
+		{name: "denylist", deny: "us-east-1,eu-west-1", allowed: []string{"ap-south-1", ""}, dropped: []string{"us-east-1", "eu-west-1"}},
+		{name: "denylist wins", allow: "us-east-1,eu-west-1", deny: "eu-west-1", allowed: []string{"us-east-1"}, dropped: []string{"eu-west-1", "ap-south-1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEnv(t, "REGION_ALLOWLIST", tt.allow)
+			setEnv(t, "REGION_DENYLIST", tt.deny)
+
+			f := newRegionFilter()
+			for _, region := range tt.allowed {
+				if !f.Allowed(region) {
+					t.Errorf("%q should be allowed", region)
+				}
+			}
+			for _, region := range tt.dropped {
+				if f.Allowed(region) {
+					t.Errorf("%q should be dropped", region)
+				}
+			}
+		})
+	}
+}
+
+func TestFilterRecordsRegionDenylist(t *testing.T) {
+	slack := captureSlack(t)
+	setEnv(t, "REGION_DENYLIST", "us-east-1")
+
+	approved := consoleRecord("CreateTags", "approved")
+	other := consoleRecord("CreateTags", "other")
+	other["awsRegion"] = "ap-south-1"
+
+	if _, err := FilterRecords(context.Background(), cloudTrailFile(approved, other).Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := slack.Bodies()
+	if len(bodies) != 1 || !strings.Contains(bodies[0], "EventId=other") {
+		t.Errorf("expected only the ap-south-1 event, got %v", bodies)
+	}
+}
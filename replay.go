@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	log "github.com/sirupsen/logrus"
+)
+
+// Replay is the {"replay": {"bucket": "...", "prefix": "..."}} payload
+// processing the log files already in a bucket, e.g. to backfill alerts
+// from an EventBridge Scheduler schedule.
+type Replay struct {
+	Bucket string `json:"bucket"`
+	Prefix string `json:"prefix"`
+	// Region is the bucket's region, by default the function's.
+	Region string `json:"region"`
+	// DryRun logs the alerts instead of sending them and leaves
+	// DEDUPE_TABLE and MATCHED_BUCKET alone.
+	DryRun bool `json:"dry_run"`
+}
+
+// S3Lister is the part of the S3 API a replay enumerates log files with.
+type S3Lister interface {
+	ListObjectsV2PagesWithContext(aws.Context, *s3.ListObjectsV2Input, func(*s3.ListObjectsV2Output, bool) bool, ...request.Option) error
+}
+
+var newS3Lister = defaultS3Lister
+
+func defaultS3Lister(region string) S3Lister {
+	client := s3.New(session.Must(session.NewSession()), s3Config(aws.NewConfig().WithRegion(region)))
+	traceAWSClient(client.Client)
+	return client
+}
+
+// ReplayHandler lists the objects under the replay's prefix and processes
+// them like the objects of an S3 event notification, OBJECT_CONCURRENCY at a
+// time.
+func ReplayHandler(ctx context.Context, replay Replay) (ProcessingResult, error) {
+	if replay.Bucket == "" {
+		return ProcessingResult{}, fmt.Errorf("replay has no bucket")
+	}
+	region := replay.Region
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+
+	var s3Event events.S3Event
+	err := newS3Lister(region).ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(replay.Bucket),
+		Prefix: aws.String(replay.Prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			s3Event.Records = append(s3Event.Records, events.S3EventRecord{
+				AWSRegion: region,
+				S3: events.S3Entity{
+					Bucket: events.S3Bucket{Name: replay.Bucket},
+					Object: events.S3Object{Key: aws.StringValue(object.Key), Size: aws.Int64Value(object.Size)},
+				},
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return ProcessingResult{}, fmt.Errorf("listing s3://%s/%s: %w", replay.Bucket, replay.Prefix, err)
+	}
+
+	log.WithFields(log.Fields{
+		"bucket":  replay.Bucket,
+		"prefix":  replay.Prefix,
+		"objects": len(s3Event.Records),
+		"dry_run": replay.DryRun,
+	}).Info("Replaying log files")
+	if replay.DryRun {
+		ctx = withDryRun(withNotifiers(ctx, dryRunNotifier{}))
+	}
+	return S3Handler(ctx, s3Event)
+}
+
+type dryRunKey struct{}
+
+// withDryRun marks ctx as a dry run, which records nothing outside of the
+// logs.
+func withDryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, true)
+}
+
+func dryRunFrom(ctx context.Context) bool {
+	dryRun, _ := ctx.Value(dryRunKey{}).(bool)
+	return dryRun
+}
+
+// isDryRun reports whether ctx is a dry run or DRY_RUN is set, either of
+// which keeps notifications and their records in DEDUPE_TABLE from being
+// written.
+func isDryRun(ctx context.Context) bool {
+	return dryRunFrom(ctx) || getEnvBool("DRY_RUN", false)
+}
+
+// dryRunNotifier logs alerts instead of sending them.
+type dryRunNotifier struct{}
+
+func (dryRunNotifier) Notify(ctx context.Context, alert AlertEvent) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"sink": "replay",
+		"body": string(body),
+	}).Info("Dry run notification")
+	return errNotNotified
+}
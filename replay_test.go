@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+// fakeS3Lister pages through keys, pageSize at a time.
+type fakeS3Lister struct {
+	keys     []string
+	pageSize int
+	input    *s3.ListObjectsV2Input
+	region   string
+}
+
+func (f *fakeS3Lister) ListObjectsV2PagesWithContext(ctx aws.Context, in *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool, opts ...request.Option) error {
+	f.input = in
+	for start := 0; start < len(f.keys); start += f.pageSize {
+		end := start + f.pageSize
+		if end > len(f.keys) {
+			end = len(f.keys)
+		}
+		page := &s3.ListObjectsV2Output{}
+		for _, key := range f.keys[start:end] {
+			page.Contents = append(page.Contents, &s3.Object{Key: aws.String(key), Size: aws.Int64(100)})
+		}
+		if !fn(page, end == len(f.keys)) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func withS3Lister(t *testing.T, lister *fakeS3Lister) {
+	newS3Lister = func(region string) S3Lister {
+		lister.region = region
+		return lister
+	}
+	t.Cleanup(func() { newS3Lister = defaultS3Lister })
+}
+
+// replayFixture lists five log files, one event each, and a digest.
+func replayFixture(t *testing.T) *fakeS3Lister {
+	prefix := "AWSLogs/012345678901/CloudTrail/us-east-1/2021/05/14/"
+	lister := &fakeS3Lister{pageSize: 2}
+	objects := map[string][]byte{}
+	for i := 1; i <= 5; i++ {
+		key := fmt.Sprintf("%sfile-%d.json.gz", prefix, i)
+		content, _ := json.Marshal(cloudTrailFile(consoleRecord("CreateTags", fmt.Sprintf("event-%d", i))))
+		objects["test-harness/"+key] = content
+		lister.keys = append(lister.keys, key)
+	}
+	lister.keys = append(lister.keys, "AWSLogs/012345678901/CloudTrail-Digest/us-east-1/2021/05/14/digest.json.gz")
+	withS3Lister(t, lister)
+	withS3Getter(t, &fakeS3Getter{objects: objects})
+	return lister
+}
+
+func TestHandlerReplay(t *testing.T) {
+	slack := captureSlack(t)
+	lister := replayFixture(t)
+
+	raw := json.RawMessage(`{"replay": {"bucket": "test-harness", "prefix": "AWSLogs/012345678901/", "region": "eu-west-1"}}`)
+	out, err := Handler(context.Background(), raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if aws.StringValue(lister.input.Bucket) != "test-harness" || aws.StringValue(lister.input.Prefix) != "AWSLogs/012345678901/" || lister.region != "eu-west-1" {
+		t.Errorf("unexpected listing %v in %s", lister.input, lister.region)
+	}
+	bodies := slack.Bodies()
+	if len(bodies) != 5 {
+		t.Fatalf("expected 5 messages, got %d", len(bodies))
+	}
+	for i := 1; i <= 5; i++ {
+		if slackBodyFor(bodies, fmt.Sprintf("event-%d", i)) == "" {
+			t.Errorf("no message for event-%d", i)
+		}
+	}
+	if result := out.(ProcessingResult); result.ObjectsProcessed != 5 {
+		t.Errorf("expected 5 objects processed, got %+v", result)
+	}
+}
+
+func TestReplayDryRun(t *testing.T) {
+	slack := captureSlack(t)
+	replayFixture(t)
+	fake := withFakeDynamoDB(t)
+	setEnv(t, "DEDUPE_TABLE", "cloudtrail-alerts")
+	hook := logtest.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+
+	if _, err := ReplayHandler(context.Background(), Replay{Bucket: "test-harness", DryRun: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if bodies := slack.Bodies(); len(bodies) != 0 {
+		t.Errorf("expected no messages in a dry run, got %d", len(bodies))
+	}
+	if len(fake.inputs) != 0 {
+		t.Errorf("expected no DEDUPE_TABLE writes in a dry run, got %d", len(fake.inputs))
+	}
+	dryRuns := 0
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Dry run notification" {
+			dryRuns++
+		}
+	}
+	if dryRuns != 5 {
+		t.Errorf("expected 5 dry run notifications, got %d", dryRuns)
+	}
+}
+
+func TestReplayDryRunSummary(t *testing.T) {
+	slack := captureSlack(t)
+	replayFixture(t)
+	fake := withFakeDynamoDB(t)
+	setEnv(t, "DEDUPE_TABLE", "cloudtrail-alerts")
+	setEnv(t, "SUMMARY_MODE", "true")
+
+	if _, err := ReplayHandler(context.Background(), Replay{Bucket: "test-harness", DryRun: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if bodies := slack.Bodies(); len(bodies) != 0 {
+		t.Errorf("expected no summaries in a dry run, got %v", bodies)
+	}
+	if len(fake.inputs) != 0 {
+		t.Errorf("expected no DEDUPE_TABLE writes in a dry run, got %d", len(fake.inputs))
+	}
+}
+
+func TestSendBudgetSummaryDryRun(t *testing.T) {
+	slack := captureSlack(t)
+	setEnv(t, "NOTIFY_DLQ_URL", "https://sqs.us-east-1.amazonaws.com/012345678901/dlq")
+	dlq := &fakeSQS{}
+	dlqClient = dlq
+	defer func() { dlqClient = nil }()
+
+	deferred := []*CloudTrailRecord{typedRecord(consoleRecord("CreateTags", "event-1"))}
+	sendBudgetSummary(withDryRun(context.Background()), deferred, testS3Record)
+
+	if bodies := slack.Bodies(); len(bodies) != 0 {
+		t.Errorf("expected no summary in a dry run, got %v", bodies)
+	}
+	if len(dlq.inputs) != 0 {
+		t.Errorf("expected no DLQ messages in a dry run, got %d", len(dlq.inputs))
+	}
+}
+
+func TestReplayWithoutBucket(t *testing.T) {
+	if _, err := ReplayHandler(context.Background(), Replay{Prefix: "AWSLogs/"}); err == nil {
+		t.Error("expected an error without a bucket")
+	}
+}
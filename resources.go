@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Maximum number of resource ARNs listed in a message.
+const maxAlertResources = 5
+
+// resourceARNs returns the ARNs of the resources a record lists, or nil when
+// it has none.
+func resourceARNs(record *CloudTrailRecord) []string {
+	var arns []string
+	for _, resource := range record.Resources {
+		if resource.ARN != "" {
+			arns = append(arns, resource.ARN)
+		}
+	}
+	return arns
+}
+
+// resourcesSummary lists the first few ARNs, or returns an empty string when
+// there are none.
+func resourcesSummary(arns []string) string {
+	if len(arns) == 0 {
+		return ""
+	}
+
+	s := "Resources: " + strings.Join(arns[:minInt(len(arns), maxAlertResources)], ", ")
+	if len(arns) > maxAlertResources {
+		s = fmt.Sprintf("%s and %d more", s, len(arns)-maxAlertResources)
+	}
+	return s
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
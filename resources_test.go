@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func withResources(raw map[string]interface{}, arns ...string) map[string]interface{} {
+	var resources []interface{}
+	for _, arn := range arns {
+		resources = append(resources, map[string]interface{}{"ARN": arn, "type": "AWS::EC2::Instance"})
+	}
+	raw["resources"] = resources
+	return raw
+}
+
+func TestResourcesSummary(t *testing.T) {
+	arns := resourceARNs(typedRecord(withResources(consoleRecord("CreateTags", "event-1"),
+		"arn:aws:s3:::a", "arn:aws:s3:::b", "", "arn:aws:s3:::c", "arn:aws:s3:::d", "arn:aws:s3:::e", "arn:aws:s3:::f", "arn:aws:s3:::g")))
+	if len(arns) != 7 {
+		t.Fatalf("expected the 7 ARNs, got %v", arns)
+	}
+
+	want := "Resources: arn:aws:s3:::a, arn:aws:s3:::b, arn:aws:s3:::c, arn:aws:s3:::d, arn:aws:s3:::e and 2 more"
+	if s := resourcesSummary(arns); s != want {
+		t.Errorf("resourcesSummary() = %q, want %q", s, want)
+	}
+	if s := resourcesSummary(nil); s != "" {
+		t.Errorf("expected no summary without resources, got %q", s)
+	}
+}
+
+func TestFilterRecordsResources(t *testing.T) {
+	tests := []struct {
+		name   string
+		record map[string]interface{}
+		want   []string
+	}{
+		{
+			"multiple",
+			withResources(consoleRecord("CreateTags", "event-1"), "arn:aws:ec2:us-east-1:012345678901:instance/i-1", "arn:aws:ec2:us-east-1:012345678901:instance/i-2"),
+			[]string{"arn:aws:ec2:us-east-1:012345678901:instance/i-1", "arn:aws:ec2:us-east-1:012345678901:instance/i-2"},
+		},
+		{
+			"one",
+			withResources(consoleRecord("CreateTags", "event-1"), "arn:aws:ec2:us-east-1:012345678901:instance/i-1"),
+			[]string{"arn:aws:ec2:us-east-1:012345678901:instance/i-1"},
+		},
+		{"none", consoleRecord("CreateTags", "event-1"), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slack := captureSlack(t)
+			var alerts []map[string]interface{}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var alert map[string]interface{}
+				json.NewDecoder(r.Body).Decode(&alert)
+				alerts = append(alerts, alert)
+			}))
+			defer srv.Close()
+			setEnv(t, "GENERIC_WEBHOOK_URL", srv.URL)
+
+			logFile := cloudTrailFile(tt.record)
+			if _, err := FilterRecords(context.Background(), logFile.Stream(), testS3Record); err != nil {
+				t.Fatal(err)
+			}
+
+			bodies := slack.Bodies()
+			if len(bodies) != 1 || len(alerts) != 1 {
+				t.Fatalf("expected 1 alert, got %d messages and %d webhook calls", len(bodies), len(alerts))
+			}
+			if tt.want == nil {
+				if _, ok := alerts[0]["resources"]; ok {
+					t.Errorf("expected no resources field, got %v", alerts[0]["resources"])
+				}
+				if strings.Contains(bodies[0], "Resources:") {
+					t.Errorf("expected no resources in the message: %s", bodies[0])
+				}
+				return
+			}
+
+			resources, _ := alerts[0]["resources"].([]interface{})
+			if len(resources) != len(tt.want) {
+				t.Fatalf("resources = %v, want %v", resources, tt.want)
+			}
+			for i, arn := range tt.want {
+				if resources[i] != arn {
+					t.Errorf("resources[%d] = %v, want %s", i, resources[i], arn)
+				}
+			}
+			if want := "Resources: " + strings.Join(tt.want, ", "); !strings.Contains(bodies[0], want) {
+				t.Errorf("message missing %q: %s", want, bodies[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// ProcessingResult is the Lambda response, counting the work an invocation
+// did.
+type ProcessingResult struct {
+	ObjectsProcessed  int `json:"objects_processed"`
+	RecordsScanned    int `json:"records_scanned"`
+	RecordsFilteredIn int `json:"records_filtered_in"`
+	NotificationsSent int `json:"notifications_sent"`
+}
+
+// SQSProcessingResult is the response of an SQS invocation, which keeps the
+// batchItemFailures Lambda reads to redeliver failed messages.
+type SQSProcessingResult struct {
+	events.SQSEventResponse
+	ProcessingResult
+}
+
+// Counted by Stream for each log file it filtered.
+const metricObjectsProcessed = "ObjectsProcessed"
+
+// processingCounter adds up the ProcessingResult of an invocation across the
+// workers of FilterRecords. A nil counter is a no-op.
+type processingCounter struct {
+	mu     sync.Mutex
+	result ProcessingResult
+}
+
+func (c *processingCounter) Add(metric string, n int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch metric {
+	case metricObjectsProcessed:
+		c.result.ObjectsProcessed += n
+	case metricRecordsScanned:
+		c.result.RecordsScanned += n
+	case metricRecordsMatched:
+		c.result.RecordsFilteredIn += n
+	case metricNotificationsSent:
+		c.result.NotificationsSent += n
+	}
+}
+
+func (c *processingCounter) Result() ProcessingResult {
+	if c == nil {
+		return ProcessingResult{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.result
+}
+
+type processingCounterKey struct{}
+
+func withProcessingCounter(ctx context.Context) context.Context {
+	return context.WithValue(ctx, processingCounterKey{}, &processingCounter{})
+}
+
+func processingCounterFrom(ctx context.Context) *processingCounter {
+	c, _ := ctx.Value(processingCounterKey{}).(*processingCounter)
+	return c
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestS3HandlerProcessingResult(t *testing.T) {
+	captureSlack(t)
+
+	cli := consoleRecord("CreateTags", "cli")
+	cli["userAgent"] = "aws-cli/2.2.5 Python/3.8.8 Linux/5.4.0"
+	readOnly := consoleRecord("DescribeInstances", "read-only")
+	readOnly["readOnly"] = true
+	malformed := consoleRecord("CreateTags", "malformed")
+	delete(malformed, "userIdentity")
+
+	files := map[string][]map[string]interface{}{
+		"file-1": {consoleRecord("CreateTags", "event-1"), cli, readOnly},
+		"file-2": {consoleRecord("RunInstances", "event-2"), malformed},
+	}
+	objects := map[string][]byte{}
+	var s3Event events.S3Event
+	for name, records := range files {
+		content, _ := json.Marshal(cloudTrailFile(records...))
+		key := fmt.Sprintf("AWSLogs/012345678901/CloudTrail/us-east-1/2021/05/14/%s.json.gz", name)
+		objects["test-harness/"+key] = content
+		evt := testS3Record
+		evt.S3.Object.Key = key
+		s3Event.Records = append(s3Event.Records, evt)
+	}
+	digest := testS3Record
+	digest.S3.Object.Key = "AWSLogs/012345678901/CloudTrail-Digest/us-east-1/2021/05/14/file.json.gz"
+	s3Event.Records = append(s3Event.Records, digest)
+	withS3Getter(t, &fakeS3Getter{objects: objects})
+
+	result, err := S3Handler(context.Background(), s3Event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ProcessingResult{ObjectsProcessed: 2, RecordsScanned: 5, RecordsFilteredIn: 2, NotificationsSent: 2}
+	if result != want {
+		t.Errorf("S3Handler() = %+v, want %+v", result, want)
+	}
+
+	// A failing object still reports the work done before it.
+	missing := testS3Record
+	missing.S3.Object.Key = "AWSLogs/012345678901/CloudTrail/us-east-1/2021/05/14/missing.json.gz"
+	s3Event.Records = append([]events.S3EventRecord{s3Event.Records[0]}, missing)
+	result, err = S3Handler(context.Background(), s3Event)
+	if err == nil {
+		t.Fatal("expected an error for the missing object")
+	}
+	if result.ObjectsProcessed != 1 || result.RecordsScanned == 0 {
+		t.Errorf("unexpected partial result %+v", result)
+	}
+}
+
+func TestHandlerProcessingResultResponse(t *testing.T) {
+	captureSlack(t)
+	content, _ := json.Marshal(cloudTrailFile(consoleRecord("CreateTags", "event-1")))
+	withS3Getter(t, &fakeS3Getter{objects: map[string][]byte{"test-harness/" + testS3Record.S3.Object.Key: content}})
+
+	raw := json.RawMessage(fmt.Sprintf(`{"Records":[{"eventSource":"aws:s3","awsRegion":"us-east-1","s3":{"bucket":{"name":"test-harness"},"object":{"key":%q}}}]}`, testS3Record.S3.Object.Key))
+	out, err := Handler(context.Background(), raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, _ := json.Marshal(out)
+	if want := `{"objects_processed":1,"records_scanned":1,"records_filtered_in":1,"notifications_sent":1}`; string(response) != want {
+		t.Errorf("response = %s, want %s", response, want)
+	}
+
+	sqsResponse, _ := json.Marshal(SQSProcessingResult{
+		SQSEventResponse: events.SQSEventResponse{BatchItemFailures: []events.SQSBatchItemFailure{{ItemIdentifier: "message-1"}}},
+		ProcessingResult: ProcessingResult{RecordsScanned: 3},
+	})
+	if want := `{"batchItemFailures":[{"itemIdentifier":"message-1"}],"objects_processed":0,"records_scanned":3,"records_filtered_in":0,"notifications_sent":0}`; string(sqsResponse) != want {
+		t.Errorf("SQS response = %s, want %s", sqsResponse, want)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// runbookLink is the runbook of the events whose name matches a path.Match
+// pattern such as "Delete*".
+type runbookLink struct {
+	pattern string
+	url     string
+}
+
+type runbookLinks []runbookLink
+
+// parseRunbookLinks decodes RUNBOOK_LINKS, a JSON object of event name
+// patterns and the runbook URL of the events they match, e.g.
+// {"StopLogging": "https://wiki.example.com/cloudtrail", "Delete*": "..."}.
+// Longer, more specific patterns are tried first.
+func parseRunbookLinks(value string) (runbookLinks, error) {
+	var raw map[string]string
+	if err := json.Unmarshal([]byte(value), &raw); err != nil {
+		return nil, err
+	}
+
+	var links runbookLinks
+	for pattern, link := range raw {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("%s: %w", pattern, err)
+		}
+		if u, err := url.Parse(link); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf("%s: invalid URL %q", pattern, link)
+		}
+		links = append(links, runbookLink{pattern, link})
+	}
+	sort.Slice(links, func(i, j int) bool {
+		if len(links[i].pattern) != len(links[j].pattern) {
+			return len(links[i].pattern) > len(links[j].pattern)
+		}
+		return links[i].pattern < links[j].pattern
+	})
+	return links, nil
+}
+
+// URL returns the runbook of eventName, or an empty string when no pattern
+// matches it.
+func (l runbookLinks) URL(eventName string) string {
+	for _, link := range l {
+		if link.pattern == eventName {
+			return link.url
+		}
+	}
+	for _, link := range l {
+		if ok, _ := path.Match(link.pattern, eventName); ok {
+			return link.url
+		}
+	}
+	return ""
+}
+
+var (
+	runbookLinksMu     sync.Mutex
+	runbookLinksEnv    string
+	runbookLinksParsed runbookLinks
+)
+
+// activeRunbookLinks returns the RUNBOOK_LINKS, parsing them again only when
+// they change.
+func activeRunbookLinks() runbookLinks {
+	env := os.Getenv("RUNBOOK_LINKS")
+
+	runbookLinksMu.Lock()
+	defer runbookLinksMu.Unlock()
+	if runbookLinksEnv != env {
+		runbookLinksParsed, runbookLinksEnv = nil, env
+		if env != "" {
+			links, err := parseRunbookLinks(env)
+			if err != nil {
+				log.Warnf("Ignoring invalid RUNBOOK_LINKS: %v", err)
+			} else {
+				runbookLinksParsed = links
+			}
+		}
+	}
+	return runbookLinksParsed
+}
+
+// runbookURL returns the runbook of an event from RUNBOOK_LINKS, falling
+// back to DEFAULT_RUNBOOK_URL, or an empty string when neither is set.
+func runbookURL(eventName string) string {
+	if link := activeRunbookLinks().URL(eventName); link != "" {
+		return link
+	}
+	return os.Getenv("DEFAULT_RUNBOOK_URL")
+}
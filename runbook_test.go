@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRunbookURL(t *testing.T) {
+	setEnv(t, "RUNBOOK_LINKS", `{"Delete*": "https://wiki.example.com/delete", "DeleteTrail": "https://wiki.example.com/cloudtrail", "Put*Policy": "https://wiki.example.com/policies"}`)
+
+	for _, defaultURL := range []string{"", "https://wiki.example.com/alerts"} {
+		setEnv(t, "DEFAULT_RUNBOOK_URL", defaultURL)
+		for eventName, want := range map[string]string{
+			"DeleteTrail":   "https://wiki.example.com/cloudtrail",
+			"DeleteBucket":  "https://wiki.example.com/delete",
+			"PutUserPolicy": "https://wiki.example.com/policies",
+			"CreateTags":    defaultURL,
+		} {
+			if got := runbookURL(eventName); got != want {
+				t.Errorf("DEFAULT_RUNBOOK_URL=%q runbookURL(%s) = %q, want %q", defaultURL, eventName, got, want)
+			}
+		}
+	}
+}
+
+func TestParseRunbookLinksInvalid(t *testing.T) {
+	for _, value := range []string{
+		`["https://wiki.example.com"]`,
+		`{"[": "https://wiki.example.com"}`,
+		`{"Delete*": "wiki.example.com/delete"}`,
+		`{"Delete*": "javascript:alert(1)"}`,
+	} {
+		if _, err := parseRunbookLinks(value); err == nil {
+			t.Errorf("parseRunbookLinks(%s) should fail", value)
+		}
+	}
+}
+
+func TestSlackRunbookLink(t *testing.T) {
+	tests := []struct {
+		name, links, defaultURL, want string
+	}{
+		{"matched pattern", `{"Create*": "https://wiki.example.com/create"}`, "https://wiki.example.com/alerts", "https://wiki.example.com/create|Runbook"},
+		{"default", `{"Delete*": "https://wiki.example.com/delete"}`, "https://wiki.example.com/alerts", "https://wiki.example.com/alerts|Runbook"},
+		{"none", `{"Delete*": "https://wiki.example.com/delete"}`, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slack := captureSlack(t)
+			setEnv(t, "RUNBOOK_LINKS", tt.links)
+			setEnv(t, "DEFAULT_RUNBOOK_URL", tt.defaultURL)
+
+			if _, err := FilterRecords(context.Background(), cloudTrailFile(consoleRecord("CreateTags", "event-1")).Stream(), testS3Record); err != nil {
+				t.Fatal(err)
+			}
+			bodies := slack.Bodies()
+			if len(bodies) != 1 {
+				t.Fatalf("expected 1 message, got %d", len(bodies))
+			}
+			body := bodies[0]
+			if tt.want == "" && strings.Contains(body, "Runbook") {
+				t.Errorf("expected no runbook link in %s", body)
+			}
+			if tt.want != "" && !strings.Contains(body, tt.want) {
+				t.Errorf("expected %s in %s", tt.want, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// logObjectInput returns the GetObjectInput reading a log file, paying for
+// the request with S3_REQUESTER_PAYS and decrypting it with the customer key
+// S3_SSE_CUSTOMER_KEY, a base64 encoded 256-bit AES key.
+func logObjectInput(bucket, key string) (*s3.GetObjectInput, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	if getEnvBool("S3_REQUESTER_PAYS", false) {
+		input.RequestPayer = aws.String(s3.RequestPayerRequester)
+	}
+	if v := os.Getenv("S3_SSE_CUSTOMER_KEY"); v != "" {
+		customerKey, err := base64.StdEncoding.DecodeString(v)
+		if err != nil || len(customerKey) != 32 {
+			return nil, fmt.Errorf("S3_SSE_CUSTOMER_KEY is not a base64 encoded 256-bit key")
+		}
+		// The SDK adds the key's MD5 and base64 encodes both.
+		input.SSECustomerAlgorithm = aws.String(s3.ServerSideEncryptionAes256)
+		input.SSECustomerKey = aws.String(string(customerKey))
+	}
+	return input, nil
+}
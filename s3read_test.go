@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestFetchLogFromS3Headers(t *testing.T) {
+	customerKey := "0123456789abcdef0123456789abcdef"
+	encodedKey := base64.StdEncoding.EncodeToString([]byte(customerKey))
+	keyMD5 := md5.Sum([]byte(customerKey))
+
+	tests := []struct {
+		name          string
+		requesterPays string
+		customerKey   string
+		want          map[string]string
+	}{
+		{"default", "", "", map[string]string{
+			"X-Amz-Request-Payer":                             "",
+			"X-Amz-Server-Side-Encryption-Customer-Algorithm": "",
+		}},
+		{"requester pays", "true", "", map[string]string{
+			"X-Amz-Request-Payer": "requester",
+		}},
+		{"customer key", "", encodedKey, map[string]string{
+			"X-Amz-Server-Side-Encryption-Customer-Algorithm": "AES256",
+			"X-Amz-Server-Side-Encryption-Customer-Key":       encodedKey,
+			"X-Amz-Server-Side-Encryption-Customer-Key-Md5":   base64.StdEncoding.EncodeToString(keyMD5[:]),
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got http.Header
+			srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header
+				w.Write([]byte(`{"Records":[]}`))
+			}))
+			defer srv.Close()
+			client := s3.New(session.Must(session.NewSession()), aws.NewConfig().
+				WithRegion("us-east-1").
+				WithEndpoint(srv.URL).
+				WithS3ForcePathStyle(true).
+				WithHTTPClient(srv.Client()).
+				WithCredentials(credentials.NewStaticCredentials("id", "secret", "")))
+			setEnv(t, "S3_REQUESTER_PAYS", tt.requesterPays)
+			setEnv(t, "S3_SSE_CUSTOMER_KEY", tt.customerKey)
+
+			obj, err := fetchLogFromS3(context.Background(), client, "test-harness", testS3Record.S3.Object.Key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			obj.Body.Close()
+			for name, want := range tt.want {
+				if got.Get(name) != want {
+					t.Errorf("header %s = %q, want %q", name, got.Get(name), want)
+				}
+			}
+		})
+	}
+}
+
+func TestLogObjectInputInvalidCustomerKey(t *testing.T) {
+	for _, invalid := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte("too short"))} {
+		setEnv(t, "S3_SSE_CUSTOMER_KEY", invalid)
+		if _, err := logObjectInput("test-harness", testS3Record.S3.Object.Key); err == nil {
+			t.Errorf("expected an error for S3_SSE_CUSTOMER_KEY %q", invalid)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultS3GetMaxRetries = 3
+
+// First backoff between GetObject attempts and the longest wait. Variables
+// so tests don't have to sleep.
+var (
+	s3RetryBaseDelay = 200 * time.Millisecond
+	s3RetryMaxDelay  = 5 * time.Second
+)
+
+// getObjectWithRetry retries GetObject on throttling, server errors and the
+// NoSuchKey returned while a just created object isn't visible yet, up to
+// S3_GET_MAX_RETRIES times with exponential backoff and jitter. It stops
+// waiting when ctx is done. The client of defaultS3Client doesn't retry by
+// itself, so these are all the attempts made.
+func getObjectWithRetry(ctx context.Context, s3Client S3Getter, input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	maxRetries := defaultS3GetMaxRetries
+	if v, err := strconv.Atoi(os.Getenv("S3_GET_MAX_RETRIES")); err == nil && v >= 0 {
+		maxRetries = v
+	}
+
+	for attempt := 0; ; attempt++ {
+		obj, err := s3Client.GetObjectWithContext(ctx, input)
+		if err == nil || attempt >= maxRetries || !retryableS3Error(err) {
+			return obj, err
+		}
+
+		wait := s3Backoff(attempt)
+		log.Debugf("Getting S3 object failed, retrying in %s: %v", wait, err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func retryableS3Error(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+
+	switch aerr.Code() {
+	case s3.ErrCodeNoSuchKey, request.ErrCodeRequestError, request.ErrCodeResponseTimeout,
+		"RequestTimeout", "SlowDown", "Throttling", "ThrottlingException", "InternalError", "ServiceUnavailable":
+		return true
+	}
+	if rerr, ok := err.(awserr.RequestFailure); ok {
+		return rerr.StatusCode() == http.StatusTooManyRequests || rerr.StatusCode() >= 500
+	}
+	return false
+}
+
+// s3Backoff doubles the delay with every attempt and picks a random wait of
+// up to that delay.
+func s3Backoff(attempt int) time.Duration {
+	delay := s3RetryBaseDelay << uint(attempt)
+	if delay <= 0 || delay > s3RetryMaxDelay {
+		delay = s3RetryMaxDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// flakyS3Getter fails with errs in turn before returning body.
+type flakyS3Getter struct {
+	errs  []error
+	body  []byte
+	calls int
+}
+
+func (f *flakyS3Getter) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	f.calls++
+	if f.calls <= len(f.errs) {
+		return nil, f.errs[f.calls-1]
+	}
+	return &s3.GetObjectOutput{Body: BufferCloser{bytes.NewBuffer(f.body)}}, nil
+}
+
+func fastS3Retries(t *testing.T) {
+	base, max := s3RetryBaseDelay, s3RetryMaxDelay
+	s3RetryBaseDelay, s3RetryMaxDelay = time.Millisecond, 4*time.Millisecond
+	t.Cleanup(func() { s3RetryBaseDelay, s3RetryMaxDelay = base, max })
+}
+
+func TestFetchLogFromS3Retries(t *testing.T) {
+	fastS3Retries(t)
+
+	getter := &flakyS3Getter{
+		errs: []error{
+			awserr.NewRequestFailure(awserr.New("SlowDown", "Please reduce your request rate.", nil), 503, "req-1"),
+			awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil),
+		},
+		body: []byte(`{"Records":[]}`),
+	}
+
+	obj, err := fetchLogFromS3(context.Background(), getter, "b", testS3Record.S3.Object.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj == nil || getter.calls != 3 {
+		t.Errorf("expected success on the third call, got %d calls", getter.calls)
+	}
+}
+
+func TestFetchLogFromS3FailsFast(t *testing.T) {
+	fastS3Retries(t)
+
+	getter := &flakyS3Getter{errs: []error{
+		awserr.NewRequestFailure(awserr.New("AccessDenied", "Access Denied", nil), 403, "req-1"),
+	}}
+
+	_, err := fetchLogFromS3(context.Background(), getter, "b", testS3Record.S3.Object.Key)
+	var rerr awserr.RequestFailure
+	if !errors.As(err, &rerr) || rerr.Code() != "AccessDenied" || rerr.StatusCode() != 403 {
+		t.Errorf("expected a wrapped AccessDenied, got %v", err)
+	}
+	if getter.calls != 1 {
+		t.Errorf("expected a single call, got %d", getter.calls)
+	}
+}
+
+func TestFetchLogFromS3GivesUp(t *testing.T) {
+	fastS3Retries(t)
+	setEnv(t, "S3_GET_MAX_RETRIES", "2")
+
+	noSuchKey := awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	getter := &flakyS3Getter{errs: []error{noSuchKey, noSuchKey, noSuchKey, noSuchKey}}
+
+	if _, err := fetchLogFromS3(context.Background(), getter, "b", testS3Record.S3.Object.Key); err == nil {
+		t.Error("expected an error")
+	}
+	if getter.calls != 3 {
+		t.Errorf("expected 3 calls, got %d", getter.calls)
+	}
+}
+
+func TestFetchLogFromS3StopsWaitingWhenCanceled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	noSuchKey := awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	getter := &flakyS3Getter{errs: []error{noSuchKey, noSuchKey}}
+
+	start := time.Now()
+	_, err := getObjectWithRetry(ctx, getter, &s3.GetObjectInput{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the context error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("kept waiting for %s after the context was done", elapsed)
+	}
+	if getter.calls != 1 {
+		t.Errorf("expected a single call, got %d", getter.calls)
+	}
+}
+
+func TestDefaultS3ClientLeavesRetriesToFetch(t *testing.T) {
+	client := defaultS3Client("us-east-1", "").(*s3.S3)
+	if n := aws.IntValue(client.Config.MaxRetries); n != 0 {
+		t.Errorf("SDK MaxRetries = %d, want 0", n)
+	}
+}
+
+func TestRetryableS3Error(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{awserr.New("RequestError", "send request failed", nil), true},
+		{awserr.NewRequestFailure(awserr.New("InternalError", "We encountered an internal error.", nil), 500, "r"), true},
+		{awserr.NewRequestFailure(awserr.New("Unknown", "", nil), 502, "r"), true},
+		{awserr.NewRequestFailure(awserr.New("AccessDenied", "Access Denied", nil), 403, "r"), false},
+		{awserr.New("InvalidObjectState", "", nil), false},
+		{errors.New("plain error"), false},
+	}
+	for _, tt := range tests {
+		if got := retryableS3Error(tt.err); got != tt.want {
+			t.Errorf("retryableS3Error(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestS3Backoff(t *testing.T) {
+	fastS3Retries(t)
+	for attempt := 0; attempt < 10; attempt++ {
+		if wait := s3Backoff(attempt); wait <= 0 || wait > s3RetryMaxDelay {
+			t.Errorf("s3Backoff(%d) = %s", attempt, wait)
+		}
+	}
+}
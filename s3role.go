@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// s3RoleArn returns the role assumed to read log files delivered for
+// accountId. S3_ROLE_ARN_<accountId> takes precedence over S3_ROLE_ARN, in
+// which {accountId} is replaced by the account id. An empty result means the
+// default credential chain is used.
+func s3RoleArn(accountId string) string {
+	if accountId != "" {
+		if roleArn := os.Getenv(fmt.Sprintf("S3_ROLE_ARN_%s", accountId)); roleArn != "" {
+			return roleArn
+		}
+	}
+
+	roleArn := os.Getenv("S3_ROLE_ARN")
+	if strings.Contains(roleArn, "{accountId}") {
+		if accountId == "" {
+			return ""
+		}
+		roleArn = strings.ReplaceAll(roleArn, "{accountId}", accountId)
+	}
+	return roleArn
+}
+
+// logAccountID returns the account id of a CloudTrail object key of the form
+// AWSLogs/<accountId>/... or AWSLogs/<orgId>/<accountId>/... for organization
+// trails.
+func logAccountID(key string) string {
+	parts := strings.Split(key, "/")
+	for i, part := range parts {
+		if part != "AWSLogs" || i+1 >= len(parts) {
+			continue
+		}
+		account := parts[i+1]
+		if strings.HasPrefix(account, "o-") && i+2 < len(parts) {
+			account = parts[i+2]
+		}
+		if len(account) == 12 && strings.Trim(account, "0123456789") == "" {
+			return account
+		}
+		return ""
+	}
+	return ""
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestS3RoleArn(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		account string
+		want    string
+	}{
+		{name: "unset", account: "111111111111"},
+		{name: "template", env: map[string]string{"S3_ROLE_ARN": "arn:aws:iam::{accountId}:role/TrailReader"}, account: "111111111111", want: "arn:aws:iam::111111111111:role/TrailReader"},
+		{name: "template without account", env: map[string]string{"S3_ROLE_ARN": "arn:aws:iam::{accountId}:role/TrailReader"}},
+		{name: "fixed role", env: map[string]string{"S3_ROLE_ARN": "arn:aws:iam::999999999999:role/TrailReader"}, account: "111111111111", want: "arn:aws:iam::999999999999:role/TrailReader"},
+		{
+			name:    "account role wins",
+			env:     map[string]string{"S3_ROLE_ARN": "arn:aws:iam::{accountId}:role/TrailReader", "S3_ROLE_ARN_222222222222": "arn:aws:iam::222222222222:role/Legacy"},
+			account: "222222222222",
+			want:    "arn:aws:iam::222222222222:role/Legacy",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEnv(t, "S3_ROLE_ARN", "")
+			for k, v := range tt.env {
+				setEnv(t, k, v)
+			}
+			if got := s3RoleArn(tt.account); got != tt.want {
+				t.Errorf("s3RoleArn(%q) = %q, want %q", tt.account, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogAccountID(t *testing.T) {
+	tests := map[string]string{
+		"AWSLogs/111111111111/CloudTrail/us-east-1/2021/05/14/file.json.gz":              "111111111111",
+		"prefix/AWSLogs/o-abc123/222222222222/CloudTrail/us-east-1/2021/05/14/file.json": "222222222222",
+		"AWSLogs/o-abc123/CloudTrail-Digest":                                             "",
+		"exports/file.json":                                                              "",
+	}
+	for key, want := range tests {
+		if got := logAccountID(key); got != want {
+			t.Errorf("logAccountID(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestStreamAssumesAccountRole(t *testing.T) {
+	setEnv(t, "S3_ROLE_ARN", "arn:aws:iam::{accountId}:role/TrailReader")
+	fastS3Retries(t)
+
+	var roles []string
+	newS3Client = func(region, roleArn string) S3Getter {
+		roles = append(roles, roleArn)
+		return &fakeS3Getter{objects: map[string][]byte{}}
+	}
+	defer func() { newS3Client = defaultS3Client }()
+
+	Stream(context.Background(), testS3Record)
+	if len(roles) != 1 || roles[0] != "arn:aws:iam::012345678901:role/TrailReader" {
+		t.Errorf("unexpected roles %v", roles)
+	}
+}
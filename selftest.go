@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SelfTest is the {"selftest": true} payload used to verify a deployment.
+// Bucket and Key optionally name an object the function must be able to read.
+type SelfTest struct {
+	SelfTest bool   `json:"selftest"`
+	Bucket   string `json:"bucket"`
+	Key      string `json:"key"`
+	Region   string `json:"region"`
+}
+
+// selfTestRecord is the sample console event run through the filter.
+func selfTestRecord() *CloudTrailRecord {
+	record := newCloudTrailRecord(map[string]interface{}{
+		"eventVersion": "1.08",
+		"eventTime":    time.Now().UTC().Format(time.RFC3339),
+		"eventSource":  "ec2.amazonaws.com",
+		"eventName":    "CreateTags",
+		"eventType":    "AwsApiCall",
+		"eventID":      "self-test",
+		"awsRegion":    getEnv("AWS_REGION", "us-east-1"),
+		"userAgent":    "console.amazonaws.com",
+		"readOnly":     false,
+		"userIdentity": map[string]interface{}{
+			"type":        "IAMUser",
+			"principalId": "AIDASELFTEST",
+			"userName":    "cloudtrail-console-actions",
+		},
+	})
+	return &record
+}
+
+// SelfTestHandler sends a message marked as a self-test to every configured
+// notifier. It reports whether the filter would alert on the sample record
+// and, when the payload names an object, whether it can be read from S3. It
+// fails when the object can't be read, a notification can't be sent or no
+// notifier sent one.
+func SelfTestHandler(ctx context.Context, test SelfTest) error {
+	ctx, err := prepareInvocation(ctx)
+	if err != nil {
+		return err
+	}
+	defer flushMetrics(ctx)
+
+	record := selfTestRecord()
+	details := []string{"Self-test message, no action needed"}
+
+	if alert, reason := ShouldAlert(record); !alert {
+		details = append(details, fmt.Sprintf("Filter: a console CreateTags event would be suppressed (%s)", reason))
+	} else {
+		details = append(details, "Filter: a console CreateTags event would alert")
+	}
+
+	var s3Err error
+	if test.Bucket != "" {
+		s3URI := fmt.Sprintf("s3://%s/%s", test.Bucket, test.Key)
+		if s3Err = checkS3Access(ctx, test); s3Err != nil {
+			details = append(details, fmt.Sprintf("S3: reading %s failed: %v", s3URI, s3Err))
+		} else {
+			details = append(details, fmt.Sprintf("S3: read %s", s3URI))
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"event_id": record.EventID,
+		"details":  details,
+	}).Info("Self-test")
+
+	notifiers, _ := ctx.Value(notifiersKey{}).([]Notifier)
+	if len(notifiers) == 0 {
+		return errors.New("self-test: no notifiers configured")
+	}
+
+	alert := AlertEvent{
+		EventName:     "Self-test",
+		EventSource:   record.EventSource,
+		EventID:       record.EventID,
+		EventTime:     record.EventTime,
+		Region:        record.AwsRegion,
+		Account:       accountLabel(""),
+		UserName:      record.UserIdentity.UserName,
+		EventURL:      consoleEventURL(record.AwsRegion, record.EventID),
+		EventURLLabel: "View in CloudTrail",
+		Severity:      severityInfo,
+		Details:       details,
+		Record:        record,
+	}
+	if err := notifyAll(ctx, alert); err != nil {
+		return fmt.Errorf("self-test: %w", err)
+	}
+	// Notifiers skip alerts they have nowhere to send, e.g. with DRY_RUN
+	// or only SLACK_WEBHOOK_<accountId> set.
+	if processingCounterFrom(ctx).Result().NotificationsSent == 0 {
+		return errors.New("self-test: no notification was sent")
+	}
+	if s3Err != nil {
+		return fmt.Errorf("self-test: reading s3://%s/%s: %w", test.Bucket, test.Key, s3Err)
+	}
+	return nil
+}
+
+// checkS3Access reads the object named by test with the same client and role
+// log files are read with.
+func checkS3Access(ctx context.Context, test SelfTest) error {
+	region := test.Region
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	s3Client := newS3Client(region, s3RoleArn(logAccountID(test.Key)))
+	input, err := logObjectInput(test.Bucket, test.Key)
+	if err != nil {
+		return err
+	}
+	obj, err := s3Client.GetObjectWithContext(ctx, input)
+	if err != nil {
+		return err
+	}
+	return obj.Body.Close()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestHandlerSelfTest(t *testing.T) {
+	slack := captureSlack(t)
+	key := testS3Record.S3.Object.Key
+	withS3Getter(t, &fakeS3Getter{objects: map[string][]byte{"test-harness/" + key: []byte(`{"Records":[]}`)}})
+
+	raw, _ := json.Marshal(map[string]interface{}{"selftest": true, "bucket": "test-harness", "key": key})
+	if _, err := Handler(context.Background(), raw); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := slack.Bodies()
+	if len(bodies) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(bodies))
+	}
+	for _, want := range []string{
+		"*Self-test* - ec2.amazonaws.com",
+		"Self-test message, no action needed",
+		"Filter: a console CreateTags event would alert",
+		"S3: read s3://test-harness/" + key,
+	} {
+		if !strings.Contains(bodies[0], want) {
+			t.Errorf("message missing %q: %s", want, bodies[0])
+		}
+	}
+}
+
+func TestHandlerSelfTestFailures(t *testing.T) {
+	slack := captureSlack(t)
+	withS3Getter(t, &fakeS3Getter{objects: map[string][]byte{}})
+	setEnv(t, "FILTER_MODE", "allowlist")
+	setEnv(t, "MONITORED_EVENTS", "ConsoleLogin")
+
+	raw := json.RawMessage(`{"selftest":true,"bucket":"test-harness","key":"missing.json.gz"}`)
+	_, err := Handler(context.Background(), raw)
+	if err == nil || !strings.Contains(err.Error(), "reading s3://test-harness/missing.json.gz") {
+		t.Errorf("expected the S3 check to fail, got %v", err)
+	}
+
+	// The message is still sent so the failure shows up in the channel.
+	bodies := slack.Bodies()
+	if len(bodies) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(bodies))
+	}
+	for _, want := range []string{
+		"Filter: a console CreateTags event would be suppressed (not in MONITORED_EVENTS)",
+		"S3: reading s3://test-harness/missing.json.gz failed",
+	} {
+		if !strings.Contains(bodies[0], want) {
+			t.Errorf("message missing %q: %s", want, bodies[0])
+		}
+	}
+}
+
+func TestHandlerSelfTestWithoutNotifiers(t *testing.T) {
+	if _, err := Handler(context.Background(), json.RawMessage(`{"selftest":true}`)); err == nil {
+		t.Error("expected an error without notifiers")
+	}
+}
+
+func TestHandlerSelfTestNothingSent(t *testing.T) {
+	for name, env := range map[string]map[string]string{
+		"dry run":              {"SLACK_WEBHOOK": "https://hooks.slack.com/services/T000/B000/XXX", "DRY_RUN": "true"},
+		"account webhook only": {"SLACK_WEBHOOK_210987654321": "https://hooks.slack.com/services/T000/B000/XXX"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			for key, value := range env {
+				setEnv(t, key, value)
+			}
+			_, err := Handler(context.Background(), json.RawMessage(`{"selftest":true}`))
+			if err == nil || !strings.Contains(err.Error(), "no notification was sent") {
+				t.Errorf("expected the self-test to fail, got %v", err)
+			}
+		})
+	}
+}
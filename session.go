@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// principalTagPrefix starts the sessionContext keys holding the tags of the
+// session's principal, e.g. aws:PrincipalTag/Environment.
+const principalTagPrefix = "aws:PrincipalTag/"
+
+// Session describes the role or federated session a record was made with.
+type Session struct {
+	// MFAAuthenticated is nil when the record doesn't say.
+	MFAAuthenticated *bool
+	SourceIdentity   string
+	// PrincipalTags are the tags of the session's principal by key.
+	PrincipalTags map[string]string
+}
+
+// ParseSession extracts the MFA and source identity attributes and the
+// principal tags of a record's sessionContext. It returns nil when the
+// record has none of them.
+func ParseSession(record *CloudTrailRecord) *Session {
+	sessionContext := record.UserIdentity.SessionContext
+	attributes, _ := sessionContext["attributes"].(map[string]interface{})
+
+	var session Session
+	switch strings.ToLower(stringField(attributes, "mfaAuthenticated")) {
+	case "true":
+		session.MFAAuthenticated = boolPtr(true)
+	case "false":
+		session.MFAAuthenticated = boolPtr(false)
+	}
+	session.SourceIdentity = stringField(sessionContext, "sourceIdentity")
+	session.PrincipalTags = principalTags(sessionContext, attributes)
+
+	if session.MFAAuthenticated == nil && session.SourceIdentity == "" && session.PrincipalTags == nil {
+		return nil
+	}
+	return &session
+}
+
+// WithoutMFA reports whether the session is known to have been opened
+// without MFA.
+func (s *Session) WithoutMFA() bool {
+	return s.MFAAuthenticated != nil && !*s.MFAAuthenticated
+}
+
+func (s *Session) Summary() string {
+	var parts []string
+	if s.MFAAuthenticated != nil {
+		mfa := "no"
+		if *s.MFAAuthenticated {
+			mfa = "yes"
+		}
+		parts = append(parts, "MFA: "+mfa)
+	}
+	if s.SourceIdentity != "" {
+		parts = append(parts, "source identity: "+s.SourceIdentity)
+	}
+	if len(s.PrincipalTags) > 0 {
+		var tags []string
+		for key, value := range s.PrincipalTags {
+			tags = append(tags, key+"="+value)
+		}
+		sort.Strings(tags)
+		parts = append(parts, "tags: "+strings.Join(tags, " "))
+	}
+	return fmt.Sprintf("Session: %s", strings.Join(parts, ", "))
+}
+
+// principalTags collects the aws:PrincipalTag/<key> values of a
+// sessionContext or of its attributes, nil when there are none.
+func principalTags(objects ...map[string]interface{}) map[string]string {
+	var tags map[string]string
+	for _, object := range objects {
+		for key, value := range object {
+			v, ok := value.(string)
+			if !ok || !strings.HasPrefix(key, principalTagPrefix) {
+				continue
+			}
+			if tags == nil {
+				tags = map[string]string{}
+			}
+			tags[strings.TrimPrefix(key, principalTagPrefix)] = v
+		}
+	}
+	return tags
+}
+
+// suppressedEnvironment reports whether the record was made by a principal
+// whose Environment tag is one of SUPPRESS_ENVIRONMENTS, a comma separated
+// list such as "sandbox,dev". Principals without the tag are never
+// suppressed.
+func suppressedEnvironment(record *CloudTrailRecord) bool {
+	session := ParseSession(record)
+	if session == nil {
+		return false
+	}
+	var environment string
+	for key, value := range session.PrincipalTags {
+		if strings.EqualFold(key, "Environment") {
+			environment = value
+		}
+	}
+	if environment == "" {
+		return false
+	}
+	for _, suppressed := range strings.Split(os.Getenv("SUPPRESS_ENVIRONMENTS"), ",") {
+		if suppressed = strings.TrimSpace(suppressed); suppressed != "" && strings.EqualFold(suppressed, environment) {
+			return true
+		}
+	}
+	return false
+}
+
+// privilegedWithoutMFA reports whether a write at warn severity or above was
+// made by a session opened without MFA.
+func privilegedWithoutMFA(record *CloudTrailRecord, session *Session, severity string) bool {
+	readOnly := record.ReadOnly != nil && *record.ReadOnly
+	return session != nil && session.WithoutMFA() && !readOnly && severityRank[severity] >= severityRank[severityWarn]
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func sessionRecord(eventID, mfaAuthenticated, sourceIdentity string) map[string]interface{} {
+	record := consoleRecord("CreateTags", eventID)
+	sessionContext := map[string]interface{}{
+		"attributes": map[string]interface{}{
+			"creationDate":     "2021-05-14T18:55:12Z",
+			"mfaAuthenticated": mfaAuthenticated,
+		},
+	}
+	if sourceIdentity != "" {
+		sessionContext["sourceIdentity"] = sourceIdentity
+	}
+	record["userIdentity"] = map[string]interface{}{
+		"type":           "AssumedRole",
+		"principalId":    "AROAQTKSM5RSQEXAMPLE:first.last@example.com",
+		"arn":            "arn:aws:sts::012345678901:assumed-role/Admin/first.last@example.com",
+		"accountId":      "012345678901",
+		"sessionContext": sessionContext,
+	}
+	return record
+}
+
+func TestParseSession(t *testing.T) {
+	tests := []struct {
+		name           string
+		record         map[string]interface{}
+		mfa            string
+		sourceIdentity string
+		summary        string
+	}{
+		{"MFA", sessionRecord("mfa", "true", ""), "true", "", "Session: MFA: yes"},
+		{"no MFA", sessionRecord("no-mfa", "false", ""), "false", "", "Session: MFA: no"},
+		{"federated", sessionRecord("federated", "false", "first.last@example.com"), "false", "first.last@example.com", "Session: MFA: no, source identity: first.last@example.com"},
+		{"IAM user", consoleRecord("CreateTags", "user"), "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := ParseSession(typedRecord(tt.record))
+			if tt.summary == "" {
+				if session != nil {
+					t.Errorf("expected no session, got %+v", session)
+				}
+				return
+			}
+			if session == nil {
+				t.Fatal("expected a session")
+			}
+			if session.MFAAuthenticated == nil || (*session.MFAAuthenticated) != (tt.mfa == "true") {
+				t.Errorf("MFAAuthenticated = %v, want %s", session.MFAAuthenticated, tt.mfa)
+			}
+			if session.SourceIdentity != tt.sourceIdentity {
+				t.Errorf("SourceIdentity = %q, want %q", session.SourceIdentity, tt.sourceIdentity)
+			}
+			if s := session.Summary(); s != tt.summary {
+				t.Errorf("Summary() = %q, want %q", s, tt.summary)
+			}
+		})
+	}
+}
+
+func TestFilterRecordsSessionMFA(t *testing.T) {
+	slack := captureSlack(t)
+	setEnv(t, "SEVERITY_RULES", `{"CreateTags": "warn"}`)
+
+	logFile := cloudTrailFile(
+		sessionRecord("mfa", "true", ""),
+		sessionRecord("no-mfa", "false", ""),
+		sessionRecord("federated", "false", "first.last@example.com"),
+	)
+	if _, err := FilterRecords(context.Background(), logFile.Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := slack.Bodies()
+	const flag = "Privileged action performed without MFA"
+	if body := slackBodyFor(bodies, "mfa"); !strings.Contains(body, "Session: MFA: yes") || strings.Contains(body, flag) {
+		t.Errorf("expected an MFA session without the flag, got %s", body)
+	}
+	if body := slackBodyFor(bodies, "no-mfa"); !strings.Contains(body, flag+`\nSession: MFA: no`) {
+		t.Errorf("expected the flag ahead of the session, got %s", body)
+	}
+	if body := slackBodyFor(bodies, "federated"); !strings.Contains(body, flag) || !strings.Contains(body, "source identity: first.last@example.com") {
+		t.Errorf("expected the flag and the source identity, got %s", body)
+	}
+}
+
+func TestFilterRecordsSessionMFAInfo(t *testing.T) {
+	slack := captureSlack(t)
+
+	if _, err := FilterRecords(context.Background(), cloudTrailFile(sessionRecord("no-mfa", "false", "")).Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+	if body := slackBodyFor(slack.Bodies(), "no-mfa"); body == "" || strings.Contains(body, "Privileged action") {
+		t.Errorf("an info event shouldn't be flagged, got %s", body)
+	}
+}
+
+func taggedSessionRecord(eventID string, tags map[string]string) map[string]interface{} {
+	record := sessionRecord(eventID, "true", "")
+	sessionContext := record["userIdentity"].(map[string]interface{})["sessionContext"].(map[string]interface{})
+	for key, value := range tags {
+		sessionContext["aws:PrincipalTag/"+key] = value
+	}
+	return record
+}
+
+func TestParseSessionPrincipalTags(t *testing.T) {
+	session := ParseSession(typedRecord(taggedSessionRecord("sandbox", map[string]string{"Environment": "sandbox", "Team": "platform"})))
+	if session == nil || session.PrincipalTags["Environment"] != "sandbox" || session.PrincipalTags["Team"] != "platform" {
+		t.Fatalf("unexpected session %+v", session)
+	}
+	if s := session.Summary(); s != "Session: MFA: yes, tags: Environment=sandbox Team=platform" {
+		t.Errorf("Summary() = %q", s)
+	}
+
+	if session := ParseSession(typedRecord(sessionRecord("untagged", "true", ""))); session == nil || session.PrincipalTags != nil {
+		t.Errorf("expected no principal tags, got %+v", session)
+	}
+}
+
+func TestFilterRecordsSuppressEnvironments(t *testing.T) {
+	slack := captureSlack(t)
+	setEnv(t, "SUPPRESS_ENVIRONMENTS", "dev, Sandbox")
+
+	logFile := cloudTrailFile(
+		taggedSessionRecord("sandbox", map[string]string{"Environment": "sandbox"}),
+		taggedSessionRecord("production", map[string]string{"Environment": "production"}),
+		taggedSessionRecord("other-tags", map[string]string{"Team": "platform"}),
+		sessionRecord("untagged", "true", ""),
+		consoleRecord("CreateTags", "iam-user"),
+	)
+	if _, err := FilterRecords(context.Background(), logFile.Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := slack.Bodies()
+	if body := slackBodyFor(bodies, "sandbox"); body != "" {
+		t.Errorf("expected the sandbox session to be suppressed, got %s", body)
+	}
+	for _, id := range []string{"production", "other-tags", "untagged", "iam-user"} {
+		if slackBodyFor(bodies, id) == "" {
+			t.Errorf("expected a message for %s", id)
+		}
+	}
+	if body := slackBodyFor(bodies, "production"); !strings.Contains(body, "tags: Environment=production") {
+		t.Errorf("expected the principal tags in the message, got %s", body)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+const (
+	severityInfo     = "info"
+	severityWarn     = "warn"
+	severityCritical = "critical"
+)
+
+var severityRank = map[string]int{
+	severityInfo:     0,
+	severityWarn:     1,
+	severityCritical: 2,
+}
+
+func maxSeverity(a, b string) string {
+	if severityRank[b] > severityRank[a] {
+		return b
+	}
+	return a
+}
+
+var severityEmojis = map[string]string{
+	severityInfo:     ":information_source:",
+	severityWarn:     ":warning:",
+	severityCritical: ":rotating_light:",
+}
+
+// severityEmoji returns the Slack emoji shown with alerts of a severity.
+func severityEmoji(severity string) string {
+	if emoji, ok := severityEmojis[severity]; ok {
+		return emoji
+	}
+	return severityEmojis[severityInfo]
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// severityRule raises the severity of the events whose name matches a
+// path.Match pattern such as "Delete*".
+type severityRule struct {
+	pattern  string
+	severity string
+}
+
+type severityRules []severityRule
+
+// parseSeverityRules decodes SEVERITY_RULES, a JSON object of event name
+// patterns and the severity of the events they match, e.g.
+// {"DeleteBucket": "critical", "Put*Policy": "warn"}.
+func parseSeverityRules(value string) (severityRules, error) {
+	var raw map[string]string
+	if err := json.Unmarshal([]byte(value), &raw); err != nil {
+		return nil, err
+	}
+
+	var rules severityRules
+	for pattern, severity := range raw {
+		if _, ok := severityRank[severity]; !ok {
+			return nil, fmt.Errorf("%s: unknown severity %q", pattern, severity)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("%s: %w", pattern, err)
+		}
+		rules = append(rules, severityRule{pattern, severity})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].pattern < rules[j].pattern })
+	return rules, nil
+}
+
+// Severity returns the highest severity of the rules matching eventName, or
+// info when none does.
+func (r severityRules) Severity(eventName string) string {
+	severity := severityInfo
+	for _, rule := range r {
+		if ok, _ := path.Match(rule.pattern, eventName); ok {
+			severity = maxSeverity(severity, rule.severity)
+		}
+	}
+	return severity
+}
+
+var (
+	severityRulesMu     sync.Mutex
+	severityRulesEnv    string
+	severityRulesParsed severityRules
+)
+
+// activeSeverityRules returns the SEVERITY_RULES, parsing them again only
+// when they change.
+func activeSeverityRules() severityRules {
+	env := os.Getenv("SEVERITY_RULES")
+
+	severityRulesMu.Lock()
+	defer severityRulesMu.Unlock()
+	if severityRulesEnv != env {
+		severityRulesParsed, severityRulesEnv = nil, env
+		if env != "" {
+			rules, err := parseSeverityRules(env)
+			if err != nil {
+				log.Warnf("Ignoring invalid SEVERITY_RULES: %v", err)
+			} else {
+				severityRulesParsed = rules
+			}
+		}
+	}
+	return severityRulesParsed
+}
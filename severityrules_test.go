@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestSeverityRules(t *testing.T) {
+	rules, err := parseSeverityRules(`{"DeleteBucket": "critical", "Delete*": "warn", "Put*Policy": "warn"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for eventName, want := range map[string]string{
+		"DeleteBucket":    severityCritical,
+		"DeleteTrail":     severityWarn,
+		"PutBucketPolicy": severityWarn,
+		"CreateTags":      severityInfo,
+	} {
+		if got := rules.Severity(eventName); got != want {
+			t.Errorf("Severity(%s) = %s, want %s", eventName, got, want)
+		}
+	}
+
+	for _, invalid := range []string{`[`, `{"DeleteBucket": "urgent"}`, `{"Delete[": "warn"}`} {
+		if _, err := parseSeverityRules(invalid); err == nil {
+			t.Errorf("expected an error for %s", invalid)
+		}
+	}
+}
+
+func TestFilterRecordsSeverityRules(t *testing.T) {
+	slack := captureSlack(t)
+	setEnv(t, "SEVERITY_RULES", `{"DeleteBucket": "critical"}`)
+
+	deleteBucket := consoleRecord("DeleteBucket", "delete-bucket")
+	deleteBucket["eventSource"] = "s3.amazonaws.com"
+	logFile := cloudTrailFile(deleteBucket, consoleRecord("CreateTags", "create-tags"))
+	if _, err := FilterRecords(context.Background(), logFile.Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := slack.Bodies()
+	if body := slackBodyFor(bodies, "delete-bucket"); !strings.Contains(body, ":rotating_light: *DeleteBucket* - s3.amazonaws.com") {
+		t.Errorf("expected a critical DeleteBucket alert, got %s", body)
+	}
+	if body := slackBodyFor(bodies, "create-tags"); !strings.Contains(body, ":information_source: *CreateTags* - ec2.amazonaws.com") {
+		t.Errorf("expected an info CreateTags alert, got %s", body)
+	}
+}
+
+func TestSeverityEmoji(t *testing.T) {
+	for severity, want := range map[string]string{
+		severityInfo:     ":information_source:",
+		severityWarn:     ":warning:",
+		severityCritical: ":rotating_light:",
+		"":               ":information_source:",
+	} {
+		if got := severityEmoji(severity); got != want {
+			t.Errorf("severityEmoji(%q) = %s, want %s", severity, got, want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+)
+
+// FilterResult is what FilterRecords made of a log file.
+type FilterResult struct {
+	// Matched are the records that passed the filters, in file order and
+	// without the duplicates of the invocation, whether or not they were
+	// notified.
+	Matched []*CloudTrailRecord
+}
+
+// recordFilter decides which records alert. It's set up from the
+// environment once per log file.
+type recordFilter struct {
+	alwaysAlert map[string]bool
+	regions     regionFilter
+	sources     eventSourceFilter
+	events      eventFilter
+}
+
+func newRecordFilter() recordFilter {
+	return recordFilter{
+		alwaysAlert: alwaysAlertEvents(),
+		regions:     newRegionFilter(),
+		sources:     newEventSourceFilter(),
+		events:      newEventFilter(),
+	}
+}
+
+// Always reports whether the record alerts regardless of the filters.
+func (f recordFilter) Always(record *CloudTrailRecord) bool {
+	return f.alwaysAlert[record.EventName] || rootAlert(record) || errorCodeAlert(record) || loggingDisabledAlert(record)
+}
+
+// ShouldAlert reports whether the record passes the filters and why.
+func (f recordFilter) ShouldAlert(record *CloudTrailRecord) (bool, string) {
+	if reason := malformedRecord(record); reason != "" {
+		return false, "malformed record: " + reason
+	}
+	if f.Always(record) {
+		return true, "always alerts"
+	}
+	if !f.regions.Allowed(record.AwsRegion) {
+		return false, "region not monitored"
+	}
+	if !f.sources.Allowed(record.EventSource) {
+		return false, "event source not monitored"
+	}
+
+	detections := MatchDetections(record)
+	if reason := f.events.SuppressionReason(record, detections); reason != "" {
+		return false, reason
+	}
+	if len(detections) > 0 {
+		var names []string
+		for _, d := range detections {
+			names = append(names, d.Name)
+		}
+		return true, "detected " + strings.Join(names, ", ")
+	}
+	return true, "not suppressed"
+}
+
+// ShouldAlert reports whether a record passes the filters configured in the
+// environment and why, e.g. false and "read-only". It neither logs nor
+// notifies, nor does it skip the duplicates FilterRecords does.
+func ShouldAlert(record *CloudTrailRecord) (bool, string) {
+	return newRecordFilter().ShouldAlert(record)
+}
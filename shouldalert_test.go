@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestShouldAlert(t *testing.T) {
+	readOnly := consoleRecord("DescribeInstances", "read-only")
+	readOnly["readOnly"] = true
+	internal := consoleRecord("CreateTags", "internal")
+	internal["userIdentity"].(map[string]interface{})["invokedBy"] = "AWS Internal"
+	cli := consoleRecord("CreateTags", "cli")
+	cli["userAgent"] = "aws-cli/2.2.5 Python/3.8.8"
+	otherRegion := consoleRecord("CreateTags", "other-region")
+	otherRegion["awsRegion"] = "ap-south-1"
+	iam := consoleRecord("CreateUser", "iam")
+	iam["eventSource"] = "iam.amazonaws.com"
+	malformed := consoleRecord("CreateTags", "malformed")
+	delete(malformed, "eventName")
+	root := consoleRecord("DescribeInstances", "root")
+	root["readOnly"] = true
+	root["userIdentity"].(map[string]interface{})["type"] = "Root"
+	accessKey := consoleRecord("CreateAccessKey", "access-key")
+	accessKey["eventSource"] = "iam.amazonaws.com"
+	accessKey["userAgent"] = "aws-cli/2.2.5 Python/3.8.8"
+	sandboxKey := taggedSessionRecord("sandbox-key", map[string]string{"Environment": "sandbox"})
+	sandboxKey["eventName"] = "CreateAccessKey"
+	sandboxKey["eventSource"] = "iam.amazonaws.com"
+	detections := map[string]string{"ENABLE_DEFAULT_DETECTIONS": "true"}
+	withDetections := func(key, value string) map[string]string {
+		return map[string]string{"ENABLE_DEFAULT_DETECTIONS": "true", key: value}
+	}
+
+	tests := []struct {
+		name   string
+		env    map[string]string
+		record map[string]interface{}
+		alert  bool
+		reason string
+	}{
+		{"console write", nil, consoleRecord("CreateTags", "write"), true, "not suppressed"},
+		{"read-only", nil, readOnly, false, "read-only"},
+		{"internal", nil, internal, false, "internal call"},
+		{"cli", nil, cli, false, "not a console user agent"},
+		{"ignored principal", map[string]string{"IGNORE_PRINCIPALS": "AIDAJU2GYCKZ322Y5JOKC"}, consoleRecord("CreateTags", "ignored"), false, "ignored principal"},
+		{"region", map[string]string{"REGION_ALLOWLIST": "us-east-1"}, otherRegion, false, "region not monitored"},
+		{"event source", map[string]string{"MONITORED_EVENT_SOURCES": "iam.amazonaws.com"}, consoleRecord("CreateTags", "ec2"), false, "event source not monitored"},
+		{"monitored event source", map[string]string{"MONITORED_EVENT_SOURCES": "iam.amazonaws.com"}, iam, true, "not suppressed"},
+		{"allowlist", map[string]string{"FILTER_MODE": "allowlist", "MONITORED_EVENTS": "ConsoleLogin"}, consoleRecord("CreateTags", "allowlist"), false, "not in MONITORED_EVENTS"},
+		{"malformed", nil, malformed, false, "malformed record: eventName is missing"},
+		{"root", map[string]string{"ALERT_ON_ROOT": "true"}, root, true, "always alerts"},
+		{"detection", detections, accessKey, true, "detected NEW_ACCESS_KEY"},
+		{"detection of ignored principal", withDetections("IGNORE_PRINCIPALS", "AIDAJU2GYCKZ322Y5JOKC"), accessKey, false, "ignored principal"},
+		{"detection of ignored identity type", withDetections("IGNORE_IDENTITY_TYPES", "IAMUser"), accessKey, false, "ignored identity type"},
+		{"detection of suppressed environment", withDetections("SUPPRESS_ENVIRONMENTS", "sandbox"), sandboxKey, false, "suppressed environment"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				setEnv(t, key, value)
+			}
+			alert, reason := ShouldAlert(typedRecord(tt.record))
+			if alert != tt.alert || reason != tt.reason {
+				t.Errorf("ShouldAlert() = %v, %q, want %v, %q", alert, reason, tt.alert, tt.reason)
+			}
+		})
+	}
+}
+
+func TestFilterRecordsMatched(t *testing.T) {
+	captureSlack(t)
+	setEnv(t, "WORKER_CONCURRENCY", "8")
+
+	readOnly := consoleRecord("DescribeInstances", "read-only")
+	readOnly["readOnly"] = true
+	logFile := cloudTrailFile(
+		consoleRecord("CreateTags", "event-1"),
+		readOnly,
+		consoleRecord("RunInstances", "event-2"),
+		consoleRecord("CreateTags", "event-1"),
+		consoleRecord("DeleteTags", "event-3"),
+	)
+	result, err := FilterRecords(context.Background(), logFile.Stream(), testS3Record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for _, record := range result.Matched {
+		ids = append(ids, record.EventID)
+	}
+	if len(ids) != 3 || ids[0] != "event-1" || ids[1] != "event-2" || ids[2] != "event-3" {
+		t.Errorf("expected event-1, event-2 and event-3 in file order, got %v", ids)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	signInPassword   = "password"
+	signInSAML       = "saml"
+	signInSSO        = "sso"
+	signInFederated  = "federated"
+	signInSwitchRole = "switch-role"
+)
+
+// SignIn describes how an AwsConsoleSignIn event authenticated.
+type SignIn struct {
+	Method  string
+	Root    bool
+	MFAUsed bool
+}
+
+// ParseSignIn extracts the authentication method of a console sign-in from
+// the userIdentity and additionalEventData. It returns nil for any other
+// event type.
+func ParseSignIn(record *CloudTrailRecord) *SignIn {
+	if record.EventType != "AwsConsoleSignIn" {
+		return nil
+	}
+
+	userIdentity := record.UserIdentity
+	additional := record.AdditionalEventData
+
+	signIn := &SignIn{
+		Method:  signInPassword,
+		Root:    userIdentity.Type == "Root",
+		MFAUsed: additional["MFAUsed"] == "Yes",
+	}
+
+	switch {
+	case record.EventName == "SwitchRole":
+		signIn.Method = signInSwitchRole
+	case userIdentity.Type == "SAMLUser" || additional["SamlProviderArn"] != nil:
+		signIn.Method = signInSAML
+	case userIdentity.Type == "AssumedRole" && strings.Contains(userIdentity.ARN, "/AWSReservedSSO_"):
+		signIn.Method = signInSSO
+	case userIdentity.Type == "AssumedRole":
+		signIn.Method = signInFederated
+	}
+
+	return signIn
+}
+
+// SingleSignOn reports whether the sign-in went through an identity provider
+// rather than an IAM or root password.
+func (s *SignIn) SingleSignOn() bool {
+	switch s.Method {
+	case signInSAML, signInSSO, signInFederated:
+		return true
+	}
+	return false
+}
+
+func (s *SignIn) Severity() string {
+	switch {
+	case s.Root:
+		return severityCritical
+	case s.Method == signInPassword:
+		return severityWarn
+	}
+	return severityInfo
+}
+
+func (s *SignIn) Summary() string {
+	identity := "IAM user"
+	switch {
+	case s.Root:
+		identity = "root"
+	case s.SingleSignOn():
+		identity = "single sign-on"
+	case s.Method == signInSwitchRole:
+		identity = "role"
+	}
+
+	mfa := "no"
+	if s.MFAUsed {
+		mfa = "yes"
+	}
+	return fmt.Sprintf("Sign-in: %s (%s), MFA: %s", s.Method, identity, mfa)
+}
+
+// signInAlerts decides whether a ConsoleLogin should alert. ALERT_NON_SSO_SIGNIN
+// alerts on password sign-ins only while WATCH_CONSOLE_LOGIN alerts on all.
+func signInAlerts(signIn *SignIn) bool {
+	if signIn == nil {
+		return getEnvBool("WATCH_CONSOLE_LOGIN", false)
+	}
+	if getEnvBool("ALERT_NON_SSO_SIGNIN", false) {
+		return !signIn.SingleSignOn()
+	}
+	return getEnvBool("WATCH_CONSOLE_LOGIN", false)
+}
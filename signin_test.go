@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func signInRecord(identityType, arn string, additional map[string]interface{}) map[string]interface{} {
+	record := consoleRecord("ConsoleLogin", "signin-1")
+	record["eventType"] = "AwsConsoleSignIn"
+	record["eventSource"] = "signin.amazonaws.com"
+	record["userAgent"] = "Mozilla/5.0"
+	record["additionalEventData"] = additional
+	record["userIdentity"] = map[string]interface{}{
+		"type":        identityType,
+		"arn":         arn,
+		"principalId": "AIDAJU2GYCKZ322Y5JOKC",
+		"accountId":   "012345678901",
+	}
+	return record
+}
+
+func TestParseSignIn(t *testing.T) {
+	tests := []struct {
+		name   string
+		record map[string]interface{}
+		method string
+		root   bool
+		mfa    bool
+		sso    bool
+	}{
+		{
+			name:   "iam user password",
+			record: signInRecord("IAMUser", "arn:aws:iam::012345678901:user/first.last", map[string]interface{}{"MFAUsed": "Yes", "LoginTo": "https://console.aws.amazon.com"}),
+			method: signInPassword,
+			mfa:    true,
+		},
+		{
+			name:   "root",
+			record: signInRecord("Root", "arn:aws:iam::012345678901:root", map[string]interface{}{"MFAUsed": "No"}),
+			method: signInPassword,
+			root:   true,
+		},
+		{
+			name:   "saml",
+			record: signInRecord("SAMLUser", "", map[string]interface{}{"SamlProviderArn": "arn:aws:iam::012345678901:saml-provider/Okta"}),
+			method: signInSAML,
+			sso:    true,
+		},
+		{
+			name:   "identity center",
+			record: signInRecord("AssumedRole", "arn:aws:sts::012345678901:assumed-role/AWSReservedSSO_Admin_0123456789abcdef/first.last", map[string]interface{}{"MFAUsed": "No"}),
+			method: signInSSO,
+			sso:    true,
+		},
+		{
+			name:   "switch role",
+			record: func() map[string]interface{} { r := signInRecord("AssumedRole", "", nil); r["eventName"] = "SwitchRole"; return r }(),
+			method: signInSwitchRole,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signIn := ParseSignIn(typedRecord(tt.record))
+			if signIn == nil {
+				t.Fatal("expected a sign-in")
+			}
+			if signIn.Method != tt.method {
+				t.Errorf("Method = %s, want %s", signIn.Method, tt.method)
+			}
+			if signIn.Root != tt.root {
+				t.Errorf("Root = %v, want %v", signIn.Root, tt.root)
+			}
+			if signIn.MFAUsed != tt.mfa {
+				t.Errorf("MFAUsed = %v, want %v", signIn.MFAUsed, tt.mfa)
+			}
+			if signIn.SingleSignOn() != tt.sso {
+				t.Errorf("SingleSignOn() = %v, want %v", signIn.SingleSignOn(), tt.sso)
+			}
+		})
+	}
+
+	if ParseSignIn(typedRecord(consoleRecord("CreateTags", "x"))) != nil {
+		t.Error("expected nil for an API call")
+	}
+}
+
+func TestConsoleLoginRouting(t *testing.T) {
+	password := signInRecord("IAMUser", "arn:aws:iam::012345678901:user/first.last", map[string]interface{}{"MFAUsed": "No"})
+	sso := signInRecord("AssumedRole", "arn:aws:sts::012345678901:assumed-role/AWSReservedSSO_Admin_0123456789abcdef/first.last", nil)
+	sso["eventID"] = "signin-2"
+
+	tests := []struct {
+		name     string
+		env      map[string]string
+		messages int
+	}{
+		{name: "suppressed by default", messages: 0},
+		{name: "watch all sign-ins", env: map[string]string{"WATCH_CONSOLE_LOGIN": "true"}, messages: 2},
+		{name: "non-sso only", env: map[string]string{"WATCH_CONSOLE_LOGIN": "true", "ALERT_NON_SSO_SIGNIN": "true"}, messages: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slack := captureSlack(t)
+			for k, v := range tt.env {
+				setEnv(t, k, v)
+			}
+
+			logFile := cloudTrailFile(password, sso)
+			if _, err := FilterRecords(context.Background(), logFile.Stream(), testS3Record); err != nil {
+				t.Fatal(err)
+			}
+
+			bodies := slack.Bodies()
+			if len(bodies) != tt.messages {
+				t.Fatalf("expected %d messages, got %d", tt.messages, len(bodies))
+			}
+			if tt.messages == 1 && !strings.Contains(bodies[0], "Sign-in: password (IAM user)") {
+				t.Errorf("expected the password sign-in, got %s", bodies[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// slackAPIURL is the Slack Web API used with SLACK_BOT_TOKEN, a variable so
+// tests can point it elsewhere.
+var slackAPIURL = "https://slack.com/api"
+
+// Longest JSON document included in a thread reply, Slack truncates messages
+// of more than 40,000 characters.
+const maxSlackThreadJSONLength = 3000
+
+// slackMessage is the part of a chat.postMessage response used to reply in
+// the thread of a posted message.
+type slackMessage struct {
+	OK      bool   `json:"ok"`
+	Error   string `json:"error"`
+	Channel string `json:"channel"`
+	TS      string `json:"ts"`
+}
+
+// PostSlackMessage sends a message with chat.postMessage and returns the
+// channel and timestamp Slack identifies it by.
+func PostSlackMessage(ctx context.Context, token string, slackBody []byte) (slackMessage, error) {
+	var message slackMessage
+	body, err := postToSlack(ctx, slackAPIURL+"/chat.postMessage", token, slackBody)
+	if err != nil {
+		return message, err
+	}
+	if err := json.Unmarshal(body, &message); err != nil {
+		return message, fmt.Errorf("decoding chat.postMessage response: %w", err)
+	}
+	if !message.OK {
+		return message, fmt.Errorf("Slack chat.postMessage failed: %s", message.Error)
+	}
+	return message, nil
+}
+
+// slackThreadReply renders the requestParameters and responseElements of a
+// record as a reply to a posted message. It returns nil when the record has
+// neither.
+func slackThreadReply(parent slackMessage, record *CloudTrailRecord) []byte {
+	if record == nil {
+		return nil
+	}
+
+	var sections []string
+	for _, part := range []struct {
+		title string
+		value interface{}
+	}{
+		{"Request parameters", record.Raw["requestParameters"]},
+		{"Response elements", record.Raw["responseElements"]},
+	} {
+		if part.value == nil {
+			continue
+		}
+		doc, _ := json.MarshalIndent(part.value, "", "  ")
+		sections = append(sections, fmt.Sprintf("*%s*\n```%s```", part.title, truncate(string(doc), maxSlackThreadJSONLength)))
+	}
+	if len(sections) == 0 {
+		return nil
+	}
+
+	reply, _ := json.Marshal(map[string]string{
+		"channel":   parent.Channel,
+		"thread_ts": parent.TS,
+		"text":      strings.Join(sections, "\n"),
+	})
+	return reply
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+type slackAPICall struct {
+	path, auth string
+	body       map[string]interface{}
+}
+
+type slackAPICapture struct {
+	sync.Mutex
+	calls []slackAPICall
+}
+
+// captureSlackAPI points the Slack Web API at a fake that answers each call
+// with response.
+func captureSlackAPI(t *testing.T, response string) *slackAPICapture {
+	c := &slackAPICapture{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := ioutil.ReadAll(r.Body)
+		call := slackAPICall{path: r.URL.Path, auth: r.Header.Get("Authorization")}
+		json.Unmarshal(raw, &call.body)
+		c.Lock()
+		c.calls = append(c.calls, call)
+		c.Unlock()
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(srv.Close)
+
+	prev := slackAPIURL
+	slackAPIURL = srv.URL + "/api"
+	t.Cleanup(func() { slackAPIURL = prev })
+	return c
+}
+
+func TestFilterRecordsSlackThreadReply(t *testing.T) {
+	api := captureSlackAPI(t, `{"ok":true,"channel":"C012AB3CD","ts":"1621018999.000100","message":{}}`)
+	setEnv(t, "SLACK_BOT_TOKEN", "xoxb-test")
+	setEnv(t, "SLACK_CHANNEL", "#security")
+
+	record := consoleRecord("CreateTags", "event-1")
+	record["requestParameters"] = map[string]interface{}{"resourcesSet": map[string]interface{}{"items": []interface{}{map[string]interface{}{"resourceId": "i-0123456789abcdef0"}}}}
+	record["responseElements"] = map[string]interface{}{"_return": true}
+
+	if _, err := FilterRecords(context.Background(), cloudTrailFile(record).Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(api.calls) != 2 {
+		t.Fatalf("expected the message and a thread reply, got %d calls", len(api.calls))
+	}
+	for _, call := range api.calls {
+		if call.path != "/api/chat.postMessage" || call.auth != "Bearer xoxb-test" {
+			t.Errorf("unexpected call to %s with %q", call.path, call.auth)
+		}
+	}
+
+	message, reply := api.calls[0].body, api.calls[1].body
+	if message["channel"] != "#security" || message["blocks"] == nil || message["thread_ts"] != nil {
+		t.Errorf("unexpected message %v", message)
+	}
+	if reply["channel"] != "C012AB3CD" || reply["thread_ts"] != "1621018999.000100" {
+		t.Errorf("reply not threaded: %v", reply)
+	}
+	text, _ := reply["text"].(string)
+	if !strings.Contains(text, "*Request parameters*\n```{") || !strings.Contains(text, `"resourceId": "i-0123456789abcdef0"`) ||
+		!strings.Contains(text, "*Response elements*") || !strings.Contains(text, `"_return": true`) {
+		t.Errorf("unexpected reply text %q", text)
+	}
+}
+
+func TestFilterRecordsSlackThreadReplySkipped(t *testing.T) {
+	api := captureSlackAPI(t, `{"ok":true,"channel":"C012AB3CD","ts":"1621018999.000100"}`)
+	setEnv(t, "SLACK_BOT_TOKEN", "xoxb-test")
+
+	if _, err := FilterRecords(context.Background(), cloudTrailFile(consoleRecord("CreateTags", "event-1")).Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+	if len(api.calls) != 1 {
+		t.Errorf("expected no reply for a record without parameters, got %d calls", len(api.calls))
+	}
+}
+
+func TestFilterRecordsSlackWebhookOnly(t *testing.T) {
+	api := captureSlackAPI(t, `{"ok":true}`)
+	slack := captureSlack(t)
+
+	record := consoleRecord("CreateTags", "event-1")
+	record["requestParameters"] = map[string]interface{}{"resourcesSet": map[string]interface{}{}}
+	if _, err := FilterRecords(context.Background(), cloudTrailFile(record).Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(slack.Bodies()); n != 1 {
+		t.Errorf("expected 1 webhook message, got %d", n)
+	}
+	if len(api.calls) != 0 {
+		t.Errorf("expected no Web API calls without a bot token, got %d", len(api.calls))
+	}
+}
+
+func TestPostSlackMessageError(t *testing.T) {
+	captureSlackAPI(t, `{"ok":false,"error":"channel_not_found"}`)
+
+	message, err := PostSlackMessage(context.Background(), "xoxb-test", []byte(`{"channel":"#missing","text":"hi"}`))
+	if err == nil || !strings.Contains(err.Error(), "channel_not_found") {
+		t.Errorf("expected channel_not_found, got %v", err)
+	}
+	if message.OK || message.Error != "channel_not_found" || message.TS != "" {
+		t.Errorf("unexpected response %+v", message)
+	}
+}
+
+func TestFilterRecordsSlackMessageTimestamp(t *testing.T) {
+	captureSlackAPI(t, `{"ok":true,"channel":"C012AB3CD","ts":"1621018999.000100"}`)
+	setEnv(t, "SLACK_BOT_TOKEN", "xoxb-test")
+	setEnv(t, "DEDUPE_TABLE", "cloudtrail-alerts")
+	fake := withFakeDynamoDB(t)
+	hook := logtest.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+
+	if _, err := FilterRecords(context.Background(), cloudTrailFile(consoleRecord("CreateTags", "event-1")).Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+
+	var posted *logrus.Entry
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Posted Slack message" {
+			posted = entry
+		}
+	}
+	if posted == nil {
+		t.Fatal("expected the posted message to be logged")
+	}
+	for field, want := range map[string]string{"event_id": "event-1", "slack_channel": "C012AB3CD", "slack_ts": "1621018999.000100"} {
+		if posted.Data[field] != want {
+			t.Errorf("%s = %v, want %s", field, posted.Data[field], want)
+		}
+	}
+
+	if len(fake.updates) != 1 {
+		t.Fatalf("expected the message to be recorded in the table, got %d updates", len(fake.updates))
+	}
+	update := fake.updates[0]
+	if aws.StringValue(update.Key["event_id"].S) != "event-1" ||
+		aws.StringValue(update.ExpressionAttributeValues[":channel"].S) != "C012AB3CD" ||
+		aws.StringValue(update.ExpressionAttributeValues[":ts"].S) != "1621018999.000100" {
+		t.Errorf("unexpected update %v", update)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"sync"
+	"text/template"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultSlackTemplate renders an AlertEvent as a Slack Block Kit message.
+// SLACK_TEMPLATE replaces it.
+const defaultSlackTemplate = `
+{
+  "channel": "{{slackChannel .EventSource}}",
+  "text": "Not Used",
+  "blocks": [
+    {
+      "type": "section",
+      "text": {
+        "type": "mrkdwn",
+        "text": "{{severityEmoji .Severity}} *{{.EventName}}* - {{.EventSource}}"
+      }
+    },{{slackDetailsBlock .Severity .Details}}
+    {
+      "type": "context",
+      "elements": [
+        {
+          "type": "mrkdwn",
+          "text": "{{.Account}}"
+        },
+        {
+          "type": "mrkdwn",
+          "text": "{{.UserName}}"
+        },{{slackContextElement .SourceIP}}{{slackContextElement (recipientAccountSummary .RecipientAccountID)}}{{slackContextElement (extraFieldsSummary .ExtraFields)}}{{slackContextElement (resourcesSummary .Resources)}}{{if .Age}}{{slackContextElement (printf "%s old" .Age)}}{{end}}{{if .RunbookURL}}{{slackContextElement (printf "<%s|Runbook>" .RunbookURL)}}{{end}}
+        {
+          "type": "mrkdwn",
+          "text": "<{{.EventURL}}|{{.EventTime}}>"
+        }
+      ]
+    }
+  ]
+}
+`
+
+var slackTemplateFuncs = template.FuncMap{
+	"slackChannel":            slackChannel,
+	"slackDetailsBlock":       slackDetailsBlock,
+	"slackContextElement":     slackContextElement,
+	"severityEmoji":           severityEmoji,
+	"resourcesSummary":        resourcesSummary,
+	"extraFieldsSummary":      extraFieldsSummary,
+	"recipientAccountSummary": recipientAccountSummary,
+	// json encodes a value, quotes included, for use in a JSON document.
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+var defaultSlackTmpl = template.Must(template.New("slack").Funcs(slackTemplateFuncs).Parse(defaultSlackTemplate))
+
+// parseSlackTemplate compiles a message template and renders it once with a
+// sample alert so references to unknown fields are caught before any event
+// is notified.
+func parseSlackTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("slack").Funcs(slackTemplateFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := tmpl.Execute(new(bytes.Buffer), sampleAlertEvent()); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// sampleAlertEvent is an alert for the self-test record, with Record set so
+// templates can refer to the fields of the CloudTrail event.
+func sampleAlertEvent() AlertEvent {
+	record := selfTestRecord()
+	return AlertEvent{
+		EventName:   record.EventName,
+		EventSource: record.EventSource,
+		EventID:     record.EventID,
+		EventTime:   record.EventTime,
+		Region:      record.AwsRegion,
+		UserName:    record.UserIdentity.UserName,
+		Severity:    severityInfo,
+		Record:      record,
+	}
+}
+
+var (
+	slackTemplateMu   sync.Mutex
+	slackTemplateText string
+	slackTmpl         *template.Template
+)
+
+// slackTemplate returns the compiled SLACK_TEMPLATE, or the default template
+// when it is unset or invalid.
+func slackTemplate() *template.Template {
+	text := os.Getenv("SLACK_TEMPLATE")
+
+	slackTemplateMu.Lock()
+	defer slackTemplateMu.Unlock()
+	if slackTmpl == nil || slackTemplateText != text {
+		slackTmpl, slackTemplateText = defaultSlackTmpl, text
+		if text != "" {
+			if tmpl, err := parseSlackTemplate(text); err != nil {
+				log.Warnf("Ignoring invalid SLACK_TEMPLATE: %v", err)
+			} else {
+				slackTmpl = tmpl
+			}
+		}
+	}
+	return slackTmpl
+}
+
+func slackEventBody(alert AlertEvent) string {
+	var buf bytes.Buffer
+	if err := slackTemplate().Execute(&buf, alert); err != nil {
+		log.Warnf("Rendering SLACK_TEMPLATE, using the default: %v", err)
+		buf.Reset()
+		defaultSlackTmpl.Execute(&buf, alert)
+	}
+	return buf.String()
+}
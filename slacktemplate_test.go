@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestSlackTemplateCustom(t *testing.T) {
+	setEnv(t, "SLACK_TEMPLATE", `{"text": {{json (printf "%s by %s in %s" .EventName .UserName .Region)}}, "severity": {{json .Severity}}}`)
+
+	body := slackEventBody(AlertEvent{EventName: "CreateTags", UserName: `first "quoted" last`, Region: "us-east-1", Severity: severityWarn})
+
+	var message map[string]string
+	if err := json.Unmarshal([]byte(body), &message); err != nil {
+		t.Fatalf("rendered invalid JSON %s: %v", body, err)
+	}
+	if message["text"] != `CreateTags by first "quoted" last in us-east-1` || message["severity"] != severityWarn {
+		t.Errorf("unexpected message %v", message)
+	}
+}
+
+func TestSlackTemplateInvalid(t *testing.T) {
+	want := slackEventBody(AlertEvent{EventName: "CreateTags"})
+
+	for _, text := range []string{`{"text": "{{.EventName"}`, `{"text": "{{.NoSuchField}}"}`} {
+		t.Run(text, func(t *testing.T) {
+			hook := logtest.NewGlobal()
+			defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+			setEnv(t, "SLACK_TEMPLATE", text)
+
+			if got := slackEventBody(AlertEvent{EventName: "CreateTags"}); got != want {
+				t.Errorf("expected the default template, got %s", got)
+			}
+			entry := hook.LastEntry()
+			if entry == nil || entry.Level != logrus.WarnLevel || !strings.Contains(entry.Message, "SLACK_TEMPLATE") {
+				t.Errorf("expected a warning, got %v", entry)
+			}
+		})
+	}
+}
+
+func TestSlackTemplateRecordFields(t *testing.T) {
+	hook := logtest.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+	setEnv(t, "SLACK_TEMPLATE", `{"text": "{{.Record.EventSource}} {{.Record.UserIdentity.Type}}"}`)
+
+	record := typedRecord(consoleRecord("CreateTags", "event-1"))
+	if body := slackEventBody(AlertEvent{EventName: "CreateTags", Record: record}); body != `{"text": "ec2.amazonaws.com IAMUser"}` {
+		t.Errorf("unexpected message %s", body)
+	}
+	if entry := hook.LastEntry(); entry != nil && entry.Level == logrus.WarnLevel {
+		t.Errorf("unexpected warning %q", entry.Message)
+	}
+}
+
+func TestFilterRecordsSlackTemplate(t *testing.T) {
+	slack := captureSlack(t)
+	setEnv(t, "SLACK_TEMPLATE", `{"text": "{{.EventName}} {{.EventID}}"}`)
+
+	if _, err := FilterRecords(context.Background(), cloudTrailFile(consoleRecord("CreateTags", "event-1")).Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+	if bodies := slack.Bodies(); len(bodies) != 1 || bodies[0] != `{"text": "CreateTags event-1"}` {
+		t.Errorf("unexpected messages %v", bodies)
+	}
+}
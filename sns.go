@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+)
+
+var (
+	snsClient   snsiface.SNSAPI
+	snsClientMu sync.Mutex
+)
+
+// snsAlert is the message published to SNS_TOPIC_ARN, carrying the same
+// fields as the "Event" log line.
+type snsAlert struct {
+	UserName    string `json:"user_name"`
+	EventName   string `json:"event_name"`
+	EventSource string `json:"event_source"`
+	AccountID   string `json:"account_id"`
+	EventID     string `json:"event_id"`
+	S3URI       string `json:"s3_uri"`
+	EventTime   string `json:"event_time"`
+	Severity    string `json:"severity"`
+}
+
+func newSNSAlert(alert AlertEvent) snsAlert {
+	return snsAlert{
+		UserName:    alert.UserName,
+		EventName:   alert.EventName,
+		EventSource: alert.EventSource,
+		AccountID:   alert.AccountID,
+		EventID:     alert.EventID,
+		S3URI:       alert.S3URI,
+		EventTime:   alert.EventTime,
+		Severity:    alert.Severity,
+	}
+}
+
+// PublishToSNS publishes an alert as JSON. The event name, source, account
+// and severity are also set as message attributes so subscriptions can
+// filter on them.
+func PublishToSNS(ctx context.Context, client snsiface.SNSAPI, topicArn string, alert snsAlert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	attributes := map[string]*sns.MessageAttributeValue{}
+	for name, value := range map[string]string{
+		"event_name":   alert.EventName,
+		"event_source": alert.EventSource,
+		"account_id":   alert.AccountID,
+		"severity":     alert.Severity,
+	} {
+		// SNS rejects attributes with an empty value.
+		if value == "" {
+			continue
+		}
+		attributes[name] = &sns.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(value),
+		}
+	}
+
+	_, err = client.PublishWithContext(ctx, &sns.PublishInput{
+		TopicArn:          aws.String(topicArn),
+		Message:           aws.String(string(body)),
+		MessageAttributes: attributes,
+	})
+	if err != nil {
+		return fmt.Errorf("publishing %s to %s: %w", alert.EventID, topicArn, err)
+	}
+	return nil
+}
+
+func defaultSNSClient() snsiface.SNSAPI {
+	snsClientMu.Lock()
+	defer snsClientMu.Unlock()
+	if snsClient == nil {
+		client := sns.New(session.Must(session.NewSession()))
+		traceAWSClient(client.Client)
+		snsClient = client
+	}
+	return snsClient
+}
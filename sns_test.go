@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+)
+
+type fakeSNS struct {
+	snsiface.SNSAPI
+	sync.Mutex
+	inputs []*sns.PublishInput
+}
+
+func (f *fakeSNS) PublishWithContext(ctx aws.Context, input *sns.PublishInput, opts ...request.Option) (*sns.PublishOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.inputs = append(f.inputs, input)
+	return &sns.PublishOutput{MessageId: aws.String("message-1")}, nil
+}
+
+func TestPublishToSNS(t *testing.T) {
+	fake := &fakeSNS{}
+	record := typedRecord(consoleRecord("CreateTags", "event-1"))
+
+	if err := PublishToSNS(context.Background(), fake, "arn:aws:sns:us-east-1:012345678901:alerts", newSNSAlert(AlertEvent{
+		EventName:   record.EventName,
+		EventSource: record.EventSource,
+		EventID:     record.EventID,
+		EventTime:   record.EventTime,
+		AccountID:   record.UserIdentity.AccountID,
+		UserName:    "first.last",
+		S3URI:       "s3://b/k",
+		Severity:    severityWarn,
+	})); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.inputs) != 1 {
+		t.Fatalf("expected 1 publish, got %d", len(fake.inputs))
+	}
+	input := fake.inputs[0]
+
+	if aws.StringValue(input.TopicArn) != "arn:aws:sns:us-east-1:012345678901:alerts" {
+		t.Errorf("TopicArn = %s", aws.StringValue(input.TopicArn))
+	}
+
+	var alert map[string]string
+	if err := json.Unmarshal([]byte(aws.StringValue(input.Message)), &alert); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"user_name":    "first.last",
+		"event_name":   "CreateTags",
+		"event_source": "ec2.amazonaws.com",
+		"account_id":   "012345678901",
+		"event_id":     "event-1",
+		"s3_uri":       "s3://b/k",
+		"event_time":   "2021-05-14T19:03:40Z",
+		"severity":     severityWarn,
+	}
+	for k, v := range want {
+		if alert[k] != v {
+			t.Errorf("%s = %q, want %q", k, alert[k], v)
+		}
+	}
+
+	for _, name := range []string{"event_name", "event_source", "account_id", "severity"} {
+		attribute, ok := input.MessageAttributes[name]
+		if !ok || aws.StringValue(attribute.DataType) != "String" || aws.StringValue(attribute.StringValue) != want[name] {
+			t.Errorf("attribute %s = %v, want %q", name, attribute, want[name])
+		}
+	}
+}
+
+func TestSNSTopicAlongsideSlack(t *testing.T) {
+	slack := captureSlack(t)
+	fake := &fakeSNS{}
+	snsClient = fake
+	defer func() { snsClient = nil }()
+	setEnv(t, "SNS_TOPIC_ARN", "arn:aws:sns:us-east-1:012345678901:alerts")
+
+	logFile := cloudTrailFile(consoleRecord("CreateTags", "event-1"), consoleRecord("DescribeInstances", "event-2"))
+	if _, err := FilterRecords(context.Background(), logFile.Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.inputs) != 1 || len(slack.Bodies()) != 1 {
+		t.Errorf("expected 1 publish and 1 Slack message, got %d and %d", len(fake.inputs), len(slack.Bodies()))
+	}
+}
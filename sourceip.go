@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// IPInfo is what an IPResolver knows about the origin of an address.
+type IPInfo struct {
+	Country string
+	ASN     uint
+	Org     string
+}
+
+// IPResolver looks up the country and ASN of a public IP address, for
+// example from a GeoIP database.
+type IPResolver interface {
+	Resolve(ip net.IP) (IPInfo, error)
+}
+
+var privateNetworks = parseCIDRs("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7")
+
+// ipResolver enriches source IPs when set, taking the place of the networks
+// of SOURCE_IP_NETWORKS.
+var ipResolver IPResolver
+
+// sourceIPNetworks names the organisation of each network, e.g. an office
+// or VPN egress range. The most specific network containing an address wins.
+type sourceIPNetworks []sourceIPNetwork
+
+type sourceIPNetwork struct {
+	network *net.IPNet
+	name    string
+}
+
+func (n sourceIPNetworks) Resolve(ip net.IP) (IPInfo, error) {
+	var info IPInfo
+	best := -1
+	for _, network := range n {
+		if ones, _ := network.network.Mask.Size(); network.network.Contains(ip) && ones > best {
+			info.Org, best = network.name, ones
+		}
+	}
+	return info, nil
+}
+
+// parseSourceIPNetworks parses a SOURCE_IP_NETWORKS JSON object mapping CIDRs
+// to names.
+func parseSourceIPNetworks(v string) (sourceIPNetworks, error) {
+	var names map[string]string
+	if err := json.Unmarshal([]byte(v), &names); err != nil {
+		return nil, err
+	}
+	var networks sourceIPNetworks
+	for cidr, name := range names {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, sourceIPNetwork{network, name})
+	}
+	return networks, nil
+}
+
+var (
+	sourceIPNetworksMu     sync.Mutex
+	sourceIPNetworksEnv    string
+	sourceIPNetworksParsed sourceIPNetworks
+)
+
+// activeSourceIPNetworks returns the SOURCE_IP_NETWORKS, parsing them again
+// only when they change.
+func activeSourceIPNetworks() sourceIPNetworks {
+	env := os.Getenv("SOURCE_IP_NETWORKS")
+
+	sourceIPNetworksMu.Lock()
+	defer sourceIPNetworksMu.Unlock()
+	if sourceIPNetworksEnv != env {
+		sourceIPNetworksParsed, sourceIPNetworksEnv = nil, env
+		if env != "" {
+			networks, err := parseSourceIPNetworks(env)
+			if err != nil {
+				log.Warnf("Ignoring invalid SOURCE_IP_NETWORKS: %v", err)
+			} else {
+				sourceIPNetworksParsed = networks
+			}
+		}
+	}
+	return sourceIPNetworksParsed
+}
+
+// sourceIPResolver returns ipResolver or, without it, the networks of
+// SOURCE_IP_NETWORKS. It is nil when neither is set.
+func sourceIPResolver() IPResolver {
+	if ipResolver != nil {
+		return ipResolver
+	}
+	if networks := activeSourceIPNetworks(); len(networks) > 0 {
+		return networks
+	}
+	return nil
+}
+
+// describeSourceIP renders the sourceIPAddress of a record. Calls made by AWS
+// services carry the service name instead of an address and are returned
+// unchanged.
+func describeSourceIP(sourceIP string) string {
+	ip := net.ParseIP(sourceIP)
+	if ip == nil {
+		return sourceIP
+	}
+	if isPrivateIP(ip) {
+		return fmt.Sprintf("%s (private)", sourceIP)
+	}
+	resolver := sourceIPResolver()
+	if resolver == nil {
+		return sourceIP
+	}
+
+	info, err := resolver.Resolve(ip)
+	if err != nil {
+		return sourceIP
+	}
+	var origin []string
+	if info.Country != "" {
+		origin = append(origin, info.Country)
+	}
+	if info.ASN != 0 {
+		origin = append(origin, fmt.Sprintf("AS%d", info.ASN))
+	}
+	if info.Org != "" {
+		origin = append(origin, info.Org)
+	}
+	if len(origin) == 0 {
+		return sourceIP
+	}
+	return fmt.Sprintf("%s (%s)", sourceIP, strings.Join(origin, ", "))
+}
+
+func isPrivateIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+		return true
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
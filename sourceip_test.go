@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+type fakeResolver map[string]IPInfo
+
+func (f fakeResolver) Resolve(ip net.IP) (IPInfo, error) {
+	info, ok := f[ip.String()]
+	if !ok {
+		return IPInfo{}, errors.New("not found")
+	}
+	return info, nil
+}
+
+func TestDescribeSourceIP(t *testing.T) {
+	ipResolver = fakeResolver{"1.1.1.1": {Country: "AU", ASN: 13335, Org: "Cloudflare"}}
+	defer func() { ipResolver = nil }()
+
+	tests := []struct {
+		sourceIP string
+		want     string
+	}{
+		{"1.1.1.1", "1.1.1.1 (AU, AS13335, Cloudflare)"},
+		{"8.8.8.8", "8.8.8.8"},
+		{"10.1.2.3", "10.1.2.3 (private)"},
+		{"fd00::1", "fd00::1 (private)"},
+		{"AWS Internal", "AWS Internal"},
+		{"ec2.amazonaws.com", "ec2.amazonaws.com"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := describeSourceIP(tt.sourceIP); got != tt.want {
+			t.Errorf("describeSourceIP(%q) = %q, want %q", tt.sourceIP, got, tt.want)
+		}
+	}
+}
+
+func TestDescribeSourceIPWithoutResolver(t *testing.T) {
+	if got := describeSourceIP("1.1.1.1"); got != "1.1.1.1" {
+		t.Errorf("expected the raw address, got %q", got)
+	}
+}
+
+func TestDescribeSourceIPNetworks(t *testing.T) {
+	setEnv(t, "SOURCE_IP_NETWORKS", `{"203.0.113.0/24":"Office","203.0.113.128/25":"Office VPN","2001:db8::/32":"CI"}`)
+
+	tests := []struct {
+		sourceIP string
+		want     string
+	}{
+		{"203.0.113.10", "203.0.113.10 (Office)"},
+		{"203.0.113.200", "203.0.113.200 (Office VPN)"},
+		{"2001:db8::1", "2001:db8::1 (CI)"},
+		{"8.8.8.8", "8.8.8.8"},
+		{"10.1.2.3", "10.1.2.3 (private)"},
+	}
+	for _, tt := range tests {
+		if got := describeSourceIP(tt.sourceIP); got != tt.want {
+			t.Errorf("describeSourceIP(%q) = %q, want %q", tt.sourceIP, got, tt.want)
+		}
+	}
+}
+
+func TestInvalidSourceIPNetworks(t *testing.T) {
+	hook := logtest.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+	setEnv(t, "SOURCE_IP_NETWORKS", `{"203.0.113.0/33":"Office"}`)
+
+	if got := describeSourceIP("203.0.113.10"); got != "203.0.113.10" {
+		t.Errorf("expected the raw address, got %q", got)
+	}
+	if entry := hook.LastEntry(); entry == nil || !strings.Contains(entry.Message, "Ignoring invalid SOURCE_IP_NETWORKS") {
+		t.Errorf("expected a warning, got %v", entry)
+	}
+}
+
+func TestSourceIPInSlackContext(t *testing.T) {
+	slack := captureSlack(t)
+
+	public := consoleRecord("CreateTags", "public")
+	public["sourceIPAddress"] = "1.1.1.1"
+	private := consoleRecord("CreateTags", "private")
+	private["sourceIPAddress"] = "10.1.2.3"
+	missing := consoleRecord("CreateTags", "missing")
+
+	if _, err := FilterRecords(context.Background(), cloudTrailFile(public, private, missing).Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := slack.Bodies()
+	if len(bodies) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(bodies))
+	}
+	for _, body := range bodies {
+		if !json.Valid([]byte(body)) {
+			t.Errorf("invalid Slack body: %s", body)
+		}
+	}
+	if body := slackBodyFor(bodies, "public"); !strings.Contains(body, `"text":"1.1.1.1"`) {
+		t.Errorf("public IP missing: %s", body)
+	}
+	if body := slackBodyFor(bodies, "private"); !strings.Contains(body, `"text":"10.1.2.3 (private)"`) {
+		t.Errorf("private IP missing: %s", body)
+	}
+	if body := slackBodyFor(bodies, "missing"); strings.Count(body, `"type": "mrkdwn"`) != 4 {
+		t.Errorf("expected no source IP element: %s", body)
+	}
+}
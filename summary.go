@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+	log "github.com/sirupsen/logrus"
+)
+
+// Number of users listed in a summary message.
+const maxSummaryActors = 5
+
+// eventSummary counts the events of a log file by user and region when
+// SUMMARY_MODE replaces the per-event notifications with a single message.
+type eventSummary struct {
+	mu      sync.Mutex
+	total   int
+	users   map[string]int
+	regions map[string]int
+}
+
+func newEventSummary() *eventSummary {
+	return &eventSummary{users: map[string]int{}, regions: map[string]int{}}
+}
+
+func (s *eventSummary) Add(alert AlertEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.total++
+	s.users[alert.UserName]++
+	s.regions[alert.Region]++
+}
+
+type summaryCount struct {
+	name  string
+	count int
+}
+
+// byCount orders counts largest first, ties by name.
+func byCount(counts map[string]int) []summaryCount {
+	var sorted []summaryCount
+	for name, count := range counts {
+		sorted = append(sorted, summaryCount{name, count})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].count != sorted[j].count {
+			return sorted[i].count > sorted[j].count
+		}
+		return sorted[i].name < sorted[j].name
+	})
+	return sorted
+}
+
+// Text renders the summary, e.g. "47 actions by 5 users in us-east-1",
+// followed by the most active users.
+func (s *eventSummary) Text(s3URI string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var regions []string
+	for _, region := range byCount(s.regions) {
+		regions = append(regions, region.name)
+	}
+	lines := []string{
+		fmt.Sprintf("*%d %s by %d %s in %s*", s.total, plural(s.total, "action"), len(s.users), plural(len(s.users), "user"), strings.Join(regions, ", ")),
+		s3URI,
+		"Top actors:",
+	}
+
+	actors := byCount(s.users)
+	if len(actors) > maxSummaryActors {
+		actors = actors[:maxSummaryActors]
+	}
+	for _, actor := range actors {
+		lines = append(lines, fmt.Sprintf("• %s - %d", actor.name, actor.count))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return noun
+	}
+	return noun + "s"
+}
+
+type eventSummaryKey struct{}
+
+func withEventSummary(ctx context.Context, summary *eventSummary) context.Context {
+	return context.WithValue(ctx, eventSummaryKey{}, summary)
+}
+
+func eventSummaryFrom(ctx context.Context) *eventSummary {
+	s, _ := ctx.Value(eventSummaryKey{}).(*eventSummary)
+	return s
+}
+
+// sendEventSummary posts the summary of a log file to Slack, if any of its
+// events qualified.
+func sendEventSummary(ctx context.Context, summary *eventSummary, evt events.S3EventRecord) {
+	if summary.total == 0 {
+		return
+	}
+
+	s3URI := objectURI(evt)
+	log.WithFields(log.Fields{
+		"s3_uri":  s3URI,
+		"events":  summary.total,
+		"users":   len(summary.users),
+		"regions": len(summary.regions),
+	}).Info("Event summary")
+
+	webhookUrl, ok := slackWebhook(logAccountID(evt.S3.Object.Key))
+	if !ok {
+		return
+	}
+
+	slackBody, _ := json.Marshal(map[string]interface{}{
+		"channel": os.Getenv("SLACK_CHANNEL"),
+		"text":    "Not Used",
+		"blocks": []interface{}{
+			map[string]interface{}{
+				"type": "section",
+				"text": map[string]string{
+					"type": "mrkdwn",
+					"text": summary.Text(s3URI),
+				},
+			},
+		},
+	})
+
+	if dryRunNotification(ctx, "slack", slackBody) {
+		return
+	}
+	if err := SendSlackNotification(ctx, webhookUrl, slackBody); err != nil {
+		log.Debugln(string(slackBody))
+		log.Debug(err)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestEventSummaryText(t *testing.T) {
+	summary := newEventSummary()
+	for i := 0; i < 47; i++ {
+		alert := AlertEvent{Region: "us-east-1", UserName: fmt.Sprintf("user-%d", i%5)}
+		if i < 30 {
+			alert.UserName = "first.last"
+		}
+		if i >= 40 {
+			alert.Region = "eu-west-1"
+		}
+		summary.Add(alert)
+	}
+
+	want := "*47 actions by 6 users in us-east-1, eu-west-1*\n" +
+		"s3://b/k\n" +
+		"Top actors:\n" +
+		"• first.last - 30\n" +
+		"• user-0 - 4\n" +
+		"• user-1 - 4\n" +
+		"• user-2 - 3\n" +
+		"• user-3 - 3"
+	if got := summary.Text("s3://b/k"); got != want {
+		t.Errorf("Text() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestEventSummaryTextSingular(t *testing.T) {
+	summary := newEventSummary()
+	summary.Add(AlertEvent{Region: "us-east-1", UserName: "first.last"})
+
+	want := "*1 action by 1 user in us-east-1*\ns3://b/k\nTop actors:\n• first.last - 1"
+	if got := summary.Text("s3://b/k"); got != want {
+		t.Errorf("Text() = %q, want %q", got, want)
+	}
+}
+
+func TestFilterRecordsSummaryMode(t *testing.T) {
+	slack := captureSlack(t)
+	setEnv(t, "SUMMARY_MODE", "true")
+
+	var records []map[string]interface{}
+	for i := 0; i < 6; i++ {
+		record := consoleRecord("CreateTags", fmt.Sprintf("event-%d", i))
+		if i%2 == 1 {
+			record["awsRegion"] = "eu-west-1"
+		}
+		records = append(records, record)
+	}
+	records = append(records, consoleRecord("DescribeInstances", "read-only"))
+
+	if _, err := FilterRecords(context.Background(), cloudTrailFile(records...).Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := slack.Bodies()
+	if len(bodies) != 1 {
+		t.Fatalf("expected a single summary message, got %d", len(bodies))
+	}
+	var message struct {
+		Blocks []struct {
+			Text struct {
+				Text string `json:"text"`
+			} `json:"text"`
+		} `json:"blocks"`
+	}
+	if err := json.Unmarshal([]byte(bodies[0]), &message); err != nil {
+		t.Fatal(err)
+	}
+	want := "*6 actions by 1 user in eu-west-1, us-east-1*\n" +
+		"s3://test-harness/" + testS3Record.S3.Object.Key + "\n" +
+		"Top actors:\n" +
+		"• first.last - 6"
+	if len(message.Blocks) != 1 || message.Blocks[0].Text.Text != want {
+		t.Errorf("unexpected summary %s", bodies[0])
+	}
+}
+
+func TestFilterRecordsSummaryModeNothingQualified(t *testing.T) {
+	slack := captureSlack(t)
+	setEnv(t, "SUMMARY_MODE", "true")
+
+	if _, err := FilterRecords(context.Background(), cloudTrailFile(consoleRecord("DescribeInstances", "read-only")).Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(slack.Bodies()); n != 0 {
+		t.Errorf("expected no summary, got %d messages", n)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+// UpdateTrail settings that narrow what a trail records when turned off.
+var trailLoggingSettings = []string{"isMultiRegionTrail", "includeGlobalServiceEvents", "enableLogFileValidation"}
+
+// loggingDisabled reports whether a record stops a trail from logging:
+// StopLogging, DeleteTrail, or an UpdateTrail turning off multi-region
+// logging, global service events or log file validation.
+func loggingDisabled(record *CloudTrailRecord) bool {
+	if record.EventSource != "cloudtrail.amazonaws.com" {
+		return false
+	}
+	switch record.EventName {
+	case "StopLogging", "DeleteTrail":
+		return true
+	case "UpdateTrail":
+		for _, setting := range trailLoggingSettings {
+			if enabled, ok := record.RequestParameters[setting].(bool); ok && !enabled {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// loggingDisabledAlert reports whether the record disables logging and
+// ALERT_ON_LOGGING_DISABLED, on by default, is set. Such records alert at
+// critical severity like an event of ALWAYS_ALERT_EVENTS.
+func loggingDisabledAlert(record *CloudTrailRecord) bool {
+	return getEnvBool("ALERT_ON_LOGGING_DISABLED", true) && loggingDisabled(record)
+}
+
+// loggingDisabledSummary names the trail whose logging a record disables.
+func loggingDisabledSummary(record *CloudTrailRecord) string {
+	trail := stringField(record.RequestParameters, "name")
+	if trail == "" {
+		return "CloudTrail logging disabled"
+	}
+	return "CloudTrail logging disabled for " + trail
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func trailRecord(eventName, eventID string, requestParameters map[string]interface{}) map[string]interface{} {
+	record := consoleRecord(eventName, eventID)
+	record["eventSource"] = "cloudtrail.amazonaws.com"
+	record["requestParameters"] = requestParameters
+	return record
+}
+
+func TestLoggingDisabled(t *testing.T) {
+	for _, tt := range []struct {
+		record map[string]interface{}
+		want   bool
+	}{
+		{trailRecord("StopLogging", "stop", map[string]interface{}{"name": "management"}), true},
+		{trailRecord("DeleteTrail", "delete", map[string]interface{}{"name": "management"}), true},
+		{trailRecord("UpdateTrail", "single-region", map[string]interface{}{"name": "management", "isMultiRegionTrail": false}), true},
+		{trailRecord("UpdateTrail", "no-validation", map[string]interface{}{"name": "management", "enableLogFileValidation": false}), true},
+		{trailRecord("UpdateTrail", "new-bucket", map[string]interface{}{"name": "management", "s3BucketName": "trail-logs"}), false},
+		{trailRecord("StartLogging", "start", map[string]interface{}{"name": "management"}), false},
+		{consoleRecord("StopLogging", "other-source"), false},
+	} {
+		record := typedRecord(tt.record)
+		if got := loggingDisabled(record); got != tt.want {
+			t.Errorf("loggingDisabled(%s) = %v, want %v", record.EventID, got, tt.want)
+		}
+	}
+}
+
+func TestFilterRecordsLoggingDisabled(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		messages int
+	}{
+		{"defaults", nil, 1},
+		{"ignored principal", map[string]string{"IGNORE_PRINCIPALS": "AIDAJU2GYCKZ322Y5JOKC"}, 1},
+		{"allowlist", map[string]string{"FILTER_MODE": "allowlist", "MONITORED_EVENTS": "CreateTags"}, 1},
+		{"quiet hours", map[string]string{"QUIET_HOURS_START": "0", "QUIET_HOURS_END": "23"}, 1},
+		{"region", map[string]string{"REGION_ALLOWLIST": "eu-west-1"}, 1},
+		{"disabled", map[string]string{"ALERT_ON_LOGGING_DISABLED": "false"}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slack := captureSlack(t)
+			for k, v := range tt.env {
+				setEnv(t, k, v)
+			}
+
+			// Made with the CLI so the user agent check would drop it.
+			record := trailRecord("StopLogging", "stop", map[string]interface{}{"name": "management"})
+			record["userAgent"] = "aws-cli/2.2.5"
+			if _, err := FilterRecords(context.Background(), cloudTrailFile(record).Stream(), testS3Record); err != nil {
+				t.Fatal(err)
+			}
+
+			bodies := slack.Bodies()
+			if len(bodies) != tt.messages {
+				t.Fatalf("expected %d messages, got %d", tt.messages, len(bodies))
+			}
+			if tt.messages == 0 {
+				return
+			}
+			for _, want := range []string{":rotating_light: *StopLogging*", "CloudTrail logging disabled for management"} {
+				if !strings.Contains(bodies[0], want) {
+					t.Errorf("message missing %q: %s", want, bodies[0])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var teamsThemeColors = map[string]string{
+	severityInfo:     "0076D7",
+	severityWarn:     "FFA500",
+	severityCritical: "D32F2F",
+}
+
+type teamsFact struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// teamsMessageCard renders an event as an Office 365 connector MessageCard
+// with a button labelled eventURLLabel opening eventURL.
+func teamsMessageCard(eventName, eventSource, userName, account, eventURL, eventURLLabel, severity string, details []string) ([]byte, error) {
+	section := map[string]interface{}{
+		"activityTitle":    fmt.Sprintf("**%s** - %s", eventName, eventSource),
+		"activitySubtitle": account,
+		"facts": []teamsFact{
+			{Name: "Event", Value: eventName},
+			{Name: "Source", Value: eventSource},
+			{Name: "User", Value: userName},
+			{Name: "Account", Value: account},
+		},
+		"markdown": true,
+	}
+	if len(details) > 0 {
+		section["text"] = fmt.Sprintf("**Severity:** %s<br>%s", severity, strings.Join(details, "<br>"))
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"@type":      "MessageCard",
+		"@context":   "https://schema.org/extensions",
+		"summary":    fmt.Sprintf("%s - %s", eventName, eventSource),
+		"themeColor": teamsThemeColors[severity],
+		"sections":   []interface{}{section},
+		"potentialAction": []interface{}{
+			map[string]interface{}{
+				"@type": "OpenUri",
+				"name":  eventURLLabel,
+				"targets": []interface{}{
+					map[string]string{"os": "default", "uri": eventURL},
+				},
+			},
+		},
+	})
+}
+
+func SendTeamsNotification(ctx context.Context, webhookUrl string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookUrl, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Content-Type", "application/json")
+
+	client := notifyHTTPClient()
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer closeResponse(resp)
+
+	// Teams answers a successful webhook call with a plain "1".
+	buf := new(bytes.Buffer)
+	buf.ReadFrom(resp.Body)
+	if resp.StatusCode != http.StatusOK || strings.TrimSpace(buf.String()) != "1" {
+		return fmt.Errorf("Non-ok response returned from Teams: %d %s", resp.StatusCode, buf.String())
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTeamsMessageCard(t *testing.T) {
+	body, err := teamsMessageCard("PutUserPolicy", "iam.amazonaws.com", "first.last", ":maple_leaf: NON-PRD", "https://console.aws.amazon.com/cloudtrail/home?region=us-east-1#/events?EventId=event-1", "View in CloudTrail", severityWarn, []string{"Detections: IAM_ADMIN_GRANT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var card struct {
+		Type       string `json:"@type"`
+		Summary    string `json:"summary"`
+		ThemeColor string `json:"themeColor"`
+		Sections   []struct {
+			ActivityTitle string      `json:"activityTitle"`
+			Text          string      `json:"text"`
+			Facts         []teamsFact `json:"facts"`
+		} `json:"sections"`
+		PotentialAction []struct {
+			Type    string `json:"@type"`
+			Name    string `json:"name"`
+			Targets []struct {
+				URI string `json:"uri"`
+			} `json:"targets"`
+		} `json:"potentialAction"`
+	}
+	if err := json.Unmarshal(body, &card); err != nil {
+		t.Fatal(err)
+	}
+
+	if card.Type != "MessageCard" || card.Summary != "PutUserPolicy - iam.amazonaws.com" || card.ThemeColor != teamsThemeColors[severityWarn] {
+		t.Errorf("unexpected card header %+v", card)
+	}
+	if len(card.Sections) != 1 {
+		t.Fatalf("expected 1 section, got %d", len(card.Sections))
+	}
+	facts := map[string]string{}
+	for _, f := range card.Sections[0].Facts {
+		facts[f.Name] = f.Value
+	}
+	want := map[string]string{"Event": "PutUserPolicy", "Source": "iam.amazonaws.com", "User": "first.last", "Account": ":maple_leaf: NON-PRD"}
+	for k, v := range want {
+		if facts[k] != v {
+			t.Errorf("fact %s = %q, want %q", k, facts[k], v)
+		}
+	}
+	if !strings.Contains(card.Sections[0].Text, "IAM_ADMIN_GRANT") {
+		t.Errorf("details missing from %q", card.Sections[0].Text)
+	}
+	if len(card.PotentialAction) != 1 || card.PotentialAction[0].Type != "OpenUri" || card.PotentialAction[0].Name != "View in CloudTrail" ||
+		!strings.HasSuffix(card.PotentialAction[0].Targets[0].URI, "EventId=event-1") {
+		t.Errorf("unexpected action %+v", card.PotentialAction)
+	}
+}
+
+func TestSendTeamsNotification(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr bool
+	}{
+		{"success", http.StatusOK, "1", false},
+		{"slack style ok", http.StatusOK, "ok", true},
+		{"error", http.StatusBadRequest, "Summary or Text is required.", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			err := SendTeamsNotification(context.Background(), srv.URL, []byte(`{}`))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SendTeamsNotification() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFilterRecordsTeams(t *testing.T) {
+	var cards []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var card map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&card)
+		cards = append(cards, card)
+		w.Write([]byte("1"))
+	}))
+	defer srv.Close()
+	setEnv(t, "TEAMS_WEBHOOK", srv.URL)
+
+	logFile := cloudTrailFile(consoleRecord("CreateTags", "event-1"))
+	if _, err := FilterRecords(context.Background(), logFile.Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 1 || cards[0]["summary"] != "CreateTags - ec2.amazonaws.com" {
+		t.Errorf("expected 1 Teams card, got %v", cards)
+	}
+}
+
+func TestFilterRecordsTeamsResourceLink(t *testing.T) {
+	var cards []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		cards = append(cards, string(body))
+		w.Write([]byte("1"))
+	}))
+	defer srv.Close()
+	setEnv(t, "TEAMS_WEBHOOK", srv.URL)
+	setEnv(t, "LINK_STYLE", "resource")
+
+	record := consoleRecord("PutBucketTagging", "event-1")
+	record["eventSource"] = "s3.amazonaws.com"
+	record["resources"] = []interface{}{map[string]interface{}{"ARN": "arn:aws:s3:::example-bucket"}}
+	if _, err := FilterRecords(context.Background(), cloudTrailFile(record).Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 1 || !strings.Contains(cards[0], `"name":"View bucket"`) || !strings.Contains(cards[0], "/s3/buckets/example-bucket") {
+		t.Errorf("expected a View bucket button, got %v", cards)
+	}
+}
+
+func TestConfiguredNotifiersEmptyTeamsWebhook(t *testing.T) {
+	setEnv(t, "TEAMS_WEBHOOK", "")
+	for _, notifier := range configuredNotifiers() {
+		if n, ok := notifier.(orderedNotifier); ok {
+			notifier = n.Notifier
+		}
+		if _, ok := notifier.(teamsNotifier); ok {
+			t.Error("expected no Teams notifier for an empty TEAMS_WEBHOOK")
+		}
+	}
+}
@@ -45,7 +45,8 @@ data "aws_iam_policy_document" "default" {
 
   statement {
     actions = [
-      "s3:ListBucket"
+      "s3:ListBucket",
+      "s3:GetBucketLocation"
     ]
     resources = [
       data.aws_s3_bucket.default.arn
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// User agents of calls made from the console, extended by
+// CONSOLE_USER_AGENTS and CONSOLE_USER_AGENT_REGEXES.
+var (
+	defaultConsoleUserAgents = []string{
+		"console.amazonaws.com",
+		"signin.amazonaws.com",
+		"Coral/Jakarta",
+		"Coral/Netty4",
+		"AWS CloudWatch Console",
+	}
+	defaultConsoleUserAgentPrefixes = []string{
+		"AWS Signin",
+		"S3Console/",
+		"[S3Console",
+		"Mozilla/",
+		"aws-internal/",
+	}
+	defaultConsoleUserAgentPatterns = []*regexp.Regexp{
+		regexp.MustCompile("console.*.amazonaws.com"),
+		regexp.MustCompile("signin.*.amazonaws.com"),
+	}
+)
+
+// userAgentMatcher recognizes console user agents by exact value, prefix or
+// regular expression.
+type userAgentMatcher struct {
+	exact    map[string]bool
+	prefixes []string
+	patterns []*regexp.Regexp
+}
+
+// newUserAgentMatcher merges the comma separated user agents and regular
+// expressions with the defaults. Invalid expressions are logged and skipped.
+// The default expressions are compiled once, when the package is loaded.
+func newUserAgentMatcher(userAgents, regexes string) *userAgentMatcher {
+	m := &userAgentMatcher{
+		exact:    map[string]bool{},
+		prefixes: defaultConsoleUserAgentPrefixes,
+		patterns: append([]*regexp.Regexp{}, defaultConsoleUserAgentPatterns...),
+	}
+	for _, ua := range defaultConsoleUserAgents {
+		m.exact[ua] = true
+	}
+	for _, ua := range strings.Split(userAgents, ",") {
+		if ua = strings.TrimSpace(ua); ua != "" {
+			m.exact[ua] = true
+		}
+	}
+
+	m.patterns = append(m.patterns, compileUserAgentRegexes("CONSOLE_USER_AGENT_REGEXES", regexes)...)
+	return m
+}
+
+// compileUserAgentRegexes compiles the comma separated regular expressions
+// of the env variable. Invalid expressions are logged and skipped.
+func compileUserAgentRegexes(env, regexes string) []*regexp.Regexp {
+	var patterns []*regexp.Regexp
+	for _, expr := range strings.Split(regexes, ",") {
+		if expr = strings.TrimSpace(expr); expr == "" {
+			continue
+		}
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			log.Warnf("Ignoring invalid %s entry %q: %v", env, expr, err)
+			continue
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns
+}
+
+func (m *userAgentMatcher) Match(ua string) bool {
+	if m.exact[ua] || hasAnyPrefix(ua, m.prefixes) {
+		return true
+	}
+	for _, pattern := range m.patterns {
+		if pattern.MatchString(ua) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	consoleUserAgentsMu  sync.Mutex
+	consoleUserAgentsEnv string
+	consoleUserAgentsM   *userAgentMatcher
+)
+
+// consoleUserAgents returns the matcher of the configured console user
+// agents, compiling it again only when the configuration changes.
+func consoleUserAgents() *userAgentMatcher {
+	userAgents, regexes := os.Getenv("CONSOLE_USER_AGENTS"), os.Getenv("CONSOLE_USER_AGENT_REGEXES")
+	env := userAgents + "\x00" + regexes
+
+	consoleUserAgentsMu.Lock()
+	defer consoleUserAgentsMu.Unlock()
+	if consoleUserAgentsM == nil || consoleUserAgentsEnv != env {
+		consoleUserAgentsM, consoleUserAgentsEnv = newUserAgentMatcher(userAgents, regexes), env
+	}
+	return consoleUserAgentsM
+}
+
+var (
+	suppressedUserAgentsMu  sync.Mutex
+	suppressedUserAgentsEnv string
+	suppressedUserAgentsP   []*regexp.Regexp
+)
+
+// suppressedUserAgent reports whether the user agent matches one of
+// SUPPRESS_USER_AGENT_REGEXES, such as the ones of SaaS tools calling from
+// a browser session.
+func suppressedUserAgent(ua string) bool {
+	for _, pattern := range suppressedUserAgents() {
+		if pattern.MatchString(ua) {
+			return true
+		}
+	}
+	return false
+}
+
+// suppressedUserAgents returns the compiled SUPPRESS_USER_AGENT_REGEXES,
+// compiling them again only when the configuration changes.
+func suppressedUserAgents() []*regexp.Regexp {
+	env := os.Getenv("SUPPRESS_USER_AGENT_REGEXES")
+
+	suppressedUserAgentsMu.Lock()
+	defer suppressedUserAgentsMu.Unlock()
+	if suppressedUserAgentsP == nil || suppressedUserAgentsEnv != env {
+		suppressedUserAgentsP, suppressedUserAgentsEnv = compileUserAgentRegexes("SUPPRESS_USER_AGENT_REGEXES", env), env
+		if suppressedUserAgentsP == nil {
+			suppressedUserAgentsP = []*regexp.Regexp{}
+		}
+	}
+	return suppressedUserAgentsP
+}
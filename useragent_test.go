@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestUserAgentMatcher(t *testing.T) {
+	m := newUserAgentMatcher("AWS-Console-Mobile/2.0, Custom Console", `^aws-cloudshell/\d+`)
+
+	for _, ua := range []string{
+		"console.amazonaws.com",
+		"console.ec2.amazonaws.com",
+		"Mozilla/5.0",
+		"[S3Console/0.4, aws-internal/3 aws-sdk-java/1.11.1002]",
+		"AWS-Console-Mobile/2.0",
+		"Custom Console",
+		"aws-cloudshell/12",
+	} {
+		if !m.Match(ua) {
+			t.Errorf("%q should match", ua)
+		}
+	}
+	for _, ua := range []string{"aws-cli/2.2.5", "AWS-Console-Mobile/2.1", "Boto3/1.17", ""} {
+		if m.Match(ua) {
+			t.Errorf("%q should not match", ua)
+		}
+	}
+}
+
+func TestUserAgentMatcherAWSInternal(t *testing.T) {
+	m := newUserAgentMatcher("", "")
+	for ua, want := range map[string]bool{
+		"aws-internal/3":                        true,
+		"aws-internal/3 aws-sdk-java/1.11.1002": true,
+		"aws-interna":                           false,
+		"aws-internall":                         false,
+		"Boto3/1.17 aws-internal/3":             false,
+	} {
+		if got := m.Match(ua); got != want {
+			t.Errorf("Match(%q) = %v, want %v", ua, got, want)
+		}
+	}
+}
+
+func TestUserAgentMatcherInvalidRegex(t *testing.T) {
+	hook := logtest.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+
+	m := newUserAgentMatcher("", `(unclosed, ^terraform/`)
+	if !m.Match("terraform/1.0.0") {
+		t.Error("valid expressions after an invalid one should still match")
+	}
+	if !m.Match("console.amazonaws.com") {
+		t.Error("defaults should still match")
+	}
+
+	entry := hook.LastEntry()
+	if entry == nil || entry.Level != logrus.WarnLevel || !strings.Contains(entry.Message, `"(unclosed"`) {
+		t.Errorf("expected a warning for the invalid expression, got %v", entry)
+	}
+}
+
+func TestFilterRecordsConsoleUserAgents(t *testing.T) {
+	slack := captureSlack(t)
+	setEnv(t, "CONSOLE_USER_AGENTS", "AWS-Console-Mobile/2.0")
+
+	mobile := consoleRecord("CreateTags", "mobile")
+	mobile["userAgent"] = "AWS-Console-Mobile/2.0"
+	cli := consoleRecord("CreateTags", "cli")
+	cli["userAgent"] = "aws-cli/2.2.5"
+
+	if _, err := FilterRecords(context.Background(), cloudTrailFile(mobile, cli).Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+	bodies := slack.Bodies()
+	if len(bodies) != 1 || slackBodyFor(bodies, "mobile") == "" {
+		t.Errorf("expected only the custom user agent to alert, got %v", bodies)
+	}
+}
+
+func TestFilterRecordsSuppressUserAgentRegexes(t *testing.T) {
+	slack := captureSlack(t)
+	hook := logtest.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+	setEnv(t, "SUPPRESS_USER_AGENT_REGEXES", `(unclosed, Mozilla/.* CloudHealth/\d+, ^vantage-`)
+
+	saas := consoleRecord("CreateTags", "saas")
+	saas["userAgent"] = "Mozilla/5.0 (X11; Linux x86_64) CloudHealth/3"
+	browser := consoleRecord("CreateTags", "browser")
+	browser["userAgent"] = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) Chrome/90.0.4430.93"
+
+	if _, err := FilterRecords(context.Background(), cloudTrailFile(saas, browser).Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+	bodies := slack.Bodies()
+	if len(bodies) != 1 || slackBodyFor(bodies, "browser") == "" {
+		t.Errorf("expected only the browser user agent to alert, got %v", bodies)
+	}
+	if ok, reason := ShouldAlert(typedRecord(saas)); ok || reason != "suppressed user agent" {
+		t.Errorf("ShouldAlert() = %v, %q", ok, reason)
+	}
+
+	var warned bool
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == logrus.WarnLevel && strings.Contains(entry.Message, `SUPPRESS_USER_AGENT_REGEXES entry "(unclosed"`) {
+			warned = true
+		}
+	}
+	if !warned {
+		t.Error("expected a warning for the invalid expression")
+	}
+}
+
+var benchmarkUserAgents = []string{
+	"console.ec2.amazonaws.com",
+	"aws-cli/2.2.5 Python/3.8.8 Darwin/20.4.0 exe/x86_64 prompt/off",
+	"[S3Console/0.4, aws-internal/3 aws-sdk-java/1.11.1002]",
+	"Boto3/1.17.78 Python/3.9.5 Linux/5.4.0 Botocore/1.20.78",
+}
+
+// BenchmarkUserAgentPerCallCompile matches the default expressions the way
+// the filter used to, compiling them for every record.
+func BenchmarkUserAgentPerCallCompile(b *testing.B) {
+	var expressions []string
+	for _, pattern := range defaultConsoleUserAgentPatterns {
+		expressions = append(expressions, pattern.String())
+	}
+
+	for i := 0; i < b.N; i++ {
+		for _, ua := range benchmarkUserAgents {
+			for _, expr := range expressions {
+				if ok, _ := regexp.MatchString(expr, ua); ok {
+					break
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkUserAgentPrecompiled(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		for _, ua := range benchmarkUserAgents {
+			for _, pattern := range defaultConsoleUserAgentPatterns {
+				if pattern.MatchString(ua) {
+					break
+				}
+			}
+		}
+	}
+}
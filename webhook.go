@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// webhookHeaders returns the GENERIC_WEBHOOK_HEADERS, a JSON object of the
+// headers sent with every webhook call such as an Authorization header.
+func webhookHeaders() map[string]string {
+	v := os.Getenv("GENERIC_WEBHOOK_HEADERS")
+	if v == "" {
+		return nil
+	}
+	var headers map[string]string
+	if err := json.Unmarshal([]byte(v), &headers); err != nil {
+		log.Warnf("Ignoring invalid GENERIC_WEBHOOK_HEADERS: %v", err)
+		return nil
+	}
+	return headers
+}
+
+// SendWebhook posts a JSON body with additional headers, which may replace
+// the Content-Type. Any 2xx response is a success.
+func SendWebhook(ctx context.Context, url string, headers map[string]string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	client := notifyHTTPClient()
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer closeResponse(resp)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		buf := new(bytes.Buffer)
+		buf.ReadFrom(io.LimitReader(resp.Body, maxErrorBodyLength+1))
+		return fmt.Errorf("Non-ok response returned from webhook: %d %s", resp.StatusCode, truncate(buf.String(), maxErrorBodyLength))
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSendWebhookHeaders(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	headers := map[string]string{"Authorization": "Bearer secret", "X-Source": "cloudtrail"}
+	if err := SendWebhook(context.Background(), srv.URL, headers, []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"Authorization": "Bearer secret",
+		"X-Source":      "cloudtrail",
+		"Content-Type":  "application/json",
+	} {
+		if got.Get(name) != want {
+			t.Errorf("header %s = %q, want %q", name, got.Get(name), want)
+		}
+	}
+
+	headers = map[string]string{"Content-Type": "application/vnd.alerts+json"}
+	if err := SendWebhook(context.Background(), srv.URL, headers, []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	if got.Get("Content-Type") != "application/vnd.alerts+json" {
+		t.Errorf("Content-Type = %q, expected the configured one", got.Get("Content-Type"))
+	}
+}
+
+func TestSendWebhookStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{"ok", http.StatusOK, false},
+		{"created", http.StatusCreated, false},
+		{"no content", http.StatusNoContent, false},
+		{"redirect", http.StatusNotModified, true},
+		{"unauthorized", http.StatusUnauthorized, true},
+		{"server error", http.StatusInternalServerError, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			err := SendWebhook(context.Background(), srv.URL, nil, []byte(`{}`))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SendWebhook() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFilterRecordsGenericWebhook(t *testing.T) {
+	var alerts []map[string]interface{}
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&alert)
+		alerts = append(alerts, alert)
+		auth = r.Header.Get("Authorization")
+	}))
+	defer srv.Close()
+	setEnv(t, "GENERIC_WEBHOOK_URL", srv.URL)
+	setEnv(t, "GENERIC_WEBHOOK_HEADERS", `{"Authorization": "Bearer secret"}`)
+
+	logFile := cloudTrailFile(consoleRecord("CreateTags", "event-1"))
+	if _, err := FilterRecords(context.Background(), logFile.Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(alerts) != 1 {
+		t.Fatalf("expected 1 webhook call, got %d", len(alerts))
+	}
+	for field, want := range map[string]string{
+		"event_name":   "CreateTags",
+		"event_source": "ec2.amazonaws.com",
+		"event_id":     "event-1",
+		"account_id":   "012345678901",
+		"user_name":    "first.last",
+		"severity":     severityInfo,
+		"s3_uri":       "s3://test-harness/" + testS3Record.S3.Object.Key,
+	} {
+		if alerts[0][field] != want {
+			t.Errorf("%s = %v, want %q", field, alerts[0][field], want)
+		}
+	}
+	if _, ok := alerts[0]["Record"]; ok {
+		t.Error("the raw record should not be posted")
+	}
+	if auth != "Bearer secret" {
+		t.Errorf("Authorization = %q", auth)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestFilterRecordsConcurrent(t *testing.T) {
+	slack := captureSlack(t)
+	setEnv(t, "WORKER_CONCURRENCY", "8")
+
+	var records []map[string]interface{}
+	want := 0
+	for i := 0; i < 200; i++ {
+		name := "CreateTags"
+		if i%3 == 0 {
+			name = "DescribeInstances"
+		} else {
+			want++
+		}
+		records = append(records, consoleRecord(name, fmt.Sprintf("event-%d", i)))
+	}
+
+	if _, err := FilterRecords(context.Background(), cloudTrailFile(records...).Stream(), testS3Record); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := slack.Bodies()
+	if len(bodies) != want {
+		t.Fatalf("expected %d messages, got %d", want, len(bodies))
+	}
+	for i := 1; i < 200; i += 3 {
+		if slackBodyFor(bodies, fmt.Sprintf("event-%d", i)) == "" {
+			t.Errorf("event-%d was not notified", i)
+		}
+	}
+}
+
+func TestFilterRecordsStreamError(t *testing.T) {
+	slack := captureSlack(t)
+	streamErr := errors.New("unexpected EOF")
+
+	logFile := cloudTrailFile(consoleRecord("CreateTags", "event-1"), consoleRecord("CreateTags", "event-2"))
+	stream := func(fn func(record *CloudTrailRecord) error) error {
+		if err := logFile.Stream()(fn); err != nil {
+			return err
+		}
+		return streamErr
+	}
+
+	if _, err := FilterRecords(context.Background(), stream, testS3Record); !errors.Is(err, streamErr) {
+		t.Errorf("expected the stream error, got %v", err)
+	}
+	// Records decoded before the error are still notified.
+	if n := len(slack.Bodies()); n != 2 {
+		t.Errorf("expected 2 messages, got %d", n)
+	}
+}
+
+func TestWorkerConcurrency(t *testing.T) {
+	for value, want := range map[string]int{"": defaultWorkerConcurrency, "16": 16, "0": defaultWorkerConcurrency, "many": defaultWorkerConcurrency} {
+		setEnv(t, "WORKER_CONCURRENCY", value)
+		if got := workerConcurrency(); got != want {
+			t.Errorf("workerConcurrency() with %q = %d, want %d", value, got, want)
+		}
+	}
+}
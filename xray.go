@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-xray-sdk-go/xray"
+)
+
+// xrayEnabled reports whether ENABLE_XRAY is set, which records X-Ray
+// subsegments for S3 reads and outbound notifications. The Lambda function
+// needs active tracing for them to be sent.
+func xrayEnabled() bool {
+	return getEnvBool("ENABLE_XRAY", false)
+}
+
+// traceAWSClient adds X-Ray subsegments to the calls of an AWS client made
+// with a traced context.
+func traceAWSClient(c *client.Client) {
+	if xrayEnabled() {
+		xray.AWS(c)
+	}
+}
+
+// tracedHTTPClient wraps client so its requests are recorded as X-Ray
+// subsegments of their context.
+func tracedHTTPClient(client *http.Client) *http.Client {
+	if xrayEnabled() {
+		return xray.Client(client)
+	}
+	return client
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/strategy/sampling"
+	"github.com/aws/aws-xray-sdk-go/xray"
+)
+
+// sampleAll records every segment so tests don't depend on the sampling
+// reservoir.
+type sampleAll struct{}
+
+func (sampleAll) ShouldTrace(*sampling.Request) *sampling.Decision {
+	return &sampling.Decision{Sample: true}
+}
+
+// xraySegment is the part of an emitted segment document the tests look at.
+type xraySegment struct {
+	Name        string        `json:"name"`
+	Subsegments []xraySegment `json:"subsegments"`
+}
+
+// captureXRay starts a segment reported to a fake X-Ray daemon and returns
+// its context and a function ending it and returning the emitted document.
+func captureXRay(t *testing.T) (context.Context, func() xraySegment) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	emitter, err := xray.NewDefaultEmitter(conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := xray.ContextWithConfig(context.Background(), xray.Config{
+		DaemonAddr:       conn.LocalAddr().String(),
+		Emitter:          emitter,
+		SamplingStrategy: sampleAll{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, seg := xray.BeginSegment(ctx, "test")
+
+	return ctx, func() xraySegment {
+		seg.Close(nil)
+
+		buf := make([]byte, 64*1024)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Each datagram is a JSON header line followed by the segment.
+		parts := strings.SplitN(string(buf[:n]), "\n", 2)
+		var doc xraySegment
+		if len(parts) != 2 || json.Unmarshal([]byte(parts[1]), &doc) != nil {
+			t.Fatalf("unexpected datagram %q", buf[:n])
+		}
+		return doc
+	}
+}
+
+func TestXRaySubsegments(t *testing.T) {
+	setEnv(t, "ENABLE_XRAY", "true")
+	fastS3Retries(t)
+
+	requests := 0
+	key := testS3Record.S3.Object.Key
+	s3Client := fakeS3Server(t, map[string]string{"/test-harness/" + key: `{"Records":[]}`}, &requests)
+	traceAWSClient(s3Client.Client)
+
+	slack := captureSlack(t)
+	webhookUrl := getEnv("SLACK_WEBHOOK", "")
+
+	ctx, endSegment := captureXRay(t)
+	obj, err := fetchLogFromS3(ctx, s3Client, "test-harness", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj.Body.Close()
+	if err := SendSlackNotification(ctx, webhookUrl, []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	if len(slack.Bodies()) != 1 {
+		t.Fatal("expected the Slack message to be sent")
+	}
+
+	doc := endSegment()
+	u, _ := url.Parse(webhookUrl)
+	var names []string
+	for _, sub := range doc.Subsegments {
+		names = append(names, sub.Name)
+	}
+	if len(names) != 2 || !strings.EqualFold(names[0], "s3") || names[1] != u.Host {
+		t.Errorf("expected S3 and %s subsegments, got %v", u.Host, names)
+	}
+}
+
+func TestXRayDisabled(t *testing.T) {
+	setEnv(t, "ENABLE_XRAY", "false")
+
+	client := &http.Client{}
+	if tracedHTTPClient(client) != client {
+		t.Error("expected the HTTP client to be used as is")
+	}
+
+	requests := 0
+	s3Client := fakeS3Server(t, map[string]string{}, &requests)
+	handlers := s3Client.Handlers.Send.Len()
+	traceAWSClient(s3Client.Client)
+	if s3Client.Handlers.Send.Len() != handlers {
+		t.Error("expected no X-Ray handlers on the S3 client")
+	}
+}